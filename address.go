@@ -0,0 +1,19 @@
+package bearclave
+
+import (
+	"github.com/tahardi/bearclave/internal/networking"
+)
+
+type Address = networking.Address
+
+const (
+	SchemeHTTP  = networking.SchemeHTTP
+	SchemeHTTPS = networking.SchemeHTTPS
+	SchemeTCP   = networking.SchemeTCP
+	SchemeTCP4  = networking.SchemeTCP4
+	SchemeTCP6  = networking.SchemeTCP6
+	SchemeUnix  = networking.SchemeUnix
+	SchemeVSock = networking.SchemeVSock
+)
+
+var ParseAddress = networking.ParseAddress
@@ -19,5 +19,6 @@ type AttestOptions = attestation.AttestOptions
 
 var (
 	WithAttestNonce     = attestation.WithAttestNonce
+	WithAttestPublicKey = attestation.WithAttestPublicKey
 	WithAttestUserData  = attestation.WithAttestUserData
 )
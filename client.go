@@ -5,6 +5,8 @@ import (
 )
 
 var (
-	NewProxiedSocketClient = networking.NewProxiedSocketClient
-	NewProxiedVSocketClient = networking.NewProxiedVSocketClient
+	NewProxiedClient           = networking.NewProxiedClient
+	NewProxiedSimVSocketClient = networking.NewProxiedSimVSocketClient
+	NewProxiedSocketClient     = networking.NewProxiedSocketClient
+	NewProxiedVSocketClient    = networking.NewProxiedVSocketClient
 )
@@ -7,8 +7,9 @@ import (
 type DialContext = networking.DialContext
 
 var (
-	NewSocketDialContext  = networking.NewSocketDialContext
-	NewVSocketDialContext = networking.NewVSocketDialContext
+	NewSimVSocketDialContext = networking.NewSimVSocketDialContext
+	NewSocketDialContext     = networking.NewSocketDialContext
+	NewVSocketDialContext    = networking.NewVSocketDialContext
 )
 
 type DialerOption = networking.DialerOption
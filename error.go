@@ -9,8 +9,10 @@ import (
 var (
 	ErrAttester            = attestation.ErrAttester
 	ErrAttesterUserData    = attestation.ErrAttesterUserData
+	ErrAddress             = networking.ErrAddress
 	ErrDialContext         = networking.ErrDialContext
 	ErrListener            = networking.ErrListener
+	ErrNoNetworkAccess     = networking.ErrNoNetworkAccess
 	ErrTimer               = clock.ErrTimer
 	ErrVerifier            = attestation.ErrVerifier
 	ErrVerifierDebugMode   = attestation.ErrVerifierDebugMode
@@ -1,6 +1,9 @@
 package attestation
 
-import "io"
+import (
+	"fmt"
+	"io"
+)
 
 type Attester interface {
 	io.Closer
@@ -15,24 +18,59 @@ type AttestResult struct {
 type AttestOption func(*AttestOptions)
 type AttestOptions struct {
 	Nonce     []byte
+	PublicKey []byte
 	UserData  []byte
 }
 
 func MakeDefaultAttestOptions() AttestOptions {
 	return AttestOptions{
 		Nonce:     nil,
+		PublicKey: nil,
 		UserData:  nil,
 	}
 }
 
+// WithAttestNonce embeds a nonce in the report. SEV and TDX reports have no
+// nonce field, so there it takes up the end of the report data (see
+// MakeReportData).
 func WithAttestNonce(nonce []byte) AttestOption {
 	return func(opts *AttestOptions) {
 		opts.Nonce = nonce
 	}
 }
 
+// WithAttestPublicKey embeds a DER-encoded public key in the report, e.g., so
+// AWS KMS can encrypt its response to it. Only Nitro (and NoTEE) reports have
+// a field for it.
+func WithAttestPublicKey(publicKey []byte) AttestOption {
+	return func(opts *AttestOptions) {
+		opts.PublicKey = publicKey
+	}
+}
+
 func WithAttestUserData(userData []byte) AttestOption {
 	return func(opts *AttestOptions) {
 		opts.UserData = userData
 	}
 }
+
+// MakeReportData lays out the report data of platforms without a nonce field:
+// user data at the start, nonce at the end, and zeros in between. Without a
+// nonce, the user data is returned as is.
+func MakeReportData(userData []byte, nonce []byte, size int) ([]byte, error) {
+	if nonce == nil {
+		return userData, nil
+	}
+	if len(userData)+len(nonce) > size {
+		msg := fmt.Sprintf(
+			"user data and nonce must be %d bytes or less",
+			size,
+		)
+		return nil, attesterErrorUserData(msg, nil)
+	}
+
+	reportData := make([]byte, size)
+	copy(reportData, userData)
+	copy(reportData[size-len(nonce):], nonce)
+	return reportData, nil
+}
@@ -5,14 +5,16 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/hf/nitrite"
 	"github.com/tahardi/bearclave/internal/drivers"
 )
 
 const (
-	AwsNitroMaxUserDataSize = 1024
-	AWSNitroDebugPCRRange   = uint(3)
+	AwsNitroMaxUserDataSize  = 1024
+	AwsNitroMaxPublicKeySize = 1024
+	AWSNitroDebugPCRRange    = uint(3)
 )
 
 type NitroAttester struct {
@@ -48,10 +50,17 @@ func (n *NitroAttester) Attest(options ...AttestOption) (*AttestResult, error) {
 		)
 		return nil, attesterErrorUserData(msg, nil)
 	}
+	if len(opts.PublicKey) > AwsNitroMaxPublicKeySize {
+		msg := fmt.Sprintf(
+			"public key must be %d bytes or less",
+			AwsNitroMaxPublicKeySize,
+		)
+		return nil, attesterError(msg, nil)
+	}
 
 	attestation, err := n.client.GetAttestation(
 		opts.Nonce,
-		nil,
+		opts.PublicKey,
 		opts.UserData,
 	)
 	if err != nil {
@@ -95,6 +104,12 @@ func (n *NitroVerifier) Verify(
 		return nil, err
 	}
 
+	issued := time.UnixMilli(int64(result.Document.Timestamp))
+	err = VerifyMaxAge(opts.MaxAge, opts.Timestamp, issued)
+	if err != nil {
+		return nil, err
+	}
+
 	debug, err := NitroIsDebugEnabled(result.Document)
 	switch {
 	case err != nil:
@@ -105,7 +120,8 @@ func (n *NitroVerifier) Verify(
 	}
 
 	verifyResult := &VerifyResult{
-		UserData: result.Document.UserData,
+		PublicKey: result.Document.PublicKey,
+		UserData:  result.Document.UserData,
 	}
 	return verifyResult, nil
 }
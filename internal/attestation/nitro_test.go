@@ -98,6 +98,39 @@ func TestNitroAttester_Attest(t *testing.T) {
 		assert.Equal(t, wantReport, got.Report)
 	})
 
+	t.Run("happy path - public key", func(t *testing.T) {
+		// given
+		wantReport := []byte("report")
+		wantPublicKey := []byte("public key")
+		client := mocks.NewNSM(t)
+		client.On("GetAttestation", []byte(nil), wantPublicKey, []byte(nil)).
+			Return(wantReport, nil)
+
+		attester, err := attestation.NewNitroAttesterWithClient(client)
+		require.NoError(t, err)
+
+		// when
+		got, err := attester.Attest(attestation.WithAttestPublicKey(wantPublicKey))
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, wantReport, got.Report)
+	})
+
+	t.Run("error - public key too long", func(t *testing.T) {
+		// given
+		publicKey := make([]byte, attestation.AwsNitroMaxPublicKeySize+1)
+		client := mocks.NewNSM(t)
+		attester, err := attestation.NewNitroAttesterWithClient(client)
+		require.NoError(t, err)
+
+		// when
+		_, err = attester.Attest(attestation.WithAttestPublicKey(publicKey))
+
+		// then
+		require.ErrorIs(t, err, attestation.ErrAttester)
+	})
+
 	t.Run("error - user data too long", func(t *testing.T) {
 		// given
 		userData := make([]byte, attestation.AwsNitroMaxUserDataSize+1)
@@ -139,6 +172,28 @@ func TestNitroVerifier_Verify(t *testing.T) {
 		assert.Equal(t, want, got.UserData)
 	})
 
+	t.Run("happy path - max age", func(t *testing.T) {
+		// given
+		timestamp := time.Unix(
+			nitroReportTimestampSeconds,
+			nitroReportTimestampNanoseconds,
+		)
+		report, _ := nitroReportFromTestData(t, nitroReportB64, timestamp)
+
+		verifier, err := attestation.NewNitroVerifier()
+		require.NoError(t, err)
+
+		// when
+		_, err = verifier.Verify(
+			report,
+			attestation.WithVerifyMaxAge(time.Minute),
+			attestation.WithVerifyTimestamp(timestamp.Add(30*time.Second)),
+		)
+
+		// then
+		require.NoError(t, err)
+	})
+
 	t.Run("happy path - debug", func(t *testing.T) {
 		// given
 		want := []byte("Hello, world!")
@@ -200,6 +255,28 @@ func TestNitroVerifier_Verify(t *testing.T) {
 		assert.ErrorContains(t, err, "certificate has expired or is not yet valid")
 	})
 
+	t.Run("error - report too old", func(t *testing.T) {
+		// given
+		timestamp := time.Unix(
+			nitroReportTimestampSeconds,
+			nitroReportTimestampNanoseconds,
+		)
+		report, _ := nitroReportFromTestData(t, nitroReportB64, timestamp)
+
+		verifier, err := attestation.NewNitroVerifier()
+		require.NoError(t, err)
+
+		// when
+		_, err = verifier.Verify(
+			report,
+			attestation.WithVerifyMaxAge(time.Minute),
+			attestation.WithVerifyTimestamp(timestamp.Add(2*time.Minute)),
+		)
+
+		// then
+		require.ErrorIs(t, err, attestation.ErrVerifierTimestamp)
+	})
+
 	t.Run("error - verifying measurement", func(t *testing.T) {
 		// given
 		measurement := "invalid measurement"
@@ -32,6 +32,7 @@ type Signature struct {
 type Report struct {
 	Userdata    []byte     `json:"userdata"`
 	Nonce       []byte     `json:"nonce"`
+	PublicKey   []byte     `json:"publickey,omitempty"`
 	Signature   *Signature `json:"signature"`
 	VerifyKey   *PublicKey `json:"verifykey"`
 	Timestamp   int64      `json:"timestamp"`
@@ -84,6 +85,7 @@ func (a *NoTEEAttester) Attest(options ...AttestOption) (*AttestResult, error) {
 
 	report := Report{
 		Nonce:       opts.Nonce,
+		PublicKey:   opts.PublicKey,
 		Userdata:    opts.UserData,
 		Signature:   signature,
 		VerifyKey:   a.publicKey,
@@ -109,6 +111,7 @@ func (n *NoTEEVerifier) Verify(
 ) (*VerifyResult, error) {
 	opts := VerifyOptions{
 		Debug:       false,
+		MaxAge:      0,
 		Measurement: "",
 		Nonce:       nil,
 		Timestamp:   time.Now(),
@@ -137,6 +140,11 @@ func (n *NoTEEVerifier) Verify(
 		)
 	}
 
+	err = VerifyMaxAge(opts.MaxAge, opts.Timestamp, time.Unix(report.Timestamp, 0))
+	if err != nil {
+		return nil, err
+	}
+
 	if opts.Measurement != "" && opts.Measurement != report.Measurement {
 		msg := fmt.Sprintf(
 			"expected '%s' got '%s'",
@@ -156,7 +164,8 @@ func (n *NoTEEVerifier) Verify(
 	}
 
 	verifyResult := &VerifyResult{
-		UserData: report.Userdata,
+		PublicKey: report.PublicKey,
+		UserData:  report.Userdata,
 	}
 	return verifyResult, nil
 }
@@ -109,6 +109,61 @@ func TestNoTEEVerifier_Verify(t *testing.T) {
 		assert.Equal(t, want, got.UserData)
 	})
 
+	t.Run("happy path - max age", func(t *testing.T) {
+		// given
+		report, _, timestamp := noTEEAttestation(t, []byte("hello world"))
+
+		verifier, err := attestation.NewNoTEEVerifier()
+		require.NoError(t, err)
+
+		// when
+		_, err = verifier.Verify(
+			report,
+			attestation.WithVerifyMaxAge(time.Minute),
+			attestation.WithVerifyTimestamp(timestamp.Add(30*time.Second)),
+		)
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("error - report too old", func(t *testing.T) {
+		// given
+		report, _, timestamp := noTEEAttestation(t, []byte("hello world"))
+
+		verifier, err := attestation.NewNoTEEVerifier()
+		require.NoError(t, err)
+
+		// when
+		_, err = verifier.Verify(
+			report,
+			attestation.WithVerifyMaxAge(time.Minute),
+			attestation.WithVerifyTimestamp(timestamp.Add(2*time.Minute)),
+		)
+
+		// then
+		require.ErrorIs(t, err, attestation.ErrVerifierTimestamp)
+	})
+
+	t.Run("happy path - public key", func(t *testing.T) {
+		// given
+		wantPublicKey := []byte("public key")
+		attester, err := attestation.NewNoTEEAttester()
+		require.NoError(t, err)
+		report, err := attester.Attest(attestation.WithAttestPublicKey(wantPublicKey))
+		require.NoError(t, err)
+
+		verifier, err := attestation.NewNoTEEVerifier()
+		require.NoError(t, err)
+
+		// when
+		got, err := verifier.Verify(report)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, wantPublicKey, got.PublicKey)
+	})
+
 	t.Run("happy path - no measurement", func(t *testing.T) {
 		// given
 		want := []byte("hello world")
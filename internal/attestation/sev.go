@@ -43,9 +43,16 @@ func (s *SEVAttester) Attest(options ...AttestOption) (*AttestResult, error) {
 		)
 		return nil, attesterErrorUserData(msg, nil)
 	}
+	if opts.PublicKey != nil {
+		return nil, attesterError("sev reports have no public key field", nil)
+	}
+	reportData, err := MakeReportData(opts.UserData, opts.Nonce, AmdSevMaxUserDataSize)
+	if err != nil {
+		return nil, err
+	}
 
 	result, err := s.client.GetReport(
-		drivers.WithSEVReportUserData(opts.UserData),
+		drivers.WithSEVReportUserData(reportData),
 		drivers.WithSEVReportCertTable(true),
 	)
 	if err != nil {
@@ -58,7 +65,7 @@ func (s *SEVAttester) Attest(options ...AttestOption) (*AttestResult, error) {
 }
 
 func (s *SEVAttester) Close() error {
-	return nil
+	return s.client.Close()
 }
 
 type SEVVerifier struct{}
@@ -102,8 +109,17 @@ func (s *SEVVerifier) Verify(
 		return nil, verifierErrorDebugMode(msg, nil)
 	}
 
+	userData, err := VerifyReportDataNonce(
+		opts.Nonce,
+		opts.MaxAge,
+		pbReport.GetReport().GetReportData(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	verifyResult := &VerifyResult{
-		UserData: pbReport.GetReport().GetReportData(),
+		UserData: userData,
 	}
 	return verifyResult, nil
 }
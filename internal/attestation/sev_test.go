@@ -120,6 +120,22 @@ func TestSEVAttester_Attest(t *testing.T) {
 	})
 }
 
+func TestSEVAttester_Close(t *testing.T) {
+	t.Run("happy path - closes client", func(t *testing.T) {
+		// given
+		client := mocks.NewSEV(t)
+		client.On("Close").Return(nil).Once()
+		attester, err := attestation.NewSEVAttesterWithClient(client)
+		require.NoError(t, err)
+
+		// when
+		err = attester.Close()
+
+		// then
+		require.NoError(t, err)
+	})
+}
+
 func TestSEVVerifier_Verify(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		// given
@@ -143,6 +159,68 @@ func TestSEVVerifier_Verify(t *testing.T) {
 		assert.Contains(t, string(got.UserData), string(want))
 	})
 
+	t.Run("happy path - nonce", func(t *testing.T) {
+		// given
+		// The test report's data is "Hello, world!" padded with zeros, so
+		// any all-zero nonce of up to 51 bytes matches its end.
+		nonce := make([]byte, 32)
+		timestamp := time.Unix(sevReportTimestampSeconds, 0)
+		report, _ := sevReportFromTestData(t, sevReportB64, timestamp)
+
+		verifier, err := attestation.NewSEVVerifier()
+		require.NoError(t, err)
+
+		// when
+		got, err := verifier.Verify(
+			report,
+			attestation.WithVerifyMaxAge(time.Minute),
+			attestation.WithVerifyVerifyNonce(nonce),
+			attestation.WithVerifyTimestamp(timestamp),
+		)
+
+		// then
+		require.NoError(t, err)
+		assert.Len(t, got.UserData, attestation.AmdSevMaxUserDataSize-len(nonce))
+	})
+
+	t.Run("error - nonce mismatch", func(t *testing.T) {
+		// given
+		timestamp := time.Unix(sevReportTimestampSeconds, 0)
+		report, _ := sevReportFromTestData(t, sevReportB64, timestamp)
+
+		verifier, err := attestation.NewSEVVerifier()
+		require.NoError(t, err)
+
+		// when
+		_, err = verifier.Verify(
+			report,
+			attestation.WithVerifyVerifyNonce([]byte("nonce")),
+			attestation.WithVerifyTimestamp(timestamp),
+		)
+
+		// then
+		require.ErrorIs(t, err, attestation.ErrVerifierNonce)
+	})
+
+	t.Run("error - max age without nonce", func(t *testing.T) {
+		// given
+		timestamp := time.Unix(sevReportTimestampSeconds, 0)
+		report, _ := sevReportFromTestData(t, sevReportB64, timestamp)
+
+		verifier, err := attestation.NewSEVVerifier()
+		require.NoError(t, err)
+
+		// when
+		_, err = verifier.Verify(
+			report,
+			attestation.WithVerifyMaxAge(time.Minute),
+			attestation.WithVerifyTimestamp(timestamp),
+		)
+
+		// then
+		require.ErrorIs(t, err, attestation.ErrVerifierNonce)
+	})
+
 	t.Run("error - invalid report", func(t *testing.T) {
 		// given
 		report := &attestation.AttestResult{Report: []byte("invalid attestation report")}
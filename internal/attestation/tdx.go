@@ -50,8 +50,16 @@ func (t *TDXAttester) Attest(options ...AttestOption) (*AttestResult, error) {
 		)
 		return nil, attesterErrorUserData(msg, nil)
 	}
+	if opts.PublicKey != nil {
+		return nil, attesterError("tdx reports have no public key field", nil)
+	}
+
+	reportData, err := MakeReportData(opts.UserData, opts.Nonce, IntelTdxMaxUserDataSize)
+	if err != nil {
+		return nil, err
+	}
 
-	report, err := t.client.GetReport(opts.UserData)
+	report, err := t.client.GetReport(reportData)
 	if err != nil {
 		return nil, attesterError("getting tdx report", err)
 	}
@@ -107,8 +115,17 @@ func (t *TDXVerifier) Verify(
 		return nil, verifierErrorDebugMode(msg, nil)
 	}
 
+	userData, err := VerifyReportDataNonce(
+		opts.Nonce,
+		opts.MaxAge,
+		quoteV4.GetTdQuoteBody().GetReportData(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	verifyResult := &VerifyResult{
-		UserData: quoteV4.GetTdQuoteBody().GetReportData(),
+		UserData: userData,
 	}
 	return verifyResult, nil
 }
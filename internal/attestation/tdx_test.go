@@ -92,6 +92,29 @@ func TestTDXAttester_Attest(t *testing.T) {
 		assert.Equal(t, wantReport, got.Report)
 	})
 
+	t.Run("happy path - nonce", func(t *testing.T) {
+		// given
+		wantReport := []byte("report")
+		wantReportData := make([]byte, attestation.IntelTdxMaxUserDataSize)
+		copy(wantReportData, "Hello, world!")
+		copy(wantReportData[attestation.IntelTdxMaxUserDataSize-5:], "nonce")
+		client := mocks.NewTDX(t)
+		client.On("GetReport", wantReportData).Return(wantReport, nil)
+
+		attester, err := attestation.NewTDXAttesterWithClient(client)
+		require.NoError(t, err)
+
+		// when
+		got, err := attester.Attest(
+			attestation.WithAttestNonce([]byte("nonce")),
+			attestation.WithAttestUserData([]byte("Hello, world!")),
+		)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, wantReport, got.Report)
+	})
+
 	t.Run("error - user data too long", func(t *testing.T) {
 		// given
 		userData := make([]byte, attestation.IntelTdxMaxUserDataSize+1)
@@ -133,6 +156,28 @@ func TestTDXVerifier_Verify(t *testing.T) {
 		assert.Contains(t, string(got.UserData), string(want))
 	})
 
+	t.Run("error - max age without nonce", func(t *testing.T) {
+		// given
+		timestamp := time.Unix(
+			tdxReportTimestampSeconds,
+			tdxReportTimestampNanoseconds,
+		)
+		report, _ := tdxReportFromTestData(t, tdxReportB64, timestamp)
+
+		verifier, err := attestation.NewTDXVerifier()
+		require.NoError(t, err)
+
+		// when
+		_, err = verifier.Verify(
+			report,
+			attestation.WithVerifyMaxAge(time.Minute),
+			attestation.WithVerifyTimestamp(timestamp),
+		)
+
+		// then
+		require.ErrorIs(t, err, attestation.ErrVerifierNonce)
+	})
+
 	t.Run("error - invalid report", func(t *testing.T) {
 		// given
 		report := &attestation.AttestResult{Report: []byte("invalid attestation report")}
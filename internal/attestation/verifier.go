@@ -1,6 +1,9 @@
 package attestation
 
 import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
 	"time"
 )
 
@@ -9,12 +12,14 @@ type Verifier interface {
 }
 
 type VerifyResult struct {
+	PublicKey []byte `json:"publickey,omitempty"`
 	UserData  []byte `json:"userdata"`
 }
 
 type VerifyOption func(*VerifyOptions)
 type VerifyOptions struct {
 	Debug       bool
+	MaxAge      time.Duration
 	Measurement string
 	Nonce       []byte
 	Timestamp   time.Time
@@ -23,6 +28,7 @@ type VerifyOptions struct {
 func MakeDefaultVerifyOptions() VerifyOptions {
 	return VerifyOptions{
 		Debug:       false,
+		MaxAge:      0,
 		Measurement: "",
 		Nonce:       nil,
 		Timestamp:   time.Now(),
@@ -35,6 +41,15 @@ func WithVerifyDebug(debug bool) VerifyOption {
 	}
 }
 
+// WithVerifyMaxAge rejects reports produced more than maxAge before the
+// verification timestamp. SEV and TDX reports carry no timestamp, so there it
+// requires a nonce instead, which the verifier must have issued recently.
+func WithVerifyMaxAge(maxAge time.Duration) VerifyOption {
+	return func(opts *VerifyOptions) {
+		opts.MaxAge = maxAge
+	}
+}
+
 func WithVerifyMeasurement(measurement string) VerifyOption {
 	return func(opts *VerifyOptions) {
 		opts.Measurement = measurement
@@ -52,3 +67,49 @@ func WithVerifyTimestamp(timestamp time.Time) VerifyOption {
 		opts.Timestamp = timestamp
 	}
 }
+
+// VerifyMaxAge checks that a report issued at issued is no more than maxAge
+// old at now. A zero maxAge disables the check.
+func VerifyMaxAge(maxAge time.Duration, now time.Time, issued time.Time) error {
+	switch {
+	case maxAge <= 0:
+		return nil
+	case issued.After(now):
+		msg := fmt.Sprintf("report issued in the future at %s", issued.UTC())
+		return verifierErrorTimestamp(msg, nil)
+	case now.Sub(issued) > maxAge:
+		msg := fmt.Sprintf("report is %s old, max age is %s", now.Sub(issued), maxAge)
+		return verifierErrorTimestamp(msg, nil)
+	}
+	return nil
+}
+
+// VerifyReportDataNonce checks the nonce of platforms without a nonce field
+// (see MakeReportData) and returns the report data before it. Without a
+// nonce, it fails if maxAge is set, since the report could be arbitrarily
+// old, and otherwise returns the report data as is.
+func VerifyReportDataNonce(
+	nonce []byte,
+	maxAge time.Duration,
+	reportData []byte,
+) ([]byte, error) {
+	switch {
+	case nonce == nil && maxAge > 0:
+		return nil, verifierErrorNonce("report has no timestamp, so max age requires a nonce", nil)
+	case nonce == nil:
+		return reportData, nil
+	case len(nonce) > len(reportData):
+		return nil, verifierErrorNonce("nonce is longer than report data", nil)
+	}
+
+	got := reportData[len(reportData)-len(nonce):]
+	if !bytes.Equal(nonce, got) {
+		msg := fmt.Sprintf(
+			"expected '%s' got '%s'",
+			base64.StdEncoding.EncodeToString(nonce),
+			base64.StdEncoding.EncodeToString(got),
+		)
+		return nil, verifierErrorNonce(msg, nil)
+	}
+	return reportData[:len(reportData)-len(nonce)], nil
+}
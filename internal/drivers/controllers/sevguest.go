@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// SEVGuestDevFile and other constants taken from the linux kernel:
+	// https://github.com/torvalds/linux/blob/v6.8/include/uapi/linux/sev-guest.h
+	SEVGuestDevFile                = "/dev/sev-guest"
+	SEVGuestIOControlMagic         = 'S'
+	SEVGuestIOControlDerivedKey    = 0x1
+	SEVGuestMessageVersion         = 1
+	SEVGuestDerivedKeyRequestSize  = 32
+	SEVGuestDerivedKeyResponseSize = 64
+)
+
+var (
+	ErrSEVGuestController = errors.New("sev guest controller")
+)
+
+// SEVGuestRequest structure taken from the linux kernel:
+// https://github.com/torvalds/linux/blob/v6.8/include/uapi/linux/sev-guest.h#L52
+type SEVGuestRequest struct {
+	MessageVersion uint8
+	_              [7]byte
+	RequestData    uint64
+	ResponseData   uint64
+	ExitInfo2      uint64
+}
+
+// SEVGuestController sends SNP_GET_DERIVED_KEY requests to the SEV-SNP guest
+// driver. Reports are fetched through configfs-tsm instead (see TSM).
+type SEVGuestController struct {
+	file *os.File
+}
+
+func NewSEVGuestController() (*SEVGuestController, error) {
+	file, err := os.OpenFile(SEVGuestDevFile, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return NewSEVGuestControllerWithFile(file)
+}
+
+func NewSEVGuestControllerWithFile(file *os.File) (*SEVGuestController, error) {
+	return &SEVGuestController{file: file}, nil
+}
+
+func (s *SEVGuestController) Close() error {
+	return s.file.Close()
+}
+
+// Send takes a marshaled snp_derived_key_req and returns the raw
+// snp_derived_key_resp, i.e., the firmware's MSG_KEY_RSP.
+func (s *SEVGuestController) Send(request []byte) ([]byte, error) {
+	if len(request) != SEVGuestDerivedKeyRequestSize {
+		return nil, fmt.Errorf(
+			"%w: invalid request size: %d",
+			ErrSEVGuestController,
+			len(request),
+		)
+	}
+
+	response := make([]byte, SEVGuestDerivedKeyResponseSize)
+	message := SEVGuestRequest{
+		MessageVersion: SEVGuestMessageVersion,
+		RequestData:    uint64(uintptr(unsafe.Pointer(&request[0]))),
+		ResponseData:   uint64(uintptr(unsafe.Pointer(&response[0]))),
+	}
+
+	command := MakeIOControlCommand(
+		IOControlRead|IOControlWrite,
+		SEVGuestIOControlMagic,
+		SEVGuestIOControlDerivedKey,
+		uint(unsafe.Sizeof(message)),
+	)
+
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		s.file.Fd(),
+		uintptr(command),
+		uintptr(unsafe.Pointer(&message)),
+	)
+
+	// The kernel reads and writes the buffers through the addresses stored in
+	// message, which the garbage collector does not know about.
+	runtime.KeepAlive(request)
+	runtime.KeepAlive(response)
+	if errno != 0 {
+		return nil, fmt.Errorf(
+			"%w: making syscall (fw error 0x%x, vmm error 0x%x): %w",
+			ErrSEVGuestController,
+			uint32(message.ExitInfo2),
+			uint32(message.ExitInfo2>>32),
+			errno,
+		)
+	}
+	return response, nil
+}
@@ -1,15 +1,37 @@
 package drivers
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"sync"
 
 	"github.com/tahardi/bearclave/internal/drivers/controllers"
 )
 
 const (
 	SEVProvider = "sev_guest"
+
+	// SEVRootKeyVCEK and other MSG_KEY_REQ constants taken from the SEV-SNP
+	// firmware ABI specification (publication 56860), section 7.2. The VCEK
+	// is unique to the chip, while the VMRK is provided by the migration
+	// agent and survives migration.
+	SEVRootKeyVCEK = uint32(0)
+	SEVRootKeyVMRK = uint32(1)
+
+	SEVKeyFieldGuestPolicy = uint64(1 << 0)
+	SEVKeyFieldImageID     = uint64(1 << 1)
+	SEVKeyFieldFamilyID    = uint64(1 << 2)
+	SEVKeyFieldMeasurement = uint64(1 << 3)
+	SEVKeyFieldGuestSVN    = uint64(1 << 4)
+	SEVKeyFieldTCBVersion  = uint64(1 << 5)
+
+	SEVDerivedKeySize       = 32
+	SEVDerivedKeyOffset     = 0x20
+	SEVDerivedKeyStatusOK   = uint32(0)
+	SEVDerivedKeyStatusSize = 4
 )
 
 var (
@@ -17,6 +39,9 @@ var (
 )
 
 type SEV interface {
+	io.Closer
+
+	GetDerivedKey(options ...SEVDerivedKeyOption) (key []byte, err error)
 	GetReport(options ...SEVReportOption) (result *SEVReportResult, err error)
 }
 
@@ -50,22 +75,179 @@ func WithSEVReportCertTable(certTable bool) SEVReportOption {
 	}
 }
 
+// SEVDerivedKeyOptions mirror the fields of MSG_KEY_REQ. FieldSelect picks
+// which guest properties are mixed into the key: a key derived with
+// SEVKeyFieldMeasurement, for example, can only be derived again by a guest
+// with the same launch measurement.
+type SEVDerivedKeyOption func(*SEVDerivedKeyOptions)
+type SEVDerivedKeyOptions struct {
+	RootKey     uint32
+	FieldSelect uint64
+	VMPL        uint32
+	GuestSVN    uint32
+	TCBVersion  uint64
+}
+
+func MakeDefaultSEVDerivedKeyOptions() SEVDerivedKeyOptions {
+	return SEVDerivedKeyOptions{
+		RootKey:     SEVRootKeyVCEK,
+		FieldSelect: SEVKeyFieldGuestPolicy | SEVKeyFieldMeasurement,
+		VMPL:        0,
+		GuestSVN:    0,
+		TCBVersion:  0,
+	}
+}
+
+func WithSEVDerivedKeyRootKey(rootKey uint32) SEVDerivedKeyOption {
+	return func(opts *SEVDerivedKeyOptions) {
+		opts.RootKey = rootKey
+	}
+}
+
+// WithSEVDerivedKeyFieldSelect replaces the default field selection
+// (SEVKeyFieldGuestPolicy|SEVKeyFieldMeasurement) with fields.
+func WithSEVDerivedKeyFieldSelect(fields uint64) SEVDerivedKeyOption {
+	return func(opts *SEVDerivedKeyOptions) {
+		opts.FieldSelect = fields
+	}
+}
+
+func WithSEVDerivedKeyVMPL(vmpl uint32) SEVDerivedKeyOption {
+	return func(opts *SEVDerivedKeyOptions) {
+		opts.VMPL = vmpl
+	}
+}
+
+// WithSEVDerivedKeyGuestSVN selects SEVKeyFieldGuestSVN and derives the key
+// for guestSVN, which must not exceed the guest's current SVN.
+func WithSEVDerivedKeyGuestSVN(guestSVN uint32) SEVDerivedKeyOption {
+	return func(opts *SEVDerivedKeyOptions) {
+		opts.FieldSelect |= SEVKeyFieldGuestSVN
+		opts.GuestSVN = guestSVN
+	}
+}
+
+// WithSEVDerivedKeyTCBVersion selects SEVKeyFieldTCBVersion and derives the
+// key for tcbVersion, which must not exceed the platform's committed TCB.
+func WithSEVDerivedKeyTCBVersion(tcbVersion uint64) SEVDerivedKeyOption {
+	return func(opts *SEVDerivedKeyOptions) {
+		opts.FieldSelect |= SEVKeyFieldTCBVersion
+		opts.TCBVersion = tcbVersion
+	}
+}
+
+// MarshalSEVDerivedKeyRequest encodes opts as a snp_derived_key_req. The
+// layout is taken from the linux kernel:
+// https://github.com/torvalds/linux/blob/v6.8/include/uapi/linux/sev-guest.h#L40
+func MarshalSEVDerivedKeyRequest(opts SEVDerivedKeyOptions) []byte {
+	req := make([]byte, controllers.SEVGuestDerivedKeyRequestSize)
+	binary.LittleEndian.PutUint32(req[0:], opts.RootKey)
+	binary.LittleEndian.PutUint64(req[8:], opts.FieldSelect)
+	binary.LittleEndian.PutUint32(req[16:], opts.VMPL)
+	binary.LittleEndian.PutUint32(req[20:], opts.GuestSVN)
+	binary.LittleEndian.PutUint64(req[24:], opts.TCBVersion)
+	return req
+}
+
 type SEVClient struct {
-	tsm controllers.TSMController
+	mu        sync.Mutex
+	tsm       controllers.TSMController
+	guest     controllers.IOController
+	openGuest func() (controllers.IOController, error)
+	closed    bool
 }
 
+// NewSEVClient opens configfs-tsm, for reports. /dev/sev-guest, for derived
+// keys, is opened on the first call to GetDerivedKey, so GetReport works on
+// guests without it.
 func NewSEVClient() (*SEVClient, error) {
 	tsm, err := controllers.NewTSM()
 	if err != nil {
 		return nil, fmt.Errorf("%w: making tsm controller: %w", ErrSEVClient, err)
 	}
-	return NewSEVClientWithTSM(tsm)
+
+	openGuest := func() (controllers.IOController, error) {
+		return controllers.NewSEVGuestController()
+	}
+	return &SEVClient{tsm: tsm, openGuest: openGuest}, nil
 }
 
 func NewSEVClientWithTSM(tsm controllers.TSMController) (*SEVClient, error) {
 	return &SEVClient{tsm: tsm}, nil
 }
 
+func NewSEVClientWithControllers(
+	tsm controllers.TSMController,
+	guest controllers.IOController,
+) (*SEVClient, error) {
+	return &SEVClient{tsm: tsm, guest: guest}, nil
+}
+
+func (s *SEVClient) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.guest == nil {
+		return nil
+	}
+	guest := s.guest
+	s.guest = nil
+	return guest.Close()
+}
+
+// GetDerivedKey requests a key from the AMD Secure Processor via
+// SNP_GET_DERIVED_KEY. The same options yield the same key for as long as
+// the selected guest properties and the root key stay the same.
+func (s *SEVClient) GetDerivedKey(options ...SEVDerivedKeyOption) ([]byte, error) {
+	opts := MakeDefaultSEVDerivedKeyOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.closed:
+		return nil, fmt.Errorf("%w: client is closed", ErrSEVClient)
+	case s.guest == nil && s.openGuest == nil:
+		return nil, fmt.Errorf(
+			"%w: %s is not available",
+			ErrSEVClient,
+			controllers.SEVGuestDevFile,
+		)
+	case s.guest == nil:
+		guest, err := s.openGuest()
+		if err != nil {
+			return nil, fmt.Errorf(
+				"%w: opening %s: %w",
+				ErrSEVClient,
+				controllers.SEVGuestDevFile,
+				err,
+			)
+		}
+		s.guest = guest
+	}
+
+	resp, err := s.guest.Send(MarshalSEVDerivedKeyRequest(opts))
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("%w: getting derived key: %w", ErrSEVClient, err)
+	case len(resp) < SEVDerivedKeyOffset+SEVDerivedKeySize:
+		return nil, fmt.Errorf("%w: short derived key response: %d", ErrSEVClient, len(resp))
+	}
+
+	status := binary.LittleEndian.Uint32(resp[:SEVDerivedKeyStatusSize])
+	if status != SEVDerivedKeyStatusOK {
+		return nil, fmt.Errorf("%w: derived key status 0x%x", ErrSEVClient, status)
+	}
+
+	key := make([]byte, SEVDerivedKeySize)
+	copy(key, resp[SEVDerivedKeyOffset:SEVDerivedKeyOffset+SEVDerivedKeySize])
+	return key, nil
+}
+
 // GetReport Information on auxillary blob and privilege levels taken from
 // Linux TSM documentation (or from AMD docs reference in the TSM doc):
 //
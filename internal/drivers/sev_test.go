@@ -1,6 +1,8 @@
 package drivers_test
 
 import (
+	"bytes"
+	"encoding/binary"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -74,3 +76,118 @@ func TestSEVClient_GetReport(t *testing.T) {
 		require.ErrorIs(t, err, drivers.ErrSEVClient)
 	})
 }
+
+func TestSEVClient_GetDerivedKey(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		want := bytes.Repeat([]byte{0xab}, drivers.SEVDerivedKeySize)
+		resp := make([]byte, controllers.SEVGuestDerivedKeyResponseSize)
+		copy(resp[drivers.SEVDerivedKeyOffset:], want)
+
+		opts := drivers.MakeDefaultSEVDerivedKeyOptions()
+		opts.FieldSelect |= drivers.SEVKeyFieldTCBVersion
+		opts.TCBVersion = 0x1122
+		wantReq := drivers.MarshalSEVDerivedKeyRequest(opts)
+
+		guest := mocks.NewIOController(t)
+		guest.On("Send", wantReq).Return(resp, nil)
+
+		client, err := drivers.NewSEVClientWithControllers(mocks.NewTSMController(t), guest)
+		require.NoError(t, err)
+
+		// when
+		got, err := client.GetDerivedKey(drivers.WithSEVDerivedKeyTCBVersion(0x1122))
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("error - firmware status", func(t *testing.T) {
+		// given
+		resp := make([]byte, controllers.SEVGuestDerivedKeyResponseSize)
+		binary.LittleEndian.PutUint32(resp, 0x16)
+		guest := mocks.NewIOController(t)
+		guest.On("Send", mock.Anything).Return(resp, nil)
+
+		client, err := drivers.NewSEVClientWithControllers(mocks.NewTSMController(t), guest)
+		require.NoError(t, err)
+
+		// when
+		_, err = client.GetDerivedKey()
+
+		// then
+		require.ErrorIs(t, err, drivers.ErrSEVClient)
+		assert.ErrorContains(t, err, "0x16")
+	})
+
+	t.Run("error - guest device unavailable", func(t *testing.T) {
+		// given
+		client, err := drivers.NewSEVClientWithTSM(mocks.NewTSMController(t))
+		require.NoError(t, err)
+
+		// when
+		_, err = client.GetDerivedKey()
+
+		// then
+		require.ErrorIs(t, err, drivers.ErrSEVClient)
+	})
+}
+
+func TestSEVClient_Close(t *testing.T) {
+	t.Run("happy path - closes guest device", func(t *testing.T) {
+		// given
+		guest := mocks.NewIOController(t)
+		guest.On("Close").Return(nil).Once()
+		client, err := drivers.NewSEVClientWithControllers(mocks.NewTSMController(t), guest)
+		require.NoError(t, err)
+
+		// when
+		err = client.Close()
+
+		// then
+		require.NoError(t, err)
+		require.NoError(t, client.Close())
+	})
+
+	t.Run("error - derived key after close", func(t *testing.T) {
+		// given
+		guest := mocks.NewIOController(t)
+		guest.On("Close").Return(nil).Once()
+		client, err := drivers.NewSEVClientWithControllers(mocks.NewTSMController(t), guest)
+		require.NoError(t, err)
+		require.NoError(t, client.Close())
+
+		// when
+		_, err = client.GetDerivedKey()
+
+		// then
+		require.ErrorIs(t, err, drivers.ErrSEVClient)
+		assert.ErrorContains(t, err, "closed")
+	})
+}
+
+func TestMarshalSEVDerivedKeyRequest(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		opts := drivers.SEVDerivedKeyOptions{
+			RootKey:     drivers.SEVRootKeyVMRK,
+			FieldSelect: drivers.SEVKeyFieldMeasurement | drivers.SEVKeyFieldGuestSVN,
+			VMPL:        1,
+			GuestSVN:    2,
+			TCBVersion:  3,
+		}
+
+		// when
+		got := drivers.MarshalSEVDerivedKeyRequest(opts)
+
+		// then
+		want := []byte{
+			1, 0, 0, 0, 0, 0, 0, 0,
+			0x18, 0, 0, 0, 0, 0, 0, 0,
+			1, 0, 0, 0, 2, 0, 0, 0,
+			3, 0, 0, 0, 0, 0, 0, 0,
+		}
+		assert.Equal(t, want, got)
+	})
+}
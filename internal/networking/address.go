@@ -0,0 +1,169 @@
+package networking
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	SchemeHTTP  = "http"
+	SchemeHTTPS = "https"
+	SchemeTCP   = "tcp"
+	SchemeTCP4  = "tcp4"
+	SchemeTCP6  = "tcp6"
+	SchemeUnix  = "unix"
+	SchemeVSock = "vsock"
+
+	DefaultHTTPPort  = "80"
+	DefaultHTTPSPort = "443"
+
+	VSockCIDHypervisor = 0
+	VSockCIDLocal      = 1
+	VSockCIDHost       = 2
+	VSockCIDAny        = math.MaxUint32
+	VSockPortAny       = math.MaxUint32
+)
+
+// Address is a parsed listen or dial address. The scheme decides the
+// transport:
+//
+//	tcp://host:port, tcp4://host:port, tcp6://[host]:port
+//	vsock://cid:port
+//	unix:///path/to/socket
+//	http://host[:port], https://host[:port]
+//
+// Addresses without a scheme (e.g., "127.0.0.1:8080" or "4:8080") are kept
+// as-is in Host and the transport is left to the platform.
+type Address struct {
+	Scheme string
+	Host   string
+	Path   string
+	CID    uint32
+	Port   uint32
+}
+
+func ParseAddress(addr string) (Address, error) {
+	scheme, rest, found := strings.Cut(addr, "://")
+	if !found {
+		return Address{Host: addr}, nil
+	}
+
+	scheme = strings.ToLower(scheme)
+	switch scheme {
+	case SchemeUnix:
+		if rest == "" {
+			msg := fmt.Sprintf("missing unix socket path in '%s'", addr)
+			return Address{}, addressError(msg, nil)
+		}
+		return Address{Scheme: scheme, Path: rest}, nil
+	case SchemeVSock:
+		cid, port, err := parseVSockCIDPort(rest)
+		if err != nil {
+			return Address{}, addressError(fmt.Sprintf("parsing '%s'", addr), err)
+		}
+		return Address{Scheme: scheme, Host: rest, CID: cid, Port: port}, nil
+	case SchemeTCP, SchemeTCP4, SchemeTCP6:
+		err := validateTCPHostPort(scheme, rest)
+		if err != nil {
+			return Address{}, addressError(fmt.Sprintf("parsing '%s'", addr), err)
+		}
+		return Address{Scheme: scheme, Host: rest}, nil
+	case SchemeHTTP, SchemeHTTPS:
+		return parseHTTPAddress(scheme, addr)
+	default:
+		msg := fmt.Sprintf("unsupported scheme '%s' in '%s'", scheme, addr)
+		return Address{}, addressError(msg, nil)
+	}
+}
+
+// Network returns the network the address must be reached over, or "" if
+// the address does not name one and the platform should decide.
+func (a Address) Network() string {
+	switch a.Scheme {
+	case SchemeTCP, SchemeTCP4, SchemeTCP6, SchemeUnix, SchemeVSock:
+		return a.Scheme
+	default:
+		return ""
+	}
+}
+
+func (a Address) String() string {
+	switch a.Scheme {
+	case "":
+		return a.Host
+	case SchemeUnix:
+		return a.Scheme + "://" + a.Path
+	default:
+		return a.Scheme + "://" + a.Host
+	}
+}
+
+func parseHTTPAddress(scheme string, addr string) (Address, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return Address{}, addressError("parsing URL", err)
+	}
+	if u.Hostname() == "" {
+		return Address{}, addressError(fmt.Sprintf("missing host in '%s'", addr), nil)
+	}
+
+	port := u.Port()
+	switch {
+	case port != "":
+	case scheme == SchemeHTTPS:
+		port = DefaultHTTPSPort
+	default:
+		port = DefaultHTTPPort
+	}
+	host := net.JoinHostPort(u.Hostname(), port)
+	err = validateTCPHostPort(SchemeTCP, host)
+	if err != nil {
+		return Address{}, addressError(fmt.Sprintf("parsing '%s'", addr), err)
+	}
+	return Address{Scheme: scheme, Host: host, Path: u.Path}, nil
+}
+
+func parseVSockCIDPort(addr string) (uint32, uint32, error) {
+	cidStr, portStr, found := strings.Cut(addr, ":")
+	if !found {
+		return 0, 0, fmt.Errorf("expected 'cid:port' got '%s'", addr)
+	}
+	cid, err := strconv.ParseUint(cidStr, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid cid '%s': %w", cidStr, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port '%s': %w", portStr, err)
+	}
+	if cid == VSockCIDHypervisor {
+		return 0, 0, fmt.Errorf("cid %d is reserved for the hypervisor", cid)
+	}
+	return uint32(cid), uint32(port), nil
+}
+
+func validateTCPHostPort(scheme string, addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	_, err = strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port '%s': %w", port, err)
+	}
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return nil
+	case scheme == SchemeTCP4 && ip.To4() == nil:
+		return fmt.Errorf("'%s' is not an IPv4 address", host)
+	case scheme == SchemeTCP6 && ip.To4() != nil:
+		return fmt.Errorf("'%s' is not an IPv6 address", host)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package networking_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/internal/networking"
+)
+
+func TestParseAddress(t *testing.T) {
+	t.Run("happy path - no scheme", func(t *testing.T) {
+		// when
+		address, err := networking.ParseAddress("127.0.0.1:8080")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "", address.Network())
+		assert.Equal(t, "127.0.0.1:8080", address.Host)
+		assert.Equal(t, "127.0.0.1:8080", address.String())
+	})
+
+	t.Run("happy path - vsock", func(t *testing.T) {
+		// when
+		address, err := networking.ParseAddress("vsock://16:8080")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, networking.SchemeVSock, address.Network())
+		assert.Equal(t, uint32(16), address.CID)
+		assert.Equal(t, uint32(8080), address.Port)
+		assert.Equal(t, "vsock://16:8080", address.String())
+	})
+
+	t.Run("happy path - tcp6", func(t *testing.T) {
+		// when
+		address, err := networking.ParseAddress("tcp6://[::1]:8080")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, networking.SchemeTCP6, address.Network())
+		assert.Equal(t, "[::1]:8080", address.Host)
+	})
+
+	t.Run("happy path - unix", func(t *testing.T) {
+		// when
+		address, err := networking.ParseAddress("unix:///run/bearclave.sock")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, networking.SchemeUnix, address.Network())
+		assert.Equal(t, "/run/bearclave.sock", address.Path)
+		assert.Equal(t, "unix:///run/bearclave.sock", address.String())
+	})
+
+	t.Run("happy path - https default port", func(t *testing.T) {
+		// when
+		address, err := networking.ParseAddress("https://example.com/path")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "", address.Network())
+		assert.Equal(t, "example.com:443", address.Host)
+		assert.Equal(t, "/path", address.Path)
+	})
+
+	t.Run("error - unsupported scheme", func(t *testing.T) {
+		// when
+		_, err := networking.ParseAddress("udp://127.0.0.1:53")
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAddress)
+		assert.ErrorContains(t, err, "unsupported scheme")
+	})
+
+	t.Run("error - vsock cid out of range", func(t *testing.T) {
+		// when
+		_, err := networking.ParseAddress("vsock://4294967296:8080")
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAddress)
+		assert.ErrorContains(t, err, "invalid cid")
+	})
+
+	t.Run("error - vsock reserved cid", func(t *testing.T) {
+		// when
+		_, err := networking.ParseAddress("vsock://0:8080")
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAddress)
+		assert.ErrorContains(t, err, "reserved")
+	})
+
+	t.Run("error - tcp port out of range", func(t *testing.T) {
+		// when
+		_, err := networking.ParseAddress("tcp://127.0.0.1:65536")
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAddress)
+		assert.ErrorContains(t, err, "invalid port")
+	})
+
+	t.Run("error - tcp4 with ipv6 host", func(t *testing.T) {
+		// when
+		_, err := networking.ParseAddress("tcp4://[::1]:8080")
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAddress)
+		assert.ErrorContains(t, err, "not an IPv4 address")
+	})
+}
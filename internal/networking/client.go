@@ -1,10 +1,17 @@
 package networking
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"net/url"
 )
 
+// PlaceholderProxyURL is the proxy URL given to the HTTP transport when the
+// proxy address names its own network (e.g., vsock:// or unix://). Its host
+// is never dialed.
+const PlaceholderProxyURL = "http://bearclave-proxy"
+
 func NewProxiedSocketClient(proxyAddr string) (*http.Client, error) {
 	dialContext, err := NewSocketDialContext()
 	if err != nil {
@@ -13,6 +20,14 @@ func NewProxiedSocketClient(proxyAddr string) (*http.Client, error) {
 	return NewProxiedClient(dialContext, proxyAddr)
 }
 
+func NewProxiedSimVSocketClient(proxyAddr string) (*http.Client, error) {
+	dialContext, err := NewSimVSocketDialContext()
+	if err != nil {
+		return nil, err
+	}
+	return NewProxiedClient(dialContext, proxyAddr)
+}
+
 func NewProxiedVSocketClient(proxyAddr string) (*http.Client, error) {
 	dialContext, err := NewVSocketDialContext()
 	if err != nil {
@@ -28,6 +43,22 @@ func NewProxiedClient(
 	// Make the HTTP client send requests to the Proxy server instead of the
 	// target server. The Proxy server should forward the request to the target.
 	proxy := func(_ *http.Request) (*url.URL, error) { return url.Parse(proxyAddr) }
+
+	// The transport only understands http(s) proxy URLs. For proxies whose
+	// address names a network, give it a placeholder URL and dial the proxy
+	// address instead. Every connection goes to the proxy, so the dialed
+	// address is ignored.
+	address, err := ParseAddress(proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if network := address.Network(); network != "" {
+		proxyDialContext := dialContext
+		proxy = func(_ *http.Request) (*url.URL, error) { return url.Parse(PlaceholderProxyURL) }
+		dialContext = func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			return proxyDialContext(ctx, network, proxyAddr)
+		}
+	}
 	transport := &http.Transport{
 		DialContext: dialContext,
 		Proxy:       proxy,
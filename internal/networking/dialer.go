@@ -59,11 +59,11 @@ func NewSocketDialContext(options ...DialerOption) (DialContext, error) {
 	}
 
 	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
-		parsedAddr, err := ParseSocketAddr(addr)
+		parsedNetwork, parsedAddr, err := ParseSocketNetworkAddr(network, addr)
 		if err != nil {
 			return nil, dialContextError("", err)
 		}
-		return dialer.DialContext(ctx, network, parsedAddr)
+		return dialer.DialContext(ctx, parsedNetwork, parsedAddr)
 	}, nil
 }
 
@@ -6,8 +6,10 @@ import (
 )
 
 var (
+	ErrAddress          = errors.New("address")
 	ErrDialContext      = errors.New("dial context")
 	ErrListener         = errors.New("listener")
+	ErrNoNetworkAccess  = errors.New("no network access")
 	ErrSocketParseAddr  = errors.New("socket parse addr")
 	ErrVSocketParseAddr = errors.New("vsocket parse addr")
 )
@@ -25,6 +27,10 @@ func wrapError(baseErr error, msg string, err error) error {
 	}
 }
 
+func addressError(msg string, err error) error {
+	return wrapError(ErrAddress, msg, err)
+}
+
 func dialContextError(msg string, err error) error {
 	return wrapError(ErrDialContext, msg, err)
 }
@@ -4,19 +4,20 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/mdlayher/vsock"
 )
 
-const NumVSockAddrFields = 2
-
 type ListenerOption func(*ListenerOptions)
 type ListenerOptions struct {
 	Control         func(network, address string, c syscall.RawConn) error
 	KeepAlive       time.Duration
 	KeepAliveConfig net.KeepAliveConfig
+	UnixSocketMode  os.FileMode
 }
 
 func WithListenControl(
@@ -39,6 +40,14 @@ func WithListenKeepAliveConfig(config net.KeepAliveConfig) ListenerOption {
 	}
 }
 
+// WithListenUnixSocketMode sets the file mode of Unix domain socket listeners
+// so access can be restricted with filesystem permissions.
+func WithListenUnixSocketMode(mode os.FileMode) ListenerOption {
+	return func(opts *ListenerOptions) {
+		opts.UnixSocketMode = mode
+	}
+}
+
 func NewSocketListener(
 	ctx context.Context,
 	network string,
@@ -56,11 +65,15 @@ func NewSocketListener(
 		KeepAliveConfig: opts.KeepAliveConfig,
 	}
 
-	parsedAddr, err := ParseSocketAddr(addr)
+	network, parsedAddr, err := ParseSocketNetworkAddr(network, addr)
 	if err != nil {
 		return nil, listenerError("", err)
 	}
 
+	if network == NetworkUnix && opts.UnixSocketMode != 0 {
+		return newUnixListener(ctx, listenConfig, parsedAddr, opts.UnixSocketMode)
+	}
+
 	// The context is only used while resolving the address. It does not
 	// affect the returned Listener.
 	listener, err := listenConfig.Listen(ctx, network, parsedAddr)
@@ -75,6 +88,65 @@ func NewSocketListener(
 	return listener, nil
 }
 
+// newUnixListener binds the socket inside a private (0700) directory next to
+// path, sets its mode, and only then links it at path. Binding at path
+// directly would leave a window in which the socket has the umask's, likely
+// looser, permissions.
+func newUnixListener(
+	ctx context.Context,
+	listenConfig *net.ListenConfig,
+	path string,
+	mode os.FileMode,
+) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".bearclave-sock-")
+	if err != nil {
+		return nil, listenerError("creating private socket directory", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, "sock")
+	listener, err := listenConfig.Listen(ctx, NetworkUnix, tmpPath)
+	if err != nil {
+		msg := fmt.Sprintf("creating unix socket listener on %s", path)
+		return nil, listenerError(msg, err)
+	}
+	unixListener, ok := listener.(*net.UnixListener)
+	if !ok {
+		listener.Close()
+		return nil, listenerError("unexpected unix listener type", nil)
+	}
+	// The listener would unlink tmpPath, not path, on Close.
+	unixListener.SetUnlinkOnClose(false)
+
+	// Unlike a rename, a link fails if path exists, so a live socket there
+	// cannot be replaced.
+	if err = os.Chmod(tmpPath, mode); err == nil {
+		err = os.Link(tmpPath, path)
+	}
+	if err != nil {
+		listener.Close()
+		msg := fmt.Sprintf("setting mode of unix socket %s", path)
+		return nil, listenerError(msg, err)
+	}
+	return &linkedUnixListener{UnixListener: unixListener, path: path}, nil
+}
+
+type linkedUnixListener struct {
+	*net.UnixListener
+
+	path string
+}
+
+func (r *linkedUnixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: r.path, Net: NetworkUnix}
+}
+
+func (r *linkedUnixListener) Close() error {
+	err := r.UnixListener.Close()
+	_ = os.Remove(r.path)
+	return err
+}
+
 func NewVSocketListener(
 	ctx context.Context,
 	_ string,
@@ -2,37 +2,64 @@ package networking
 
 import (
 	"fmt"
-	"net/url"
-	"strings"
+)
+
+const (
+	NetworkTCP  = "tcp"
+	NetworkTCP4 = "tcp4"
+	NetworkTCP6 = "tcp6"
+	NetworkUnix = "unix"
 )
 
 func ParseSocketAddr(addr string) (string, error) {
-	if !strings.Contains(addr, "://") {
-		return addr, nil
-	}
+	_, parsedAddr, err := ParseSocketNetworkAddr("", addr)
+	return parsedAddr, err
+}
 
-	u, err := url.Parse(addr)
+// ParseSocketNetworkAddr returns the network and address to use for addr.
+// Addresses with a "tcp", "tcp4", "tcp6" or "unix" scheme override network,
+// e.g., "tcp6://[::1]:8080" or "unix:///run/bearclave.sock". Other schemes
+// (e.g., "http") are stripped and network is returned unchanged.
+func ParseSocketNetworkAddr(network string, addr string) (string, string, error) {
+	address, err := ParseAddress(addr)
 	if err != nil {
-		return "", fmt.Errorf("%w: parsing URL: %w", ErrSocketParseAddr, err)
+		return "", "", fmt.Errorf("%w: %w", ErrSocketParseAddr, err)
+	}
+
+	switch address.Scheme {
+	case SchemeVSock:
+		msg := fmt.Sprintf("'%s' is not a socket address", addr)
+		return "", "", fmt.Errorf("%w: %s", ErrSocketParseAddr, msg)
+	case SchemeUnix:
+		return NetworkUnix, address.Path, nil
+	case SchemeTCP, SchemeTCP4, SchemeTCP6:
+		return address.Scheme, address.Host, nil
+	default:
+		return network, address.Host, nil
 	}
-	return u.Host, nil
 }
 
+// ParseVSocketAddr parses "cid:port", optionally with a "vsock" scheme. For
+// backwards compatibility an "http" or "https" scheme is also accepted.
 func ParseVSocketAddr(addr string) (uint32, uint32, error) {
-	// Handle URLs like "http://cid:port" by stripping the scheme
-	if idx := strings.Index(addr, "://"); idx != -1 {
-		addr = addr[idx+3:]
+	address, err := ParseAddress(addr)
+	if err != nil {
+		msg := fmt.Sprintf("expected format 'cid:port' got '%s'", addr)
+		return 0, 0, fmt.Errorf("%w: %s: %w", ErrVSocketParseAddr, msg, err)
 	}
 
-	var cid, port uint32
-	n, err := fmt.Sscanf(addr, "%d:%d", &cid, &port)
-	switch {
-	case err != nil:
+	switch address.Scheme {
+	case SchemeVSock:
+		return address.CID, address.Port, nil
+	case "", SchemeHTTP, SchemeHTTPS:
+		cid, port, err := parseVSockCIDPort(address.Host)
+		if err != nil {
+			msg := fmt.Sprintf("expected format 'cid:port' got '%s'", addr)
+			return 0, 0, fmt.Errorf("%w: %s: %w", ErrVSocketParseAddr, msg, err)
+		}
+		return cid, port, nil
+	default:
 		msg := fmt.Sprintf("expected format 'cid:port' got '%s'", addr)
-		return 0, 0, fmt.Errorf("%w: %s: %w", ErrVSocketParseAddr, msg, err)
-	case n != NumVSockAddrFields:
-		msg := fmt.Sprintf("expected 2 fields got %d", n)
 		return 0, 0, fmt.Errorf("%w: %s", ErrVSocketParseAddr, msg)
 	}
-	return cid, port, nil
 }
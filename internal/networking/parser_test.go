@@ -36,6 +36,66 @@ func TestParseSocketAddr(t *testing.T) {
 	})
 }
 
+func TestParseSocketNetworkAddr(t *testing.T) {
+	t.Run("happy path - no scheme", func(t *testing.T) {
+		// when
+		network, addr, err := networking.ParseSocketNetworkAddr("tcp4", "127.0.0.1:8080")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "tcp4", network)
+		assert.Equal(t, "127.0.0.1:8080", addr)
+	})
+
+	t.Run("happy path - http scheme keeps network", func(t *testing.T) {
+		// when
+		network, addr, err := networking.ParseSocketNetworkAddr("tcp4", "http://127.0.0.1:8080")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "tcp4", network)
+		assert.Equal(t, "127.0.0.1:8080", addr)
+	})
+
+	t.Run("happy path - tcp6 scheme", func(t *testing.T) {
+		// when
+		network, addr, err := networking.ParseSocketNetworkAddr("tcp4", "tcp6://[::1]:8080")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "tcp6", network)
+		assert.Equal(t, "[::1]:8080", addr)
+	})
+
+	t.Run("happy path - dual-stack tcp scheme", func(t *testing.T) {
+		// when
+		network, addr, err := networking.ParseSocketNetworkAddr("tcp4", "tcp://:8080")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "tcp", network)
+		assert.Equal(t, ":8080", addr)
+	})
+
+	t.Run("happy path - unix scheme", func(t *testing.T) {
+		// when
+		network, addr, err := networking.ParseSocketNetworkAddr("tcp4", "unix:///run/bearclave.sock")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "unix", network)
+		assert.Equal(t, "/run/bearclave.sock", addr)
+	})
+
+	t.Run("error - missing unix socket path", func(t *testing.T) {
+		// when
+		_, _, err := networking.ParseSocketNetworkAddr("tcp4", "unix://")
+
+		// then
+		require.ErrorIs(t, err, networking.ErrSocketParseAddr)
+	})
+}
+
 func TestParseVSocketAddr(t *testing.T) {
 	t.Run("happy path - no scheme", func(t *testing.T) {
 		// given
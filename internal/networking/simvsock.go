@@ -0,0 +1,156 @@
+package networking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	// SimVSocketDirEnv overrides the directory holding simulated vsock
+	// sockets. Host and enclave processes must agree on it.
+	SimVSocketDirEnv     = "BEARCLAVE_NITROSIM_DIR"
+	DefaultSimVSocketDir = "bearclave-nitrosim"
+	NetworkVSock         = "vsock"
+	SimVSocketDirMode    = 0o700
+	SimVSocketProbeTime  = 100 * time.Millisecond
+)
+
+// SimVSocketAddr is the address of a simulated vsock listener. It prints as
+// "cid:port" so it can be passed straight to a simulated vsock dialer.
+type SimVSocketAddr struct {
+	CID  uint32
+	Port uint32
+}
+
+func (s SimVSocketAddr) Network() string { return NetworkVSock }
+func (s SimVSocketAddr) String() string  { return fmt.Sprintf("%d:%d", s.CID, s.Port) }
+
+// SimVSocketDir returns the directory where simulated vsock sockets live. The
+// default is per user, so other users cannot claim addresses in it.
+func SimVSocketDir() string {
+	if dir := os.Getenv(SimVSocketDirEnv); dir != "" {
+		return dir
+	}
+	name := fmt.Sprintf("%s-%d", DefaultSimVSocketDir, os.Getuid())
+	return filepath.Join(os.TempDir(), name)
+}
+
+// ensureSimVSocketDir creates dir if needed and makes sure that only the
+// current user can create, and so take over, sockets in it.
+func ensureSimVSocketDir(dir string) error {
+	err := os.Mkdir(dir, SimVSocketDirMode)
+	if err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	switch {
+	case !info.IsDir():
+		return fmt.Errorf("'%s' is not a directory", dir)
+	case info.Mode().Perm()&0o022 != 0:
+		return fmt.Errorf("'%s' is writable by other users", dir)
+	case ok && int(stat.Uid) != os.Getuid():
+		return fmt.Errorf("'%s' is owned by another user", dir)
+	}
+	return nil
+}
+
+// SimVSocketPath returns the Unix socket path that stands in for cid:port.
+func SimVSocketPath(cid uint32, port uint32) string {
+	return filepath.Join(SimVSocketDir(), fmt.Sprintf("vsock-%d-%d.sock", cid, port))
+}
+
+type simVSocketListener struct {
+	net.Listener
+
+	addr SimVSocketAddr
+}
+
+func (s *simVSocketListener) Addr() net.Addr { return s.addr }
+
+// NewSimVSocketListener emulates NewVSocketListener by listening on a Unix
+// socket named after the "cid:port" address. Like a real vsock listener, it
+// rejects regular network addresses.
+func NewSimVSocketListener(
+	ctx context.Context,
+	_ string,
+	addr string,
+	options ...ListenerOption,
+) (net.Listener, error) {
+	opts := ListenerOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	cid, port, err := ParseVSocketAddr(addr)
+	if err != nil {
+		msg := fmt.Sprintf("enclave cannot listen on '%s' directly", addr)
+		return nil, listenerError(msg, errors.Join(ErrNoNetworkAccess, err))
+	}
+
+	path := SimVSocketPath(cid, port)
+	if err = ensureSimVSocketDir(filepath.Dir(path)); err != nil {
+		return nil, listenerError("preparing simulated vsock directory", err)
+	}
+
+	// A socket left behind by a process that crashed would otherwise make
+	// the address unusable, which is not how vsock behaves. A socket that
+	// still accepts connections belongs to a live listener, though.
+	conn, err := net.DialTimeout(NetworkUnix, path, SimVSocketProbeTime)
+	if err == nil {
+		conn.Close()
+		msg := fmt.Sprintf("simulated vsock '%s' is in use", addr)
+		return nil, listenerError(msg, nil)
+	}
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, listenerError("removing stale simulated vsock", err)
+	}
+
+	listenConfig := &net.ListenConfig{Control: opts.Control}
+	listener, err := listenConfig.Listen(ctx, NetworkUnix, path)
+	if err != nil {
+		msg := fmt.Sprintf("creating simulated vsock listener on '%s'", addr)
+		return nil, listenerError(msg, err)
+	}
+	return &simVSocketListener{
+		Listener: listener,
+		addr:     SimVSocketAddr{CID: cid, Port: port},
+	}, nil
+}
+
+// NewSimVSocketDialContext emulates NewVSocketDialContext. Only "cid:port"
+// addresses can be dialed, so code that reaches for the network directly
+// instead of going through a host proxy fails just as it would in an enclave.
+func NewSimVSocketDialContext(options ...DialerOption) (DialContext, error) {
+	opts := DialerOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	dialer := &net.Dialer{Control: opts.Control, Timeout: opts.Timeout}
+
+	return func(ctx context.Context, _ string, addr string) (net.Conn, error) {
+		cid, port, err := ParseVSocketAddr(addr)
+		if err != nil {
+			msg := fmt.Sprintf("enclave cannot dial '%s' directly", addr)
+			return nil, dialContextError(msg, errors.Join(ErrNoNetworkAccess, err))
+		}
+
+		conn, err := dialer.DialContext(ctx, NetworkUnix, SimVSocketPath(cid, port))
+		if err != nil {
+			return nil, dialContextError(fmt.Sprintf("dialing '%s'", addr), err)
+		}
+		return conn, nil
+	}, nil
+}
@@ -4,9 +4,13 @@ import (
 	"github.com/tahardi/bearclave/internal/networking"
 )
 
+const SimVSocketDirEnv = networking.SimVSocketDirEnv
+
 var (
-	NewSocketListener  = networking.NewSocketListener
-	NewVSocketListener = networking.NewVSocketListener
+	SimVSocketDir         = networking.SimVSocketDir
+	NewSimVSocketListener = networking.NewSimVSocketListener
+	NewSocketListener     = networking.NewSocketListener
+	NewVSocketListener    = networking.NewVSocketListener
 )
 
 type ListenerOption = networking.ListenerOption
@@ -16,4 +20,5 @@ var (
 	WithListenControl         = networking.WithListenControl
 	WithListenKeepAlive       = networking.WithListenKeepAlive
 	WithListenKeepAliveConfig = networking.WithListenKeepAliveConfig
+	WithListenUnixSocketMode  = networking.WithListenUnixSocketMode
 )
@@ -36,6 +36,114 @@ func (_m *SEV) EXPECT() *SEV_Expecter {
 	return &SEV_Expecter{mock: &_m.Mock}
 }
 
+// Close provides a mock function for the type SEV
+func (_mock *SEV) Close() error {
+	ret := _mock.Called()
+
+	if len(ret) == 0 {
+		panic("no return value specified for Close")
+	}
+
+	var r0 error
+	if returnFunc, ok := ret.Get(0).(func() error); ok {
+		r0 = returnFunc()
+	} else {
+		r0 = ret.Error(0)
+	}
+	return r0
+}
+
+// SEV_Close_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Close'
+type SEV_Close_Call struct {
+	*mock.Call
+}
+
+// Close is a helper method to define mock.On call
+func (_e *SEV_Expecter) Close() *SEV_Close_Call {
+	return &SEV_Close_Call{Call: _e.mock.On("Close")}
+}
+
+func (_c *SEV_Close_Call) Run(run func()) *SEV_Close_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run()
+	})
+	return _c
+}
+
+func (_c *SEV_Close_Call) Return(err error) *SEV_Close_Call {
+	_c.Call.Return(err)
+	return _c
+}
+
+func (_c *SEV_Close_Call) RunAndReturn(run func() error) *SEV_Close_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// GetDerivedKey provides a mock function for the type SEV
+func (_mock *SEV) GetDerivedKey(options ...drivers.SEVDerivedKeyOption) ([]byte, error) {
+	var tmpRet mock.Arguments
+	if len(options) > 0 {
+		tmpRet = _mock.Called(options)
+	} else {
+		tmpRet = _mock.Called()
+	}
+	ret := tmpRet
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetDerivedKey")
+	}
+
+	var r0 []byte
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(...drivers.SEVDerivedKeyOption) ([]byte, error)); ok {
+		return returnFunc(options...)
+	}
+	if returnFunc, ok := ret.Get(0).(func(...drivers.SEVDerivedKeyOption) []byte); ok {
+		r0 = returnFunc(options...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]byte)
+		}
+	}
+	if returnFunc, ok := ret.Get(1).(func(...drivers.SEVDerivedKeyOption) error); ok {
+		r1 = returnFunc(options...)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// SEV_GetDerivedKey_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetDerivedKey'
+type SEV_GetDerivedKey_Call struct {
+	*mock.Call
+}
+
+// GetDerivedKey is a helper method to define mock.On call
+//   - options
+func (_e *SEV_Expecter) GetDerivedKey(options ...interface{}) *SEV_GetDerivedKey_Call {
+	return &SEV_GetDerivedKey_Call{Call: _e.mock.On("GetDerivedKey",
+		append([]interface{}{}, options...)...)}
+}
+
+func (_c *SEV_GetDerivedKey_Call) Run(run func(options ...drivers.SEVDerivedKeyOption)) *SEV_GetDerivedKey_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		variadicArgs := args[0].([]drivers.SEVDerivedKeyOption)
+		run(variadicArgs...)
+	})
+	return _c
+}
+
+func (_c *SEV_GetDerivedKey_Call) Return(key []byte, err error) *SEV_GetDerivedKey_Call {
+	_c.Call.Return(key, err)
+	return _c
+}
+
+func (_c *SEV_GetDerivedKey_Call) RunAndReturn(run func(options ...drivers.SEVDerivedKeyOption) ([]byte, error)) *SEV_GetDerivedKey_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // GetReport provides a mock function for the type SEV
 func (_mock *SEV) GetReport(options ...drivers.SEVReportOption) (*drivers.SEVReportResult, error) {
 	var tmpRet mock.Arguments
@@ -0,0 +1,34 @@
+package bearclave
+
+import (
+	"github.com/tahardi/bearclave/internal/drivers"
+)
+
+type SEV = drivers.SEV
+
+var (
+	NewSEVClient = drivers.NewSEVClient
+)
+
+const (
+	SEVRootKeyVCEK = drivers.SEVRootKeyVCEK
+	SEVRootKeyVMRK = drivers.SEVRootKeyVMRK
+
+	SEVKeyFieldGuestPolicy = drivers.SEVKeyFieldGuestPolicy
+	SEVKeyFieldImageID     = drivers.SEVKeyFieldImageID
+	SEVKeyFieldFamilyID    = drivers.SEVKeyFieldFamilyID
+	SEVKeyFieldMeasurement = drivers.SEVKeyFieldMeasurement
+	SEVKeyFieldGuestSVN    = drivers.SEVKeyFieldGuestSVN
+	SEVKeyFieldTCBVersion  = drivers.SEVKeyFieldTCBVersion
+)
+
+type SEVDerivedKeyOption = drivers.SEVDerivedKeyOption
+type SEVDerivedKeyOptions = drivers.SEVDerivedKeyOptions
+
+var (
+	WithSEVDerivedKeyRootKey     = drivers.WithSEVDerivedKeyRootKey
+	WithSEVDerivedKeyFieldSelect = drivers.WithSEVDerivedKeyFieldSelect
+	WithSEVDerivedKeyVMPL        = drivers.WithSEVDerivedKeyVMPL
+	WithSEVDerivedKeyGuestSVN    = drivers.WithSEVDerivedKeyGuestSVN
+	WithSEVDerivedKeyTCBVersion  = drivers.WithSEVDerivedKeyTCBVersion
+)
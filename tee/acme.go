@@ -0,0 +1,398 @@
+package tee
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	ACMEChallengeHTTP01    = "http-01"
+	ACMEChallengeTLSALPN01 = "tls-alpn-01"
+	ACMEHTTP01PathPrefix   = "/.well-known/acme-challenge/"
+	DefaultACMERenewBefore = 30 * 24 * time.Hour
+	DefaultACMETimeout     = 5 * time.Minute
+	LetsEncryptURL         = acme.LetsEncryptURL
+)
+
+// TLSALPNChallengeResponder is implemented by cert providers that answer
+// ACME TLS-ALPN-01 challenges. NewServerTLSWithListener advertises the
+// "acme-tls/1" protocol for such providers and serves the challenge
+// certificate to validation handshakes.
+type TLSALPNChallengeResponder interface {
+	TLSALPNChallengeCert(hello *tls.ClientHelloInfo) (*tls.Certificate, bool)
+}
+
+// ACMECertProvider obtains certificates from an ACME CA (e.g., Let's Encrypt)
+// for a key generated and kept inside the enclave. Challenges are answered
+// through the enclave's own listeners: TLS-ALPN-01 via a Server from
+// NewServerTLS using this provider, or HTTP-01 via HTTPHandler. GetCert
+// renews the certificate in the background once it is within RenewBefore of
+// expiring, and keeps serving the current one until the new one is ready.
+type ACMECertProvider struct {
+	mu       sync.Mutex
+	obtainMu sync.Mutex
+	client   *acme.Client
+	cert     *tls.Certificate
+	certKey  crypto.Signer
+	domains  []string
+	opts     ACMEOptions
+	renewing bool
+
+	challengeMu    sync.Mutex
+	http01Tokens   map[string]string
+	tlsALPN01Certs map[string]*tls.Certificate
+}
+
+// NewACMECertProvider creates a provider that reaches the ACME directory
+// through the proxy at proxyAddr (see NewProxiedClient).
+func NewACMECertProvider(
+	platform Platform,
+	proxyAddr string,
+	directoryURL string,
+	domains []string,
+	options ...ACMEOption,
+) (*ACMECertProvider, error) {
+	client, err := NewProxiedClient(platform, proxyAddr)
+	if err != nil {
+		return nil, certProviderError("creating proxied client", err)
+	}
+	return NewACMECertProviderWithClient(client, directoryURL, domains, options...)
+}
+
+func NewACMECertProviderWithClient(
+	client *http.Client,
+	directoryURL string,
+	domains []string,
+	options ...ACMEOption,
+) (*ACMECertProvider, error) {
+	opts := MakeDefaultACMEOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	switch {
+	case len(domains) == 0:
+		return nil, certProviderError("no domains", nil)
+	case opts.Challenge != ACMEChallengeHTTP01 && opts.Challenge != ACMEChallengeTLSALPN01:
+		return nil, certProviderError("unsupported challenge: "+opts.Challenge, nil)
+	}
+
+	accountKey := opts.AccountKey
+	if accountKey == nil {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+		if err != nil {
+			return nil, certProviderError("generating account key", err)
+		}
+		accountKey = key
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		return nil, certProviderError("generating certificate key", err)
+	}
+
+	return &ACMECertProvider{
+		client: &acme.Client{
+			Key:          accountKey,
+			HTTPClient:   client,
+			DirectoryURL: directoryURL,
+			UserAgent:    "bearclave",
+		},
+		certKey:        certKey,
+		domains:        slices.Clone(domains),
+		opts:           opts,
+		http01Tokens:   map[string]string{},
+		tlsALPN01Certs: map[string]*tls.Certificate{},
+	}, nil
+}
+
+func (a *ACMECertProvider) GetCert(ctx context.Context) (*tls.Certificate, error) {
+	a.mu.Lock()
+	cert := a.cert
+	if cert != nil && time.Until(cert.Leaf.NotAfter) <= a.opts.RenewBefore && !a.renewing {
+		a.renewing = true
+		go a.renewInBackground()
+	}
+	a.mu.Unlock()
+
+	if cert != nil {
+		return cert, nil
+	}
+
+	// Only the first caller obtains the initial certificate. The others wait
+	// for it rather than placing orders of their own.
+	a.obtainMu.Lock()
+	defer a.obtainMu.Unlock()
+	a.mu.Lock()
+	cert = a.cert
+	a.mu.Unlock()
+	if cert != nil {
+		return cert, nil
+	}
+	return a.rotateCert(ctx)
+}
+
+// RotateCert obtains a new certificate from the CA and serves it from then on.
+func (a *ACMECertProvider) RotateCert(ctx context.Context) error {
+	a.obtainMu.Lock()
+	defer a.obtainMu.Unlock()
+	_, err := a.rotateCert(ctx)
+	return err
+}
+
+// RotateKey generates a new certificate key inside the enclave and obtains a
+// certificate for it.
+func (a *ACMECertProvider) RotateKey(ctx context.Context) error {
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		return certProviderError("generating certificate key", err)
+	}
+
+	a.obtainMu.Lock()
+	defer a.obtainMu.Unlock()
+	a.certKey = certKey
+	_, err = a.rotateCert(ctx)
+	return err
+}
+
+func (a *ACMECertProvider) rotateCert(ctx context.Context) (*tls.Certificate, error) {
+	cert, err := a.obtainCert(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cert = cert
+	return cert, nil
+}
+
+func (a *ACMECertProvider) renewInBackground() {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultACMETimeout)
+	defer cancel()
+
+	err := a.RotateCert(ctx)
+	if err != nil {
+		a.opts.Logger.Error("renewing acme certificate", slog.String("error", err.Error()))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.renewing = false
+}
+
+func (a *ACMECertProvider) obtainCert(ctx context.Context) (*tls.Certificate, error) {
+	account := &acme.Account{}
+	if a.opts.Email != "" {
+		account.Contact = []string{"mailto:" + a.opts.Email}
+	}
+	_, err := a.client.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, certProviderError("registering acme account", err)
+	}
+
+	order, err := a.client.AuthorizeOrder(ctx, acme.DomainIDs(a.domains...))
+	if err != nil {
+		return nil, certProviderError("creating acme order", err)
+	}
+	for _, authzURL := range order.AuthzURLs {
+		if err = a.authorize(ctx, authzURL); err != nil {
+			return nil, err
+		}
+	}
+
+	order, err = a.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, certProviderError("waiting for acme order", err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(
+		crand.Reader,
+		&x509.CertificateRequest{DNSNames: a.domains},
+		a.certKey,
+	)
+	if err != nil {
+		return nil, certProviderError("creating certificate request", err)
+	}
+	chain, _, err := a.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, certProviderError("finalizing acme order", err)
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, certProviderError("parsing certificate", err)
+	}
+	return &tls.Certificate{Certificate: chain, PrivateKey: a.certKey, Leaf: leaf}, nil
+}
+
+func (a *ACMECertProvider) authorize(ctx context.Context, authzURL string) error {
+	authz, err := a.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return certProviderError("getting acme authorization", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == a.opts.Challenge {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		msg := fmt.Sprintf("no %s challenge for %s", a.opts.Challenge, authz.Identifier.Value)
+		return certProviderError(msg, nil)
+	}
+
+	cleanup, err := a.prepareChallenge(authz.Identifier.Value, challenge)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	_, err = a.client.Accept(ctx, challenge)
+	if err != nil {
+		return certProviderError("accepting acme challenge", err)
+	}
+	_, err = a.client.WaitAuthorization(ctx, authz.URI)
+	if err != nil {
+		return certProviderError("waiting for acme authorization", err)
+	}
+	return nil
+}
+
+func (a *ACMECertProvider) prepareChallenge(
+	domain string,
+	challenge *acme.Challenge,
+) (func(), error) {
+	a.challengeMu.Lock()
+	defer a.challengeMu.Unlock()
+
+	switch challenge.Type {
+	case ACMEChallengeHTTP01:
+		keyAuth, err := a.client.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return nil, certProviderError("computing http-01 response", err)
+		}
+		path := a.client.HTTP01ChallengePath(challenge.Token)
+		a.http01Tokens[path] = keyAuth
+		return func() { a.removeChallenge(func() { delete(a.http01Tokens, path) }) }, nil
+	default:
+		cert, err := a.client.TLSALPN01ChallengeCert(challenge.Token, domain)
+		if err != nil {
+			return nil, certProviderError("creating tls-alpn-01 certificate", err)
+		}
+		a.tlsALPN01Certs[domain] = &cert
+		return func() { a.removeChallenge(func() { delete(a.tlsALPN01Certs, domain) }) }, nil
+	}
+}
+
+func (a *ACMECertProvider) removeChallenge(remove func()) {
+	a.challengeMu.Lock()
+	defer a.challengeMu.Unlock()
+	remove()
+}
+
+func (a *ACMECertProvider) TLSALPNChallengeCert(
+	hello *tls.ClientHelloInfo,
+) (*tls.Certificate, bool) {
+	if !slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+		return nil, false
+	}
+	a.challengeMu.Lock()
+	defer a.challengeMu.Unlock()
+	cert, ok := a.tlsALPN01Certs[strings.ToLower(hello.ServerName)]
+	return cert, ok
+}
+
+// HTTPHandler answers HTTP-01 challenges and passes all other requests to
+// fallback. Serve it on port 80 of the enclave, e.g., with NewServer.
+func (a *ACMECertProvider) HTTPHandler(fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, ACMEHTTP01PathPrefix) {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		a.challengeMu.Lock()
+		keyAuth, ok := a.http01Tokens[r.URL.Path]
+		a.challengeMu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(keyAuth))
+	})
+}
+
+type ACMEOption func(*ACMEOptions)
+type ACMEOptions struct {
+	AccountKey  crypto.Signer
+	Challenge   string
+	Email       string
+	Logger      *slog.Logger
+	RenewBefore time.Duration
+}
+
+func MakeDefaultACMEOptions() ACMEOptions {
+	return ACMEOptions{
+		AccountKey:  nil,
+		Challenge:   ACMEChallengeTLSALPN01,
+		Email:       "",
+		Logger:      slog.New(slog.DiscardHandler),
+		RenewBefore: DefaultACMERenewBefore,
+	}
+}
+
+// WithACMEAccountKey sets the ACME account key. By default a new key, and
+// with it a new account, is generated for every provider.
+func WithACMEAccountKey(key crypto.Signer) ACMEOption {
+	return func(opts *ACMEOptions) {
+		opts.AccountKey = key
+	}
+}
+
+// WithACMEChallenge selects ACMEChallengeTLSALPN01 (default) or
+// ACMEChallengeHTTP01.
+func WithACMEChallenge(challenge string) ACMEOption {
+	return func(opts *ACMEOptions) {
+		opts.Challenge = challenge
+	}
+}
+
+func WithACMEEmail(email string) ACMEOption {
+	return func(opts *ACMEOptions) {
+		opts.Email = email
+	}
+}
+
+func WithACMELogger(logger *slog.Logger) ACMEOption {
+	return func(opts *ACMEOptions) {
+		opts.Logger = logger
+	}
+}
+
+func WithACMERenewBefore(renewBefore time.Duration) ACMEOption {
+	return func(opts *ACMEOptions) {
+		opts.RenewBefore = renewBefore
+	}
+}
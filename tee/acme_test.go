@@ -0,0 +1,530 @@
+package tee_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+	"golang.org/x/crypto/acme"
+)
+
+var oidACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+type testACMEChallenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+}
+
+type testACMEAuthz struct {
+	Status     string               `json:"status"`
+	Identifier acme.AuthzID         `json:"identifier"`
+	Challenges []*testACMEChallenge `json:"challenges"`
+	account    string
+}
+
+type testACMEOrder struct {
+	Status         string         `json:"status"`
+	Identifiers    []acme.AuthzID `json:"identifiers"`
+	Authorizations []string       `json:"authorizations"`
+	Finalize       string         `json:"finalize"`
+	Certificate    string         `json:"certificate,omitempty"`
+	url            string
+	certPEM        []byte
+}
+
+// testACMEServer is a minimal stand-in for an ACME CA such as Pebble. It
+// does not check JWS signatures or nonces, but it does validate challenges
+// against the addresses it is given and issues certificates from its own CA.
+type testACMEServer struct {
+	*httptest.Server
+
+	t          *testing.T
+	ca         *testCA
+	validity   time.Duration
+	mu         sync.Mutex
+	nextID     int
+	accounts   map[string]string
+	authzs     map[string]*testACMEAuthz
+	challenges map[string]*testACMEAuthz
+	orders     map[string]*testACMEOrder
+	tlsAddr    string
+	httpAddr   string
+}
+
+func newTestACMEServer(t *testing.T, validity time.Duration) *testACMEServer {
+	t.Helper()
+	s := &testACMEServer{
+		t:          t,
+		ca:         newTestCA(t),
+		validity:   validity,
+		accounts:   map[string]string{},
+		authzs:     map[string]*testACMEAuthz{},
+		challenges: map[string]*testACMEAuthz{},
+		orders:     map[string]*testACMEOrder{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testACMEServer) DirectoryURL() string { return s.URL + "/directory" }
+
+func (s *testACMEServer) SetValidationAddrs(tlsAddr string, httpAddr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tlsAddr = tlsAddr
+	s.httpAddr = httpAddr
+}
+
+func (s *testACMEServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	if r.URL.Path == "/directory" {
+		writeTestACMEJSON(w, http.StatusOK, map[string]string{
+			"newNonce":   s.URL + "/new-nonce",
+			"newAccount": s.URL + "/new-account",
+			"newOrder":   s.URL + "/new-order",
+			"revokeCert": s.URL + "/revoke-cert",
+			"keyChange":  s.URL + "/key-change",
+		})
+		return
+	}
+	if r.URL.Path == "/new-nonce" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	header, payload := s.parseJWS(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kind, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch kind {
+	case "new-account":
+		s.newAccount(w, header)
+	case "new-order":
+		s.newOrder(w, header, payload)
+	case "authz":
+		writeTestACMEJSON(w, http.StatusOK, s.authzs[id])
+	case "chall":
+		s.validateChallenge(w, id)
+	case "order":
+		order := s.orders[id]
+		w.Header().Set("Location", order.url)
+		writeTestACMEJSON(w, http.StatusOK, s.orderStatus(order))
+	case "finalize":
+		s.finalize(w, id, payload)
+	case "cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = w.Write(s.orders[id].certPEM)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type testJWSHeader struct {
+	JWK map[string]string `json:"jwk"`
+	KID string            `json:"kid"`
+}
+
+func (s *testACMEServer) parseJWS(r *http.Request) (testJWSHeader, []byte) {
+	jws := struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+	}{}
+	require.NoError(s.t, json.NewDecoder(r.Body).Decode(&jws))
+	protected, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	require.NoError(s.t, err)
+	header := testJWSHeader{}
+	require.NoError(s.t, json.Unmarshal(protected, &header))
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	require.NoError(s.t, err)
+	return header, payload
+}
+
+func (s *testACMEServer) newAccount(w http.ResponseWriter, header testJWSHeader) {
+	x, err := base64.RawURLEncoding.DecodeString(header.JWK["x"])
+	require.NoError(s.t, err)
+	y, err := base64.RawURLEncoding.DecodeString(header.JWK["y"])
+	require.NoError(s.t, err)
+	publicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	thumbprint, err := acme.JWKThumbprint(publicKey)
+	require.NoError(s.t, err)
+
+	status := http.StatusOK
+	accountURL, ok := s.accounts[thumbprint]
+	if !ok {
+		status = http.StatusCreated
+		accountURL = s.url("account")
+		s.accounts[accountURL] = thumbprint
+		s.accounts[thumbprint] = accountURL
+	}
+	w.Header().Set("Location", accountURL)
+	writeTestACMEJSON(w, status, map[string]string{"status": acme.StatusValid})
+}
+
+func (s *testACMEServer) newOrder(w http.ResponseWriter, header testJWSHeader, payload []byte) {
+	req := struct {
+		Identifiers []acme.AuthzID `json:"identifiers"`
+	}{}
+	require.NoError(s.t, json.Unmarshal(payload, &req))
+
+	order := &testACMEOrder{
+		Status:      acme.StatusPending,
+		Identifiers: req.Identifiers,
+		url:         s.url("order"),
+	}
+	for _, identifier := range req.Identifiers {
+		authzURL := s.url("authz")
+		authz := &testACMEAuthz{
+			Status:     acme.StatusPending,
+			Identifier: identifier,
+			account:    header.KID,
+		}
+		for _, challengeType := range []string{tee.ACMEChallengeHTTP01, tee.ACMEChallengeTLSALPN01} {
+			challengeURL := s.url("chall")
+			authz.Challenges = append(authz.Challenges, &testACMEChallenge{
+				Type:   challengeType,
+				URL:    challengeURL,
+				Token:  base64.RawURLEncoding.EncodeToString([]byte(challengeURL)),
+				Status: acme.StatusPending,
+			})
+			s.challenges[lastSegment(challengeURL)] = authz
+		}
+		s.authzs[lastSegment(authzURL)] = authz
+		order.Authorizations = append(order.Authorizations, authzURL)
+	}
+	order.Finalize = s.URL + "/finalize/" + lastSegment(order.url)
+	s.orders[lastSegment(order.url)] = order
+
+	w.Header().Set("Location", order.url)
+	writeTestACMEJSON(w, http.StatusCreated, order)
+}
+
+func (s *testACMEServer) validateChallenge(w http.ResponseWriter, id string) {
+	authz := s.challenges[id]
+	var challenge *testACMEChallenge
+	for _, c := range authz.Challenges {
+		if lastSegment(c.URL) == id {
+			challenge = c
+		}
+	}
+	keyAuth := challenge.Token + "." + s.accounts[authz.account]
+
+	var err error
+	switch challenge.Type {
+	case tee.ACMEChallengeHTTP01:
+		err = validateTestHTTP01(s.httpAddr, challenge.Token, keyAuth)
+	default:
+		err = validateTestTLSALPN01(s.tlsAddr, authz.Identifier.Value, keyAuth)
+	}
+
+	challenge.Status = acme.StatusValid
+	authz.Status = acme.StatusValid
+	if err != nil {
+		challenge.Status = acme.StatusInvalid
+		authz.Status = acme.StatusInvalid
+	}
+	writeTestACMEJSON(w, http.StatusOK, challenge)
+}
+
+func validateTestHTTP01(addr string, token string, keyAuth string) error {
+	resp, err := http.Get("http://" + addr + tee.ACMEHTTP01PathPrefix + token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if string(body) != keyAuth {
+		return fmt.Errorf("unexpected key authorization: %s", body)
+	}
+	return nil
+}
+
+func validateTestTLSALPN01(addr string, domain string, keyAuth string) error {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		ServerName:         domain,
+		NextProtos:         []string{acme.ALPNProto},
+		InsecureSkipVerify: true, //nolint:gosec
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != acme.ALPNProto {
+		return fmt.Errorf("unexpected protocol: %s", state.NegotiatedProtocol)
+	}
+	want := sha256.Sum256([]byte(keyAuth))
+	for _, ext := range state.PeerCertificates[0].Extensions {
+		var got []byte
+		if ext.Id.Equal(oidACMEIdentifier) {
+			if _, err = asn1.Unmarshal(ext.Value, &got); err != nil {
+				return err
+			}
+			if bytes.Equal(got, want[:]) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("missing acme identifier")
+}
+
+func (s *testACMEServer) orderStatus(order *testACMEOrder) *testACMEOrder {
+	if order.Status != acme.StatusPending {
+		return order
+	}
+	for _, authzURL := range order.Authorizations {
+		switch s.authzs[lastSegment(authzURL)].Status {
+		case acme.StatusInvalid:
+			order.Status = acme.StatusInvalid
+			return order
+		case acme.StatusPending:
+			return order
+		}
+	}
+	order.Status = acme.StatusReady
+	return order
+}
+
+func (s *testACMEServer) finalize(w http.ResponseWriter, id string, payload []byte) {
+	order := s.orderStatus(s.orders[id])
+	if order.Status != acme.StatusReady {
+		writeTestACMEJSON(w, http.StatusForbidden, map[string]string{
+			"type": "urn:ietf:params:acme:error:orderNotReady",
+		})
+		return
+	}
+
+	req := struct {
+		CSR string `json:"csr"`
+	}{}
+	require.NoError(s.t, json.Unmarshal(payload, &req))
+	csrDER, err := base64.RawURLEncoding.DecodeString(req.CSR)
+	require.NoError(s.t, err)
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	require.NoError(s.t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(s.validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, s.ca.cert, csr.PublicKey, s.ca.key)
+	require.NoError(s.t, err)
+
+	order.certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	order.certPEM = append(order.certPEM, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: s.ca.cert.Raw,
+	})...)
+	order.Status = acme.StatusValid
+	order.Certificate = s.URL + "/cert/" + id
+	w.Header().Set("Location", order.url)
+	writeTestACMEJSON(w, http.StatusOK, order)
+}
+
+func (s *testACMEServer) url(kind string) string {
+	s.nextID++
+	return fmt.Sprintf("%s/%s/%d", s.URL, kind, s.nextID)
+}
+
+func lastSegment(url string) string {
+	return url[strings.LastIndex(url, "/")+1:]
+}
+
+func writeTestACMEJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestACMECertProvider(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	domains := []string{tee.DefaultDomain}
+
+	t.Run("happy path - tls-alpn-01 through server listener", func(t *testing.T) {
+		// given
+		acmeServer := newTestACMEServer(t, time.Hour)
+		certProvider, err := tee.NewACMECertProviderWithClient(
+			http.DefaultClient,
+			acmeServer.DirectoryURL(),
+			domains,
+		)
+		require.NoError(t, err)
+
+		server, err := tee.NewServerTLS(
+			context.Background(),
+			tee.NoTEE,
+			"127.0.0.1:0",
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = io.WriteString(w, "hello world")
+			}),
+			certProvider,
+			logger,
+		)
+		require.NoError(t, err)
+		defer server.Close()
+		runService(func() { _ = server.Serve() }, 0)
+		acmeServer.SetValidationAddrs(server.Addr(), "")
+
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: acmeServer.ca.pool, ServerName: tee.DefaultDomain},
+		}}
+
+		// when
+		resp, err := client.Get("https://" + server.Addr())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		// then
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(got))
+	})
+
+	t.Run("happy path - http-01", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		acmeServer := newTestACMEServer(t, time.Hour)
+		certProvider, err := tee.NewACMECertProviderWithClient(
+			http.DefaultClient,
+			acmeServer.DirectoryURL(),
+			domains,
+			tee.WithACMEChallenge(tee.ACMEChallengeHTTP01),
+		)
+		require.NoError(t, err)
+
+		server, err := tee.NewServer(
+			ctx,
+			tee.NoTEE,
+			"127.0.0.1:0",
+			certProvider.HTTPHandler(http.NotFoundHandler()),
+			logger,
+		)
+		require.NoError(t, err)
+		defer server.Close()
+		runService(func() { _ = server.Serve() }, 0)
+		acmeServer.SetValidationAddrs("", server.Addr())
+
+		// when
+		err = certProvider.RotateCert(ctx)
+		require.NoError(t, err)
+
+		// then
+		cert, err := certProvider.GetCert(ctx)
+		require.NoError(t, err)
+		_, err = cert.Leaf.Verify(x509.VerifyOptions{
+			DNSName: tee.DefaultDomain,
+			Roots:   acmeServer.ca.pool,
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("happy path - renews before expiry", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		acmeServer := newTestACMEServer(t, time.Hour)
+		certProvider, err := tee.NewACMECertProviderWithClient(
+			http.DefaultClient,
+			acmeServer.DirectoryURL(),
+			domains,
+			tee.WithACMEChallenge(tee.ACMEChallengeHTTP01),
+			tee.WithACMERenewBefore(2*time.Hour),
+		)
+		require.NoError(t, err)
+
+		server, err := tee.NewServer(
+			ctx,
+			tee.NoTEE,
+			"127.0.0.1:0",
+			certProvider.HTTPHandler(http.NotFoundHandler()),
+			logger,
+		)
+		require.NoError(t, err)
+		defer server.Close()
+		runService(func() { _ = server.Serve() }, 0)
+		acmeServer.SetValidationAddrs("", server.Addr())
+
+		first, err := certProvider.GetCert(ctx)
+		require.NoError(t, err)
+
+		// when
+		current, err := certProvider.GetCert(ctx)
+		require.NoError(t, err)
+
+		// then
+		assert.Same(t, first, current)
+		assert.Eventually(t, func() bool {
+			renewed, err := certProvider.GetCert(ctx)
+			return err == nil && renewed != first
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("error - challenge validation fails", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		acmeServer := newTestACMEServer(t, time.Hour)
+		certProvider, err := tee.NewACMECertProviderWithClient(
+			http.DefaultClient,
+			acmeServer.DirectoryURL(),
+			domains,
+			tee.WithACMEChallenge(tee.ACMEChallengeHTTP01),
+		)
+		require.NoError(t, err)
+		acmeServer.SetValidationAddrs("", "127.0.0.1:1")
+
+		// when
+		err = certProvider.RotateCert(ctx)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrCertProvider)
+	})
+
+	t.Run("error - unsupported challenge", func(t *testing.T) {
+		// when
+		_, err := tee.NewACMECertProviderWithClient(
+			http.DefaultClient,
+			"",
+			domains,
+			tee.WithACMEChallenge("dns-01"),
+		)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrCertProvider)
+	})
+}
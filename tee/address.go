@@ -0,0 +1,19 @@
+package tee
+
+import (
+	"github.com/tahardi/bearclave"
+)
+
+type Address = bearclave.Address
+
+const (
+	SchemeHTTP  = bearclave.SchemeHTTP
+	SchemeHTTPS = bearclave.SchemeHTTPS
+	SchemeTCP   = bearclave.SchemeTCP
+	SchemeTCP4  = bearclave.SchemeTCP4
+	SchemeTCP6  = bearclave.SchemeTCP6
+	SchemeUnix  = bearclave.SchemeUnix
+	SchemeVSock = bearclave.SchemeVSock
+)
+
+var ParseAddress = bearclave.ParseAddress
@@ -0,0 +1,587 @@
+package tee
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AttestCAPath            = "/bearclave/attest-ca/issue"
+	AttestCANoncePath       = "/bearclave/attest-ca/nonce"
+	AttestCAMaxBodySize     = 1 * Megabyte
+	AttestCASerialBits      = 128
+	DefaultAttestCANonceTTL = 1 * time.Minute
+	DefaultAttestCAValidity = 24 * time.Hour
+
+	// DefaultAttestCANonceRate and DefaultAttestCANonceBurst limit the nonces
+	// a single source can keep outstanding within a TTL to well below
+	// MemoryNonceStoreMax.
+	DefaultAttestCANonceRate  = 10
+	DefaultAttestCANonceBurst = 20
+	AttestCAMaxNonceSources   = MemoryNonceStoreMax
+)
+
+type AttestCANonceResponse struct {
+	Nonce []byte `json:"nonce"`
+}
+
+type AttestCARequest struct {
+	Nonce       []byte        `json:"nonce"`
+	CSR         []byte        `json:"csr"`
+	Attestation *AttestResult `json:"attestation"`
+}
+
+type AttestCAResponse struct {
+	Chain [][]byte `json:"chain"`
+}
+
+// AttestCAKeyBinding is the user data an enclave attests to when requesting
+// a certificate: the SHA-256 hash of the CA's nonce followed by the
+// DER-encoded SubjectPublicKeyInfo of the key in its CSR. The nonce keeps an
+// old attestation, e.g., from an enclave image that has since been revoked,
+// from being replayed to renew a certificate for the same key.
+func AttestCAKeyBinding(nonce []byte, publicKey crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, attestCAError("marshaling public key", err)
+	}
+	hash := sha256.New()
+	hash.Write(nonce)
+	hash.Write(der)
+	return hash.Sum(nil), nil
+}
+
+// AttestCAPolicy is what an enclave running Measurement may be issued a
+// certificate for. A CSR may only ask for the DNS names, IP addresses and
+// URIs listed, and its subject may only be a common name that is one of
+// DNSNames.
+type AttestCAPolicy struct {
+	Measurement string
+	DNSNames    []string
+	IPAddresses []net.IP
+	URIs        []string
+}
+
+// AttestCA is a private certificate authority that only issues certificates
+// to enclaves. An enclave fetches a single-use nonce, then sends a CSR and an
+// attestation to that nonce whose user data is the AttestCAKeyBinding of the
+// nonce and the CSR's key. Certificates are short-lived, so enclaves are
+// expected to renew them, e.g., with a CertRotator.
+type AttestCA struct {
+	caCert    *x509.Certificate
+	caKey     crypto.Signer
+	verifier  *Verifier
+	nonces    *NonceManager
+	nonceRate *rateLimiter
+	opts      AttestCAOptions
+}
+
+func NewAttestCA(
+	caCert *x509.Certificate,
+	caKey crypto.Signer,
+	verifier *Verifier,
+	options ...AttestCAOption,
+) (*AttestCA, error) {
+	opts := MakeDefaultAttestCAOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	switch {
+	case caCert == nil || caKey == nil:
+		return nil, attestCAError("missing CA certificate or key", nil)
+	case !caCert.IsCA:
+		return nil, attestCAError("certificate is not a CA", nil)
+	case opts.Validity <= 0:
+		return nil, attestCAError("validity must be positive", nil)
+	case opts.NonceTTL <= 0:
+		return nil, attestCAError("nonce ttl must be positive", nil)
+	case opts.NonceRate <= 0 || opts.NonceBurst < 1:
+		return nil, attestCAError("nonce rate and burst must be positive", nil)
+	case len(opts.Policies) == 0:
+		return nil, attestCAError("at least one measurement policy is required", nil)
+	}
+	for _, policy := range opts.Policies {
+		if policy.Measurement == "" {
+			return nil, attestCAError("policy is missing a measurement", nil)
+		}
+	}
+
+	nonces := opts.NonceManager
+	if nonces == nil {
+		var err error
+		nonces, err = NewNonceManager(NewMemoryNonceStore(), WithNonceManagerTTL(opts.NonceTTL))
+		if err != nil {
+			return nil, attestCAError("making nonce manager", err)
+		}
+	}
+	return &AttestCA{
+		caCert:    caCert,
+		caKey:     caKey,
+		verifier:  verifier,
+		nonces:    nonces,
+		nonceRate: newRateLimiter(opts.NonceRate, opts.NonceBurst, AttestCAMaxNonceSources),
+		opts:      opts,
+	}, nil
+}
+
+// Nonce issues a nonce that Issue accepts once, within the nonce TTL. Anyone
+// can ask for a nonce, so issuance is rate limited per source (see
+// WithAttestCANonceRate) to keep clients from filling the nonce store.
+// source identifies the requester, e.g., its IP address.
+func (c *AttestCA) Nonce(ctx context.Context, source string) ([]byte, error) {
+	if !c.nonceRate.allow(source) {
+		return nil, attestCAErrorRateLimited("too many nonce requests", nil)
+	}
+	nonce, err := c.nonces.Issue(ctx)
+	if err != nil {
+		return nil, attestCAError("issuing nonce", err)
+	}
+	return nonce, nil
+}
+
+// Issue verifies the request and returns the DER-encoded certificate chain,
+// leaf first. The request's nonce is only consumed once a certificate is
+// issued, so a rejected request cannot be used to burn an enclave's nonce.
+func (c *AttestCA) Issue(ctx context.Context, req *AttestCARequest) ([][]byte, error) {
+	if err := c.nonces.Check(ctx, req.Nonce); err != nil {
+		return nil, attestCAError("checking nonce", err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(req.CSR)
+	if err != nil {
+		return nil, attestCAError("parsing CSR", err)
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return nil, attestCAError("checking CSR signature", err)
+	}
+
+	binding, err := AttestCAKeyBinding(req.Nonce, csr.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.verify(req.Attestation, req.Nonce, binding, csr); err != nil {
+		return nil, err
+	}
+
+	serialNumber, err := crand.Int(crand.Reader, new(big.Int).Lsh(big.NewInt(1), AttestCASerialBits))
+	if err != nil {
+		return nil, attestCAError("generating serial number", err)
+	}
+
+	now := time.Now()
+	notAfter := now.Add(c.opts.Validity)
+	if notAfter.After(c.caCert.NotAfter) {
+		notAfter = c.caCert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      csr.Subject,
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+		DNSNames:    csr.DNSNames,
+		IPAddresses: csr.IPAddresses,
+		URIs:        csr.URIs,
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, c.caCert, csr.PublicKey, c.caKey)
+	if err != nil {
+		return nil, attestCAError("creating certificate", err)
+	}
+
+	if err = c.nonces.Consume(ctx, req.Nonce); err != nil {
+		return nil, attestCAError("consuming nonce", err)
+	}
+	chain := [][]byte{der, c.caCert.Raw}
+	return append(chain, c.opts.Intermediates...), nil
+}
+
+// verify accepts the attestation if it verifies against nonce and the
+// measurement of a policy that allows every name in the CSR.
+func (c *AttestCA) verify(
+	attestation *AttestResult,
+	nonce []byte,
+	binding []byte,
+	csr *x509.CertificateRequest,
+) error {
+	if attestation == nil {
+		return attestCAError("missing attestation", nil)
+	}
+
+	var errs []error
+	for _, policy := range c.opts.Policies {
+		options := append(
+			[]VerifyOption{WithVerifyNonce(nonce), WithVerifyMeasurement(policy.Measurement)},
+			c.opts.Verify...,
+		)
+		verifyResult, err := c.verifier.Verify(attestation, options...)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !bytes.Equal(verifyResult.UserData, binding) {
+			return attestCAError("attestation is not bound to the CSR key", nil)
+		}
+		if err = policy.allows(csr); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return nil
+	}
+	return attestCAError("verifying attestation", errors.Join(errs...))
+}
+
+func (p *AttestCAPolicy) allows(csr *x509.CertificateRequest) error {
+	switch {
+	case len(csr.EmailAddresses) > 0:
+		return attestCAError("CSR asks for email addresses", nil)
+	case csr.Subject.String() != pkix.Name{CommonName: csr.Subject.CommonName}.String():
+		return attestCAError("CSR subject has more than a common name", nil)
+	case csr.Subject.CommonName != "" && !slices.Contains(p.DNSNames, csr.Subject.CommonName):
+		return attestCAError(fmt.Sprintf("common name '%s' is not allowed", csr.Subject.CommonName), nil)
+	}
+	for _, name := range csr.DNSNames {
+		if !slices.Contains(p.DNSNames, name) {
+			return attestCAError(fmt.Sprintf("DNS name '%s' is not allowed", name), nil)
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		if !slices.ContainsFunc(p.IPAddresses, ip.Equal) {
+			return attestCAError(fmt.Sprintf("IP address '%s' is not allowed", ip), nil)
+		}
+	}
+	for _, uri := range csr.URIs {
+		if !slices.Contains(p.URIs, uri.String()) {
+			return attestCAError(fmt.Sprintf("URI '%s' is not allowed", uri), nil)
+		}
+	}
+	return nil
+}
+
+// MakeAttestCAHandler serves AttestCA.Nonce at AttestCANoncePath and
+// AttestCA.Issue at AttestCAPath. Nonces are rate limited by the request's
+// remote IP, so a CA behind a proxy should use a ProxyProtocolListener.
+func MakeAttestCAHandler(ca *AttestCA, logger *slog.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+AttestCANoncePath, func(w http.ResponseWriter, r *http.Request) {
+		source, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			source = r.RemoteAddr
+		}
+		nonce, err := ca.Nonce(r.Context(), source)
+		switch {
+		case errors.Is(err, ErrAttestCARateLimited):
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		case err != nil:
+			logger.Error("issuing nonce", slog.String("error", err.Error()))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		WriteResponse(w, AttestCANonceResponse{Nonce: nonce})
+	})
+	mux.HandleFunc("POST "+AttestCAPath, func(w http.ResponseWriter, r *http.Request) {
+		req := AttestCARequest{}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, AttestCAMaxBodySize)).Decode(&req)
+		if err != nil {
+			http.Error(w, attestCAError("decoding request", err).Error(), http.StatusBadRequest)
+			return
+		}
+
+		chain, err := ca.Issue(r.Context(), &req)
+		if err != nil {
+			logger.Error("issuing certificate", slog.String("error", err.Error()))
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		WriteResponse(w, AttestCAResponse{Chain: chain})
+	})
+	return mux
+}
+
+type AttestCAOption func(*AttestCAOptions)
+type AttestCAOptions struct {
+	Intermediates [][]byte
+	NonceBurst    int
+	NonceManager  *NonceManager
+	NonceRate     float64
+	NonceTTL      time.Duration
+	Policies      []AttestCAPolicy
+	Validity      time.Duration
+	Verify        []VerifyOption
+}
+
+func MakeDefaultAttestCAOptions() AttestCAOptions {
+	return AttestCAOptions{
+		Intermediates: nil,
+		NonceBurst:    DefaultAttestCANonceBurst,
+		NonceManager:  nil,
+		NonceRate:     DefaultAttestCANonceRate,
+		NonceTTL:      DefaultAttestCANonceTTL,
+		Policies:      nil,
+		Validity:      DefaultAttestCAValidity,
+		Verify:        nil,
+	}
+}
+
+// WithAttestCAIntermediates appends DER-encoded certificates to every issued
+// chain after the CA certificate, e.g., when the CA is itself an intermediate.
+func WithAttestCAIntermediates(intermediates ...[]byte) AttestCAOption {
+	return func(opts *AttestCAOptions) {
+		opts.Intermediates = append(opts.Intermediates, intermediates...)
+	}
+}
+
+// WithAttestCANonceManager sets the NonceManager used to issue nonces, e.g.,
+// one backed by a DirNonceStore so nonces survive restarts. It overrides
+// WithAttestCANonceTTL.
+func WithAttestCANonceManager(manager *NonceManager) AttestCAOption {
+	return func(opts *AttestCAOptions) {
+		opts.NonceManager = manager
+	}
+}
+
+// WithAttestCANonceRate limits nonce issuance to rate per second with bursts
+// of up to burst, per source. Requests over the limit fail with
+// ErrAttestCARateLimited.
+func WithAttestCANonceRate(rate float64, burst int) AttestCAOption {
+	return func(opts *AttestCAOptions) {
+		opts.NonceRate = rate
+		opts.NonceBurst = burst
+	}
+}
+
+// WithAttestCANonceTTL sets how long a nonce stays valid, which bounds how
+// old an attestation the CA accepts.
+func WithAttestCANonceTTL(ttl time.Duration) AttestCAOption {
+	return func(opts *AttestCAOptions) {
+		opts.NonceTTL = ttl
+	}
+}
+
+// WithAttestCAPolicies only issues certificates to enclaves running the
+// measurement of one of policies, for the names that policy allows. At least
+// one is required. Allowing several makes it possible to roll out a new
+// enclave image while the old one is still running.
+func WithAttestCAPolicies(policies ...AttestCAPolicy) AttestCAOption {
+	return func(opts *AttestCAOptions) {
+		opts.Policies = append(opts.Policies, policies...)
+	}
+}
+
+func WithAttestCAValidity(validity time.Duration) AttestCAOption {
+	return func(opts *AttestCAOptions) {
+		opts.Validity = validity
+	}
+}
+
+func WithAttestCAVerifyOptions(options ...VerifyOption) AttestCAOption {
+	return func(opts *AttestCAOptions) {
+		opts.Verify = append(opts.Verify, options...)
+	}
+}
+
+// AttestCACertProvider is the enclave side of AttestCA. It generates its key
+// inside the enclave and requests certificates for it from the CA at caURL,
+// so NewServerTLS can serve certificates that clients verify against the CA
+// rather than pinning self-signed ones.
+type AttestCACertProvider struct {
+	mu         sync.Mutex
+	attester   *Attester
+	client     *http.Client
+	caURL      string
+	cert       *tls.Certificate
+	privateKey crypto.Signer
+	domain     string
+	ip         string
+}
+
+// NewAttestCACertProvider creates a provider that reaches the CA through the
+// proxy at proxyAddr (see NewProxiedClient).
+func NewAttestCACertProvider(
+	ctx context.Context,
+	platform Platform,
+	proxyAddr string,
+	caURL string,
+	attester *Attester,
+	domain string,
+	ip string,
+) (*AttestCACertProvider, error) {
+	client, err := NewProxiedClient(platform, proxyAddr)
+	if err != nil {
+		return nil, attestCAError("creating proxied client", err)
+	}
+	return NewAttestCACertProviderWithClient(ctx, client, caURL, attester, domain, ip)
+}
+
+func NewAttestCACertProviderWithClient(
+	ctx context.Context,
+	client *http.Client,
+	caURL string,
+	attester *Attester,
+	domain string,
+	ip string,
+) (*AttestCACertProvider, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		return nil, attestCAError("generating private key", err)
+	}
+
+	provider := &AttestCACertProvider{
+		attester:   attester,
+		client:     client,
+		caURL:      strings.TrimSuffix(caURL, "/"),
+		privateKey: privateKey,
+		domain:     domain,
+		ip:         ip,
+	}
+	if err = provider.rotate(ctx, privateKey); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+func (a *AttestCACertProvider) GetCert(_ context.Context) (*tls.Certificate, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cert, nil
+}
+
+// RotateCert requests a new certificate for the current key.
+func (a *AttestCACertProvider) RotateCert(ctx context.Context) error {
+	a.mu.Lock()
+	privateKey := a.privateKey
+	a.mu.Unlock()
+	return a.rotate(ctx, privateKey)
+}
+
+// RotateKey generates a new key and requests a certificate for it.
+func (a *AttestCACertProvider) RotateKey(ctx context.Context) error {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		return attestCAError("generating private key", err)
+	}
+	return a.rotate(ctx, privateKey)
+}
+
+func (a *AttestCACertProvider) rotate(ctx context.Context, privateKey crypto.Signer) error {
+	cert, err := a.requestCert(ctx, privateKey)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cert = cert
+	a.privateKey = privateKey
+	return nil
+}
+
+func (a *AttestCACertProvider) requestCert(
+	ctx context.Context,
+	privateKey crypto.Signer,
+) (*tls.Certificate, error) {
+	template := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: a.domain},
+		DNSNames: []string{a.domain},
+	}
+	if ip := net.ParseIP(a.ip); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	}
+	csr, err := x509.CreateCertificateRequest(crand.Reader, template, privateKey)
+	if err != nil {
+		return nil, attestCAError("creating CSR", err)
+	}
+
+	nonceResp := AttestCANonceResponse{}
+	if err = a.post(ctx, AttestCANoncePath, struct{}{}, &nonceResp); err != nil {
+		return nil, err
+	}
+	binding, err := AttestCAKeyBinding(nonceResp.Nonce, privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	attestation, err := a.attester.Attest(
+		WithAttestUserData(binding),
+		WithAttestNonce(nonceResp.Nonce),
+	)
+	if err != nil {
+		return nil, attestCAError("attesting", err)
+	}
+
+	req := AttestCARequest{Nonce: nonceResp.Nonce, CSR: csr, Attestation: attestation}
+	caResp := AttestCAResponse{}
+	if err = a.post(ctx, AttestCAPath, req, &caResp); err != nil {
+		return nil, err
+	}
+	if len(caResp.Chain) == 0 {
+		return nil, attestCAError("empty certificate chain", nil)
+	}
+
+	leaf, err := x509.ParseCertificate(caResp.Chain[0])
+	if err != nil {
+		return nil, attestCAError("parsing certificate", err)
+	}
+	leafBinding, err := AttestCAKeyBinding(nonceResp.Nonce, leaf.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(leafBinding, binding) {
+		return nil, attestCAError("certificate does not match private key", nil)
+	}
+	return &tls.Certificate{Certificate: caResp.Chain, PrivateKey: privateKey, Leaf: leaf}, nil
+}
+
+func (a *AttestCACertProvider) post(ctx context.Context, path string, in any, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return attestCAError("marshaling request", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.caURL+path, bytes.NewReader(body))
+	if err != nil {
+		return attestCAError("creating request", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return attestCAError("sending request", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, AttestCAMaxBodySize))
+		errMsg := fmt.Sprintf("CA rejected request: %s: %s", resp.Status, bytes.TrimSpace(msg))
+		return attestCAError(errMsg, nil)
+	}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, AttestCAMaxBodySize)).Decode(out)
+	if err != nil {
+		return attestCAError("decoding response", err)
+	}
+	return nil
+}
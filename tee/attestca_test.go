@@ -0,0 +1,364 @@
+package tee_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+const noTEEMeasurement = "Not a TEE platform. Code measurements are not real."
+
+func newTestAttestCAPolicy(measurement string) tee.AttestCAPolicy {
+	return tee.AttestCAPolicy{
+		Measurement: measurement,
+		DNSNames:    []string{tee.DefaultDomain},
+		IPAddresses: []net.IP{net.ParseIP(tee.DefaultIP)},
+	}
+}
+
+func newTestAttestCAServer(t *testing.T, ca *testCA, options ...tee.AttestCAOption) string {
+	t.Helper()
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+	attestCA, err := tee.NewAttestCA(ca.cert, ca.key, verifier, options...)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(tee.MakeAttestCAHandler(attestCA, slog.New(slog.DiscardHandler)))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestAttestCACertProvider(t *testing.T) {
+	ctx := context.Background()
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+
+	t.Run("happy path - server cert chains to attest ca", func(t *testing.T) {
+		// given
+		ca := newTestCA(t)
+		caURL := newTestAttestCAServer(t, ca, tee.WithAttestCAPolicies(
+			newTestAttestCAPolicy("old"),
+			newTestAttestCAPolicy(noTEEMeasurement),
+		))
+		certProvider, err := tee.NewAttestCACertProviderWithClient(
+			ctx,
+			http.DefaultClient,
+			caURL,
+			attester,
+			tee.DefaultDomain,
+			tee.DefaultIP,
+		)
+		require.NoError(t, err)
+
+		server, err := tee.NewServerTLS(
+			ctx,
+			tee.NoTEE,
+			"127.0.0.1:0",
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = io.WriteString(w, "hello world")
+			}),
+			certProvider,
+			slog.New(slog.DiscardHandler),
+		)
+		require.NoError(t, err)
+		defer server.Close()
+		runService(func() { _ = server.Serve() }, 0)
+
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: ca.pool},
+		}}
+
+		// when
+		resp, err := client.Get("https://" + server.Addr())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		// then
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(got))
+	})
+
+	t.Run("happy path - rotate key", func(t *testing.T) {
+		// given
+		ca := newTestCA(t)
+		caURL := newTestAttestCAServer(
+			t,
+			ca,
+			tee.WithAttestCAPolicies(newTestAttestCAPolicy(noTEEMeasurement)),
+		)
+		certProvider, err := tee.NewAttestCACertProviderWithClient(
+			ctx,
+			http.DefaultClient,
+			caURL,
+			attester,
+			tee.DefaultDomain,
+			tee.DefaultIP,
+		)
+		require.NoError(t, err)
+		oldCert, err := certProvider.GetCert(ctx)
+		require.NoError(t, err)
+
+		// when
+		err = certProvider.RotateKey(ctx)
+		require.NoError(t, err)
+
+		// then
+		newCert, err := certProvider.GetCert(ctx)
+		require.NoError(t, err)
+		assert.NotEqual(t, oldCert.PrivateKey, newCert.PrivateKey)
+		_, err = newCert.Leaf.Verify(x509.VerifyOptions{
+			DNSName: tee.DefaultDomain,
+			Roots:   ca.pool,
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("error - measurement not allowed", func(t *testing.T) {
+		// given
+		caURL := newTestAttestCAServer(
+			t,
+			newTestCA(t),
+			tee.WithAttestCAPolicies(newTestAttestCAPolicy("other")),
+		)
+
+		// when
+		_, err := tee.NewAttestCACertProviderWithClient(
+			ctx,
+			http.DefaultClient,
+			caURL,
+			attester,
+			tee.DefaultDomain,
+			tee.DefaultIP,
+		)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestCA)
+		assert.ErrorContains(t, err, "403")
+	})
+}
+
+func TestAttestCA_Issue(t *testing.T) {
+	ctx := context.Background()
+	ca := newTestCA(t)
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+	otherPolicy := tee.AttestCAPolicy{Measurement: "other", DNSNames: []string{"admin.example.org"}}
+	attestCA, err := tee.NewAttestCA(
+		ca.cert,
+		ca.key,
+		verifier,
+		tee.WithAttestCAPolicies(otherPolicy, newTestAttestCAPolicy(noTEEMeasurement)),
+	)
+	require.NoError(t, err)
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+
+	newCSRWithTemplate := func(
+		t *testing.T,
+		template *x509.CertificateRequest,
+	) ([]byte, *ecdsa.PrivateKey) {
+		t.Helper()
+		key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+		require.NoError(t, err)
+		csr, err := x509.CreateCertificateRequest(crand.Reader, template, key)
+		require.NoError(t, err)
+		return csr, key
+	}
+	newCSR := func(t *testing.T) ([]byte, *ecdsa.PrivateKey) {
+		t.Helper()
+		return newCSRWithTemplate(t, &x509.CertificateRequest{DNSNames: []string{tee.DefaultDomain}})
+	}
+	newRequest := func(t *testing.T, csr []byte, key *ecdsa.PrivateKey) *tee.AttestCARequest {
+		t.Helper()
+		nonce, err := attestCA.Nonce(ctx, "127.0.0.1")
+		require.NoError(t, err)
+		binding, err := tee.AttestCAKeyBinding(nonce, key.Public())
+		require.NoError(t, err)
+		attestation, err := attester.Attest(tee.WithAttestUserData(binding), tee.WithAttestNonce(nonce))
+		require.NoError(t, err)
+		return &tee.AttestCARequest{Nonce: nonce, CSR: csr, Attestation: attestation}
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		csr, key := newCSRWithTemplate(t, &x509.CertificateRequest{
+			Subject:     pkix.Name{CommonName: tee.DefaultDomain},
+			DNSNames:    []string{tee.DefaultDomain},
+			IPAddresses: []net.IP{net.ParseIP(tee.DefaultIP)},
+		})
+
+		// when
+		chain, err := attestCA.Issue(ctx, newRequest(t, csr, key))
+
+		// then
+		require.NoError(t, err)
+		leaf, err := x509.ParseCertificate(chain[0])
+		require.NoError(t, err)
+		assert.Equal(t, []string{tee.DefaultDomain}, leaf.DNSNames)
+	})
+
+	t.Run("error - name allowed only for another measurement", func(t *testing.T) {
+		// given
+		csr, key := newCSRWithTemplate(t, &x509.CertificateRequest{
+			DNSNames: []string{"admin.example.org"},
+		})
+
+		// when
+		_, err = attestCA.Issue(ctx, newRequest(t, csr, key))
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestCA)
+		assert.ErrorContains(t, err, "DNS name 'admin.example.org' is not allowed")
+	})
+
+	t.Run("error - uri not allowed", func(t *testing.T) {
+		// given
+		uri, err := url.Parse("spiffe://example.org/ns/prod/sa/admin")
+		require.NoError(t, err)
+		csr, key := newCSRWithTemplate(t, &x509.CertificateRequest{
+			DNSNames: []string{tee.DefaultDomain},
+			URIs:     []*url.URL{uri},
+		})
+
+		// when
+		_, err = attestCA.Issue(ctx, newRequest(t, csr, key))
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestCA)
+		assert.ErrorContains(t, err, "is not allowed")
+	})
+
+	t.Run("error - subject not allowed", func(t *testing.T) {
+		// given
+		csr, key := newCSRWithTemplate(t, &x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: tee.DefaultDomain, Organization: []string{"admins"}},
+			DNSNames: []string{tee.DefaultDomain},
+		})
+
+		// when
+		_, err = attestCA.Issue(ctx, newRequest(t, csr, key))
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestCA)
+		assert.ErrorContains(t, err, "more than a common name")
+	})
+
+	t.Run("error - attestation bound to another key", func(t *testing.T) {
+		// given
+		csr, _ := newCSR(t)
+		_, otherKey := newCSR(t)
+		req := newRequest(t, csr, otherKey)
+
+		// when
+		_, err = attestCA.Issue(ctx, req)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestCA)
+		assert.ErrorContains(t, err, "not bound")
+	})
+
+	t.Run("error - missing attestation", func(t *testing.T) {
+		// given
+		csr, key := newCSR(t)
+		req := newRequest(t, csr, key)
+		req.Attestation = nil
+
+		// when
+		_, err = attestCA.Issue(ctx, req)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestCA)
+	})
+
+	t.Run("error - nonce replayed", func(t *testing.T) {
+		// given
+		csr, key := newCSR(t)
+		req := newRequest(t, csr, key)
+		_, err = attestCA.Issue(ctx, req)
+		require.NoError(t, err)
+
+		// when
+		_, err = attestCA.Issue(ctx, req)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestCA)
+		require.ErrorIs(t, err, tee.ErrNonceManagerNotFound)
+	})
+
+	t.Run("error - attestation to another nonce", func(t *testing.T) {
+		// given
+		csr, key := newCSR(t)
+		req := newRequest(t, csr, key)
+		req.Nonce, err = attestCA.Nonce(ctx, "127.0.0.1")
+		require.NoError(t, err)
+
+		// when
+		_, err = attestCA.Issue(ctx, req)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestCA)
+	})
+
+	t.Run("error - not a CA certificate", func(t *testing.T) {
+		// given
+		leaf := ca.issueClientCert(t, "spiffe://example.org/leaf")
+		cert, err := x509.ParseCertificate(leaf.Certificate[0])
+		require.NoError(t, err)
+
+		// when
+		_, err = tee.NewAttestCA(
+			cert,
+			ca.key,
+			verifier,
+			tee.WithAttestCAPolicies(newTestAttestCAPolicy(noTEEMeasurement)),
+		)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestCA)
+	})
+
+	t.Run("error - nonce rate limited", func(t *testing.T) {
+		// given
+		limitedCA, err := tee.NewAttestCA(
+			ca.cert,
+			ca.key,
+			verifier,
+			tee.WithAttestCAPolicies(newTestAttestCAPolicy(noTEEMeasurement)),
+			tee.WithAttestCANonceRate(0.001, 1),
+		)
+		require.NoError(t, err)
+		_, err = limitedCA.Nonce(ctx, "192.0.2.1")
+		require.NoError(t, err)
+
+		// when
+		_, err = limitedCA.Nonce(ctx, "192.0.2.1")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestCARateLimited)
+	})
+
+	t.Run("error - no measurement policy", func(t *testing.T) {
+		// when
+		_, err = tee.NewAttestCA(ca.cert, ca.key, verifier)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestCA)
+		assert.ErrorContains(t, err, "measurement policy")
+	})
+}
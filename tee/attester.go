@@ -2,12 +2,14 @@ package tee
 
 import (
 	"crypto/sha256"
+	"time"
 
 	"github.com/tahardi/bearclave"
 )
 
 type Attester struct {
-	base bearclave.Attester
+	base     bearclave.Attester
+	platform Platform
 }
 
 func NewAttester(platform Platform) (*Attester, error) {
@@ -23,6 +25,8 @@ func NewAttester(platform Platform) (*Attester, error) {
 		base, err = bearclave.NewTDXAttester()
 	case NoTEE:
 		base, err = bearclave.NewNoTEEAttester()
+	case NitroSim:
+		base, err = bearclave.NewNoTEEAttester()
 	default:
 		return nil, unsupportedPlatformError(string(platform), nil)
 	}
@@ -30,11 +34,11 @@ func NewAttester(platform Platform) (*Attester, error) {
 	if err != nil {
 		return nil, attesterError("making attester", err)
 	}
-	return NewAttesterWithBase(base)
+	return &Attester{base: base, platform: platform}, nil
 }
 
 func NewAttesterWithBase(base bearclave.Attester) (*Attester, error) {
-	return &Attester{base: base}, nil
+	return &Attester{base: base, platform: UnknownPlatform}, nil
 }
 
 func (a *Attester) Close() error {
@@ -47,6 +51,19 @@ type AttestResult struct {
 }
 
 func (a *Attester) Attest(options ...AttestOption) (*AttestResult, error) {
+	start := time.Now()
+	attestResult, err := a.attest(options...)
+
+	labels := Labels{"platform": string(a.platform)}
+	DefaultMetrics.ObserveDuration(MetricAttestDuration, labels, start)
+	if err != nil {
+		labels["class"] = MetricsErrorClass(err)
+		DefaultMetrics.IncCounter(MetricAttestErrors, labels)
+	}
+	return attestResult, err
+}
+
+func (a *Attester) attest(options ...AttestOption) (*AttestResult, error) {
 	opts := MakeDefaultAttestOptions()
 	for _, opt := range options {
 		opt(&opts)
@@ -91,6 +108,14 @@ func WithAttestNonce(nonce []byte) AttestOption {
 	}
 }
 
+// WithAttestPublicKey embeds a DER-encoded public key in the attestation.
+// Only Nitro and NoTEE attestations support it.
+func WithAttestPublicKey(publicKey []byte) AttestOption {
+	return func(opts *AttestOptions) {
+		opts.Base = append(opts.Base, bearclave.WithAttestPublicKey(publicKey))
+	}
+}
+
 func WithAttestUserData(userData []byte) AttestOption {
 	return func(opts *AttestOptions) {
 		opts.UserData = userData
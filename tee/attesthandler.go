@@ -0,0 +1,316 @@
+package tee
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	AttestPath         = "/bearclave/attest"
+	AttestMaxBodySize  = 1 * Megabyte
+	AttestNonceSize    = 32
+	AttestMinNonceSize = 16
+
+	// AttestMaxNonceSize is what SEV and TDX have room for: their 64 bytes of
+	// report data also hold the 32-byte hash of the user data.
+	AttestMaxNonceSize = 32
+)
+
+// AttestRequest asks an enclave to attest to a fresh nonce and, optionally,
+// to user data supplied by the caller.
+type AttestRequest struct {
+	Nonce       []byte `json:"nonce"`
+	UserData    []byte `json:"userdata,omitempty"`
+	ContentType string `json:"contenttype,omitempty"`
+}
+
+type AttestResponse struct {
+	Attestation *AttestResult `json:"attestation"`
+}
+
+// AttestStatement is the user data MakeAttestHandler attests to. The nonce is
+// also passed to WithAttestNonce, so that verifiers can check it, and the
+// freshness it proves, with WithVerifyNonce.
+type AttestStatement struct {
+	Nonce       []byte `json:"nonce"`
+	UserData    []byte `json:"userdata,omitempty"`
+	ContentType string `json:"contenttype,omitempty"`
+}
+
+// AttestUserDataFunc returns the user data an enclave attests to on its own
+// behalf, e.g., its TLS certificate chain, along with its content type.
+type AttestUserDataFunc func(r *http.Request) (userData []byte, contentType string, err error)
+
+// MakeAttestHandler serves a challenge/response attestation endpoint at
+// AttestPath: the caller sends a nonce and gets back an AttestResult whose
+// user data is an AttestStatement. Callers may only supply their own user
+// data if the handler allows it (see WithAttestHandlerMaxUserDataSize).
+func MakeAttestHandler(
+	attester *Attester,
+	logger *slog.Logger,
+	options ...AttestHandlerOption,
+) http.Handler {
+	opts := MakeDefaultAttestHandlerOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+AttestPath, func(w http.ResponseWriter, r *http.Request) {
+		req := AttestRequest{}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, AttestMaxBodySize)).Decode(&req)
+		if err != nil {
+			http.Error(w, attesterError("decoding request", err).Error(), http.StatusBadRequest)
+			return
+		}
+
+		statement, err := makeAttestStatement(r, &req, opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		userData, err := json.Marshal(statement)
+		if err != nil {
+			WriteError(w, attesterError("marshaling statement", err))
+			return
+		}
+
+		attestation, err := attester.Attest(WithAttestUserData(userData), WithAttestNonce(req.Nonce))
+		if err != nil {
+			logger.Error("attesting", slog.String("error", err.Error()))
+			WriteError(w, err)
+			return
+		}
+		WriteResponse(w, AttestResponse{Attestation: attestation})
+	})
+	return mux
+}
+
+func makeAttestStatement(
+	r *http.Request,
+	req *AttestRequest,
+	opts AttestHandlerOptions,
+) (*AttestStatement, error) {
+	switch {
+	case len(req.Nonce) < AttestMinNonceSize || len(req.Nonce) > AttestMaxNonceSize:
+		msg := fmt.Sprintf("nonce must be %d to %d bytes", AttestMinNonceSize, AttestMaxNonceSize)
+		return nil, attesterError(msg, nil)
+	case req.UserData == nil && req.ContentType != "":
+		return nil, attesterError("content type without user data", nil)
+	case req.UserData == nil && opts.UserData == nil:
+		return &AttestStatement{Nonce: req.Nonce}, nil
+	case req.UserData == nil:
+		userData, contentType, err := opts.UserData(r)
+		if err != nil {
+			return nil, attesterError("getting user data", err)
+		}
+		return &AttestStatement{Nonce: req.Nonce, UserData: userData, ContentType: contentType}, nil
+	case len(req.UserData) > opts.MaxUserDataSize:
+		msg := fmt.Sprintf("user data exceeds %d bytes", opts.MaxUserDataSize)
+		return nil, attesterError(msg, nil)
+	}
+
+	if len(opts.ContentTypes) > 0 {
+		mediaType, _, err := mime.ParseMediaType(req.ContentType)
+		if err != nil || !slices.Contains(opts.ContentTypes, mediaType) {
+			msg := fmt.Sprintf("content type '%s' is not allowed", req.ContentType)
+			return nil, attesterError(msg, nil)
+		}
+	}
+	return &AttestStatement{Nonce: req.Nonce, UserData: req.UserData, ContentType: req.ContentType}, nil
+}
+
+type AttestHandlerOption func(*AttestHandlerOptions)
+type AttestHandlerOptions struct {
+	ContentTypes    []string
+	MaxUserDataSize int
+	UserData        AttestUserDataFunc
+}
+
+func MakeDefaultAttestHandlerOptions() AttestHandlerOptions {
+	return AttestHandlerOptions{
+		ContentTypes:    nil,
+		MaxUserDataSize: 0,
+		UserData:        nil,
+	}
+}
+
+// WithAttestHandlerContentTypes only accepts caller user data with one of the
+// given media types, e.g., "application/json".
+func WithAttestHandlerContentTypes(contentTypes ...string) AttestHandlerOption {
+	return func(opts *AttestHandlerOptions) {
+		opts.ContentTypes = append(opts.ContentTypes, contentTypes...)
+	}
+}
+
+// WithAttestHandlerMaxUserDataSize lets callers supply up to size bytes of
+// user data. By default, callers cannot supply user data, since an enclave
+// attesting to arbitrary data lets anyone speak in its name.
+func WithAttestHandlerMaxUserDataSize(size int) AttestHandlerOption {
+	return func(opts *AttestHandlerOptions) {
+		opts.MaxUserDataSize = size
+	}
+}
+
+// WithAttestHandlerUserData sets the user data attested to when the caller
+// does not supply any.
+func WithAttestHandlerUserData(userData AttestUserDataFunc) AttestHandlerOption {
+	return func(opts *AttestHandlerOptions) {
+		opts.UserData = userData
+	}
+}
+
+// AttestationClient fetches attestations from MakeAttestHandler endpoints and
+// verifies them, including the nonce, in one call.
+type AttestationClient struct {
+	client   *http.Client
+	verifier *Verifier
+}
+
+func NewAttestationClient(verifier *Verifier) (*AttestationClient, error) {
+	return NewAttestationClientWithClient(http.DefaultClient, verifier)
+}
+
+func NewAttestationClientWithClient(
+	client *http.Client,
+	verifier *Verifier,
+) (*AttestationClient, error) {
+	if verifier == nil {
+		return nil, verifierError("verifier is required", nil)
+	}
+	return &AttestationClient{client: client, verifier: verifier}, nil
+}
+
+// AttestationClientResult is a verified attestation along with the user data
+// and content type from its AttestStatement.
+type AttestationClientResult struct {
+	Verified    *VerifyResult
+	UserData    []byte
+	ContentType string
+}
+
+// Attest sends a fresh nonce to the attestation endpoint at baseURL and
+// verifies the response with the given options and WithVerifyNonce, which
+// lets WithVerifyMaxAge work on SEV and TDX.
+func (a *AttestationClient) Attest(
+	ctx context.Context,
+	baseURL string,
+	options ...AttestationClientOption,
+) (*AttestationClientResult, error) {
+	opts := MakeDefaultAttestationClientOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	nonce := make([]byte, AttestNonceSize)
+	if _, err := crand.Read(nonce); err != nil {
+		return nil, verifierError("generating nonce", err)
+	}
+	attestation, err := a.fetch(ctx, baseURL, &AttestRequest{
+		Nonce:       nonce,
+		UserData:    opts.UserData,
+		ContentType: opts.ContentType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	verifyOptions := append([]VerifyOption{WithVerifyNonce(nonce)}, opts.Verify...)
+	verified, err := a.verifier.Verify(attestation, verifyOptions...)
+	if err != nil {
+		return nil, err
+	}
+	statement := AttestStatement{}
+	if err = json.Unmarshal(verified.UserData, &statement); err != nil {
+		return nil, verifierError("unmarshaling statement", err)
+	}
+	switch {
+	case !bytes.Equal(statement.Nonce, nonce):
+		return nil, verifierError("statement nonce does not match", nil)
+	case opts.UserData != nil && !bytes.Equal(statement.UserData, opts.UserData):
+		return nil, verifierError("statement user data does not match", nil)
+	}
+	return &AttestationClientResult{
+		Verified:    verified,
+		UserData:    statement.UserData,
+		ContentType: statement.ContentType,
+	}, nil
+}
+
+func (a *AttestationClient) fetch(
+	ctx context.Context,
+	baseURL string,
+	attestReq *AttestRequest,
+) (*AttestResult, error) {
+	body, err := json.Marshal(attestReq)
+	if err != nil {
+		return nil, verifierError("marshaling request", err)
+	}
+	url := strings.TrimSuffix(baseURL, "/") + AttestPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, verifierError("creating request", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, verifierError("sending request", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, AttestMaxBodySize))
+		errMsg := fmt.Sprintf("attestation rejected: %s: %s", resp.Status, bytes.TrimSpace(msg))
+		return nil, verifierError(errMsg, nil)
+	}
+
+	attestResp := AttestResponse{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, AttestMaxBodySize)).Decode(&attestResp)
+	switch {
+	case err != nil:
+		return nil, verifierError("decoding response", err)
+	case attestResp.Attestation == nil:
+		return nil, verifierError("missing attestation", nil)
+	}
+	return attestResp.Attestation, nil
+}
+
+type AttestationClientOption func(*AttestationClientOptions)
+type AttestationClientOptions struct {
+	ContentType string
+	UserData    []byte
+	Verify      []VerifyOption
+}
+
+func MakeDefaultAttestationClientOptions() AttestationClientOptions {
+	return AttestationClientOptions{
+		ContentType: "",
+		UserData:    nil,
+		Verify:      nil,
+	}
+}
+
+// WithAttestationClientUserData asks the enclave to attest to userData, which
+// the endpoint must allow (see WithAttestHandlerMaxUserDataSize).
+func WithAttestationClientUserData(userData []byte, contentType string) AttestationClientOption {
+	return func(opts *AttestationClientOptions) {
+		opts.UserData = userData
+		opts.ContentType = contentType
+	}
+}
+
+func WithAttestationClientVerifyOptions(options ...VerifyOption) AttestationClientOption {
+	return func(opts *AttestationClientOptions) {
+		opts.Verify = append(opts.Verify, options...)
+	}
+}
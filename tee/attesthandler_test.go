@@ -0,0 +1,217 @@
+package tee_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func newTestAttestServer(t *testing.T, options ...tee.AttestHandlerOption) string {
+	t.Helper()
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	server := httptest.NewServer(tee.MakeAttestHandler(attester, slog.New(slog.DiscardHandler), options...))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func newTestAttestationClient(t *testing.T) *tee.AttestationClient {
+	t.Helper()
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+	client, err := tee.NewAttestationClient(verifier)
+	require.NoError(t, err)
+	return client
+}
+
+func TestAttestationClient_Attest(t *testing.T) {
+	ctx := context.Background()
+	client := newTestAttestationClient(t)
+
+	t.Run("happy path - nonce only", func(t *testing.T) {
+		// given
+		serverURL := newTestAttestServer(t)
+
+		// when
+		got, err := client.Attest(
+			ctx,
+			serverURL,
+			tee.WithAttestationClientVerifyOptions(tee.WithVerifyMeasurement(noTEEMeasurement)),
+		)
+
+		// then
+		require.NoError(t, err)
+		assert.Nil(t, got.UserData)
+		assert.NotNil(t, got.Verified)
+	})
+
+	t.Run("happy path - max age", func(t *testing.T) {
+		// given
+		serverURL := newTestAttestServer(t)
+
+		// when
+		got, err := client.Attest(
+			ctx,
+			serverURL,
+			tee.WithAttestationClientVerifyOptions(tee.WithVerifyMaxAge(time.Minute)),
+		)
+
+		// then
+		require.NoError(t, err)
+		assert.NotNil(t, got.Verified)
+	})
+
+	t.Run("happy path - nonce bound by platform", func(t *testing.T) {
+		// given
+		serverURL := newTestAttestServer(t)
+		nonce := bytes.Repeat([]byte{0x01}, tee.AttestNonceSize)
+		body, err := json.Marshal(tee.AttestRequest{Nonce: nonce})
+		require.NoError(t, err)
+		resp, err := http.Post(serverURL+tee.AttestPath, "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		attestResp := tee.AttestResponse{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&attestResp))
+		verifier, err := tee.NewVerifier(tee.NoTEE)
+		require.NoError(t, err)
+
+		// when
+		_, err = verifier.Verify(
+			attestResp.Attestation,
+			tee.WithVerifyNonce(nonce),
+			tee.WithVerifyMaxAge(time.Minute),
+		)
+		_, otherErr := verifier.Verify(
+			attestResp.Attestation,
+			tee.WithVerifyNonce(bytes.Repeat([]byte{0x02}, tee.AttestNonceSize)),
+		)
+
+		// then
+		require.NoError(t, err)
+		require.ErrorIs(t, otherErr, tee.ErrVerifierNonce)
+	})
+
+	t.Run("happy path - server user data", func(t *testing.T) {
+		// given
+		certProvider := newTestSelfSignedCertProvider(t, tee.DefaultValidity)
+		serverURL := newTestAttestServer(t, tee.WithAttestHandlerUserData(
+			func(r *http.Request) ([]byte, string, error) {
+				cert, err := certProvider.GetCert(r.Context())
+				if err != nil {
+					return nil, "", err
+				}
+				chain, err := json.Marshal(cert.Certificate)
+				return chain, "application/json", err
+			},
+		))
+
+		// when
+		got, err := client.Attest(ctx, serverURL)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "application/json", got.ContentType)
+		chain := [][]byte{}
+		require.NoError(t, json.Unmarshal(got.UserData, &chain))
+		cert, err := certProvider.GetCert(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, cert.Certificate, chain)
+	})
+
+	t.Run("happy path - caller user data", func(t *testing.T) {
+		// given
+		serverURL := newTestAttestServer(
+			t,
+			tee.WithAttestHandlerMaxUserDataSize(16),
+			tee.WithAttestHandlerContentTypes("text/plain"),
+		)
+
+		// when
+		got, err := client.Attest(
+			ctx,
+			serverURL,
+			tee.WithAttestationClientUserData([]byte("hello world"), "text/plain; charset=utf-8"),
+		)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, []byte("hello world"), got.UserData)
+		assert.Equal(t, "text/plain; charset=utf-8", got.ContentType)
+	})
+
+	t.Run("error - caller user data not allowed", func(t *testing.T) {
+		// given
+		serverURL := newTestAttestServer(t)
+
+		// when
+		_, err := client.Attest(
+			ctx,
+			serverURL,
+			tee.WithAttestationClientUserData([]byte("hello world"), "text/plain"),
+		)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrVerifier)
+		assert.ErrorContains(t, err, "user data exceeds 0 bytes")
+	})
+
+	t.Run("error - caller user data too long", func(t *testing.T) {
+		// given
+		serverURL := newTestAttestServer(t, tee.WithAttestHandlerMaxUserDataSize(4))
+
+		// when
+		_, err := client.Attest(
+			ctx,
+			serverURL,
+			tee.WithAttestationClientUserData(bytes.Repeat([]byte("a"), 5), ""),
+		)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrVerifier)
+		assert.ErrorContains(t, err, "user data exceeds 4 bytes")
+	})
+
+	t.Run("error - content type not allowed", func(t *testing.T) {
+		// given
+		serverURL := newTestAttestServer(
+			t,
+			tee.WithAttestHandlerMaxUserDataSize(16),
+			tee.WithAttestHandlerContentTypes("application/json"),
+		)
+
+		// when
+		_, err := client.Attest(
+			ctx,
+			serverURL,
+			tee.WithAttestationClientUserData([]byte("hello world"), "text/plain"),
+		)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrVerifier)
+		assert.ErrorContains(t, err, "content type 'text/plain' is not allowed")
+	})
+
+	t.Run("error - wrong measurement", func(t *testing.T) {
+		// given
+		serverURL := newTestAttestServer(t)
+
+		// when
+		_, err := client.Attest(
+			ctx,
+			serverURL,
+			tee.WithAttestationClientVerifyOptions(tee.WithVerifyMeasurement("other")),
+		)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrVerifierMeasurement)
+	})
+}
@@ -0,0 +1,555 @@
+package tee
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+const (
+	AttestResponsesLabel          = "bearclave attest responses v1"
+	AttestResponsesNonceHeader    = "Bearclave-Nonce"
+	AttestResponsesEvidenceHeader = "Bearclave-Attestation"
+	AttestResponsesMaxBodySize    = 10 * Megabyte
+	ContentDigestHeader           = "Content-Digest"
+)
+
+// AttestResponsesHeaders are the response headers AttestResponsesBinding
+// covers. VerifyingTransport drops every other header, apart from the
+// evidence itself, so callers only ever see attested headers.
+var AttestResponsesHeaders = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Location",
+	"Content-Type",
+	"Expires",
+	"Last-Modified",
+	"Link",
+	"Location",
+	"Refresh",
+	"Retry-After",
+	"Set-Cookie",
+	"Vary",
+	"WWW-Authenticate",
+}
+
+// AttestResponsesBinding is the user data AttestResponses attests to. It
+// binds the response status, the AttestResponsesHeaders in header, and the
+// body to the request it answers (method, host, request URI, request body
+// digest, and the caller's nonce).
+func AttestResponsesBinding(
+	method string,
+	host string,
+	requestURI string,
+	requestDigest []byte,
+	nonce []byte,
+	status int,
+	header http.Header,
+	bodyDigest []byte,
+) []byte {
+	hash := sha256.New()
+	hash.Write([]byte(AttestResponsesLabel))
+	hash.Write([]byte{0})
+	hash.Write([]byte(method))
+	hash.Write([]byte{0})
+	hash.Write([]byte(host))
+	hash.Write([]byte{0})
+	hash.Write([]byte(requestURI))
+	hash.Write([]byte{0})
+	hash.Write(requestDigest)
+	hash.Write([]byte(strconv.Itoa(status)))
+	hash.Write([]byte{0})
+	for _, name := range AttestResponsesHeaders {
+		values := header.Values(name)
+		hash.Write([]byte(name))
+		hash.Write([]byte{0})
+		hash.Write([]byte(strconv.Itoa(len(values))))
+		hash.Write([]byte{0})
+		for _, value := range values {
+			hash.Write([]byte(value))
+			hash.Write([]byte{0})
+		}
+	}
+	hash.Write(bodyDigest)
+	hash.Write(nonce)
+	return hash.Sum(nil)
+}
+
+// ContentDigest formats a SHA-256 body digest as a Content-Digest header
+// value (RFC 9530).
+func ContentDigest(bodyDigest []byte) string {
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(bodyDigest) + ":"
+}
+
+// AttestResponses attests every response next writes to a request carrying
+// an AttestResponsesNonceHeader, so that existing APIs become attested without
+// changing their payloads. The attestation is sent base64-encoded in the
+// AttestResponsesEvidenceHeader, alongside a Content-Digest, and checked by a
+// VerifyingTransport. Requests without a nonce are passed through unattested.
+//
+// Request bodies are read in full (see WithAttestResponsesMaxBodySize) so that
+// their digest can be bound too. By default, responses are also buffered so
+// that the evidence can go in the headers. With WithAttestResponsesTrailer,
+// responses are streamed and the evidence is sent in trailers instead.
+func AttestResponses(
+	next http.Handler,
+	attester *Attester,
+	options ...AttestResponsesOption,
+) http.Handler {
+	opts := MakeDefaultAttestResponsesOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(AttestResponsesNonceHeader)
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		nonce, err := base64.StdEncoding.DecodeString(header)
+		if err != nil || len(nonce) < AttestMinNonceSize || len(nonce) > AttestMaxNonceSize {
+			msg := fmt.Sprintf("nonce must be %d to %d base64-encoded bytes", AttestMinNonceSize, AttestMaxNonceSize)
+			http.Error(w, attestResponsesError(msg, nil).Error(), http.StatusBadRequest)
+			return
+		}
+
+		requestBody, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(opts.MaxBodySize)))
+		if err != nil {
+			msg := fmt.Sprintf("request exceeds %d bytes", opts.MaxBodySize)
+			http.Error(w, attestResponsesError(msg, nil).Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(requestBody))
+		requestDigest := sha256.Sum256(requestBody)
+
+		attest := func(status int, header http.Header, bodyDigest []byte) (string, error) {
+			binding := AttestResponsesBinding(
+				r.Method,
+				r.Host,
+				r.URL.RequestURI(),
+				requestDigest[:],
+				nonce,
+				status,
+				header,
+				bodyDigest,
+			)
+			attestation, err := attester.Attest(
+				WithAttestUserData(binding),
+				WithAttestNonce(nonce),
+			)
+			if err != nil {
+				return "", attestResponsesError("attesting response", err)
+			}
+			evidence, err := json.Marshal(attestation)
+			if err != nil {
+				return "", attestResponsesError("marshaling attestation", err)
+			}
+			return base64.StdEncoding.EncodeToString(evidence), nil
+		}
+
+		if opts.Trailer {
+			serveAttestResponseTrailer(w, r, next, attest, opts.Logger)
+			return
+		}
+		serveAttestResponseBuffered(w, r, next, attest, opts)
+	})
+}
+
+func serveAttestResponseBuffered(
+	w http.ResponseWriter,
+	r *http.Request,
+	next http.Handler,
+	attest func(int, http.Header, []byte) (string, error),
+	opts AttestResponsesOptions,
+) {
+	buffer := &bufferedResponseWriter{header: http.Header{}, maxBodySize: opts.MaxBodySize}
+	next.ServeHTTP(buffer, r)
+	if buffer.tooLarge {
+		msg := fmt.Sprintf("response exceeds %d bytes", opts.MaxBodySize)
+		opts.Logger.Error("attesting response", slog.String("error", msg))
+		WriteError(w, attestResponsesError(msg, nil))
+		return
+	}
+	if buffer.status == 0 {
+		buffer.status = http.StatusOK
+	}
+
+	// net/http discards the body of responses to HEAD requests.
+	body := buffer.body.Bytes()
+	if r.Method == http.MethodHead {
+		body = nil
+	}
+	// Sniff the content type as net/http would, so that it is attested too.
+	if _, ok := buffer.header["Content-Type"]; !ok && len(body) > 0 {
+		buffer.header.Set("Content-Type", http.DetectContentType(body))
+	}
+	bodyDigest := sha256.Sum256(body)
+	evidence, err := attest(buffer.status, buffer.header, bodyDigest[:])
+	if err != nil {
+		opts.Logger.Error("attesting response", slog.String("error", err.Error()))
+		WriteError(w, err)
+		return
+	}
+
+	for key, values := range buffer.header {
+		w.Header()[key] = values
+	}
+	w.Header().Set(ContentDigestHeader, ContentDigest(bodyDigest[:]))
+	w.Header().Set(AttestResponsesEvidenceHeader, evidence)
+	if r.Method != http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.WriteHeader(buffer.status)
+	_, _ = w.Write(body)
+}
+
+func serveAttestResponseTrailer(
+	w http.ResponseWriter,
+	r *http.Request,
+	next http.Handler,
+	attest func(int, http.Header, []byte) (string, error),
+	logger *slog.Logger,
+) {
+	stream := &trailerResponseWriter{ResponseWriter: w, hash: sha256.New()}
+	next.ServeHTTP(stream, r)
+	if !stream.wroteHeader {
+		stream.WriteHeader(http.StatusOK)
+	}
+
+	// Once the headers are sent, there is no way to report an error to the
+	// client, which fails verification when the trailers are missing.
+	bodyDigest := stream.hash.Sum(nil)
+	if r.Method == http.MethodHead {
+		empty := sha256.Sum256(nil)
+		bodyDigest = empty[:]
+	}
+	evidence, err := attest(stream.status, stream.sentHeader, bodyDigest)
+	if err != nil {
+		logger.Error("attesting response", slog.String("error", err.Error()))
+		return
+	}
+	w.Header().Set(ContentDigestHeader, ContentDigest(bodyDigest))
+	w.Header().Set(AttestResponsesEvidenceHeader, evidence)
+}
+
+type bufferedResponseWriter struct {
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	maxBodySize int
+	tooLarge    bool
+}
+
+func (b *bufferedResponseWriter) Header() http.Header { return b.header }
+
+func (b *bufferedResponseWriter) WriteHeader(status int) {
+	if b.status == 0 && status >= http.StatusOK {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponseWriter) Write(data []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	if b.body.Len()+len(data) > b.maxBodySize {
+		b.tooLarge = true
+		return 0, attestResponsesError("response too large", nil)
+	}
+	return b.body.Write(data)
+}
+
+type trailerResponseWriter struct {
+	http.ResponseWriter
+
+	hash        hash.Hash
+	sentHeader  http.Header
+	status      int
+	wroteHeader bool
+}
+
+func (t *trailerResponseWriter) WriteHeader(status int) {
+	if t.wroteHeader || status < http.StatusOK {
+		t.ResponseWriter.WriteHeader(status)
+		return
+	}
+	t.status = status
+	t.wroteHeader = true
+
+	// Trailers are only sent with chunked responses.
+	header := t.ResponseWriter.Header()
+	header.Del("Content-Length")
+	header.Add("Trailer", ContentDigestHeader)
+	header.Add("Trailer", AttestResponsesEvidenceHeader)
+	// The content type cannot be sniffed before it is attested, so a nil
+	// value stops net/http from adding one after the fact.
+	if _, ok := header["Content-Type"]; !ok {
+		header["Content-Type"] = nil
+	}
+	t.sentHeader = header.Clone()
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *trailerResponseWriter) Write(data []byte) (int, error) {
+	if !t.wroteHeader {
+		t.WriteHeader(http.StatusOK)
+	}
+	n, err := t.ResponseWriter.Write(data)
+	t.hash.Write(data[:n])
+	return n, err
+}
+
+func (t *trailerResponseWriter) Unwrap() http.ResponseWriter { return t.ResponseWriter }
+
+type AttestResponsesOption func(*AttestResponsesOptions)
+type AttestResponsesOptions struct {
+	Logger      *slog.Logger
+	MaxBodySize int
+	Trailer     bool
+}
+
+func MakeDefaultAttestResponsesOptions() AttestResponsesOptions {
+	return AttestResponsesOptions{
+		Logger:      slog.New(slog.DiscardHandler),
+		MaxBodySize: AttestResponsesMaxBodySize,
+		Trailer:     false,
+	}
+}
+
+func WithAttestResponsesLogger(logger *slog.Logger) AttestResponsesOption {
+	return func(opts *AttestResponsesOptions) {
+		opts.Logger = logger
+	}
+}
+
+// WithAttestResponsesMaxBodySize limits the size of request bodies and of
+// buffered responses. Larger requests are rejected and larger responses are
+// replaced by an error. Streamed responses are not limited (see
+// WithAttestResponsesTrailer).
+func WithAttestResponsesMaxBodySize(size int) AttestResponsesOption {
+	return func(opts *AttestResponsesOptions) {
+		opts.MaxBodySize = size
+	}
+}
+
+// WithAttestResponsesTrailer streams responses and sends the evidence in
+// trailers, which suits large or long-lived responses. Handlers must not rely
+// on Content-Length, since trailers require chunked encoding.
+func WithAttestResponsesTrailer() AttestResponsesOption {
+	return func(opts *AttestResponsesOptions) {
+		opts.Trailer = true
+	}
+}
+
+// VerifyingTransport is an http.RoundTripper that sends a fresh nonce with
+// every request and only returns responses whose AttestResponses evidence
+// verifies against one of the allowed measurements. Responses are read in
+// full before they are returned, and stripped of headers the evidence does
+// not cover (see AttestResponsesHeaders). Request bodies are read in full too,
+// so that the evidence can be checked against their digest.
+type VerifyingTransport struct {
+	base     http.RoundTripper
+	verifier *Verifier
+	opts     VerifyingTransportOptions
+}
+
+func NewVerifyingTransport(
+	verifier *Verifier,
+	options ...VerifyingTransportOption,
+) (*VerifyingTransport, error) {
+	return NewVerifyingTransportWithBase(http.DefaultTransport, verifier, options...)
+}
+
+func NewVerifyingTransportWithBase(
+	base http.RoundTripper,
+	verifier *Verifier,
+	options ...VerifyingTransportOption,
+) (*VerifyingTransport, error) {
+	opts := MakeDefaultVerifyingTransportOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	switch {
+	case base == nil:
+		return nil, attestResponsesError("base transport is required", nil)
+	case verifier == nil:
+		return nil, attestResponsesError("verifier is required", nil)
+	case len(opts.Measurements) == 0:
+		return nil, attestResponsesError("at least one measurement is required", nil)
+	}
+	return &VerifyingTransport{base: base, verifier: verifier, opts: opts}, nil
+}
+
+func (v *VerifyingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	nonce := make([]byte, AttestNonceSize)
+	if _, err := crand.Read(nonce); err != nil {
+		return nil, attestResponsesError("generating nonce", err)
+	}
+	// A RoundTripper must not modify the request it is given.
+	req, requestBody, err := v.cloneRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(AttestResponsesNonceHeader, base64.StdEncoding.EncodeToString(nonce))
+
+	resp, err := v.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(v.opts.MaxBodySize)+1))
+	switch {
+	case err != nil:
+		return nil, attestResponsesError("reading response", err)
+	case len(body) > v.opts.MaxBodySize:
+		msg := fmt.Sprintf("response exceeds %d bytes", v.opts.MaxBodySize)
+		return nil, attestResponsesError(msg, nil)
+	}
+
+	evidence := resp.Header.Get(AttestResponsesEvidenceHeader)
+	if evidence == "" {
+		evidence = resp.Trailer.Get(AttestResponsesEvidenceHeader)
+	}
+	if evidence == "" {
+		return nil, attestResponsesError("missing attestation: "+resp.Status, nil)
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	requestDigest := sha256.Sum256(requestBody)
+	bodyDigest := sha256.Sum256(body)
+	binding := AttestResponsesBinding(
+		req.Method,
+		host,
+		req.URL.RequestURI(),
+		requestDigest[:],
+		nonce,
+		resp.StatusCode,
+		resp.Header,
+		bodyDigest[:],
+	)
+	if err = v.verify(evidence, binding, nonce); err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	for _, name := range AttestResponsesHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			header[name] = values
+		}
+	}
+	header.Set(ContentDigestHeader, ContentDigest(bodyDigest[:]))
+	header.Set(AttestResponsesEvidenceHeader, evidence)
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header = header
+	resp.Trailer = nil
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return resp, nil
+}
+
+// cloneRequest clones req and reads its body, which the clone is given a
+// fresh copy of.
+func (v *VerifyingTransport) cloneRequest(req *http.Request) (*http.Request, []byte, error) {
+	clone := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return clone, nil, nil
+	}
+	defer req.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, int64(v.opts.MaxBodySize)+1))
+	switch {
+	case err != nil:
+		return nil, nil, attestResponsesError("reading request", err)
+	case len(body) > v.opts.MaxBodySize:
+		msg := fmt.Sprintf("request exceeds %d bytes", v.opts.MaxBodySize)
+		return nil, nil, attestResponsesError(msg, nil)
+	}
+	clone.Body = io.NopCloser(bytes.NewReader(body))
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	clone.ContentLength = int64(len(body))
+	return clone, body, nil
+}
+
+func (v *VerifyingTransport) verify(evidence string, binding []byte, nonce []byte) error {
+	data, err := base64.StdEncoding.DecodeString(evidence)
+	if err != nil {
+		return attestResponsesError("decoding attestation", err)
+	}
+	attestation := AttestResult{}
+	if err = json.Unmarshal(data, &attestation); err != nil {
+		return attestResponsesError("unmarshaling attestation", err)
+	}
+
+	var errs []error
+	for _, measurement := range v.opts.Measurements {
+		options := append(
+			[]VerifyOption{WithVerifyNonce(nonce), WithVerifyMeasurement(measurement)},
+			v.opts.Verify...,
+		)
+		verifyResult, err := v.verifier.Verify(&attestation, options...)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !bytes.Equal(verifyResult.UserData, binding) {
+			return attestResponsesError("attestation is not bound to this response", nil)
+		}
+		return nil
+	}
+	return attestResponsesError("verifying attestation", errors.Join(errs...))
+}
+
+type VerifyingTransportOption func(*VerifyingTransportOptions)
+type VerifyingTransportOptions struct {
+	MaxBodySize  int
+	Measurements []string
+	Verify       []VerifyOption
+}
+
+func MakeDefaultVerifyingTransportOptions() VerifyingTransportOptions {
+	return VerifyingTransportOptions{
+		MaxBodySize:  AttestResponsesMaxBodySize,
+		Measurements: nil,
+		Verify:       nil,
+	}
+}
+
+// WithVerifyingTransportMaxBodySize limits the size of the request and
+// response bodies the transport reads in full.
+func WithVerifyingTransportMaxBodySize(size int) VerifyingTransportOption {
+	return func(opts *VerifyingTransportOptions) {
+		opts.MaxBodySize = size
+	}
+}
+
+// WithVerifyingTransportMeasurements only accepts responses from enclaves
+// running one of the given measurements. At least one is required.
+func WithVerifyingTransportMeasurements(measurements ...string) VerifyingTransportOption {
+	return func(opts *VerifyingTransportOptions) {
+		opts.Measurements = append(opts.Measurements, measurements...)
+	}
+}
+
+func WithVerifyingTransportVerifyOptions(options ...VerifyOption) VerifyingTransportOption {
+	return func(opts *VerifyingTransportOptions) {
+		opts.Verify = append(opts.Verify, options...)
+	}
+}
@@ -0,0 +1,334 @@
+package tee_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func newTestAttestResponsesServer(
+	t *testing.T,
+	handler http.Handler,
+	options ...tee.AttestResponsesOption,
+) *httptest.Server {
+	t.Helper()
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	server := httptest.NewServer(tee.AttestResponses(handler, attester, options...))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestVerifyingClient(
+	t *testing.T,
+	server *httptest.Server,
+	options ...tee.VerifyingTransportOption,
+) *http.Client {
+	t.Helper()
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+	options = append([]tee.VerifyingTransportOption{
+		tee.WithVerifyingTransportMeasurements(noTEEMeasurement),
+	}, options...)
+	transport, err := tee.NewVerifyingTransportWithBase(server.Client().Transport, verifier, options...)
+	require.NoError(t, err)
+	return &http.Client{Transport: transport}
+}
+
+func TestVerifyingTransport_RoundTrip(t *testing.T) {
+	hello := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Unattested", "yes")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, "hello "+r.URL.Query().Get("name"))
+	})
+
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.Copy(w, r.Body)
+	})
+
+	t.Run("happy path - headers", func(t *testing.T) {
+		// given
+		server := newTestAttestResponsesServer(t, hello)
+		client := newTestVerifyingClient(t, server)
+
+		// when
+		resp, err := client.Post(server.URL+"/greet?name=world", "text/plain", nil)
+
+		// then
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, "hello world", string(body))
+		assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+		assert.NotEmpty(t, resp.Header.Get(tee.ContentDigestHeader))
+		assert.Empty(t, resp.Header.Get("X-Unattested"))
+	})
+
+	t.Run("happy path - request body", func(t *testing.T) {
+		// given
+		server := newTestAttestResponsesServer(t, echo)
+		client := newTestVerifyingClient(t, server)
+
+		// when
+		resp, err := client.Post(server.URL+"/echo", "text/plain", strings.NewReader("ping"))
+
+		// then
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(body))
+	})
+
+	t.Run("happy path - sniffed content type", func(t *testing.T) {
+		// given
+		server := newTestAttestResponsesServer(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, "<html></html>")
+		}))
+		client := newTestVerifyingClient(t, server)
+
+		// when
+		resp, err := client.Get(server.URL)
+
+		// then
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	})
+
+	t.Run("happy path - trailers", func(t *testing.T) {
+		// given
+		server := newTestAttestResponsesServer(t, hello, tee.WithAttestResponsesTrailer())
+		client := newTestVerifyingClient(t, server)
+
+		// when
+		resp, err := client.Get(server.URL + "/greet?name=trailers")
+
+		// then
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello trailers", string(body))
+		assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+		assert.NotEmpty(t, resp.Header.Get(tee.AttestResponsesEvidenceHeader))
+		assert.Empty(t, resp.Header.Get("X-Unattested"))
+	})
+
+	t.Run("happy path - plain clients are not attested", func(t *testing.T) {
+		// given
+		server := newTestAttestResponsesServer(t, hello)
+
+		// when
+		resp, err := server.Client().Get(server.URL + "/greet")
+
+		// then
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Empty(t, resp.Header.Get(tee.AttestResponsesEvidenceHeader))
+	})
+
+	t.Run("error - body modified in transit", func(t *testing.T) {
+		// given
+		server := newTestAttestResponsesServer(t, hello)
+		tamper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req, err := http.NewRequestWithContext(r.Context(), r.Method, server.URL+r.URL.RequestURI(), nil)
+			assert.NoError(t, err)
+			req.Header = r.Header.Clone()
+			req.Host = r.Host
+			resp, err := server.Client().Do(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			for key, values := range resp.Header {
+				w.Header()[key] = values
+			}
+			w.Header().Del("Content-Length")
+			w.WriteHeader(resp.StatusCode)
+			_, _ = io.WriteString(w, strings.ToUpper(string(body)))
+		}))
+		defer tamper.Close()
+		client := newTestVerifyingClient(t, tamper)
+
+		// when
+		_, err := client.Get(tamper.URL + "/greet?name=world")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestResponses)
+		assert.ErrorContains(t, err, "not bound to this response")
+	})
+
+	t.Run("error - header modified in transit", func(t *testing.T) {
+		// given
+		server := newTestAttestResponsesServer(t, hello)
+		tamper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req, err := http.NewRequestWithContext(r.Context(), r.Method, server.URL+r.URL.RequestURI(), nil)
+			assert.NoError(t, err)
+			req.Header = r.Header.Clone()
+			req.Host = r.Host
+			resp, err := server.Client().Do(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+
+			for key, values := range resp.Header {
+				w.Header()[key] = values
+			}
+			w.Header().Set("Location", "https://attacker.example")
+			w.WriteHeader(resp.StatusCode)
+			_, _ = io.Copy(w, resp.Body)
+		}))
+		defer tamper.Close()
+		client := newTestVerifyingClient(t, tamper)
+
+		// when
+		_, err := client.Get(tamper.URL + "/greet?name=world")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestResponses)
+		assert.ErrorContains(t, err, "not bound to this response")
+	})
+
+	t.Run("error - request body modified in transit", func(t *testing.T) {
+		// given
+		server := newTestAttestResponsesServer(t, hello)
+		tamper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req, err := http.NewRequestWithContext(
+				r.Context(),
+				r.Method,
+				server.URL+r.URL.RequestURI(),
+				strings.NewReader("tampered"),
+			)
+			assert.NoError(t, err)
+			req.Header = r.Header.Clone()
+			req.Header.Del("Content-Length")
+			req.Host = r.Host
+			resp, err := server.Client().Do(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+
+			for key, values := range resp.Header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(resp.StatusCode)
+			_, _ = io.Copy(w, resp.Body)
+		}))
+		defer tamper.Close()
+		client := newTestVerifyingClient(t, tamper)
+
+		// when
+		_, err := client.Post(tamper.URL+"/greet?name=world", "text/plain", strings.NewReader("original"))
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestResponses)
+		assert.ErrorContains(t, err, "not bound to this response")
+	})
+
+	t.Run("error - request sent to another host", func(t *testing.T) {
+		// given
+		server := newTestAttestResponsesServer(t, hello)
+		tamper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req, err := http.NewRequestWithContext(r.Context(), r.Method, server.URL+r.URL.RequestURI(), nil)
+			assert.NoError(t, err)
+			req.Header = r.Header.Clone()
+			resp, err := server.Client().Do(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+
+			for key, values := range resp.Header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(resp.StatusCode)
+			_, _ = io.Copy(w, resp.Body)
+		}))
+		defer tamper.Close()
+		client := newTestVerifyingClient(t, tamper)
+
+		// when
+		_, err := client.Get(tamper.URL + "/greet?name=world")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestResponses)
+		assert.ErrorContains(t, err, "not bound to this response")
+	})
+
+	t.Run("error - missing attestation", func(t *testing.T) {
+		// given
+		server := httptest.NewServer(hello)
+		defer server.Close()
+		client := newTestVerifyingClient(t, server)
+
+		// when
+		_, err := client.Get(server.URL + "/greet")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestResponses)
+		assert.ErrorContains(t, err, "missing attestation")
+	})
+
+	t.Run("error - wrong measurement", func(t *testing.T) {
+		// given
+		server := newTestAttestResponsesServer(t, hello)
+		verifier, err := tee.NewVerifier(tee.NoTEE)
+		require.NoError(t, err)
+		transport, err := tee.NewVerifyingTransportWithBase(
+			server.Client().Transport,
+			verifier,
+			tee.WithVerifyingTransportMeasurements("other"),
+		)
+		require.NoError(t, err)
+		client := &http.Client{Transport: transport}
+
+		// when
+		_, err = client.Get(server.URL + "/greet")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrVerifierMeasurement)
+	})
+
+	t.Run("error - response too large to buffer", func(t *testing.T) {
+		// given
+		server := newTestAttestResponsesServer(t, hello, tee.WithAttestResponsesMaxBodySize(4))
+		client := newTestVerifyingClient(t, server)
+
+		// when
+		_, err := client.Get(server.URL + "/greet")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestResponses)
+		assert.ErrorContains(t, err, "missing attestation: 500")
+	})
+}
+
+func TestNewVerifyingTransport(t *testing.T) {
+	t.Run("error - no measurement", func(t *testing.T) {
+		// given
+		verifier, err := tee.NewVerifier(tee.NoTEE)
+		require.NoError(t, err)
+
+		// when
+		_, err = tee.NewVerifyingTransport(verifier)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrAttestResponses)
+		assert.ErrorContains(t, err, "measurement")
+	})
+}
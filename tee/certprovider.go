@@ -29,6 +29,12 @@ type CertProvider interface {
 	RotateCert(ctx context.Context) error
 }
 
+// KeyRotator is implemented by cert providers that can replace their private
+// key along with their certificate.
+type KeyRotator interface {
+	RotateKey(ctx context.Context) error
+}
+
 type SelfSignedCertProvider struct {
 	mu         sync.Mutex
 	cert       *tls.Certificate
@@ -83,8 +89,29 @@ func (s *SelfSignedCertProvider) RotateCert(
 ) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.rotate(s.privateKey)
+}
+
+// RotateKey generates a new private key of the same type as the current one
+// and issues a certificate for it.
+func (s *SelfSignedCertProvider) RotateKey(
+	_ context.Context,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	privateKey, err := GenerateKeyLike(s.privateKey)
+	if err != nil {
+		return err
+	}
+	return s.rotate(privateKey)
+}
+
+// rotate must be called with the lock held so that concurrent rotations
+// cannot pair a certificate with a key other than the one it was issued for.
+func (s *SelfSignedCertProvider) rotate(privateKey crypto.PrivateKey) error {
 	cert, err := GenerateSelfSignedCert(
-		s.privateKey,
+		privateKey,
 		s.domain,
 		s.ip,
 		s.validity,
@@ -93,6 +120,7 @@ func (s *SelfSignedCertProvider) RotateCert(
 		return err
 	}
 	s.cert = cert
+	s.privateKey = privateKey
 	return nil
 }
 
@@ -132,9 +160,15 @@ func GenerateSelfSignedCert(
 		return nil, certProviderError("creating certificate", err)
 	}
 
+	leaf, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, certProviderError("parsing certificate", err)
+	}
+
 	return &tls.Certificate{
 		Certificate: [][]byte{certDER},
 		PrivateKey:  privateKey,
+		Leaf:        leaf,
 	}, nil
 }
 
@@ -151,3 +185,23 @@ func GetPublicKey(privateKey crypto.PrivateKey) (crypto.PublicKey, error) {
 		return nil, certProviderError(msg, nil)
 	}
 }
+
+func GenerateKeyLike(privateKey crypto.PrivateKey) (crypto.PrivateKey, error) {
+	var newKey crypto.PrivateKey
+	var err error
+	switch pk := privateKey.(type) {
+	case *rsa.PrivateKey:
+		newKey, err = rsa.GenerateKey(crand.Reader, pk.N.BitLen())
+	case *ecdsa.PrivateKey:
+		newKey, err = ecdsa.GenerateKey(pk.Curve, crand.Reader)
+	case ed25519.PrivateKey:
+		_, newKey, err = ed25519.GenerateKey(crand.Reader)
+	default:
+		msg := fmt.Sprintf("unsupported private key type: %T", privateKey)
+		return nil, certProviderError(msg, nil)
+	}
+	if err != nil {
+		return nil, certProviderError("generating private key", err)
+	}
+	return newKey, nil
+}
@@ -10,6 +10,7 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -43,6 +44,10 @@ func TestSelfSignedCertProvider_Interfaces(t *testing.T) {
 	t.Run("CertProvider", func(_ *testing.T) {
 		var _ tee.CertProvider = &tee.SelfSignedCertProvider{}
 	})
+
+	t.Run("KeyRotator", func(_ *testing.T) {
+		var _ tee.KeyRotator = &tee.SelfSignedCertProvider{}
+	})
 }
 
 func TestNewSelfSignedCertProvider_GetCert(t *testing.T) {
@@ -101,6 +106,68 @@ func TestNewSelfSignedCertProvider_RotateCert(t *testing.T) {
 	})
 }
 
+func TestNewSelfSignedCertProvider_RotateKey(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		privateKey := newTestECDSAPrivateKey(t)
+		certProvider, err := tee.NewSelfSignedCertProviderWithKey(
+			privateKey,
+			tee.DefaultDomain,
+			tee.DefaultIP,
+			tee.DefaultValidity,
+		)
+		require.NoError(t, err)
+
+		// when
+		err = certProvider.RotateKey(ctx)
+		require.NoError(t, err)
+
+		// then
+		newCert, err := certProvider.GetCert(ctx)
+		require.NoError(t, err)
+		newKey, ok := newCert.PrivateKey.(*ecdsa.PrivateKey)
+		require.True(t, ok)
+		assert.Equal(t, privateKey.Curve, newKey.Curve)
+		assert.False(t, privateKey.Equal(newKey))
+		assert.Equal(t, newKey.Public(), newCert.Leaf.PublicKey)
+	})
+
+	t.Run("happy path - concurrent with rotate cert", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		certProvider, err := tee.NewSelfSignedCertProvider(
+			tee.DefaultDomain,
+			tee.DefaultIP,
+			tee.DefaultValidity,
+		)
+		require.NoError(t, err)
+
+		// when
+		wg := sync.WaitGroup{}
+		for range 8 {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, certProvider.RotateKey(ctx))
+			}()
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, certProvider.RotateCert(ctx))
+			}()
+		}
+		wg.Wait()
+
+		// then
+		cert, err := certProvider.GetCert(ctx)
+		require.NoError(t, err)
+		require.NoError(t, certProvider.RotateCert(ctx))
+		rotated, err := certProvider.GetCert(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, cert.PrivateKey, rotated.PrivateKey)
+	})
+}
+
 func TestGenerateSelfSignedCert(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		// given
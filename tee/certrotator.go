@@ -0,0 +1,260 @@
+package tee
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCertRotationFraction = 2.0 / 3.0
+	DefaultCertRotationRetry    = time.Minute
+	DefaultCertRotationTimeout  = 5 * time.Minute
+)
+
+// CertRotationEvent describes a completed rotation. KeyRotated reports
+// whether New was issued for a fresh private key, in which case any
+// attestation evidence bound to the old key must be refreshed.
+type CertRotationEvent struct {
+	Old        *tls.Certificate
+	New        *tls.Certificate
+	KeyRotated bool
+}
+
+// CertRotationHook runs after a new certificate is ready but before it is
+// served. Returning an error aborts the rotation and keeps the old
+// certificate in place until the next attempt.
+type CertRotationHook func(ctx context.Context, event CertRotationEvent) error
+
+// CertRotator wraps a CertProvider and rotates its certificate once a
+// configurable fraction of the certificate's lifetime has elapsed. GetCert
+// always returns the last certificate that completed rotation, so callers
+// keep getting the old certificate while a new one is being issued.
+type CertRotator struct {
+	mu        sync.Mutex
+	rotateMu  sync.Mutex
+	provider  CertProvider
+	cert      *tls.Certificate
+	opts      CertRotatorOptions
+	rotated   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewCertRotator(
+	ctx context.Context,
+	provider CertProvider,
+	options ...CertRotatorOption,
+) (*CertRotator, error) {
+	opts := MakeDefaultCertRotatorOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	if opts.Fraction <= 0 || opts.Fraction > 1 {
+		return nil, certProviderError("rotation fraction must be in (0, 1]", nil)
+	}
+	if _, ok := provider.(KeyRotator); opts.FreshKey && !ok {
+		return nil, certProviderError("provider does not support key rotation", nil)
+	}
+
+	cert, err := getLeafCert(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+	return &CertRotator{
+		provider: provider,
+		cert:     cert,
+		opts:     opts,
+		rotated:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func (r *CertRotator) GetCert(_ context.Context) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
+
+// RotateCert rotates the certificate immediately, and with it the key if
+// WithCertRotatorFreshKey is set.
+func (r *CertRotator) RotateCert(ctx context.Context) error {
+	r.rotateMu.Lock()
+	defer r.rotateMu.Unlock()
+
+	var err error
+	if r.opts.FreshKey {
+		err = r.provider.(KeyRotator).RotateKey(ctx)
+	} else {
+		err = r.provider.RotateCert(ctx)
+	}
+	if err != nil {
+		return certProviderError("rotating certificate", err)
+	}
+
+	cert, err := getLeafCert(ctx, r.provider)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	event := CertRotationEvent{Old: r.cert, New: cert, KeyRotated: r.opts.FreshKey}
+	r.mu.Unlock()
+	for _, hook := range r.opts.Hooks {
+		if err = hook(ctx, event); err != nil {
+			return certProviderError("running rotation hook", err)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = cert
+	r.mu.Unlock()
+
+	select {
+	case r.rotated <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// NextRotation returns when the current certificate is due for rotation.
+func (r *CertRotator) NextRotation() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	leaf := r.cert.Leaf
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	return leaf.NotBefore.Add(time.Duration(float64(lifetime) * r.opts.Fraction))
+}
+
+// Serve rotates the certificate on schedule until Close is called. Failed
+// rotations are retried every RetryInterval.
+func (r *CertRotator) Serve() error {
+	var retryAt time.Time
+	for {
+		next := r.NextRotation()
+		if !retryAt.IsZero() {
+			next = retryAt
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-r.done:
+			timer.Stop()
+			return nil
+		case <-r.rotated:
+			timer.Stop()
+			retryAt = time.Time{}
+			continue
+		case <-timer.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultCertRotationTimeout)
+		err := r.RotateCert(ctx)
+		cancel()
+		if err != nil {
+			retryAt = time.Now().Add(r.opts.RetryInterval)
+			r.opts.Logger.Error(
+				"rotating certificate",
+				slog.String("error", err.Error()),
+				slog.Time("retry", retryAt),
+			)
+			continue
+		}
+
+		// Drop our own rotation signal, and guard against spinning on a
+		// provider whose new certificate is already due (e.g., because it
+		// returned the old one).
+		select {
+		case <-r.rotated:
+		default:
+		}
+		retryAt = time.Time{}
+		next = r.NextRotation()
+		if !next.After(time.Now()) {
+			retryAt = time.Now().Add(r.opts.RetryInterval)
+			next = retryAt
+		}
+		r.opts.Logger.Info("rotated certificate", slog.Time("next", next))
+	}
+}
+
+func (r *CertRotator) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	return nil
+}
+
+func getLeafCert(ctx context.Context, provider CertProvider) (*tls.Certificate, error) {
+	cert, err := provider.GetCert(ctx)
+	if err != nil {
+		return nil, certProviderError("getting certificate", err)
+	}
+	if cert.Leaf != nil {
+		return cert, nil
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, certProviderError("parsing certificate", err)
+	}
+	withLeaf := *cert
+	withLeaf.Leaf = leaf
+	return &withLeaf, nil
+}
+
+type CertRotatorOption func(*CertRotatorOptions)
+type CertRotatorOptions struct {
+	Fraction      float64
+	FreshKey      bool
+	Hooks         []CertRotationHook
+	Logger        *slog.Logger
+	RetryInterval time.Duration
+}
+
+func MakeDefaultCertRotatorOptions() CertRotatorOptions {
+	return CertRotatorOptions{
+		Fraction:      DefaultCertRotationFraction,
+		FreshKey:      false,
+		Hooks:         nil,
+		Logger:        slog.New(slog.DiscardHandler),
+		RetryInterval: DefaultCertRotationRetry,
+	}
+}
+
+// WithCertRotatorFraction sets the fraction of a certificate's lifetime after
+// which it is rotated, e.g., 0.5 rotates a one-year certificate after six
+// months.
+func WithCertRotatorFraction(fraction float64) CertRotatorOption {
+	return func(opts *CertRotatorOptions) {
+		opts.Fraction = fraction
+	}
+}
+
+// WithCertRotatorFreshKey generates a new private key on every rotation. The
+// wrapped provider must implement KeyRotator.
+func WithCertRotatorFreshKey() CertRotatorOption {
+	return func(opts *CertRotatorOptions) {
+		opts.FreshKey = true
+	}
+}
+
+func WithCertRotatorHook(hook CertRotationHook) CertRotatorOption {
+	return func(opts *CertRotatorOptions) {
+		opts.Hooks = append(opts.Hooks, hook)
+	}
+}
+
+func WithCertRotatorLogger(logger *slog.Logger) CertRotatorOption {
+	return func(opts *CertRotatorOptions) {
+		opts.Logger = logger
+	}
+}
+
+func WithCertRotatorRetryInterval(interval time.Duration) CertRotatorOption {
+	return func(opts *CertRotatorOptions) {
+		opts.RetryInterval = interval
+	}
+}
@@ -0,0 +1,221 @@
+package tee_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func newTestSelfSignedCertProvider(
+	t *testing.T,
+	validity time.Duration,
+) *tee.SelfSignedCertProvider {
+	t.Helper()
+	certProvider, err := tee.NewSelfSignedCertProvider(
+		tee.DefaultDomain,
+		tee.DefaultIP,
+		validity,
+	)
+	require.NoError(t, err)
+	return certProvider
+}
+
+func TestCertRotator_Interfaces(t *testing.T) {
+	t.Run("CertProvider", func(_ *testing.T) {
+		var _ tee.CertProvider = &tee.CertRotator{}
+	})
+}
+
+func TestCertRotator_RotateCert(t *testing.T) {
+	t.Run("happy path - keeps key by default", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		rotator, err := tee.NewCertRotator(
+			ctx,
+			newTestSelfSignedCertProvider(t, tee.DefaultValidity),
+		)
+		require.NoError(t, err)
+		oldCert, err := rotator.GetCert(ctx)
+		require.NoError(t, err)
+
+		// when
+		err = rotator.RotateCert(ctx)
+		require.NoError(t, err)
+
+		// then
+		newCert, err := rotator.GetCert(ctx)
+		require.NoError(t, err)
+		assert.NotEqual(t, oldCert.Certificate, newCert.Certificate)
+		assert.Equal(t, oldCert.PrivateKey, newCert.PrivateKey)
+	})
+
+	t.Run("happy path - fresh key and hook", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		var got tee.CertRotationEvent
+		rotator, err := tee.NewCertRotator(
+			ctx,
+			newTestSelfSignedCertProvider(t, tee.DefaultValidity),
+			tee.WithCertRotatorFreshKey(),
+			tee.WithCertRotatorHook(func(_ context.Context, event tee.CertRotationEvent) error {
+				got = event
+				return nil
+			}),
+		)
+		require.NoError(t, err)
+		oldCert, err := rotator.GetCert(ctx)
+		require.NoError(t, err)
+
+		// when
+		err = rotator.RotateCert(ctx)
+		require.NoError(t, err)
+
+		// then
+		newCert, err := rotator.GetCert(ctx)
+		require.NoError(t, err)
+		assert.NotEqual(t, oldCert.PrivateKey, newCert.PrivateKey)
+		assert.Same(t, oldCert, got.Old)
+		assert.Same(t, newCert, got.New)
+		assert.True(t, got.KeyRotated)
+	})
+
+	t.Run("happy path - serves old cert until new cert is ready", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		inHook := make(chan struct{})
+		release := make(chan struct{})
+		rotator, err := tee.NewCertRotator(
+			ctx,
+			newTestSelfSignedCertProvider(t, tee.DefaultValidity),
+			tee.WithCertRotatorHook(func(context.Context, tee.CertRotationEvent) error {
+				close(inHook)
+				<-release
+				return nil
+			}),
+		)
+		require.NoError(t, err)
+		oldCert, err := rotator.GetCert(ctx)
+		require.NoError(t, err)
+
+		errChan := make(chan error, 1)
+		go func() { errChan <- rotator.RotateCert(ctx) }()
+		<-inHook
+
+		// when
+		during, err := rotator.GetCert(ctx)
+		require.NoError(t, err)
+		close(release)
+		require.NoError(t, <-errChan)
+		after, err := rotator.GetCert(ctx)
+		require.NoError(t, err)
+
+		// then
+		assert.Same(t, oldCert, during)
+		assert.NotSame(t, oldCert, after)
+	})
+
+	t.Run("error - hook fails", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		hookErr := errors.New("refreshing attestation")
+		rotator, err := tee.NewCertRotator(
+			ctx,
+			newTestSelfSignedCertProvider(t, tee.DefaultValidity),
+			tee.WithCertRotatorHook(func(context.Context, tee.CertRotationEvent) error {
+				return hookErr
+			}),
+		)
+		require.NoError(t, err)
+		oldCert, err := rotator.GetCert(ctx)
+		require.NoError(t, err)
+
+		// when
+		err = rotator.RotateCert(ctx)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrCertProvider)
+		require.ErrorIs(t, err, hookErr)
+		cert, err := rotator.GetCert(ctx)
+		require.NoError(t, err)
+		assert.Same(t, oldCert, cert)
+	})
+}
+
+func TestCertRotator_Serve(t *testing.T) {
+	t.Run("happy path - rotates at fraction of lifetime", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		var rotations atomic.Int32
+		rotator, err := tee.NewCertRotator(
+			ctx,
+			newTestSelfSignedCertProvider(t, 3*time.Second),
+			tee.WithCertRotatorFraction(0.3),
+			tee.WithCertRotatorHook(func(context.Context, tee.CertRotationEvent) error {
+				rotations.Add(1)
+				return nil
+			}),
+		)
+		require.NoError(t, err)
+		oldCert, err := rotator.GetCert(ctx)
+		require.NoError(t, err)
+		assert.WithinDuration(
+			t,
+			oldCert.Leaf.NotBefore.Add(900*time.Millisecond),
+			rotator.NextRotation(),
+			time.Millisecond,
+		)
+
+		// when
+		errChan := make(chan error, 1)
+		go func() { errChan <- rotator.Serve() }()
+
+		// then
+		assert.Eventually(t, func() bool {
+			return rotations.Load() >= 1
+		}, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, rotator.Close())
+		require.NoError(t, <-errChan)
+
+		newCert, err := rotator.GetCert(ctx)
+		require.NoError(t, err)
+		assert.NotEqual(t, oldCert.Certificate, newCert.Certificate)
+	})
+}
+
+func TestNewCertRotator(t *testing.T) {
+	t.Run("error - invalid fraction", func(t *testing.T) {
+		// when
+		_, err := tee.NewCertRotator(
+			context.Background(),
+			newTestSelfSignedCertProvider(t, tee.DefaultValidity),
+			tee.WithCertRotatorFraction(1.5),
+		)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrCertProvider)
+	})
+
+	t.Run("error - provider cannot rotate keys", func(t *testing.T) {
+		// given
+		provider := struct{ tee.CertProvider }{
+			newTestSelfSignedCertProvider(t, tee.DefaultValidity),
+		}
+
+		// when
+		_, err := tee.NewCertRotator(
+			context.Background(),
+			provider,
+			tee.WithCertRotatorFreshKey(),
+		)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrCertProvider)
+		assert.ErrorContains(t, err, "key rotation")
+	})
+}
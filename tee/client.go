@@ -6,20 +6,17 @@ import (
 	"github.com/tahardi/bearclave"
 )
 
+// NewProxiedClient creates a client that sends all requests through the proxy
+// at proxyAddr. The proxy may be any Address, e.g., "vsock://3:8080" or
+// "unix:///run/proxy.sock"; addresses without a network are dialed according
+// to platform.
 func NewProxiedClient(
 	platform Platform,
 	proxyAddr string,
 ) (*http.Client, error) {
-	switch platform {
-	case Nitro:
-		return bearclave.NewProxiedVSocketClient(proxyAddr)
-	case SEV:
-		return bearclave.NewProxiedSocketClient(proxyAddr)
-	case TDX:
-		return bearclave.NewProxiedSocketClient(proxyAddr)
-	case NoTEE:
-		return bearclave.NewProxiedSocketClient(proxyAddr)
-	default:
-		return nil, unsupportedPlatformError(string(platform), nil)
+	dialContext, err := NewDialContext(platform)
+	if err != nil {
+		return nil, err
 	}
+	return bearclave.NewProxiedClient(dialContext, proxyAddr)
 }
@@ -0,0 +1,115 @@
+package tee
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const SchemeSPIFFE = "spiffe"
+
+type clientIdentityKey struct{}
+
+// ClientIdentity describes the certificate a client presented during a
+// mutual TLS handshake. Certificate is the leaf of the chain the server
+// verified it against its client CAs.
+type ClientIdentity struct {
+	Certificate *x509.Certificate
+	Chain       []*x509.Certificate
+	SPIFFEID    string
+	URIs        []*url.URL
+}
+
+type VerifyClientFunc func(identity *ClientIdentity) error
+
+// NewClientIdentity returns the identity of the client from its verified
+// chain. Unverified certificates, e.g., from tls.RequireAnyClientCert, are
+// not an identity, so it returns false for them.
+func NewClientIdentity(state tls.ConnectionState) (*ClientIdentity, bool) {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+
+	chain := state.VerifiedChains[0]
+	leaf := chain[0]
+	identity := &ClientIdentity{
+		Certificate: leaf,
+		Chain:       chain,
+		URIs:        leaf.URIs,
+	}
+	for _, uri := range leaf.URIs {
+		if strings.EqualFold(uri.Scheme, SchemeSPIFFE) {
+			identity.SPIFFEID = uri.String()
+			break
+		}
+	}
+	return identity, true
+}
+
+func ClientIdentityFromContext(ctx context.Context) (*ClientIdentity, bool) {
+	identity, ok := ctx.Value(clientIdentityKey{}).(*ClientIdentity)
+	return identity, ok
+}
+
+func ContextWithClientIdentity(
+	ctx context.Context,
+	identity *ClientIdentity,
+) context.Context {
+	return context.WithValue(ctx, clientIdentityKey{}, identity)
+}
+
+// WithClientIdentity adds the identity of the TLS client, if the server
+// verified its certificate, to the request context.
+func WithClientIdentity(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			if identity, ok := NewClientIdentity(*r.TLS); ok {
+				r = r.WithContext(ContextWithClientIdentity(r.Context(), identity))
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// MatchSPIFFEID reports whether id matches pattern. Patterns are SPIFFE IDs
+// in which "*" matches a single path segment, e.g.,
+// "spiffe://example.org/ns/*/sa/api". A pattern with no path, e.g.,
+// "spiffe://example.org", matches every ID in that trust domain.
+func MatchSPIFFEID(pattern string, id string) bool {
+	patternURL, err := url.Parse(pattern)
+	if err != nil || !strings.EqualFold(patternURL.Scheme, SchemeSPIFFE) {
+		return false
+	}
+	idURL, err := url.Parse(id)
+	if err != nil || !strings.EqualFold(idURL.Scheme, SchemeSPIFFE) {
+		return false
+	}
+	if !strings.EqualFold(patternURL.Host, idURL.Host) {
+		return false
+	}
+	if patternURL.Path == "" || patternURL.Path == "/" {
+		return true
+	}
+	matched, err := path.Match(patternURL.Path, idURL.Path)
+	return err == nil && matched
+}
+
+// VerifyClientSPIFFEIDs returns a VerifyClientFunc that accepts clients whose
+// SPIFFE ID matches one of patterns (see MatchSPIFFEID).
+func VerifyClientSPIFFEIDs(patterns ...string) VerifyClientFunc {
+	return func(identity *ClientIdentity) error {
+		if identity.SPIFFEID == "" {
+			return serverError("client certificate has no SPIFFE ID", nil)
+		}
+		for _, pattern := range patterns {
+			if MatchSPIFFEID(pattern, identity.SPIFFEID) {
+				return nil
+			}
+		}
+		return serverError("client SPIFFE ID not allowed: "+identity.SPIFFEID, nil)
+	}
+}
@@ -0,0 +1,315 @@
+package tee_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (c *testCA) issueClientCert(t *testing.T, spiffeID string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	require.NoError(t, err)
+	uri, err := url.Parse(spiffeID)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		URIs:         []*url.URL{uri},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, c.cert, &key.PublicKey, c.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func newTestSelfSignedClientCert(t *testing.T, spiffeID string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	require.NoError(t, err)
+	uri, err := url.Parse(spiffeID)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		URIs:         []*url.URL{uri},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func newTestServerTLSWithHandler(
+	t *testing.T,
+	handler http.Handler,
+	options ...tee.ServerTLSOption,
+) *tee.Server {
+	t.Helper()
+	certProvider, err := tee.NewSelfSignedCertProvider(
+		tee.DefaultDomain,
+		tee.DefaultIP,
+		tee.DefaultValidity,
+	)
+	require.NoError(t, err)
+	server, err := tee.NewServerTLS(
+		context.Background(),
+		tee.NoTEE,
+		"127.0.0.1:0",
+		handler,
+		certProvider,
+		slog.New(slog.DiscardHandler),
+		options...,
+	)
+	require.NoError(t, err)
+	runService(func() { _ = server.Serve() }, 0)
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func newTestServerTLS(t *testing.T, options ...tee.ServerTLSOption) *tee.Server {
+	t.Helper()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := tee.ClientIdentityFromContext(r.Context())
+		if !ok {
+			_, _ = io.WriteString(w, "anonymous")
+			return
+		}
+		_, _ = io.WriteString(w, identity.SPIFFEID)
+	})
+	return newTestServerTLSWithHandler(t, handler, options...)
+}
+
+func newTestClientTLS(certs ...tls.Certificate) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec
+			Certificates:       certs,
+		},
+	}}
+}
+
+func TestNewServerTLS_ClientAuth(t *testing.T) {
+	spiffeID := "spiffe://example.org/ns/prod/sa/api"
+
+	t.Run("happy path - verified client identity in context", func(t *testing.T) {
+		// given
+		ca := newTestCA(t)
+		server := newTestServerTLS(
+			t,
+			tee.WithServerTLSClientCAs(ca.pool),
+			tee.WithServerTLSAllowedSPIFFEIDs("spiffe://example.org/ns/*/sa/api"),
+		)
+		client := newTestClientTLS(ca.issueClientCert(t, spiffeID))
+
+		// when
+		resp, err := client.Get("https://" + server.Addr())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		// then
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, spiffeID, string(got))
+	})
+
+	t.Run("happy path - optional client auth without certificate", func(t *testing.T) {
+		// given
+		ca := newTestCA(t)
+		server := newTestServerTLS(
+			t,
+			tee.WithServerTLSClientCAs(ca.pool),
+			tee.WithServerTLSClientAuth(tls.VerifyClientCertIfGiven),
+		)
+		client := newTestClientTLS()
+
+		// when
+		resp, err := client.Get("https://" + server.Addr())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		// then
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "anonymous", string(got))
+	})
+
+	t.Run("error - missing client certificate", func(t *testing.T) {
+		// given
+		ca := newTestCA(t)
+		server := newTestServerTLS(t, tee.WithServerTLSClientCAs(ca.pool))
+		client := newTestClientTLS()
+
+		// when
+		_, err := client.Get("https://" + server.Addr())
+
+		// then
+		require.Error(t, err)
+	})
+
+	t.Run("error - client certificate from unknown ca", func(t *testing.T) {
+		// given
+		ca := newTestCA(t)
+		otherCA := newTestCA(t)
+		server := newTestServerTLS(t, tee.WithServerTLSClientCAs(ca.pool))
+		client := newTestClientTLS(otherCA.issueClientCert(t, spiffeID))
+
+		// when
+		_, err := client.Get("https://" + server.Addr())
+
+		// then
+		require.Error(t, err)
+	})
+
+	t.Run("error - spiffe id not allowed", func(t *testing.T) {
+		// given
+		ca := newTestCA(t)
+		server := newTestServerTLS(
+			t,
+			tee.WithServerTLSClientCAs(ca.pool),
+			tee.WithServerTLSAllowedSPIFFEIDs("spiffe://example.org/ns/*/sa/admin"),
+		)
+		client := newTestClientTLS(ca.issueClientCert(t, spiffeID))
+
+		// when
+		_, err := client.Get("https://" + server.Addr())
+
+		// then
+		require.Error(t, err)
+	})
+
+	t.Run("error - allowed spiffe ids without client cas", func(t *testing.T) {
+		// given
+		certProvider, err := tee.NewSelfSignedCertProvider(
+			tee.DefaultDomain,
+			tee.DefaultIP,
+			tee.DefaultValidity,
+		)
+		require.NoError(t, err)
+
+		// when
+		_, err = tee.NewServerTLS(
+			context.Background(),
+			tee.NoTEE,
+			"127.0.0.1:0",
+			http.NotFoundHandler(),
+			certProvider,
+			slog.New(slog.DiscardHandler),
+			tee.WithServerTLSAllowedSPIFFEIDs(spiffeID),
+		)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrServer)
+	})
+
+	t.Run("error - self-signed certificate with allowed spiffe id", func(t *testing.T) {
+		// given
+		ca := newTestCA(t)
+		server := newTestServerTLS(
+			t,
+			tee.WithServerTLSClientAuth(tls.RequireAnyClientCert),
+			tee.WithServerTLSClientCAs(ca.pool),
+			tee.WithServerTLSAllowedSPIFFEIDs(spiffeID),
+		)
+		client := newTestClientTLS(newTestSelfSignedClientCert(t, spiffeID))
+
+		// when
+		_, err := client.Get("https://" + server.Addr())
+
+		// then
+		require.Error(t, err)
+	})
+}
+
+func TestNewClientIdentity(t *testing.T) {
+	spiffeID := "spiffe://example.org/ns/prod/sa/api"
+
+	t.Run("error - unverified peer certificate", func(t *testing.T) {
+		// given
+		cert := newTestSelfSignedClientCert(t, spiffeID)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(t, err)
+		state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+
+		// when
+		_, ok := tee.NewClientIdentity(state)
+
+		// then
+		assert.False(t, ok)
+	})
+}
+
+func TestMatchSPIFFEID(t *testing.T) {
+	id := "spiffe://example.org/ns/prod/sa/api"
+
+	t.Run("happy path - exact", func(t *testing.T) {
+		assert.True(t, tee.MatchSPIFFEID(id, id))
+	})
+
+	t.Run("happy path - wildcard segment", func(t *testing.T) {
+		assert.True(t, tee.MatchSPIFFEID("spiffe://example.org/ns/*/sa/api", id))
+	})
+
+	t.Run("happy path - trust domain", func(t *testing.T) {
+		assert.True(t, tee.MatchSPIFFEID("spiffe://example.org", id))
+	})
+
+	t.Run("error - other trust domain", func(t *testing.T) {
+		assert.False(t, tee.MatchSPIFFEID("spiffe://example.com", id))
+	})
+
+	t.Run("error - wildcard does not cross segments", func(t *testing.T) {
+		assert.False(t, tee.MatchSPIFFEID("spiffe://example.org/ns/*", id))
+	})
+
+	t.Run("error - not a spiffe id", func(t *testing.T) {
+		assert.False(t, tee.MatchSPIFFEID("spiffe://example.org", "https://example.org/ns"))
+	})
+}
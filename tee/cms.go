@@ -0,0 +1,302 @@
+package tee
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+const (
+	berClassContext  = 2
+	berTagInteger    = 2
+	berTagOctets     = 4
+	berTagOID        = 6
+	berTagSequence   = 16
+	berTagSet        = 17
+	berMaxDepth      = 32
+	berMaxLengthSize = 4
+)
+
+var (
+	oidCMSEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidRSAESOAEP        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidAES256CBC        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// berValue is a single BER-encoded value. CMS producers, including KMS, are
+// free to use indefinite lengths and chunked OCTET STRINGs, neither of which
+// encoding/asn1 accepts, so we parse just enough BER ourselves.
+type berValue struct {
+	class       int
+	constructed bool
+	tag         int
+	content     []byte
+}
+
+func readBER(data []byte, depth int) (berValue, []byte, error) {
+	if depth > berMaxDepth {
+		return berValue{}, nil, errors.New("nested too deeply")
+	}
+	if len(data) < 2 {
+		return berValue{}, nil, errors.New("truncated header")
+	}
+
+	value := berValue{
+		class:       int(data[0] >> 6),
+		constructed: data[0]&0x20 != 0,
+		tag:         int(data[0] & 0x1f),
+	}
+	if value.tag == 0x1f {
+		return berValue{}, nil, errors.New("high tag numbers are not supported")
+	}
+
+	lengthByte, data := data[1], data[2:]
+	switch {
+	case lengthByte == 0x80:
+		if !value.constructed {
+			return berValue{}, nil, errors.New("indefinite length on primitive value")
+		}
+		rest := data
+		for {
+			if len(rest) >= 2 && rest[0] == 0 && rest[1] == 0 {
+				value.content = data[:len(data)-len(rest)]
+				return value, rest[2:], nil
+			}
+			var err error
+			if _, rest, err = readBER(rest, depth+1); err != nil {
+				return berValue{}, nil, err
+			}
+		}
+	case lengthByte&0x80 == 0:
+		return splitBER(value, data, int(lengthByte))
+	default:
+		size := int(lengthByte & 0x7f)
+		if size > berMaxLengthSize || size > len(data) {
+			return berValue{}, nil, errors.New("invalid length")
+		}
+		length := 0
+		for _, b := range data[:size] {
+			length = length<<8 | int(b)
+		}
+		return splitBER(value, data[size:], length)
+	}
+}
+
+func splitBER(value berValue, data []byte, length int) (berValue, []byte, error) {
+	if length < 0 || length > len(data) {
+		return berValue{}, nil, errors.New("truncated value")
+	}
+	value.content = data[:length]
+	return value, data[length:], nil
+}
+
+func (b berValue) is(class int, tag int) bool {
+	return b.class == class && b.tag == tag
+}
+
+func (b berValue) children() ([]berValue, error) {
+	if !b.constructed {
+		return nil, errors.New("expected constructed value")
+	}
+	var children []berValue
+	for rest := b.content; len(rest) > 0; {
+		var child berValue
+		var err error
+		if child, rest, err = readBER(rest, 0); err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	return children, nil
+}
+
+// octets returns the contents of an OCTET STRING, joining the chunks of a
+// constructed one.
+func (b berValue) octets() ([]byte, error) {
+	if !b.constructed {
+		return b.content, nil
+	}
+	children, err := b.children()
+	if err != nil {
+		return nil, err
+	}
+	var octets []byte
+	for _, child := range children {
+		chunk, err := child.octets()
+		if err != nil {
+			return nil, err
+		}
+		octets = append(octets, chunk...)
+	}
+	return octets, nil
+}
+
+func (b berValue) oid() (asn1.ObjectIdentifier, error) {
+	if b.constructed || !b.is(0, berTagOID) || len(b.content) > 127 {
+		return nil, errors.New("expected object identifier")
+	}
+	der := append([]byte{berTagOID, byte(len(b.content))}, b.content...)
+	oid := asn1.ObjectIdentifier{}
+	if _, err := asn1.Unmarshal(der, &oid); err != nil {
+		return nil, err
+	}
+	return oid, nil
+}
+
+// berSequence parses data as a SEQUENCE (or SET) and returns its elements.
+func berSequence(data []byte, tag int) ([]berValue, error) {
+	value, _, err := readBER(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !value.is(0, tag) {
+		return nil, fmt.Errorf("expected tag %d, got %d", tag, value.tag)
+	}
+	return value.children()
+}
+
+// decryptEnvelopedData decrypts a CMS ContentInfo holding EnvelopedData
+// (RFC 5652) whose content-encryption key was wrapped for privateKey with
+// RSAES-OAEP SHA-256 and whose content was encrypted with AES-256-CBC. This
+// is the format KMS uses for CiphertextForRecipient.
+func decryptEnvelopedData(data []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
+	contentInfo, err := berSequence(data, berTagSequence)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("parsing content info: %w", err)
+	case len(contentInfo) != 2 || !contentInfo[1].is(berClassContext, 0):
+		return nil, errors.New("malformed content info")
+	}
+	contentType, err := contentInfo[0].oid()
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("parsing content type: %w", err)
+	case !contentType.Equal(oidCMSEnvelopedData):
+		return nil, fmt.Errorf("unexpected content type %s", contentType)
+	}
+
+	explicit, err := contentInfo[1].children()
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("parsing enveloped data: %w", err)
+	case len(explicit) != 1 || !explicit[0].is(0, berTagSequence):
+		return nil, errors.New("malformed enveloped data")
+	}
+	envelopedData, err := explicit[0].children()
+	if err != nil {
+		return nil, fmt.Errorf("parsing enveloped data: %w", err)
+	}
+
+	// EnvelopedData ::= SEQUENCE { version, originatorInfo [0] OPTIONAL,
+	// recipientInfos, encryptedContentInfo, unprotectedAttrs [1] OPTIONAL }
+	fields := make([]berValue, 0, len(envelopedData))
+	for _, field := range envelopedData {
+		if field.class == 0 {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) != 3 || !fields[0].is(0, berTagInteger) || !fields[1].is(0, berTagSet) {
+		return nil, errors.New("malformed enveloped data")
+	}
+
+	contentKey, err := decryptContentKey(fields[1], privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return decryptContent(fields[2], contentKey)
+}
+
+// decryptContentKey tries every KeyTransRecipientInfo, since KMS does not
+// identify the recipient in a way we can match against.
+func decryptContentKey(recipientInfos berValue, privateKey *rsa.PrivateKey) ([]byte, error) {
+	recipients, err := recipientInfos.children()
+	if err != nil {
+		return nil, fmt.Errorf("parsing recipient infos: %w", err)
+	}
+
+	for _, recipient := range recipients {
+		// KeyTransRecipientInfo ::= SEQUENCE { version, rid,
+		// keyEncryptionAlgorithm, encryptedKey }
+		fields, err := recipient.children()
+		if err != nil || len(fields) != 4 || !fields[2].is(0, berTagSequence) {
+			continue
+		}
+		algorithm, err := fields[2].children()
+		if err != nil || len(algorithm) == 0 {
+			continue
+		}
+		if oid, err := algorithm[0].oid(); err != nil || !oid.Equal(oidRSAESOAEP) {
+			continue
+		}
+		encryptedKey, err := fields[3].octets()
+		if err != nil {
+			continue
+		}
+
+		contentKey, err := rsa.DecryptOAEP(sha256.New(), crand.Reader, privateKey, encryptedKey, nil)
+		if err == nil {
+			return contentKey, nil
+		}
+	}
+	return nil, errors.New("no recipient info for our key")
+}
+
+func decryptContent(encryptedContentInfo berValue, contentKey []byte) ([]byte, error) {
+	// EncryptedContentInfo ::= SEQUENCE { contentType,
+	// contentEncryptionAlgorithm, encryptedContent [0] IMPLICIT OPTIONAL }
+	fields, err := encryptedContentInfo.children()
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("parsing encrypted content info: %w", err)
+	case len(fields) != 3 || !fields[1].is(0, berTagSequence) || !fields[2].is(berClassContext, 0):
+		return nil, errors.New("malformed encrypted content info")
+	}
+
+	algorithm, err := fields[1].children()
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("parsing content encryption algorithm: %w", err)
+	case len(algorithm) != 2 || !algorithm[1].is(0, berTagOctets):
+		return nil, errors.New("malformed content encryption algorithm")
+	}
+	oid, err := algorithm[0].oid()
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("parsing content encryption algorithm: %w", err)
+	case !oid.Equal(oidAES256CBC):
+		return nil, fmt.Errorf("unsupported content encryption algorithm %s", oid)
+	}
+	iv, err := algorithm[1].octets()
+	if err != nil {
+		return nil, fmt.Errorf("parsing iv: %w", err)
+	}
+	ciphertext, err := fields[2].octets()
+	if err != nil {
+		return nil, fmt.Errorf("parsing encrypted content: %w", err)
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	case len(iv) != aes.BlockSize:
+		return nil, errors.New("invalid iv size")
+	case len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0:
+		return nil, errors.New("invalid ciphertext size")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize ||
+		!bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("invalid padding")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
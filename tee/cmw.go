@@ -0,0 +1,333 @@
+package tee
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/tahardi/bearclave"
+)
+
+const (
+	CMWIndicatorEvidence = 4
+	EATMediaTypeCBOR     = "application/eat-ucs+cbor"
+	EATMediaTypeJSON     = "application/eat-ucs+json"
+	EATProfileParam      = "eat_profile"
+	EATProfilePrefix     = "tag:github.com/tahardi/bearclave,2025:"
+)
+
+type CMWFormat string
+
+const (
+	CMWFormatCBOR CMWFormat = "cbor"
+	CMWFormatJSON CMWFormat = "json"
+)
+
+// CMW is a RATS Conceptual Message Wrapper record
+// (draft-ietf-rats-msg-wrap). It is an array of the media type of Value,
+// Value itself, and an optional indicator of what kind of message it is.
+type CMW struct {
+	Type      string
+	Value     []byte
+	Indicator uint64
+}
+
+func (c *CMW) MarshalCBOR() ([]byte, error) {
+	record := []any{c.Type, c.Value}
+	if c.Indicator != 0 {
+		record = append(record, c.Indicator)
+	}
+	return cbor.Marshal(record)
+}
+
+func (c *CMW) UnmarshalCBOR(data []byte) error {
+	record := []cbor.RawMessage{}
+	if err := cbor.Unmarshal(data, &record); err != nil {
+		return cmwError("decoding cbor record", err)
+	}
+	if len(record) != 2 && len(record) != 3 {
+		return cmwError(fmt.Sprintf("record has %d items", len(record)), nil)
+	}
+
+	cmw := CMW{}
+	if err := cbor.Unmarshal(record[0], &cmw.Type); err != nil {
+		return cmwError("decoding type", err)
+	}
+	if err := cbor.Unmarshal(record[1], &cmw.Value); err != nil {
+		return cmwError("decoding value", err)
+	}
+	if len(record) == 3 {
+		if err := cbor.Unmarshal(record[2], &cmw.Indicator); err != nil {
+			return cmwError("decoding indicator", err)
+		}
+	}
+	*c = cmw
+	return nil
+}
+
+func (c *CMW) MarshalJSON() ([]byte, error) {
+	record := []any{c.Type, base64.RawURLEncoding.EncodeToString(c.Value)}
+	if c.Indicator != 0 {
+		record = append(record, c.Indicator)
+	}
+	return json.Marshal(record)
+}
+
+func (c *CMW) UnmarshalJSON(data []byte) error {
+	record := []json.RawMessage{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return cmwError("decoding json record", err)
+	}
+	if len(record) != 2 && len(record) != 3 {
+		return cmwError(fmt.Sprintf("record has %d items", len(record)), nil)
+	}
+
+	cmw := CMW{}
+	value := ""
+	if err := json.Unmarshal(record[0], &cmw.Type); err != nil {
+		return cmwError("decoding type", err)
+	}
+	if err := json.Unmarshal(record[1], &value); err != nil {
+		return cmwError("decoding value", err)
+	}
+	var err error
+	if cmw.Value, err = base64.RawURLEncoding.DecodeString(value); err != nil {
+		return cmwError("decoding value", err)
+	}
+	if len(record) == 3 {
+		if err = json.Unmarshal(record[2], &cmw.Indicator); err != nil {
+			return cmwError("decoding indicator", err)
+		}
+	}
+	*c = cmw
+	return nil
+}
+
+// EATClaims is the unprotected EAT claims set (RFC 9711) bearclave evidence
+// is wrapped in. The report carries its own platform signature, so the claims
+// set itself is not signed. Report and UserData are private claims.
+type EATClaims struct {
+	Profile  string `cbor:"265,keyasint"`
+	Report   []byte `cbor:"-65537,keyasint"`
+	UserData []byte `cbor:"-65538,keyasint,omitempty"`
+}
+
+// eatClaimsJSON is EATClaims as JSON, in which EAT encodes binary claims as
+// unpadded base64url.
+type eatClaimsJSON struct {
+	Profile  string `json:"eat_profile"`
+	Report   string `json:"bearclave_report"`
+	UserData string `json:"bearclave_userdata,omitempty"`
+}
+
+// EATProfile returns the eat_profile identifying evidence from platform.
+func EATProfile(platform Platform) (string, error) {
+	switch platform {
+	case Nitro, SEV, TDX, NoTEE:
+		return EATProfilePrefix + string(platform), nil
+	case NitroSim:
+		// NitroSim attests the same way as NoTEE.
+		return EATProfilePrefix + string(NoTEE), nil
+	default:
+		return "", unsupportedPlatformError(string(platform), nil)
+	}
+}
+
+// EATPlatform is the inverse of EATProfile.
+func EATPlatform(profile string) (Platform, error) {
+	platform := Platform(strings.TrimPrefix(profile, EATProfilePrefix))
+	switch {
+	case !strings.HasPrefix(profile, EATProfilePrefix):
+		return "", cmwError(fmt.Sprintf("unknown eat profile '%s'", profile), nil)
+	case platform == Nitro, platform == SEV, platform == TDX, platform == NoTEE:
+		return platform, nil
+	default:
+		return "", unsupportedPlatformError(string(platform), nil)
+	}
+}
+
+// EATMediaType returns the media type of EAT claims in the given format from
+// platform. The eat_profile parameter tells verifiers which platform the
+// evidence is from without decoding it.
+func EATMediaType(platform Platform, format CMWFormat) (string, error) {
+	profile, err := EATProfile(platform)
+	if err != nil {
+		return "", err
+	}
+	switch format {
+	case CMWFormatCBOR:
+		return mime.FormatMediaType(EATMediaTypeCBOR, map[string]string{EATProfileParam: profile}), nil
+	case CMWFormatJSON:
+		return mime.FormatMediaType(EATMediaTypeJSON, map[string]string{EATProfileParam: profile}), nil
+	default:
+		return "", cmwError(fmt.Sprintf("unknown format '%s'", format), nil)
+	}
+}
+
+// ParseEATMediaType returns the platform and format of an EATMediaType.
+func ParseEATMediaType(mediaType string) (Platform, CMWFormat, error) {
+	base, params, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return "", "", cmwError("parsing media type", err)
+	}
+
+	var format CMWFormat
+	switch base {
+	case EATMediaTypeCBOR:
+		format = CMWFormatCBOR
+	case EATMediaTypeJSON:
+		format = CMWFormatJSON
+	default:
+		return "", "", cmwError(fmt.Sprintf("unsupported media type '%s'", base), nil)
+	}
+
+	platform, err := EATPlatform(params[EATProfileParam])
+	if err != nil {
+		return "", "", err
+	}
+	return platform, format, nil
+}
+
+// MarshalEAT encodes attestResult as EAT claims in the given format.
+func MarshalEAT(platform Platform, attestResult *AttestResult, format CMWFormat) ([]byte, error) {
+	if attestResult == nil || attestResult.Base == nil {
+		return nil, cmwError("missing attestation report", nil)
+	}
+	profile, err := EATProfile(platform)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	switch format {
+	case CMWFormatCBOR:
+		data, err = cbor.Marshal(EATClaims{
+			Profile:  profile,
+			Report:   attestResult.Base.Report,
+			UserData: attestResult.UserData,
+		})
+	case CMWFormatJSON:
+		data, err = json.Marshal(eatClaimsJSON{
+			Profile:  profile,
+			Report:   base64.RawURLEncoding.EncodeToString(attestResult.Base.Report),
+			UserData: base64.RawURLEncoding.EncodeToString(attestResult.UserData),
+		})
+	default:
+		return nil, cmwError(fmt.Sprintf("unknown format '%s'", format), nil)
+	}
+	if err != nil {
+		return nil, cmwError("marshaling eat claims", err)
+	}
+	return data, nil
+}
+
+// UnmarshalEAT decodes EAT claims in the given format into the platform and
+// attestation they hold.
+func UnmarshalEAT(data []byte, format CMWFormat) (Platform, *AttestResult, error) {
+	claims := EATClaims{}
+	switch format {
+	case CMWFormatCBOR:
+		if err := cbor.Unmarshal(data, &claims); err != nil {
+			return "", nil, cmwError("unmarshaling eat claims", err)
+		}
+	case CMWFormatJSON:
+		claimsJSON := eatClaimsJSON{}
+		if err := json.Unmarshal(data, &claimsJSON); err != nil {
+			return "", nil, cmwError("unmarshaling eat claims", err)
+		}
+		var err error
+		claims.Profile = claimsJSON.Profile
+		if claims.Report, err = base64.RawURLEncoding.DecodeString(claimsJSON.Report); err != nil {
+			return "", nil, cmwError("decoding report", err)
+		}
+		if claims.UserData, err = base64.RawURLEncoding.DecodeString(claimsJSON.UserData); err != nil {
+			return "", nil, cmwError("decoding user data", err)
+		}
+	default:
+		return "", nil, cmwError(fmt.Sprintf("unknown format '%s'", format), nil)
+	}
+
+	platform, err := EATPlatform(claims.Profile)
+	switch {
+	case err != nil:
+		return "", nil, err
+	case len(claims.Report) == 0:
+		return "", nil, cmwError("missing attestation report", nil)
+	case len(claims.UserData) == 0:
+		claims.UserData = nil
+	}
+	return platform, &AttestResult{
+		Base:     &bearclave.AttestResult{Report: claims.Report},
+		UserData: claims.UserData,
+	}, nil
+}
+
+// MarshalCMW wraps attestResult, as EAT claims, in a CMW record. Both are
+// encoded in the given format.
+func MarshalCMW(platform Platform, attestResult *AttestResult, format CMWFormat) ([]byte, error) {
+	mediaType, err := EATMediaType(platform, format)
+	if err != nil {
+		return nil, err
+	}
+	value, err := MarshalEAT(platform, attestResult, format)
+	if err != nil {
+		return nil, err
+	}
+
+	cmw := &CMW{Type: mediaType, Value: value, Indicator: CMWIndicatorEvidence}
+	if format == CMWFormatCBOR {
+		return cmw.MarshalCBOR()
+	}
+	return cmw.MarshalJSON()
+}
+
+// UnmarshalCMW decodes a CMW record from MarshalCMW, in either format, and
+// returns the platform it claims to be from. The platform is only a routing
+// hint: the record is not signed, so anyone can claim any platform, and a
+// NoTEE report proves nothing. It is refused unless it is one of allowed,
+// where NitroSim allows NoTEE, and callers must still verify the result with
+// a verifier for a platform they trust, never one picked by the record.
+func UnmarshalCMW(data []byte, allowed []Platform) (Platform, *AttestResult, error) {
+	cmw := &CMW{}
+	var err error
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = cmw.UnmarshalJSON(data)
+	} else {
+		err = cmw.UnmarshalCBOR(data)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if cmw.Indicator != 0 && cmw.Indicator&CMWIndicatorEvidence == 0 {
+		return "", nil, cmwError("record is not evidence", nil)
+	}
+
+	platform, format, err := ParseEATMediaType(cmw.Type)
+	if err != nil {
+		return "", nil, err
+	}
+	if !cmwPlatformAllowed(platform, allowed) {
+		return "", nil, cmwError(fmt.Sprintf("platform '%s' is not allowed", platform), nil)
+	}
+	eatPlatform, attestResult, err := UnmarshalEAT(cmw.Value, format)
+	switch {
+	case err != nil:
+		return "", nil, err
+	case eatPlatform != platform:
+		return "", nil, cmwError("media type and eat profile disagree", nil)
+	}
+	return platform, attestResult, nil
+}
+
+func cmwPlatformAllowed(platform Platform, allowed []Platform) bool {
+	for _, allow := range allowed {
+		if allow == platform || (allow == NitroSim && platform == NoTEE) {
+			return true
+		}
+	}
+	return false
+}
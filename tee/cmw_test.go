@@ -0,0 +1,124 @@
+package tee_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func TestCMW(t *testing.T) {
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	attestation, err := attester.Attest(tee.WithAttestUserData([]byte("hello world")))
+	require.NoError(t, err)
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+	allowed := []tee.Platform{tee.NitroSim}
+
+	for _, format := range []tee.CMWFormat{tee.CMWFormatCBOR, tee.CMWFormatJSON} {
+		t.Run("happy path - "+string(format), func(t *testing.T) {
+			// given
+			data, err := tee.MarshalCMW(tee.NitroSim, attestation, format)
+			require.NoError(t, err)
+
+			// when
+			platform, got, err := tee.UnmarshalCMW(data, allowed)
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, tee.NoTEE, platform)
+			assert.Equal(t, attestation, got)
+
+			verified, err := verifier.Verify(got, tee.WithVerifyMeasurement(noTEEMeasurement))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("hello world"), verified.UserData)
+		})
+	}
+
+	t.Run("happy path - json record layout", func(t *testing.T) {
+		// when
+		data, err := tee.MarshalCMW(tee.Nitro, attestation, tee.CMWFormatJSON)
+
+		// then
+		require.NoError(t, err)
+		record := []any{}
+		require.NoError(t, json.Unmarshal(data, &record))
+		require.Len(t, record, 3)
+		assert.Equal(
+			t,
+			`application/eat-ucs+json; eat_profile="tag:github.com/tahardi/bearclave,2025:nitro"`,
+			record[0],
+		)
+		assert.InDelta(t, tee.CMWIndicatorEvidence, record[2], 0)
+	})
+
+	t.Run("happy path - cbor claims use eat_profile key", func(t *testing.T) {
+		// given
+		data, err := tee.MarshalEAT(tee.SEV, attestation, tee.CMWFormatCBOR)
+		require.NoError(t, err)
+
+		// when
+		claims := map[int]any{}
+		err = cbor.Unmarshal(data, &claims)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "tag:github.com/tahardi/bearclave,2025:sev", claims[265])
+	})
+
+	t.Run("error - media type and eat profile disagree", func(t *testing.T) {
+		// given
+		value, err := tee.MarshalEAT(tee.NoTEE, attestation, tee.CMWFormatCBOR)
+		require.NoError(t, err)
+		mediaType, err := tee.EATMediaType(tee.Nitro, tee.CMWFormatCBOR)
+		require.NoError(t, err)
+		cmw := &tee.CMW{Type: mediaType, Value: value, Indicator: tee.CMWIndicatorEvidence}
+		data, err := cmw.MarshalCBOR()
+		require.NoError(t, err)
+
+		// when
+		_, _, err = tee.UnmarshalCMW(data, []tee.Platform{tee.Nitro})
+
+		// then
+		require.ErrorIs(t, err, tee.ErrCMW)
+		assert.ErrorContains(t, err, "disagree")
+	})
+
+	t.Run("error - notee not allowed", func(t *testing.T) {
+		// given
+		data, err := tee.MarshalCMW(tee.NoTEE, attestation, tee.CMWFormatCBOR)
+		require.NoError(t, err)
+
+		// when
+		_, _, err = tee.UnmarshalCMW(data, []tee.Platform{tee.Nitro, tee.SEV, tee.TDX})
+
+		// then
+		require.ErrorIs(t, err, tee.ErrCMW)
+		assert.ErrorContains(t, err, "not allowed")
+	})
+
+	t.Run("error - unknown eat profile", func(t *testing.T) {
+		// given
+		cmw := &tee.CMW{Type: `application/eat-ucs+json; eat_profile="tag:example.com,2025:x"`}
+		data, err := cmw.MarshalJSON()
+		require.NoError(t, err)
+
+		// when
+		_, _, err = tee.UnmarshalCMW(data, allowed)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrCMW)
+	})
+
+	t.Run("error - unsupported platform", func(t *testing.T) {
+		// when
+		_, err := tee.MarshalCMW(tee.UnknownPlatform, attestation, tee.CMWFormatCBOR)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrUnsupportedPlatform)
+	})
+}
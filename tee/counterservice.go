@@ -0,0 +1,281 @@
+package tee
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	CounterServiceLoadPath    = "/bearclave/counter/load"
+	CounterServiceAdvancePath = "/bearclave/counter/advance"
+	CounterServiceLabel       = "bearclave counter service v1"
+	CounterServiceMaxBodySize = 1 * Megabyte
+)
+
+type CounterServiceRequest struct {
+	Name        string        `json:"name"`
+	Version     uint64        `json:"version,omitempty"`
+	Attestation *AttestResult `json:"attestation,omitempty"`
+}
+
+type CounterServiceResponse struct {
+	Version uint64 `json:"version"`
+}
+
+// CounterServiceBinding is the user data an enclave attests to when advancing
+// the counter for name to version.
+func CounterServiceBinding(name string, version uint64) []byte {
+	binding := make([]byte, 0, len(CounterServiceLabel)+len(name)+DirCounterVersionSize+2)
+	binding = append(binding, CounterServiceLabel...)
+	binding = append(binding, 0)
+	binding = append(binding, name...)
+	binding = append(binding, 0)
+	return binary.BigEndian.AppendUint64(binding, version)
+}
+
+// CounterService keeps the MonotonicCounter of a SealedStore off the enclave
+// host, e.g., next to a KeyBroker, so the host cannot roll it back by
+// restarting the enclave. Versions are kept in a persistent counter such as
+// a DirCounter. Versions are not secret, so anyone may load one, but only an
+// enclave running an allowed measurement may advance one, by attesting to
+// the name and the new version. A replayed request can only advance a
+// counter to a version the enclave asked for, so requests need no nonce.
+type CounterService struct {
+	verifier *Verifier
+	counter  MonotonicCounter
+	opts     CounterServiceOptions
+}
+
+func NewCounterService(
+	verifier *Verifier,
+	counter MonotonicCounter,
+	options ...CounterServiceOption,
+) (*CounterService, error) {
+	opts := MakeDefaultCounterServiceOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	switch {
+	case verifier == nil || counter == nil:
+		return nil, counterServiceError("verifier and counter are required", nil)
+	case len(opts.Measurements) == 0:
+		return nil, counterServiceError("at least one measurement is required", nil)
+	}
+	return &CounterService{verifier: verifier, counter: counter, opts: opts}, nil
+}
+
+func (c *CounterService) Load(ctx context.Context, name string) (uint64, error) {
+	version, err := c.counter.Load(ctx, name)
+	if err != nil {
+		return 0, counterServiceError("loading counter", err)
+	}
+	return version, nil
+}
+
+// Advance verifies the request's attestation and advances the counter. It
+// returns ErrSealedStoreRollback if the version would go down.
+func (c *CounterService) Advance(ctx context.Context, req *CounterServiceRequest) error {
+	if req.Attestation == nil {
+		return counterServiceError("missing attestation", nil)
+	}
+
+	binding := CounterServiceBinding(req.Name, req.Version)
+	var errs []error
+	for _, measurement := range c.opts.Measurements {
+		options := append([]VerifyOption{WithVerifyMeasurement(measurement)}, c.opts.Verify...)
+		verifyResult, err := c.verifier.Verify(req.Attestation, options...)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !bytes.Equal(verifyResult.UserData, binding) {
+			return counterServiceError("attestation is not bound to this request", nil)
+		}
+		if err = c.counter.Advance(ctx, req.Name, req.Version); err != nil {
+			return counterServiceError("advancing counter", err)
+		}
+		return nil
+	}
+	return counterServiceError("verifying attestation", errors.Join(errs...))
+}
+
+// MakeCounterServiceHandler serves CounterService.Load at
+// CounterServiceLoadPath and CounterService.Advance at
+// CounterServiceAdvancePath. Why a request failed is only logged.
+func MakeCounterServiceHandler(service *CounterService, logger *slog.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+CounterServiceLoadPath, func(w http.ResponseWriter, r *http.Request) {
+		req := CounterServiceRequest{}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, CounterServiceMaxBodySize)).Decode(&req)
+		if err != nil {
+			http.Error(w, counterServiceError("decoding request", err).Error(), http.StatusBadRequest)
+			return
+		}
+
+		version, err := service.Load(r.Context(), req.Name)
+		if err != nil {
+			logger.Error("loading counter", slog.String("error", err.Error()))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		WriteResponse(w, CounterServiceResponse{Version: version})
+	})
+	mux.HandleFunc("POST "+CounterServiceAdvancePath, func(w http.ResponseWriter, r *http.Request) {
+		req := CounterServiceRequest{}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, CounterServiceMaxBodySize)).Decode(&req)
+		if err != nil {
+			http.Error(w, counterServiceError("decoding request", err).Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = service.Advance(r.Context(), &req)
+		switch {
+		case errors.Is(err, ErrSealedStoreRollback):
+			http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+			return
+		case err != nil:
+			logger.Error("advancing counter", slog.String("error", err.Error()))
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		WriteResponse(w, CounterServiceResponse{Version: req.Version})
+	})
+	return mux
+}
+
+type CounterServiceOption func(*CounterServiceOptions)
+type CounterServiceOptions struct {
+	Measurements []string
+	Verify       []VerifyOption
+}
+
+func MakeDefaultCounterServiceOptions() CounterServiceOptions {
+	return CounterServiceOptions{
+		Measurements: nil,
+		Verify:       nil,
+	}
+}
+
+// WithCounterServiceMeasurements only lets enclaves running one of the given
+// measurements advance counters. At least one is required.
+func WithCounterServiceMeasurements(measurements ...string) CounterServiceOption {
+	return func(opts *CounterServiceOptions) {
+		opts.Measurements = append(opts.Measurements, measurements...)
+	}
+}
+
+func WithCounterServiceVerifyOptions(options ...VerifyOption) CounterServiceOption {
+	return func(opts *CounterServiceOptions) {
+		opts.Verify = append(opts.Verify, options...)
+	}
+}
+
+// CounterServiceClient is the enclave side of CounterService. It is a
+// MonotonicCounter, so it can back a SealedStore that detects rollback
+// across restarts.
+type CounterServiceClient struct {
+	attester   *Attester
+	client     *http.Client
+	serviceURL string
+}
+
+// NewCounterServiceClient creates a client that reaches the service at
+// serviceURL through the proxy at proxyAddr (see NewProxiedClient).
+// serviceURL must be https: the service is only authenticated by TLS, and a
+// host that could answer in its place could roll the counters back.
+func NewCounterServiceClient(
+	platform Platform,
+	proxyAddr string,
+	serviceURL string,
+	attester *Attester,
+) (*CounterServiceClient, error) {
+	client, err := NewProxiedClient(platform, proxyAddr)
+	if err != nil {
+		return nil, counterServiceError("creating proxied client", err)
+	}
+	return NewCounterServiceClientWithClient(client, serviceURL, attester)
+}
+
+func NewCounterServiceClientWithClient(
+	client *http.Client,
+	serviceURL string,
+	attester *Attester,
+) (*CounterServiceClient, error) {
+	parsed, err := url.Parse(serviceURL)
+	switch {
+	case err != nil:
+		return nil, counterServiceError("parsing service url", err)
+	case parsed.Scheme != "https":
+		return nil, counterServiceError("service url must be https: "+serviceURL, nil)
+	case attester == nil:
+		return nil, counterServiceError("attester is required", nil)
+	}
+	return &CounterServiceClient{
+		attester:   attester,
+		client:     client,
+		serviceURL: strings.TrimSuffix(serviceURL, "/"),
+	}, nil
+}
+
+func (c *CounterServiceClient) Load(ctx context.Context, name string) (uint64, error) {
+	resp := CounterServiceResponse{}
+	err := c.post(ctx, CounterServiceLoadPath, CounterServiceRequest{Name: name}, &resp)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Version, nil
+}
+
+func (c *CounterServiceClient) Advance(ctx context.Context, name string, version uint64) error {
+	attestation, err := c.attester.Attest(WithAttestUserData(CounterServiceBinding(name, version)))
+	if err != nil {
+		return counterServiceError("attesting", err)
+	}
+
+	req := CounterServiceRequest{Name: name, Version: version, Attestation: attestation}
+	resp := CounterServiceResponse{}
+	return c.post(ctx, CounterServiceAdvancePath, req, &resp)
+}
+
+func (c *CounterServiceClient) post(ctx context.Context, path string, in any, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return counterServiceError("marshaling request", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.serviceURL+path, bytes.NewReader(body))
+	if err != nil {
+		return counterServiceError("creating request", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return counterServiceError("sending request", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		return counterServiceError("service refused version", ErrSealedStoreRollback)
+	case resp.StatusCode != http.StatusOK:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, CounterServiceMaxBodySize))
+		errMsg := fmt.Sprintf("service rejected request: %s: %s", resp.Status, bytes.TrimSpace(msg))
+		return counterServiceError(errMsg, nil)
+	}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, CounterServiceMaxBodySize)).Decode(out)
+	if err != nil {
+		return counterServiceError("decoding response", err)
+	}
+	return nil
+}
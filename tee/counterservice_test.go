@@ -0,0 +1,142 @@
+package tee_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func newTestCounterServiceClient(
+	t *testing.T,
+	counter tee.MonotonicCounter,
+	options ...tee.CounterServiceOption,
+) *tee.CounterServiceClient {
+	t.Helper()
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+	service, err := tee.NewCounterService(verifier, counter, options...)
+	require.NoError(t, err)
+	server := httptest.NewTLSServer(tee.MakeCounterServiceHandler(service, slog.New(slog.DiscardHandler)))
+	t.Cleanup(server.Close)
+
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	client, err := tee.NewCounterServiceClientWithClient(server.Client(), server.URL, attester)
+	require.NoError(t, err)
+	return client
+}
+
+func TestCounterServiceClient_Interfaces(t *testing.T) {
+	t.Run("MonotonicCounter", func(_ *testing.T) {
+		var _ tee.MonotonicCounter = &tee.CounterServiceClient{}
+	})
+}
+
+func TestCounterServiceClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("error - host replays old record after restart", func(t *testing.T) {
+		// given
+		counter, err := tee.NewDirCounter(t.TempDir())
+		require.NoError(t, err)
+		client := newTestCounterServiceClient(
+			t,
+			counter,
+			tee.WithCounterServiceMeasurements(noTEEMeasurement),
+		)
+		sealer, err := tee.NewAEADSealer(bytes.Repeat([]byte{0x07}, tee.SealKeySize))
+		require.NoError(t, err)
+		dir := t.TempDir()
+		backend, err := tee.NewDirStoreBackend(dir)
+		require.NoError(t, err)
+
+		store, err := tee.NewSealedStore(sealer, backend, client)
+		require.NoError(t, err)
+		require.NoError(t, store.Put(ctx, "config", []byte("v1")))
+		path, oldRecord := readTestSealedRecord(t, dir)
+		require.NoError(t, store.Put(ctx, "config", []byte("v2")))
+		require.NoError(t, os.WriteFile(path, oldRecord, tee.SealedStoreFileMode))
+
+		// when
+		restarted, err := tee.NewSealedStore(sealer, backend, client)
+		require.NoError(t, err)
+		_, err = restarted.Get(ctx, "config")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrSealedStoreRollback)
+	})
+
+	t.Run("error - going backwards", func(t *testing.T) {
+		// given
+		client := newTestCounterServiceClient(
+			t,
+			tee.NewMemoryCounter(),
+			tee.WithCounterServiceMeasurements(noTEEMeasurement),
+		)
+		require.NoError(t, client.Advance(ctx, "config", 2))
+
+		// when
+		err := client.Advance(ctx, "config", 1)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrCounterService)
+		require.ErrorIs(t, err, tee.ErrSealedStoreRollback)
+		got, err := client.Load(ctx, "config")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), got)
+	})
+
+	t.Run("error - measurement not allowed", func(t *testing.T) {
+		// given
+		client := newTestCounterServiceClient(
+			t,
+			tee.NewMemoryCounter(),
+			tee.WithCounterServiceMeasurements("other"),
+		)
+
+		// when
+		err := client.Advance(ctx, "config", 1)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrCounterService)
+		assert.ErrorContains(t, err, "403 Forbidden")
+	})
+}
+
+func TestNewCounterServiceClientWithClient(t *testing.T) {
+	t.Run("error - service url is not https", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+
+		// when
+		_, err = tee.NewCounterServiceClientWithClient(http.DefaultClient, "http://counter.example", attester)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrCounterService)
+		assert.ErrorContains(t, err, "must be https")
+	})
+}
+
+func TestNewCounterService(t *testing.T) {
+	t.Run("error - no measurement", func(t *testing.T) {
+		// given
+		verifier, err := tee.NewVerifier(tee.NoTEE)
+		require.NoError(t, err)
+
+		// when
+		_, err = tee.NewCounterService(verifier, tee.NewMemoryCounter())
+
+		// then
+		require.ErrorIs(t, err, tee.ErrCounterService)
+		assert.ErrorContains(t, err, "measurement")
+	})
+}
@@ -1,14 +1,58 @@
 package tee
 
 import (
+	"context"
+	"net"
+
 	"github.com/tahardi/bearclave"
 )
 
 type DialContext = bearclave.DialContext
 
+// NewDialContext creates a dialer for the given platform. Addresses that name
+// their network (see Address) are dialed over that network regardless of
+// platform, so a single config can mix vsock and socket peers. NitroSim is
+// the exception: like an enclave, it can only dial vsock addresses.
 func NewDialContext(
 	platform Platform,
 	options ...DialerOption,
+) (DialContext, error) {
+	platformDialContext, err := newPlatformDialContext(platform, options...)
+	if err != nil {
+		return nil, err
+	}
+	if platform == NitroSim {
+		return platformDialContext, nil
+	}
+
+	socketDialContext, err := bearclave.NewSocketDialContext(options...)
+	if err != nil {
+		return nil, err
+	}
+	vsockDialContext, err := bearclave.NewVSocketDialContext(options...)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		address, err := ParseAddress(addr)
+		if err != nil {
+			return nil, dialContextError("", err)
+		}
+
+		switch address.Network() {
+		case "":
+			return platformDialContext(ctx, network, addr)
+		case SchemeVSock:
+			return vsockDialContext(ctx, network, addr)
+		default:
+			return socketDialContext(ctx, network, addr)
+		}
+	}, nil
+}
+
+func newPlatformDialContext(
+	platform Platform,
+	options ...DialerOption,
 ) (DialContext, error) {
 	switch platform {
 	case Nitro:
@@ -19,6 +63,8 @@ func NewDialContext(
 		return bearclave.NewSocketDialContext(options...)
 	case NoTEE:
 		return bearclave.NewSocketDialContext(options...)
+	case NitroSim:
+		return bearclave.NewSimVSocketDialContext(options...)
 	default:
 		return nil, unsupportedPlatformError(string(platform), nil)
 	}
@@ -0,0 +1,1226 @@
+package tee
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DNSClassINET  = 1
+	DNSTypeNS     = 2
+	DNSTypeCNAME  = 5
+	DNSTypeSOA    = 6
+	DNSTypePTR    = 12
+	DNSTypeMX     = 15
+	DNSTypeSRV    = 33
+	DNSTypeDNAME  = 39
+	DNSTypeOPT    = 41
+	DNSTypeDS     = 43
+	DNSTypeRRSIG  = 46
+	DNSTypeNSEC   = 47
+	DNSTypeDNSKEY = 48
+	DNSTypeNSEC3  = 50
+
+	DNSHeaderFlagResponse         = 0x8000
+	DNSHeaderFlagRecursionDesired = 0x0100
+	DNSHeaderFlagCheckingDisabled = 0x0010
+	DNSHeaderRCodeMask            = 0x000F
+	DNSRCodeSuccess               = 0
+	DNSRCodeNameError             = 3
+	DNSEDNSPayloadSize            = 1232
+	DNSEDNSFlagDNSSECOK           = 0x8000
+	DNSMaxNameSize                = 255
+	DNSMaxLabelSize               = 63
+	DNSMaxCNAMEChain              = 8
+
+	DNSSECAlgorithmRSASHA256       = 8
+	DNSSECAlgorithmRSASHA512       = 10
+	DNSSECAlgorithmECDSAP256SHA256 = 13
+	DNSSECAlgorithmECDSAP384SHA384 = 14
+	DNSSECAlgorithmED25519         = 15
+	DNSSECDigestSHA256             = 2
+	DNSSECDigestSHA384             = 4
+	DNSSECKeyFlagZone              = 0x0100
+	DNSSECKeyProtocol              = 3
+	DNSSECNSEC3HashSHA1            = 1
+	DNSSECMaxNSEC3Iterations       = 100
+	DNSSECMaxCachedZones           = 1024
+	DNSSECRRSIGHeaderSize          = 18
+)
+
+// DNSSECTrustAnchor is a DS record for Zone whose keys are trusted without
+// a signature from a parent zone.
+type DNSSECTrustAnchor struct {
+	Zone       string
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     []byte
+}
+
+// DNSSECRootAnchors are the root zone trust anchors published by IANA
+// (KSK-2017 and KSK-2024) at https://data.iana.org/root-anchors/.
+var DNSSECRootAnchors = []DNSSECTrustAnchor{
+	{
+		Zone:       ".",
+		KeyTag:     20326,
+		Algorithm:  DNSSECAlgorithmRSASHA256,
+		DigestType: DNSSECDigestSHA256,
+		Digest:     mustDecodeHex("E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"),
+	},
+	{
+		Zone:       ".",
+		KeyTag:     38696,
+		Algorithm:  DNSSECAlgorithmRSASHA256,
+		DigestType: DNSSECDigestSHA256,
+		Digest:     mustDecodeHex("683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16"),
+	},
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+type dnsExchange func(ctx context.Context, query []byte) ([]byte, error)
+
+// dnssecValidator validates answers itself, walking the chain of DS and
+// DNSKEY records from the trust anchors down to the zone that signed them
+// (RFC 4035, Section 5). The upstream resolver is only used to fetch records
+// and is asked not to validate (CD bit), so neither it nor the host between
+// the enclave and it is trusted. Anything that cannot be proven fails
+// closed: unsigned zones, NXDOMAIN answers, and empty answers without an
+// NSEC or NSEC3 record proving the name has no records of the asked type.
+type dnssecValidator struct {
+	upstream dnsExchange
+	anchors  map[string][]DNSSECTrustAnchor
+
+	mu   sync.Mutex
+	keys map[string]dnssecZoneKeys
+}
+
+type dnssecZoneKeys struct {
+	keys    []dnsKey
+	expires time.Time
+}
+
+func newDNSSECValidator(
+	upstream dnsExchange,
+	anchors []DNSSECTrustAnchor,
+) (*dnssecValidator, error) {
+	if len(anchors) == 0 {
+		return nil, resolverErrorDNSSEC("at least one trust anchor is required", nil)
+	}
+
+	byZone := make(map[string][]DNSSECTrustAnchor, len(anchors))
+	for _, anchor := range anchors {
+		zone, err := makeDNSName(anchor.Zone)
+		if err != nil {
+			return nil, resolverErrorDNSSEC("parsing trust anchor zone", err)
+		}
+		byZone[string(zone)] = append(byZone[string(zone)], anchor)
+	}
+	return &dnssecValidator{
+		upstream: upstream,
+		anchors:  byZone,
+		keys:     make(map[string]dnssecZoneKeys),
+	}, nil
+}
+
+// exchange answers query with a validated answer. Only the answer section
+// is returned, with the AD bit set, so nothing unvalidated reaches the
+// caller.
+func (v *dnssecValidator) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	q, err := parseDNSMessage(query)
+	if err != nil {
+		return nil, err
+	}
+	if q.qclass != DNSClassINET {
+		return nil, resolverErrorDNSSEC("only class IN can be validated", nil)
+	}
+
+	answer, msg, err := v.query(ctx, q.id, q.qname, q.qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case msg.rcode() == DNSRCodeNameError:
+		msgErr := "nonexistent names cannot be validated: " + dnsNameString(q.qname)
+		return nil, resolverErrorDNSSEC(msgErr, nil)
+	case msg.rcode() != DNSRCodeSuccess:
+		msgErr := fmt.Sprintf("upstream returned rcode %d", msg.rcode())
+		return nil, resolverErrorDNSSEC(msgErr, nil)
+	case len(msg.answers) == 0:
+		err = v.validateNoData(ctx, msg)
+	default:
+		err = v.validateAnswers(ctx, msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response := bytes.Clone(answer[:msg.answerEnd])
+	binary.BigEndian.PutUint32(response[8:], 0) // no authority or additional records
+	response[DNSFlagsOffset] |= DNSFlagAuthenticData
+	return response, nil
+}
+
+// query asks the upstream for the records of name and type, with DNSSEC
+// records included and validation left to us.
+func (v *dnssecValidator) query(
+	ctx context.Context,
+	id uint16,
+	name []byte,
+	typ uint16,
+) ([]byte, *dnsMessage, error) {
+	answer, err := v.upstream(ctx, makeDNSQuery(id, name, typ))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msg, err := parseDNSMessage(answer)
+	switch {
+	case err != nil:
+		return nil, nil, err
+	case msg.flags&DNSHeaderFlagResponse == 0 || msg.id != id:
+		return nil, nil, resolverErrorDNSSEC("answer does not match query", nil)
+	case !bytes.Equal(msg.qname, name) || msg.qtype != typ:
+		return nil, nil, resolverErrorDNSSEC("answer is for another question", nil)
+	}
+	return answer, msg, nil
+}
+
+func (v *dnssecValidator) validateAnswers(ctx context.Context, msg *dnsMessage) error {
+	rrsets, sigs, err := groupDNSRRsets(msg.answers)
+	if err != nil {
+		return err
+	}
+
+	for _, rrset := range rrsets {
+		sig, err := v.validateRRset(ctx, rrset, sigs, nil)
+		if err != nil {
+			return err
+		}
+		if int(sig.labels) < dnsNameLabels(rrset[0].name) {
+			err = v.validateWildcard(ctx, msg, rrset[0].name, sig)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// The answer must lead from the question to records of the asked type,
+	// possibly through CNAMEs.
+	name := msg.qname
+	for range DNSMaxCNAMEChain {
+		if findDNSRRset(rrsets, name, msg.qtype) != nil {
+			return nil
+		}
+		cname := findDNSRRset(rrsets, name, DNSTypeCNAME)
+		if cname == nil {
+			break
+		}
+		name = cname[0].rdata
+	}
+	return resolverErrorDNSSEC("answer does not answer the question", nil)
+}
+
+// validateNoData checks that an empty answer comes with a signed NSEC or
+// NSEC3 record for the name whose type bitmap lacks the asked type.
+func (v *dnssecValidator) validateNoData(ctx context.Context, msg *dnsMessage) error {
+	rrsets, sigs, err := groupDNSRRsets(msg.authority)
+	if err != nil {
+		return err
+	}
+
+	for _, rrset := range rrsets {
+		var types []byte
+		var zone []byte
+		switch rrset[0].typ {
+		case DNSTypeNSEC:
+			if !bytes.Equal(rrset[0].name, msg.qname) {
+				continue
+			}
+			nsec, err := parseDNSNSEC(rrset[0].rdata)
+			if err != nil {
+				continue
+			}
+			types = nsec.types
+		case DNSTypeNSEC3:
+			zone = dnsNameParent(rrset[0].name)
+			nsec3, err := parseDNSNSEC3(rrset[0].rdata)
+			if err != nil || zone == nil || !isDNSSubdomain(msg.qname, zone) {
+				continue
+			}
+			owner, err := nsec3OwnerHash(rrset[0].name)
+			if err != nil {
+				continue
+			}
+			hash, err := nsec3.hash(msg.qname)
+			if err != nil || !bytes.Equal(owner, hash) {
+				continue
+			}
+			types = nsec3.types
+		default:
+			continue
+		}
+
+		switch {
+		case dnsTypeInBitmap(types, msg.qtype), dnsTypeInBitmap(types, DNSTypeCNAME):
+			continue
+		case msg.qtype != DNSTypeDS &&
+			dnsTypeInBitmap(types, DNSTypeNS) &&
+			!dnsTypeInBitmap(types, DNSTypeSOA):
+			// A parent zone's record at a delegation says nothing about
+			// the child zone's records (RFC 6840, Section 4.1).
+			continue
+		}
+
+		accept := func(signer []byte) bool { return zone == nil || bytes.Equal(signer, zone) }
+		if _, err = v.validateRRset(ctx, rrset, sigs, accept); err == nil {
+			return nil
+		}
+	}
+	msgErr := "empty answer is not proven: " + dnsNameString(msg.qname)
+	return resolverErrorDNSSEC(msgErr, nil)
+}
+
+// validateWildcard checks that an answer synthesized from a wildcard comes
+// with proof that the name itself does not exist (RFC 4035, Section 5.3.4,
+// and RFC 5155, Section 8.8).
+func (v *dnssecValidator) validateWildcard(
+	ctx context.Context,
+	msg *dnsMessage,
+	owner []byte,
+	wildcardSig dnsRRSIG,
+) error {
+	rrsets, sigs, err := groupDNSRRsets(msg.authority)
+	if err != nil {
+		return err
+	}
+
+	zone := wildcardSig.signer
+	nextCloser := dnsNameSuffix(owner, int(wildcardSig.labels)+1)
+	for _, rrset := range rrsets {
+		switch rrset[0].typ {
+		case DNSTypeNSEC:
+			nsec, err := parseDNSNSEC(rrset[0].rdata)
+			if err != nil || !coversDNSName(rrset[0].name, nsec.next, owner) {
+				continue
+			}
+		case DNSTypeNSEC3:
+			if !bytes.Equal(dnsNameParent(rrset[0].name), zone) {
+				continue
+			}
+			nsec3, err := parseDNSNSEC3(rrset[0].rdata)
+			if err != nil {
+				continue
+			}
+			ownerHash, err := nsec3OwnerHash(rrset[0].name)
+			if err != nil {
+				continue
+			}
+			hash, err := nsec3.hash(nextCloser)
+			if err != nil || !coversNSEC3Hash(ownerHash, nsec3.next, hash) {
+				continue
+			}
+		default:
+			continue
+		}
+
+		accept := func(signer []byte) bool { return bytes.Equal(signer, zone) }
+		if _, err = v.validateRRset(ctx, rrset, sigs, accept); err == nil {
+			return nil
+		}
+	}
+	msgErr := "wildcard answer is not proven: " + dnsNameString(owner)
+	return resolverErrorDNSSEC(msgErr, nil)
+}
+
+// validateRRset checks that one of the RRSIGs for rrset was made by a key of
+// a validated zone, returning that RRSIG. If accept is set, only signers it
+// accepts are tried.
+func (v *dnssecValidator) validateRRset(
+	ctx context.Context,
+	rrset []dnsRR,
+	sigs map[string][]dnsRRSIG,
+	accept func(signer []byte) bool,
+) (dnsRRSIG, error) {
+	owner, typ := rrset[0].name, rrset[0].typ
+	now := time.Now()
+
+	var errs []error
+	for _, sig := range sigs[dnsRRsetKey(owner, typ)] {
+		if !isDNSSubdomain(owner, sig.signer) || (accept != nil && !accept(sig.signer)) {
+			continue
+		}
+		keys, err := v.zoneKeys(ctx, sig.signer)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, key := range keys {
+			if key.tag != sig.keyTag || key.algorithm != sig.algorithm {
+				continue
+			}
+			err = verifyDNSRRset(rrset, sig, key, now)
+			if err == nil {
+				return sig, nil
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	msgErr := fmt.Sprintf("no valid signature for %s type %d", dnsNameString(owner), typ)
+	return dnsRRSIG{}, resolverErrorDNSSEC(msgErr, errors.Join(errs...))
+}
+
+// zoneKeys returns the DNSKEYs of zone once its key set is proven to be
+// signed by a key that a DS record from the parent zone, or a trust anchor,
+// vouches for.
+func (v *dnssecValidator) zoneKeys(ctx context.Context, zone []byte) ([]dnsKey, error) {
+	now := time.Now()
+	v.mu.Lock()
+	cached, ok := v.keys[string(zone)]
+	v.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.keys, nil
+	}
+
+	signers, err := v.delegationSigners(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	_, msg, err := v.query(ctx, randomDNSID(), zone, DNSTypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+	if msg.rcode() != DNSRCodeSuccess {
+		msgErr := fmt.Sprintf("fetching keys of %s: rcode %d", dnsNameString(zone), msg.rcode())
+		return nil, resolverErrorDNSSEC(msgErr, nil)
+	}
+	rrsets, sigs, err := groupDNSRRsets(msg.answers)
+	if err != nil {
+		return nil, err
+	}
+	keySet := findDNSRRset(rrsets, zone, DNSTypeDNSKEY)
+	if keySet == nil {
+		return nil, resolverErrorDNSSEC("zone has no keys: "+dnsNameString(zone), nil)
+	}
+
+	keys := make([]dnsKey, 0, len(keySet))
+	for _, rr := range keySet {
+		key, err := parseDNSKey(rr.rdata)
+		if err != nil || key.flags&DNSSECKeyFlagZone == 0 || key.protocol != DNSSECKeyProtocol {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	for _, sig := range sigs[dnsRRsetKey(zone, DNSTypeDNSKEY)] {
+		if !bytes.Equal(sig.signer, zone) {
+			continue
+		}
+		for _, key := range keys {
+			if !matchesDNSSECDigest(signers, zone, key) {
+				continue
+			}
+			if verifyDNSRRset(keySet, sig, key, now) != nil {
+				continue
+			}
+
+			ttl := min(keySet[0].ttl, sig.origTTL)
+			expires := now.Add(time.Duration(ttl) * time.Second)
+			remaining := int32(sig.expiration - uint32(now.Unix())) //nolint:gosec
+			sigExpires := now.Add(time.Duration(remaining) * time.Second)
+			if sigExpires.Before(expires) {
+				expires = sigExpires
+			}
+			v.cacheKeys(zone, keys, expires)
+			return keys, nil
+		}
+	}
+	msgErr := "no trusted key signs the keys of " + dnsNameString(zone)
+	return nil, resolverErrorDNSSEC(msgErr, nil)
+}
+
+// delegationSigners returns the trust anchors for zone or, failing that,
+// the DS records its parent zone signed for it.
+func (v *dnssecValidator) delegationSigners(
+	ctx context.Context,
+	zone []byte,
+) ([]DNSSECTrustAnchor, error) {
+	if anchors, ok := v.anchors[string(zone)]; ok {
+		return anchors, nil
+	}
+	if dnsNameParent(zone) == nil {
+		return nil, resolverErrorDNSSEC("no trust anchor for the root zone", nil)
+	}
+
+	_, msg, err := v.query(ctx, randomDNSID(), zone, DNSTypeDS)
+	if err != nil {
+		return nil, err
+	}
+	if msg.rcode() != DNSRCodeSuccess {
+		msgErr := fmt.Sprintf("fetching ds of %s: rcode %d", dnsNameString(zone), msg.rcode())
+		return nil, resolverErrorDNSSEC(msgErr, nil)
+	}
+	rrsets, sigs, err := groupDNSRRsets(msg.answers)
+	if err != nil {
+		return nil, err
+	}
+	dsSet := findDNSRRset(rrsets, zone, DNSTypeDS)
+	if dsSet == nil {
+		return nil, resolverErrorDNSSEC("zone is not signed: "+dnsNameString(zone), nil)
+	}
+
+	// DS records live in the parent zone, so they must be signed by one of
+	// zone's ancestors and never by zone itself.
+	accept := func(signer []byte) bool { return !bytes.Equal(signer, zone) }
+	if _, err = v.validateRRset(ctx, dsSet, sigs, accept); err != nil {
+		return nil, err
+	}
+
+	signers := make([]DNSSECTrustAnchor, 0, len(dsSet))
+	for _, rr := range dsSet {
+		ds, err := parseDNSDS(rr.rdata)
+		if err != nil {
+			continue
+		}
+		signers = append(signers, ds)
+	}
+	return signers, nil
+}
+
+func (v *dnssecValidator) cacheKeys(zone []byte, keys []dnsKey, expires time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.keys) >= DNSSECMaxCachedZones {
+		clear(v.keys)
+	}
+	v.keys[string(zone)] = dnssecZoneKeys{keys: keys, expires: expires}
+}
+
+func matchesDNSSECDigest(signers []DNSSECTrustAnchor, zone []byte, key dnsKey) bool {
+	data := append(bytes.Clone(zone), key.rdata...)
+	for _, ds := range signers {
+		if ds.KeyTag != key.tag || ds.Algorithm != key.algorithm {
+			continue
+		}
+
+		var digest []byte
+		switch ds.DigestType {
+		case DNSSECDigestSHA256:
+			sum := sha256.Sum256(data)
+			digest = sum[:]
+		case DNSSECDigestSHA384:
+			sum := sha512.Sum384(data)
+			digest = sum[:]
+		default:
+			continue
+		}
+		if bytes.Equal(digest, ds.Digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyDNSRRset checks sig over rrset in canonical form (RFC 4034,
+// Section 3.1.8.1 and Section 6).
+func verifyDNSRRset(rrset []dnsRR, sig dnsRRSIG, key dnsKey, now time.Time) error {
+	owner, typ := rrset[0].name, rrset[0].typ
+	now32 := uint32(now.Unix()) //nolint:gosec
+	switch {
+	case sig.typeCovered != typ:
+		return resolverErrorDNSSEC("signature covers another type", nil)
+	case sig.algorithm != key.algorithm || sig.keyTag != key.tag:
+		return resolverErrorDNSSEC("signature is from another key", nil)
+	case int(sig.labels) > dnsNameLabels(owner):
+		return resolverErrorDNSSEC("signature has more labels than its owner", nil)
+	case int32(now32-sig.inception) < 0 || int32(sig.expiration-now32) < 0: //nolint:gosec
+		return resolverErrorDNSSEC("signature is not valid now", nil)
+	}
+
+	// An answer synthesized from a wildcard is signed as the wildcard.
+	signedOwner := owner
+	if int(sig.labels) < dnsNameLabels(owner) {
+		signedOwner = append([]byte{1, '*'}, dnsNameSuffix(owner, int(sig.labels))...)
+	}
+
+	rdatas := make([][]byte, 0, len(rrset))
+	for _, rr := range rrset {
+		rdatas = append(rdatas, rr.rdata)
+	}
+	slices.SortFunc(rdatas, bytes.Compare)
+	rdatas = slices.CompactFunc(rdatas, bytes.Equal)
+
+	data := bytes.Clone(sig.header)
+	for _, rdata := range rdatas {
+		data = append(data, signedOwner...)
+		data = binary.BigEndian.AppendUint16(data, typ)
+		data = binary.BigEndian.AppendUint16(data, DNSClassINET)
+		data = binary.BigEndian.AppendUint32(data, sig.origTTL)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(rdata))) //nolint:gosec
+		data = append(data, rdata...)
+	}
+	return verifyDNSSECSignature(key, data, sig.signature)
+}
+
+func verifyDNSSECSignature(key dnsKey, data []byte, signature []byte) error {
+	switch key.algorithm {
+	case DNSSECAlgorithmRSASHA256:
+		return verifyDNSSECRSA(key.publicKey, crypto.SHA256, data, signature)
+	case DNSSECAlgorithmRSASHA512:
+		return verifyDNSSECRSA(key.publicKey, crypto.SHA512, data, signature)
+	case DNSSECAlgorithmECDSAP256SHA256:
+		return verifyDNSSECECDSA(key.publicKey, elliptic.P256(), crypto.SHA256, data, signature)
+	case DNSSECAlgorithmECDSAP384SHA384:
+		return verifyDNSSECECDSA(key.publicKey, elliptic.P384(), crypto.SHA384, data, signature)
+	case DNSSECAlgorithmED25519:
+		if len(key.publicKey) != ed25519.PublicKeySize ||
+			!ed25519.Verify(key.publicKey, data, signature) {
+			return resolverErrorDNSSEC("invalid signature", nil)
+		}
+		return nil
+	default:
+		msg := fmt.Sprintf("unsupported algorithm %d", key.algorithm)
+		return resolverErrorDNSSEC(msg, nil)
+	}
+}
+
+// verifyDNSSECRSA checks a PKCS #1 v1.5 signature by a key in the format of
+// RFC 3110, Section 2.
+func verifyDNSSECRSA(publicKey []byte, hash crypto.Hash, data []byte, signature []byte) error {
+	if len(publicKey) < 3 {
+		return resolverErrorDNSSEC("malformed rsa key", nil)
+	}
+	expSize, off := int(publicKey[0]), 1
+	if expSize == 0 {
+		expSize, off = int(binary.BigEndian.Uint16(publicKey[1:])), 3
+	}
+	if expSize == 0 || expSize > 4 || len(publicKey) <= off+expSize {
+		return resolverErrorDNSSEC("malformed rsa key", nil)
+	}
+
+	exponent := 0
+	for _, b := range publicKey[off : off+expSize] {
+		exponent = exponent<<8 | int(b)
+	}
+	pub := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(publicKey[off+expSize:]),
+		E: exponent,
+	}
+
+	h := hash.New()
+	h.Write(data)
+	if err := rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), signature); err != nil {
+		return resolverErrorDNSSEC("invalid signature", err)
+	}
+	return nil
+}
+
+// verifyDNSSECECDSA checks a signature by a key in the format of RFC 6605,
+// Section 4: both the key and the signature are fixed-size concatenations.
+func verifyDNSSECECDSA(
+	publicKey []byte,
+	curve elliptic.Curve,
+	hash crypto.Hash,
+	data []byte,
+	signature []byte,
+) error {
+	size := (curve.Params().BitSize + 7) / 8
+	if len(publicKey) != 2*size || len(signature) != 2*size {
+		return resolverErrorDNSSEC("malformed ecdsa key or signature", nil)
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(publicKey[:size]),
+		Y:     new(big.Int).SetBytes(publicKey[size:]),
+	}
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+
+	h := hash.New()
+	h.Write(data)
+	if !ecdsa.Verify(pub, h.Sum(nil), r, s) {
+		return resolverErrorDNSSEC("invalid signature", nil)
+	}
+	return nil
+}
+
+func makeDNSQuery(id uint16, name []byte, typ uint16) []byte {
+	query := make([]byte, DNSHeaderSize, DNSHeaderSize+len(name)+15)
+	binary.BigEndian.PutUint16(query[0:], id)
+	binary.BigEndian.PutUint16(
+		query[2:],
+		DNSHeaderFlagRecursionDesired|DNSHeaderFlagCheckingDisabled,
+	)
+	binary.BigEndian.PutUint16(query[4:], 1)  // one question
+	binary.BigEndian.PutUint16(query[10:], 1) // one OPT record
+
+	query = append(query, name...)
+	query = binary.BigEndian.AppendUint16(query, typ)
+	query = binary.BigEndian.AppendUint16(query, DNSClassINET)
+
+	// The DO bit asks for the DNSSEC records (RFC 3225).
+	query = append(query, 0)
+	query = binary.BigEndian.AppendUint16(query, DNSTypeOPT)
+	query = binary.BigEndian.AppendUint16(query, DNSEDNSPayloadSize)
+	query = binary.BigEndian.AppendUint32(query, DNSEDNSFlagDNSSECOK)
+	return binary.BigEndian.AppendUint16(query, 0)
+}
+
+func randomDNSID() uint16 {
+	id := make([]byte, 2)
+	_, _ = rand.Read(id)
+	return binary.BigEndian.Uint16(id)
+}
+
+// dnsRR is a resource record in canonical form: names are uncompressed and
+// lowercase, including the names inside the RDATA of the types listed in
+// RFC 4034, Section 6.2, that are still in use.
+type dnsRR struct {
+	name  []byte
+	typ   uint16
+	class uint16
+	ttl   uint32
+	rdata []byte
+}
+
+type dnsMessage struct {
+	id        uint16
+	flags     uint16
+	qname     []byte
+	qtype     uint16
+	qclass    uint16
+	answers   []dnsRR
+	authority []dnsRR
+	answerEnd int
+}
+
+func (m *dnsMessage) rcode() int {
+	return int(m.flags & DNSHeaderRCodeMask)
+}
+
+func parseDNSMessage(msg []byte) (*dnsMessage, error) {
+	if len(msg) < DNSHeaderSize {
+		return nil, resolverErrorDNSSEC("message too short", nil)
+	}
+	m := &dnsMessage{
+		id:    binary.BigEndian.Uint16(msg[0:]),
+		flags: binary.BigEndian.Uint16(msg[2:]),
+	}
+	if binary.BigEndian.Uint16(msg[4:]) != 1 {
+		return nil, resolverErrorDNSSEC("message must have one question", nil)
+	}
+
+	qname, off, err := readDNSName(msg, DNSHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) < off+4 {
+		return nil, resolverErrorDNSSEC("message too short", nil)
+	}
+	m.qname = qname
+	m.qtype = binary.BigEndian.Uint16(msg[off:])
+	m.qclass = binary.BigEndian.Uint16(msg[off+2:])
+
+	m.answers, off, err = readDNSRRs(msg, off+4, int(binary.BigEndian.Uint16(msg[6:])))
+	if err != nil {
+		return nil, err
+	}
+	m.answerEnd = off
+	m.authority, _, err = readDNSRRs(msg, off, int(binary.BigEndian.Uint16(msg[8:])))
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func readDNSRRs(msg []byte, off int, count int) ([]dnsRR, int, error) {
+	rrs := make([]dnsRR, 0, min(count, len(msg)/DNSHeaderSize))
+	for range count {
+		rr, next, err := readDNSRR(msg, off)
+		if err != nil {
+			return nil, 0, err
+		}
+		rrs = append(rrs, rr)
+		off = next
+	}
+	return rrs, off, nil
+}
+
+func readDNSRR(msg []byte, off int) (dnsRR, int, error) {
+	name, off, err := readDNSName(msg, off)
+	if err != nil {
+		return dnsRR{}, 0, err
+	}
+	if len(msg) < off+10 {
+		return dnsRR{}, 0, resolverErrorDNSSEC("record too short", nil)
+	}
+	rr := dnsRR{
+		name:  name,
+		typ:   binary.BigEndian.Uint16(msg[off:]),
+		class: binary.BigEndian.Uint16(msg[off+2:]),
+		ttl:   binary.BigEndian.Uint32(msg[off+4:]),
+	}
+	size := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	if len(msg) < off+size {
+		return dnsRR{}, 0, resolverErrorDNSSEC("record too short", nil)
+	}
+
+	rr.rdata, err = readDNSRData(msg, off, size, rr.typ)
+	if err != nil {
+		return dnsRR{}, 0, err
+	}
+	return rr, off + size, nil
+}
+
+// readDNSRData returns the canonical RDATA at off, decompressing and
+// lowercasing the names of the types that may carry compressed names.
+func readDNSRData(msg []byte, off int, size int, typ uint16) ([]byte, error) {
+	var prefix, names, suffix int
+	switch typ {
+	case DNSTypeNS, DNSTypeCNAME, DNSTypePTR, DNSTypeDNAME:
+		names = 1
+	case DNSTypeMX:
+		prefix, names = 2, 1
+	case DNSTypeSRV:
+		prefix, names = 6, 1
+	case DNSTypeSOA:
+		names, suffix = 2, 20
+	default:
+		return bytes.Clone(msg[off : off+size]), nil
+	}
+
+	end := off + size
+	if size < prefix {
+		return nil, resolverErrorDNSSEC("malformed rdata", nil)
+	}
+	rdata := bytes.Clone(msg[off : off+prefix])
+	pos := off + prefix
+	for range names {
+		name, next, err := readDNSName(msg, pos)
+		if err != nil {
+			return nil, err
+		}
+		if next > end {
+			return nil, resolverErrorDNSSEC("malformed rdata", nil)
+		}
+		rdata = append(rdata, name...)
+		pos = next
+	}
+	if end-pos != suffix {
+		return nil, resolverErrorDNSSEC("malformed rdata", nil)
+	}
+	return append(rdata, msg[pos:end]...), nil
+}
+
+// readDNSName returns the lowercase wire-format name at off and the offset
+// just past it. Compression pointers may only point backwards.
+func readDNSName(msg []byte, off int) ([]byte, int, error) {
+	name := make([]byte, 0, DNSMaxLabelSize)
+	next := -1
+	for {
+		if off >= len(msg) {
+			return nil, 0, resolverErrorDNSSEC("name too short", nil)
+		}
+		size := int(msg[off])
+		switch {
+		case size == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return append(name, 0), next, nil
+		case size&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return nil, 0, resolverErrorDNSSEC("name too short", nil)
+			}
+			ptr := int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			if ptr >= off {
+				return nil, 0, resolverErrorDNSSEC("name pointer does not point back", nil)
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = ptr
+		case size > DNSMaxLabelSize:
+			return nil, 0, resolverErrorDNSSEC("malformed label", nil)
+		default:
+			if off+1+size > len(msg) {
+				return nil, 0, resolverErrorDNSSEC("name too short", nil)
+			}
+			name = append(name, byte(size))
+			name = appendDNSLower(name, msg[off+1:off+1+size])
+			off += 1 + size
+		}
+		if len(name) >= DNSMaxNameSize {
+			return nil, 0, resolverErrorDNSSEC("name too long", nil)
+		}
+	}
+}
+
+func appendDNSLower(dst []byte, label []byte) []byte {
+	for _, c := range label {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+// makeDNSName returns the lowercase wire format of a name such as
+// "example.com." ("." is the root).
+func makeDNSName(s string) ([]byte, error) {
+	s = strings.TrimSuffix(s, ".")
+	name := make([]byte, 0, len(s)+2)
+	if s != "" {
+		for _, label := range strings.Split(s, ".") {
+			if label == "" || len(label) > DNSMaxLabelSize {
+				return nil, resolverErrorDNSSEC("malformed name: "+s, nil)
+			}
+			name = append(name, byte(len(label)))
+			name = appendDNSLower(name, []byte(label))
+		}
+	}
+	name = append(name, 0)
+	if len(name) > DNSMaxNameSize {
+		return nil, resolverErrorDNSSEC("name too long: "+s, nil)
+	}
+	return name, nil
+}
+
+func dnsNameString(name []byte) string {
+	labels := dnsNameLabelList(name)
+	if len(labels) == 0 {
+		return "."
+	}
+	var b strings.Builder
+	for _, label := range labels {
+		b.Write(label)
+		b.WriteByte('.')
+	}
+	return b.String()
+}
+
+func dnsNameLabelList(name []byte) [][]byte {
+	var labels [][]byte
+	for len(name) > 1 {
+		labels = append(labels, name[1:1+name[0]])
+		name = name[1+name[0]:]
+	}
+	return labels
+}
+
+// dnsNameLabels counts the labels of name as an RRSIG does, without the
+// root or a leading wildcard.
+func dnsNameLabels(name []byte) int {
+	labels := len(dnsNameLabelList(name))
+	if len(name) > 2 && name[0] == 1 && name[1] == '*' {
+		labels--
+	}
+	return labels
+}
+
+// dnsNameParent returns the name without its first label, or nil for the
+// root.
+func dnsNameParent(name []byte) []byte {
+	if len(name) <= 1 {
+		return nil
+	}
+	return name[1+name[0]:]
+}
+
+// dnsNameSuffix returns the last labels labels of name.
+func dnsNameSuffix(name []byte, labels int) []byte {
+	for extra := len(dnsNameLabelList(name)) - labels; extra > 0; extra-- {
+		name = dnsNameParent(name)
+	}
+	return name
+}
+
+func isDNSSubdomain(name []byte, zone []byte) bool {
+	for ; name != nil; name = dnsNameParent(name) {
+		if bytes.Equal(name, zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareDNSNames orders names canonically (RFC 4034, Section 6.1).
+func compareDNSNames(a []byte, b []byte) int {
+	aLabels, bLabels := dnsNameLabelList(a), dnsNameLabelList(b)
+	for i := 1; i <= len(aLabels) && i <= len(bLabels); i++ {
+		c := bytes.Compare(aLabels[len(aLabels)-i], bLabels[len(bLabels)-i])
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(aLabels), len(bLabels))
+}
+
+// coversDNSName reports whether the NSEC record from owner to next proves
+// that name does not exist. The last NSEC record of a zone points back to
+// the apex.
+func coversDNSName(owner []byte, next []byte, name []byte) bool {
+	if compareDNSNames(owner, name) >= 0 {
+		return false
+	}
+	return compareDNSNames(name, next) < 0 || compareDNSNames(next, owner) <= 0
+}
+
+func coversNSEC3Hash(owner []byte, next []byte, hash []byte) bool {
+	if bytes.Compare(owner, hash) >= 0 {
+		return false
+	}
+	return bytes.Compare(hash, next) < 0 || bytes.Compare(next, owner) <= 0
+}
+
+func groupDNSRRsets(rrs []dnsRR) ([][]dnsRR, map[string][]dnsRRSIG, error) {
+	var rrsets [][]dnsRR
+	index := make(map[string]int)
+	sigs := make(map[string][]dnsRRSIG)
+	for _, rr := range rrs {
+		if rr.class != DNSClassINET {
+			return nil, nil, resolverErrorDNSSEC("only class IN can be validated", nil)
+		}
+		if rr.typ == DNSTypeRRSIG {
+			sig, err := parseDNSRRSIG(rr.rdata)
+			if err != nil {
+				continue
+			}
+			key := dnsRRsetKey(rr.name, sig.typeCovered)
+			sigs[key] = append(sigs[key], sig)
+			continue
+		}
+
+		key := dnsRRsetKey(rr.name, rr.typ)
+		i, ok := index[key]
+		if !ok {
+			i = len(rrsets)
+			index[key] = i
+			rrsets = append(rrsets, nil)
+		}
+		rrsets[i] = append(rrsets[i], rr)
+	}
+	return rrsets, sigs, nil
+}
+
+func findDNSRRset(rrsets [][]dnsRR, name []byte, typ uint16) []dnsRR {
+	for _, rrset := range rrsets {
+		if rrset[0].typ == typ && bytes.Equal(rrset[0].name, name) {
+			return rrset
+		}
+	}
+	return nil
+}
+
+func dnsRRsetKey(name []byte, typ uint16) string {
+	return string(binary.BigEndian.AppendUint16(bytes.Clone(name), typ))
+}
+
+type dnsRRSIG struct {
+	typeCovered uint16
+	algorithm   uint8
+	labels      uint8
+	origTTL     uint32
+	expiration  uint32
+	inception   uint32
+	keyTag      uint16
+	signer      []byte
+	signature   []byte
+	header      []byte // the signed RDATA fields, up to and including signer
+}
+
+func parseDNSRRSIG(rdata []byte) (dnsRRSIG, error) {
+	if len(rdata) < DNSSECRRSIGHeaderSize {
+		return dnsRRSIG{}, resolverErrorDNSSEC("malformed rrsig", nil)
+	}
+	signer, off, err := readDNSName(rdata, DNSSECRRSIGHeaderSize)
+	if err != nil {
+		return dnsRRSIG{}, err
+	}
+	return dnsRRSIG{
+		typeCovered: binary.BigEndian.Uint16(rdata[0:]),
+		algorithm:   rdata[2],
+		labels:      rdata[3],
+		origTTL:     binary.BigEndian.Uint32(rdata[4:]),
+		expiration:  binary.BigEndian.Uint32(rdata[8:]),
+		inception:   binary.BigEndian.Uint32(rdata[12:]),
+		keyTag:      binary.BigEndian.Uint16(rdata[16:]),
+		signer:      signer,
+		signature:   rdata[off:],
+		header:      append(bytes.Clone(rdata[:DNSSECRRSIGHeaderSize]), signer...),
+	}, nil
+}
+
+type dnsKey struct {
+	flags     uint16
+	protocol  uint8
+	algorithm uint8
+	publicKey []byte
+	tag       uint16
+	rdata     []byte
+}
+
+func parseDNSKey(rdata []byte) (dnsKey, error) {
+	if len(rdata) < 4 {
+		return dnsKey{}, resolverErrorDNSSEC("malformed dnskey", nil)
+	}
+	return dnsKey{
+		flags:     binary.BigEndian.Uint16(rdata[0:]),
+		protocol:  rdata[2],
+		algorithm: rdata[3],
+		publicKey: rdata[4:],
+		tag:       dnssecKeyTag(rdata),
+		rdata:     rdata,
+	}, nil
+}
+
+// dnssecKeyTag computes the key tag of a DNSKEY (RFC 4034, Appendix B).
+func dnssecKeyTag(rdata []byte) uint16 {
+	var sum uint32
+	for i, b := range rdata {
+		if i&1 == 0 {
+			sum += uint32(b) << 8
+		} else {
+			sum += uint32(b)
+		}
+	}
+	sum += sum >> 16 & 0xFFFF
+	return uint16(sum) //nolint:gosec
+}
+
+func parseDNSDS(rdata []byte) (DNSSECTrustAnchor, error) {
+	if len(rdata) < 5 {
+		return DNSSECTrustAnchor{}, resolverErrorDNSSEC("malformed ds", nil)
+	}
+	return DNSSECTrustAnchor{
+		KeyTag:     binary.BigEndian.Uint16(rdata[0:]),
+		Algorithm:  rdata[2],
+		DigestType: rdata[3],
+		Digest:     rdata[4:],
+	}, nil
+}
+
+type dnsNSEC struct {
+	next  []byte
+	types []byte
+}
+
+func parseDNSNSEC(rdata []byte) (dnsNSEC, error) {
+	next, off, err := readDNSName(rdata, 0)
+	if err != nil {
+		return dnsNSEC{}, err
+	}
+	return dnsNSEC{next: next, types: rdata[off:]}, nil
+}
+
+type dnsNSEC3 struct {
+	hashAlgorithm uint8
+	iterations    uint16
+	salt          []byte
+	next          []byte
+	types         []byte
+}
+
+func parseDNSNSEC3(rdata []byte) (dnsNSEC3, error) {
+	if len(rdata) < 5 {
+		return dnsNSEC3{}, resolverErrorDNSSEC("malformed nsec3", nil)
+	}
+	saltEnd := 5 + int(rdata[4])
+	if len(rdata) < saltEnd+1 {
+		return dnsNSEC3{}, resolverErrorDNSSEC("malformed nsec3", nil)
+	}
+	nextEnd := saltEnd + 1 + int(rdata[saltEnd])
+	if len(rdata) < nextEnd {
+		return dnsNSEC3{}, resolverErrorDNSSEC("malformed nsec3", nil)
+	}
+	return dnsNSEC3{
+		hashAlgorithm: rdata[0],
+		iterations:    binary.BigEndian.Uint16(rdata[2:]),
+		salt:          rdata[5:saltEnd],
+		next:          rdata[saltEnd+1 : nextEnd],
+		types:         rdata[nextEnd:],
+	}, nil
+}
+
+// hash computes the NSEC3 hash of name (RFC 5155, Section 5). Records with
+// more iterations than DNSSECMaxNSEC3Iterations are not trusted (RFC 9276).
+func (n dnsNSEC3) hash(name []byte) ([]byte, error) {
+	switch {
+	case n.hashAlgorithm != DNSSECNSEC3HashSHA1:
+		return nil, resolverErrorDNSSEC("unsupported nsec3 hash", nil)
+	case n.iterations > DNSSECMaxNSEC3Iterations:
+		return nil, resolverErrorDNSSEC("too many nsec3 iterations", nil)
+	}
+
+	sum := sha1.Sum(append(bytes.Clone(name), n.salt...)) //nolint:gosec
+	for range n.iterations {
+		sum = sha1.Sum(append(sum[:], n.salt...)) //nolint:gosec
+	}
+	return sum[:], nil
+}
+
+// nsec3OwnerHash decodes the hash in the first label of an NSEC3 owner.
+func nsec3OwnerHash(owner []byte) ([]byte, error) {
+	if len(owner) < 2 {
+		return nil, resolverErrorDNSSEC("malformed nsec3 owner", nil)
+	}
+	label := strings.ToUpper(string(owner[1 : 1+owner[0]]))
+	hash, err := base32.HexEncoding.WithPadding(base32.NoPadding).DecodeString(label)
+	if err != nil {
+		return nil, resolverErrorDNSSEC("malformed nsec3 owner", err)
+	}
+	return hash, nil
+}
+
+// dnsTypeInBitmap reports whether typ is in an NSEC or NSEC3 type bitmap
+// (RFC 4034, Section 4.1.2). A malformed bitmap counts as holding every
+// type, so it never proves that a type is absent.
+func dnsTypeInBitmap(bitmap []byte, typ uint16) bool {
+	window, bit := byte(typ>>8), int(typ&0xFF)
+	for len(bitmap) > 0 {
+		if len(bitmap) < 2 {
+			return true
+		}
+		size := int(bitmap[1])
+		if size == 0 || size > 32 || len(bitmap) < 2+size {
+			return true
+		}
+		if bitmap[0] == window {
+			return bit/8 < size && bitmap[2+bit/8]&(0x80>>(bit%8)) != 0
+		}
+		bitmap = bitmap[2+size:]
+	}
+	return false
+}
@@ -0,0 +1,367 @@
+package tee_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const testDNSSECTTL = 60
+
+// testDNSSECZone is a zone signed with a single ECDSA P-256 key.
+type testDNSSECZone struct {
+	name   string
+	key    *ecdsa.PrivateKey
+	dnskey []byte
+	tag    uint16
+}
+
+func newTestDNSSECZone(t *testing.T, name string) testDNSSECZone {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	pub, err := key.PublicKey.ECDH()
+	require.NoError(t, err)
+
+	// Flags 257 (zone key, secure entry point), protocol 3, algorithm 13,
+	// and the key without its 0x04 prefix (RFC 6605).
+	dnskey := []byte{0x01, 0x01, tee.DNSSECKeyProtocol, tee.DNSSECAlgorithmECDSAP256SHA256}
+	dnskey = append(dnskey, pub.Bytes()[1:]...)
+
+	var sum uint32
+	for i, b := range dnskey {
+		if i&1 == 0 {
+			sum += uint32(b) << 8
+		} else {
+			sum += uint32(b)
+		}
+	}
+	sum += sum >> 16 & 0xFFFF
+	return testDNSSECZone{name: name, key: key, dnskey: dnskey, tag: uint16(sum)} //nolint:gosec
+}
+
+func (z testDNSSECZone) anchor() tee.DNSSECTrustAnchor {
+	digest := sha256.Sum256(append(makeTestDNSName(z.name), z.dnskey...))
+	return tee.DNSSECTrustAnchor{
+		Zone:       z.name,
+		KeyTag:     z.tag,
+		Algorithm:  tee.DNSSECAlgorithmECDSAP256SHA256,
+		DigestType: tee.DNSSECDigestSHA256,
+		Digest:     digest[:],
+	}
+}
+
+func (z testDNSSECZone) ds() []byte {
+	anchor := z.anchor()
+	ds := binary.BigEndian.AppendUint16(nil, anchor.KeyTag)
+	ds = append(ds, anchor.Algorithm, anchor.DigestType)
+	return append(ds, anchor.Digest...)
+}
+
+// sign returns the RRSIG RDATA over the RRset of owner and typ.
+func (z testDNSSECZone) sign(t *testing.T, owner string, typ uint16, rdatas ...[]byte) []byte {
+	t.Helper()
+	now := uint32(time.Now().Unix()) //nolint:gosec
+	labels := len(strings.FieldsFunc(owner, func(r rune) bool { return r == '.' }))
+	rrsig := binary.BigEndian.AppendUint16(nil, typ)
+	rrsig = append(rrsig, tee.DNSSECAlgorithmECDSAP256SHA256, byte(labels))
+	rrsig = binary.BigEndian.AppendUint32(rrsig, testDNSSECTTL)
+	rrsig = binary.BigEndian.AppendUint32(rrsig, now+3600)
+	rrsig = binary.BigEndian.AppendUint32(rrsig, now-3600)
+	rrsig = binary.BigEndian.AppendUint16(rrsig, z.tag)
+	rrsig = append(rrsig, makeTestDNSName(z.name)...)
+
+	data := bytes.Clone(rrsig)
+	rdatas = slices.Clone(rdatas)
+	slices.SortFunc(rdatas, bytes.Compare)
+	for _, rdata := range rdatas {
+		data = append(data, makeTestDNSName(owner)...)
+		data = binary.BigEndian.AppendUint16(data, typ)
+		data = binary.BigEndian.AppendUint16(data, tee.DNSClassINET)
+		data = binary.BigEndian.AppendUint32(data, testDNSSECTTL)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(rdata))) //nolint:gosec
+		data = append(data, rdata...)
+	}
+
+	digest := sha256.Sum256(data)
+	r, s, err := ecdsa.Sign(rand.Reader, z.key, digest[:])
+	require.NoError(t, err)
+	rrsig = append(rrsig, r.FillBytes(make([]byte, 32))...)
+	return append(rrsig, s.FillBytes(make([]byte, 32))...)
+}
+
+func makeTestDNSName(name string) []byte {
+	var wire []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label != "" {
+			wire = append(wire, byte(len(label)))
+			wire = append(wire, label...)
+		}
+	}
+	return append(wire, 0)
+}
+
+type testDNSRecord struct {
+	owner string
+	typ   uint16
+	rdata []byte
+}
+
+// newTestDNSSECUpstream serves a signed root zone that delegates to a
+// signed "example." zone holding:
+//   - www.example.      A 10.1.2.3, signed, and no AAAA (proven by NSEC)
+//   - forged.example.   A 10.1.2.3, signed over another address
+//   - unsigned.example. A 10.1.2.3, without a signature
+func newTestDNSSECUpstream(
+	t *testing.T,
+	root testDNSSECZone,
+	example testDNSSECZone,
+) func([]byte) []byte {
+	t.Helper()
+	ip := testDNSAnswerIP[:]
+	forgedIP := []byte{10, 9, 9, 9}
+	// Types A (1), RRSIG (46), and NSEC (47) in window 0.
+	nsec := append(makeTestDNSName("example."), 0, 6, 0x40, 0, 0, 0, 0, 0x03)
+
+	signed := func(zone testDNSSECZone, owner string, typ uint16, rdata []byte) []testDNSRecord {
+		return []testDNSRecord{
+			{owner: owner, typ: typ, rdata: rdata},
+			{owner: owner, typ: tee.DNSTypeRRSIG, rdata: zone.sign(t, owner, typ, rdata)},
+		}
+	}
+	answers := map[string][]testDNSRecord{
+		".|48":                 signed(root, ".", tee.DNSTypeDNSKEY, root.dnskey),
+		"example.|43":          signed(root, "example.", tee.DNSTypeDS, example.ds()),
+		"example.|48":          signed(example, "example.", tee.DNSTypeDNSKEY, example.dnskey),
+		"www.example.|1":       signed(example, "www.example.", uint16(dnsmessage.TypeA), ip),
+		"unsigned.example.|1":  {{owner: "unsigned.example.", typ: uint16(dnsmessage.TypeA), rdata: ip}},
+		"forged.example.|1":    signed(example, "forged.example.", uint16(dnsmessage.TypeA), forgedIP),
+		"www.example.|28":      nil,
+		"forged.example.|28":   nil,
+		"unsigned.example.|28": nil,
+	}
+	answers["forged.example.|1"][0].rdata = ip
+	authority := map[string][]testDNSRecord{
+		"www.example.|28": signed(example, "www.example.", tee.DNSTypeNSEC, nsec),
+	}
+
+	return func(query []byte) []byte {
+		parser := dnsmessage.Parser{}
+		header, err := parser.Start(query)
+		require.NoError(t, err)
+		question, err := parser.Question()
+		require.NoError(t, err)
+
+		key := strings.ToLower(question.Name.String()) + "|" + strconv.Itoa(int(question.Type))
+		records, ok := answers[key]
+		rcode := dnsmessage.RCodeSuccess
+		if !ok {
+			rcode = dnsmessage.RCodeNameError
+		}
+
+		builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+			ID:                 header.ID,
+			Response:           true,
+			RecursionAvailable: true,
+			RCode:              rcode,
+		})
+		require.NoError(t, builder.StartQuestions())
+		require.NoError(t, builder.Question(question))
+		require.NoError(t, builder.StartAnswers())
+		addTestDNSRecords(t, &builder, records)
+		require.NoError(t, builder.StartAuthorities())
+		addTestDNSRecords(t, &builder, authority[key])
+		answer, err := builder.Finish()
+		require.NoError(t, err)
+		return answer
+	}
+}
+
+func addTestDNSRecords(t *testing.T, builder *dnsmessage.Builder, records []testDNSRecord) {
+	t.Helper()
+	for _, record := range records {
+		header := dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName(record.owner),
+			Class: dnsmessage.ClassINET,
+			TTL:   testDNSSECTTL,
+		}
+		resource := dnsmessage.UnknownResource{
+			Type: dnsmessage.Type(record.typ),
+			Data: record.rdata,
+		}
+		require.NoError(t, builder.UnknownResource(header, resource))
+	}
+}
+
+func TestResolver_DNSSEC(t *testing.T) {
+	ctx := context.Background()
+	platform := tee.NoTEE
+	logger := slog.New(slog.DiscardHandler)
+	want := []string{net.IP(testDNSAnswerIP[:]).String()}
+
+	root := newTestDNSSECZone(t, ".")
+	example := newTestDNSSECZone(t, "example.")
+	upstream := newTestDNSServer(t, newTestDNSSECUpstream(t, root, example))
+	defer upstream.Close()
+
+	forwarder, err := tee.NewHostDNSForwarder(
+		ctx,
+		platform,
+		"127.0.0.1:0",
+		upstream.Addr().String(),
+		logger,
+	)
+	require.NoError(t, err)
+	defer forwarder.Close()
+	runService(func() { _ = forwarder.Serve() }, 10*time.Millisecond)
+
+	dohServer := newTestDoHServer(t, newTestDNSSECUpstream(t, root, example))
+	defer dohServer.Close()
+
+	t.Run("happy path - through host dns forwarder", func(t *testing.T) {
+		// given
+		resolver, err := tee.NewResolver(
+			platform,
+			forwarder.Addrs()[0],
+			tee.WithResolverDNSSEC(root.anchor()),
+		)
+		require.NoError(t, err)
+
+		// when
+		got, err := resolver.LookupHost(ctx, "www.example.")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("happy path - dns over https", func(t *testing.T) {
+		// given
+		resolver, err := tee.NewDoHResolver(
+			dohServer.Client(),
+			dohServer.URL,
+			tee.WithResolverDNSSEC(root.anchor()),
+		)
+		require.NoError(t, err)
+
+		// when
+		got, err := resolver.LookupHost(ctx, "www.example.")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("happy path - empty answer proven by nsec", func(t *testing.T) {
+		// given
+		resolver, err := tee.NewResolver(
+			platform,
+			forwarder.Addrs()[0],
+			tee.WithResolverDNSSEC(root.anchor()),
+		)
+		require.NoError(t, err)
+
+		// when
+		_, err = resolver.LookupIP(ctx, "ip6", "www.example.")
+
+		// then
+		dnsErr := &net.DNSError{}
+		require.ErrorAs(t, err, &dnsErr)
+		assert.True(t, dnsErr.IsNotFound)
+	})
+
+	t.Run("error - empty answer not proven", func(t *testing.T) {
+		// given
+		resolver, err := tee.NewResolver(
+			platform,
+			forwarder.Addrs()[0],
+			tee.WithResolverDNSSEC(root.anchor()),
+		)
+		require.NoError(t, err)
+
+		// when
+		_, err = resolver.LookupIP(ctx, "ip6", "unsigned.example.")
+
+		// then
+		dnsErr := &net.DNSError{}
+		require.ErrorAs(t, err, &dnsErr)
+		assert.False(t, dnsErr.IsNotFound)
+	})
+
+	t.Run("error - forged answer", func(t *testing.T) {
+		// given
+		resolver, err := tee.NewResolver(
+			platform,
+			forwarder.Addrs()[0],
+			tee.WithResolverDNSSEC(root.anchor()),
+		)
+		require.NoError(t, err)
+
+		// when
+		_, err = resolver.LookupHost(ctx, "forged.example.")
+
+		// then
+		assert.Error(t, err)
+	})
+
+	t.Run("error - unsigned answer", func(t *testing.T) {
+		// given
+		resolver, err := tee.NewResolver(
+			platform,
+			forwarder.Addrs()[0],
+			tee.WithResolverDNSSEC(root.anchor()),
+		)
+		require.NoError(t, err)
+
+		// when
+		_, err = resolver.LookupHost(ctx, "unsigned.example.")
+
+		// then
+		assert.Error(t, err)
+	})
+
+	t.Run("error - root key not trusted", func(t *testing.T) {
+		// given
+		other := newTestDNSSECZone(t, ".")
+		resolver, err := tee.NewResolver(
+			platform,
+			forwarder.Addrs()[0],
+			tee.WithResolverDNSSEC(other.anchor()),
+		)
+		require.NoError(t, err)
+
+		// when
+		_, err = resolver.LookupHost(ctx, "www.example.")
+
+		// then
+		assert.Error(t, err)
+	})
+
+	t.Run("error - trust anchor zone malformed", func(t *testing.T) {
+		// given
+		anchor := root.anchor()
+		anchor.Zone = "bad..zone"
+
+		// when
+		_, err := tee.NewResolver(platform, forwarder.Addrs()[0], tee.WithResolverDNSSEC(anchor))
+
+		// then
+		assert.ErrorIs(t, err, tee.ErrResolverDNSSEC)
+	})
+}
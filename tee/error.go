@@ -8,22 +8,47 @@ import (
 )
 
 var (
-	ErrAttester            = bearclave.ErrAttester
-	ErrAttesterUserData    = bearclave.ErrAttesterUserData
-	ErrDialContext         = bearclave.ErrDialContext
-	ErrListener            = bearclave.ErrListener
-	ErrProxy               = errors.New("proxy")
-	ErrReverseProxy        = errors.New("reverse proxy")
-	ErrServer              = errors.New("server")
-	ErrSocket              = errors.New("socket")
-	ErrTimer               = bearclave.ErrTimer
-	ErrVerifier            = bearclave.ErrVerifier
-	ErrVerifierDebugMode   = bearclave.ErrVerifierDebugMode
-	ErrVerifierMeasurement = bearclave.ErrVerifierMeasurement
-	ErrVerifierNonce       = bearclave.ErrVerifierNonce
-	ErrVerifierTimestamp   = bearclave.ErrVerifierTimestamp
-	ErrCertProvider        = errors.New("cert provider")
-	ErrUnsupportedPlatform = errors.New("unsupported platform")
+	ErrAddress              = bearclave.ErrAddress
+	ErrAttestCA             = errors.New("attest ca")
+	ErrAttestCARateLimited  = fmt.Errorf("%w: rate limited", ErrAttestCA)
+	ErrAttester             = bearclave.ErrAttester
+	ErrAttestResponses      = errors.New("attest responses")
+	ErrAttesterUserData     = bearclave.ErrAttesterUserData
+	ErrCMW                  = errors.New("cmw")
+	ErrCounterService       = errors.New("counter service")
+	ErrDialContext          = bearclave.ErrDialContext
+	ErrForwarder            = errors.New("forwarder")
+	ErrFrame                = errors.New("frame")
+	ErrKeyBroker            = errors.New("key broker")
+	ErrKeyBrokerRateLimited = fmt.Errorf("%w: rate limited", ErrKeyBroker)
+	ErrKMS                  = errors.New("kms")
+	ErrListener             = bearclave.ErrListener
+	ErrMutualAttest         = errors.New("mutual attest")
+	ErrNonceManager         = errors.New("nonce manager")
+	ErrNonceManagerExpired  = fmt.Errorf("%w: expired", ErrNonceManager)
+	ErrNonceManagerNotFound = fmt.Errorf("%w: unknown or used nonce", ErrNonceManager)
+	ErrNoNetworkAccess      = bearclave.ErrNoNetworkAccess
+	ErrProxy                = errors.New("proxy")
+	ErrProxyProtocol        = errors.New("proxy protocol")
+	ErrResolver             = errors.New("resolver")
+	ErrResolverDNSSEC       = fmt.Errorf("%w: dnssec", ErrResolver)
+	ErrReverseProxy         = errors.New("reverse proxy")
+	ErrRPC                  = errors.New("rpc")
+	ErrSealedStore          = errors.New("sealed store")
+	ErrSealedStoreNotFound  = fmt.Errorf("%w: not found", ErrSealedStore)
+	ErrSealedStoreRollback  = fmt.Errorf("%w: rollback", ErrSealedStore)
+	ErrSealer               = errors.New("sealer")
+	ErrSecureConn           = errors.New("secure conn")
+	ErrServer               = errors.New("server")
+	ErrSocket               = errors.New("socket")
+	ErrTimer                = bearclave.ErrTimer
+	ErrVerifier             = bearclave.ErrVerifier
+	ErrVerifierDebugMode    = bearclave.ErrVerifierDebugMode
+	ErrVerifierMeasurement  = bearclave.ErrVerifierMeasurement
+	ErrVerifierNonce        = bearclave.ErrVerifierNonce
+	ErrVerifierTimestamp    = bearclave.ErrVerifierTimestamp
+	ErrCertProvider         = errors.New("cert provider")
+	ErrUnsupportedPlatform  = errors.New("unsupported platform")
 )
 
 func wrapError(baseErr error, msg string, err error) error {
@@ -39,22 +64,122 @@ func wrapError(baseErr error, msg string, err error) error {
 	}
 }
 
+func attestCAError(msg string, err error) error {
+	return wrapError(ErrAttestCA, msg, err)
+}
+
+func attestCAErrorRateLimited(msg string, err error) error {
+	return wrapError(ErrAttestCARateLimited, msg, err)
+}
+
 func attesterError(msg string, err error) error {
 	return wrapError(ErrAttester, msg, err)
 }
 
+func attestResponsesError(msg string, err error) error {
+	return wrapError(ErrAttestResponses, msg, err)
+}
+
 func certProviderError(msg string, err error) error {
 	return wrapError(ErrCertProvider, msg, err)
 }
 
+func cmwError(msg string, err error) error {
+	return wrapError(ErrCMW, msg, err)
+}
+
+func counterServiceError(msg string, err error) error {
+	return wrapError(ErrCounterService, msg, err)
+}
+
+func dialContextError(msg string, err error) error {
+	return wrapError(ErrDialContext, msg, err)
+}
+
+func forwarderError(msg string, err error) error {
+	return wrapError(ErrForwarder, msg, err)
+}
+
+func frameError(msg string, err error) error {
+	return wrapError(ErrFrame, msg, err)
+}
+
+func keyBrokerError(msg string, err error) error {
+	return wrapError(ErrKeyBroker, msg, err)
+}
+
+func keyBrokerErrorRateLimited(msg string, err error) error {
+	return wrapError(ErrKeyBrokerRateLimited, msg, err)
+}
+
+func kmsError(msg string, err error) error {
+	return wrapError(ErrKMS, msg, err)
+}
+
+func listenerError(msg string, err error) error {
+	return wrapError(ErrListener, msg, err)
+}
+
+func mutualAttestError(msg string, err error) error {
+	return wrapError(ErrMutualAttest, msg, err)
+}
+
+func nonceManagerError(msg string, err error) error {
+	return wrapError(ErrNonceManager, msg, err)
+}
+
+func nonceManagerErrorExpired(msg string, err error) error {
+	return wrapError(ErrNonceManagerExpired, msg, err)
+}
+
+func nonceManagerErrorNotFound(msg string, err error) error {
+	return wrapError(ErrNonceManagerNotFound, msg, err)
+}
+
 func proxyError(msg string, err error) error {
 	return wrapError(ErrProxy, msg, err)
 }
 
+func proxyProtocolError(msg string, err error) error {
+	return wrapError(ErrProxyProtocol, msg, err)
+}
+
+func resolverError(msg string, err error) error {
+	return wrapError(ErrResolver, msg, err)
+}
+
+func resolverErrorDNSSEC(msg string, err error) error {
+	return wrapError(ErrResolverDNSSEC, msg, err)
+}
+
 func reverseProxyError(msg string, err error) error {
 	return wrapError(ErrReverseProxy, msg, err)
 }
 
+func rpcError(msg string, err error) error {
+	return wrapError(ErrRPC, msg, err)
+}
+
+func sealedStoreError(msg string, err error) error {
+	return wrapError(ErrSealedStore, msg, err)
+}
+
+func sealedStoreErrorNotFound(msg string, err error) error {
+	return wrapError(ErrSealedStoreNotFound, msg, err)
+}
+
+func sealedStoreErrorRollback(msg string, err error) error {
+	return wrapError(ErrSealedStoreRollback, msg, err)
+}
+
+func sealerError(msg string, err error) error {
+	return wrapError(ErrSealer, msg, err)
+}
+
+func secureConnError(msg string, err error) error {
+	return wrapError(ErrSecureConn, msg, err)
+}
+
 func serverError(msg string, err error) error {
 	return wrapError(ErrServer, msg, err)
 }
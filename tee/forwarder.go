@@ -0,0 +1,195 @@
+package tee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+)
+
+type ForwardMapping struct {
+	ListenAddr string `json:"listen_addr"`
+	TargetAddr string `json:"target_addr"`
+}
+
+type ForwarderRoute struct {
+	Listener    net.Listener
+	DialContext DialContext
+	TargetAddr  string
+}
+
+// Forwarder tunnels raw TCP streams between the enclave and the outside
+// world. On Nitro the host side listens on vsock and dials the TCP target,
+// while the enclave side listens on a local TCP port and dials the host over
+// vsock. Each ForwardMapping gets its own listener so one Forwarder can
+// serve several targets (e.g., Postgres, Redis, and KMS) at once.
+type Forwarder struct {
+	routes    []ForwarderRoute
+	logger    *slog.Logger
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewHostForwarder(
+	ctx context.Context,
+	platform Platform,
+	mappings []ForwardMapping,
+	logger *slog.Logger,
+) (*Forwarder, error) {
+	dialContext, err := NewDialContext(NoTEE)
+	if err != nil {
+		return nil, forwarderError("creating dialer", err)
+	}
+	return newForwarder(ctx, platform, dialContext, mappings, logger)
+}
+
+func NewEnclaveForwarder(
+	ctx context.Context,
+	platform Platform,
+	mappings []ForwardMapping,
+	logger *slog.Logger,
+) (*Forwarder, error) {
+	dialContext, err := NewDialContext(platform)
+	if err != nil {
+		return nil, forwarderError("creating dialer", err)
+	}
+	return newForwarder(ctx, NoTEE, dialContext, mappings, logger)
+}
+
+func newForwarder(
+	ctx context.Context,
+	listenPlatform Platform,
+	dialContext DialContext,
+	mappings []ForwardMapping,
+	logger *slog.Logger,
+) (*Forwarder, error) {
+	routes := make([]ForwarderRoute, 0, len(mappings))
+	for _, mapping := range mappings {
+		listener, err := NewListener(ctx, listenPlatform, NetworkTCP, mapping.ListenAddr)
+		if err != nil {
+			for _, route := range routes {
+				route.Listener.Close()
+			}
+			msg := fmt.Sprintf("creating listener on '%s'", mapping.ListenAddr)
+			return nil, forwarderError(msg, err)
+		}
+		routes = append(routes, ForwarderRoute{
+			Listener:    listener,
+			DialContext: dialContext,
+			TargetAddr:  mapping.TargetAddr,
+		})
+	}
+	return NewForwarderWithRoutes(routes, logger)
+}
+
+func NewForwarderWithRoutes(
+	routes []ForwarderRoute,
+	logger *slog.Logger,
+) (*Forwarder, error) {
+	if len(routes) == 0 {
+		return nil, forwarderError("no routes provided", nil)
+	}
+	return &Forwarder{
+		routes: routes,
+		logger: logger,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+func (f *Forwarder) Addrs() []string {
+	addrs := make([]string, 0, len(f.routes))
+	for _, route := range f.routes {
+		addrs = append(addrs, route.Listener.Addr().String())
+	}
+	return addrs
+}
+
+func (f *Forwarder) Close() error {
+	f.closeOnce.Do(func() { close(f.done) })
+
+	var errs []error
+	for _, route := range f.routes {
+		if err := route.Listener.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f *Forwarder) Serve() error {
+	errChan := make(chan error, len(f.routes))
+	for _, route := range f.routes {
+		go func() { errChan <- f.serveRoute(route) }()
+	}
+
+	var errs []error
+	for range f.routes {
+		if err := <-errChan; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f *Forwarder) serveRoute(route ForwarderRoute) error {
+	for {
+		clientConn, err := route.Listener.Accept()
+		if err != nil {
+			select {
+			case <-f.done:
+				return nil
+			default:
+			}
+			msg := fmt.Sprintf("accepting connection on '%s'", route.Listener.Addr())
+			return forwarderError(msg, err)
+		}
+		go f.forwardConn(clientConn, route.DialContext, route.TargetAddr)
+	}
+}
+
+func (f *Forwarder) forwardConn(
+	clientConn net.Conn,
+	dialContext DialContext,
+	targetAddr string,
+) {
+	defer clientConn.Close()
+
+	dialCtx, dialCancel := context.WithTimeout(context.Background(), DefaultConnTimeout)
+	defer dialCancel()
+
+	serverConn, err := dialContext(dialCtx, NetworkTCP, targetAddr)
+	if err != nil {
+		f.logger.Error(
+			"dialing target",
+			slog.String("target", targetAddr),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	defer serverConn.Close()
+
+	// Unlike the reverse proxy, forwarded connections (e.g., database
+	// sessions) are long-lived, so there is no overall connection timeout.
+	// The tunnel stays open until either side closes or the Forwarder does.
+	connDone := make(chan error, NumConnDoneChannels)
+	go func() {
+		_, connErr := copyNoSplice(serverConn, clientConn, forwarderError)
+		connDone <- connErr
+	}()
+	go func() {
+		_, connErr := copyNoSplice(clientConn, serverConn, forwarderError)
+		connDone <- connErr
+	}()
+
+	select {
+	case connErr := <-connDone:
+		if connErr != nil && !errors.Is(connErr, io.EOF) {
+			f.logger.Error("conn error", slog.String("error", connErr.Error()))
+		}
+	case <-f.done:
+		f.logger.Info("forwarder shutdown signal received, closing connection")
+	}
+}
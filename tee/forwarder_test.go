@@ -0,0 +1,141 @@
+package tee_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func newTestEchoServer(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestForwarder(t *testing.T) {
+	ctx := context.Background()
+	platform := tee.NoTEE
+	logger := slog.New(slog.DiscardHandler)
+
+	t.Run("happy path - enclave to host to target", func(t *testing.T) {
+		// given
+		want := []byte("hello world")
+		target := newTestEchoServer(t)
+		defer target.Close()
+
+		hostMappings := []tee.ForwardMapping{
+			{ListenAddr: "127.0.0.1:0", TargetAddr: target.Addr().String()},
+		}
+		host, err := tee.NewHostForwarder(ctx, platform, hostMappings, logger)
+		require.NoError(t, err)
+		defer host.Close()
+
+		enclaveMappings := []tee.ForwardMapping{
+			{ListenAddr: "127.0.0.1:0", TargetAddr: host.Addrs()[0]},
+		}
+		enclave, err := tee.NewEnclaveForwarder(ctx, platform, enclaveMappings, logger)
+		require.NoError(t, err)
+		defer enclave.Close()
+
+		runService(func() { _ = host.Serve() }, 10*time.Millisecond)
+		runService(func() { _ = enclave.Serve() }, 10*time.Millisecond)
+
+		// when
+		conn, err := net.Dial("tcp4", enclave.Addrs()[0])
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write(want)
+		require.NoError(t, err)
+
+		got := make([]byte, len(want))
+		_, err = io.ReadFull(conn, got)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("happy path - multiple mappings", func(t *testing.T) {
+		// given
+		target1 := newTestEchoServer(t)
+		defer target1.Close()
+		target2 := newTestEchoServer(t)
+		defer target2.Close()
+
+		mappings := []tee.ForwardMapping{
+			{ListenAddr: "127.0.0.1:0", TargetAddr: target1.Addr().String()},
+			{ListenAddr: "127.0.0.1:0", TargetAddr: target2.Addr().String()},
+		}
+		forwarder, err := tee.NewHostForwarder(ctx, platform, mappings, logger)
+		require.NoError(t, err)
+		defer forwarder.Close()
+
+		runService(func() { _ = forwarder.Serve() }, 10*time.Millisecond)
+
+		for _, addr := range forwarder.Addrs() {
+			// when
+			want := []byte(addr)
+			conn, err := net.Dial("tcp4", addr)
+			require.NoError(t, err)
+
+			_, err = conn.Write(want)
+			require.NoError(t, err)
+
+			got := make([]byte, len(want))
+			_, err = io.ReadFull(conn, got)
+			conn.Close()
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		}
+	})
+
+	t.Run("happy path - serve returns on close", func(t *testing.T) {
+		// given
+		mappings := []tee.ForwardMapping{
+			{ListenAddr: "127.0.0.1:0", TargetAddr: "127.0.0.1:9"},
+		}
+		forwarder, err := tee.NewHostForwarder(ctx, platform, mappings, logger)
+		require.NoError(t, err)
+
+		serveErr := make(chan error, 1)
+		runService(func() { serveErr <- forwarder.Serve() }, 10*time.Millisecond)
+
+		// when
+		err = forwarder.Close()
+
+		// then
+		require.NoError(t, err)
+		assert.NoError(t, <-serveErr)
+	})
+
+	t.Run("error - no routes", func(t *testing.T) {
+		// when
+		_, err := tee.NewForwarderWithRoutes(nil, logger)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrForwarder)
+	})
+}
@@ -0,0 +1,49 @@
+package tee
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const FrameHeaderSize = 4
+
+// readFrame reads a single length-prefixed frame from r. The length is a
+// 4-byte big-endian header. Frames larger than maxSize are rejected before
+// any of the payload is read so a peer cannot force a large allocation.
+func readFrame(r io.Reader, maxSize int) ([]byte, error) {
+	header := make([]byte, FrameHeaderSize)
+	_, err := io.ReadFull(r, header)
+	switch {
+	case err == io.EOF: //nolint:errorlint
+		// The peer closed the connection cleanly between frames.
+		return nil, io.EOF
+	case err != nil:
+		return nil, frameError("reading header", err)
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if uint64(size) > uint64(maxSize) {
+		return nil, frameError(fmt.Sprintf("size %d exceeds max %d", size, maxSize), nil)
+	}
+
+	data := make([]byte, size)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, frameError("reading payload", err)
+	}
+	return data, nil
+}
+
+func writeFrame(w io.Writer, data []byte, maxSize int) error {
+	if len(data) > maxSize {
+		return frameError(fmt.Sprintf("size %d exceeds max %d", len(data), maxSize), nil)
+	}
+
+	frame := make([]byte, FrameHeaderSize+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data))) //nolint:gosec
+	copy(frame[FrameHeaderSize:], data)
+	if _, err := w.Write(frame); err != nil {
+		return frameError("writing", err)
+	}
+	return nil
+}
@@ -10,6 +10,8 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -53,17 +55,23 @@ func MakeProxyTLSHandler(
 		dialCtx, cancel := context.WithTimeout(r.Context(), timeout)
 		defer cancel()
 
+		labels := Labels{"component": ComponentProxyTLS}
+		DefaultMetrics.IncCounter(MetricProxyConnections, labels)
+
 		targetAddr := r.RequestURI
-		serverConn, err := (&net.Dialer{}).DialContext(dialCtx, NetworkTCP4, targetAddr)
+		serverConn, err := (&net.Dialer{}).DialContext(dialCtx, NetworkTCP, targetAddr)
 		if err != nil {
 			msg := "dialing: " + targetAddr
 			logger.Error(msg, slog.String("error", err.Error()))
+			DefaultMetrics.IncCounter(MetricProxyDialFailures, labels)
+			recordHTTPResponse(ComponentProxyTLS, http.StatusBadGateway)
 			_, _ = clientConn.Write([]byte(ProxyBadGateway))
 			return
 		}
 		defer serverConn.Close()
 
 		logger.Info("connection established")
+		recordHTTPResponse(ComponentProxyTLS, http.StatusOK)
 		_, err = clientConn.Write([]byte(ProxyConnectionEstablished))
 		if err != nil {
 			logger.Error("writing response", slog.String("error", err.Error()))
@@ -73,13 +81,18 @@ func MakeProxyTLSHandler(
 		connCtx, connCancel := context.WithTimeout(r.Context(), timeout)
 		defer connCancel()
 
+		start := time.Now()
+		defer DefaultMetrics.ObserveDuration(MetricProxyTunnelSeconds, labels, start)
+
 		connDone := make(chan error, NumConnDoneChannels)
 		go func() {
-			_, connErr := copyNoSplice(serverConn, clientConn)
+			n, connErr := copyNoSplice(serverConn, clientConn, proxyError)
+			recordProxyBytes(ComponentProxyTLS, DirectionUpstream, n)
 			connDone <- connErr
 		}()
 		go func() {
-			_, connErr := copyNoSplice(clientConn, serverConn)
+			n, connErr := copyNoSplice(clientConn, serverConn, proxyError)
+			recordProxyBytes(ComponentProxyTLS, DirectionDownstream, n)
 			connDone <- connErr
 		}()
 
@@ -103,6 +116,7 @@ func MakeProxyHandler(
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
+		DefaultMetrics.IncCounter(MetricProxyConnections, Labels{"component": ComponentProxy})
 		targetURL := &url.URL{
 			Scheme:   "http",
 			Host:     r.Host,
@@ -111,8 +125,15 @@ func MakeProxyHandler(
 			RawQuery: r.URL.RawQuery,
 		}
 
-		ctx, cancel := context.WithTimeout(r.Context(), timeout)
-		defer cancel()
+		// Upgraded connections (e.g., WebSockets) are long-lived, so they are
+		// not bound by the proxy timeout.
+		upgrade, isUpgrade := UpgradeType(r.Header)
+		ctx := r.Context()
+		if !isUpgrade {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
 		f, err := http.NewRequestWithContext(
 			ctx, r.Method, targetURL.String(), r.Body,
 		)
@@ -127,6 +148,10 @@ func MakeProxyHandler(
 		CopyHTTPHeadersForForwarding(f.Header, r.Header)
 		SetHTTPHeadersForForwarding(f, r)
 		f.RequestURI = ""
+		if isUpgrade {
+			f.Header.Set("Connection", "Upgrade")
+			f.Header.Set("Upgrade", upgrade)
+		}
 
 		logger.Info("forwarding request", slog.String("url", f.URL.String()))
 		resp, err := client.Do(f)
@@ -134,19 +159,27 @@ func MakeProxyHandler(
 			logger.Error(
 				"forwarding request", slog.String("error", err.Error()),
 			)
+			DefaultMetrics.IncCounter(MetricProxyDialFailures, Labels{"component": ComponentProxy})
+			recordHTTPResponse(ComponentProxy, http.StatusInternalServerError)
 			WriteError(w, proxyError("forwarding request", err))
 			return
 		}
 		defer resp.Body.Close()
 
-		for key, values := range resp.Header {
-			for _, value := range values {
-				w.Header().Add(key, value)
-			}
+		recordHTTPResponse(ComponentProxy, resp.StatusCode)
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			proxyUpgradedConn(w, resp, upgrade, logger)
+			return
 		}
 
+		CopyHTTPHeadersForForwarding(w.Header(), resp.Header)
 		w.WriteHeader(resp.StatusCode)
-		if _, err := io.Copy(w, resp.Body); err != nil {
+
+		// Flush after every read so streaming responses (e.g., server-sent
+		// events or chunked bodies) reach the client as they arrive.
+		n, err := copyNoSplice(&flushWriter{w: w}, resp.Body, proxyError)
+		recordProxyBytes(ComponentProxy, DirectionDownstream, n)
+		if err != nil {
 			logger.Error(
 				"copying response body", slog.String("error", err.Error()),
 			)
@@ -155,6 +188,139 @@ func MakeProxyHandler(
 	}
 }
 
+func proxyUpgradedConn(
+	w http.ResponseWriter,
+	resp *http.Response,
+	upgrade string,
+	logger *slog.Logger,
+) {
+	respUpgrade, _ := UpgradeType(resp.Header)
+	if !strings.EqualFold(respUpgrade, upgrade) {
+		msg := fmt.Sprintf("upgrade mismatch: requested '%s' got '%s'", upgrade, respUpgrade)
+		logger.Error(msg)
+		WriteError(w, proxyError(msg, nil))
+		return
+	}
+
+	serverConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		msg := "upgraded response body is not writable"
+		logger.Error(msg)
+		WriteError(w, proxyError(msg, nil))
+		return
+	}
+	defer serverConn.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		msg := "hijacking is not supported"
+		logger.Error(msg)
+		WriteError(w, proxyError(msg, nil))
+		return
+	}
+
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		msg := "hijacking connection"
+		logger.Error(msg, slog.String("error", err.Error()))
+		WriteError(w, proxyError(msg, err))
+		return
+	}
+	defer clientConn.Close()
+
+	// NOTE: Do NOT write to ResponseWriter after hijacking connection. The
+	// server's read/write timeouts may still be set on the hijacked conn.
+	_ = clientConn.SetDeadline(time.Time{})
+
+	header := http.Header{}
+	CopyHTTPHeadersForForwarding(header, resp.Header)
+	header.Set("Connection", "Upgrade")
+	header.Set("Upgrade", respUpgrade)
+	_, err = fmt.Fprintf(clientBuf, "HTTP/1.1 %s\r\n", resp.Status)
+	if err == nil {
+		err = header.Write(clientBuf)
+	}
+	if err == nil {
+		_, err = clientBuf.WriteString("\r\n")
+	}
+	if err == nil {
+		err = clientBuf.Flush()
+	}
+	if err != nil {
+		logger.Error("writing upgrade response", slog.String("error", err.Error()))
+		return
+	}
+
+	logger.Info("connection upgraded", slog.String("upgrade", respUpgrade))
+	connDone := make(chan error, NumConnDoneChannels)
+	go func() {
+		// The client may have sent data right after its request, which the
+		// server has already buffered, so read from the buffer first.
+		n, connErr := copyNoSplice(serverConn, clientBuf, proxyError)
+		recordProxyBytes(ComponentProxy, DirectionUpstream, n)
+		connDone <- connErr
+	}()
+	go func() {
+		n, connErr := copyNoSplice(clientConn, serverConn, proxyError)
+		recordProxyBytes(ComponentProxy, DirectionDownstream, n)
+		connDone <- connErr
+	}()
+
+	connErr := <-connDone
+	if connErr != nil && !errors.Is(connErr, io.EOF) {
+		logger.Error("copy error", slog.String("error", connErr.Error()))
+	} else {
+		logger.Info("connection closed")
+	}
+}
+
+// UpgradeType returns the protocol requested in the Upgrade header if the
+// Connection header includes the "upgrade" token.
+func UpgradeType(header http.Header) (string, bool) {
+	if !HeaderHasToken(header, "Connection", "upgrade") {
+		return "", false
+	}
+	upgrade := header.Get("Upgrade")
+	return upgrade, upgrade != ""
+}
+
+func HeaderHasToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for field := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f *flushWriter) Write(b []byte) (int, error) {
+	n, err := f.w.Write(b)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+func recordHTTPResponse(component string, status int) {
+	DefaultMetrics.IncCounter(MetricHTTPResponses, Labels{
+		"component": component,
+		"code":      strconv.Itoa(status),
+	})
+}
+
+func recordProxyBytes(component string, direction string, n int64) {
+	DefaultMetrics.AddCounter(MetricProxyBytes, Labels{
+		"component": component,
+		"direction": direction,
+	}, float64(n))
+}
+
 func CopyHTTPHeadersForForwarding(f http.Header, r http.Header) {
 	ignoredHeaders := map[string]bool{
 		"Connection":          true,
@@ -162,10 +328,22 @@ func CopyHTTPHeadersForForwarding(f http.Header, r http.Header) {
 		"Proxy-Authenticate":  true,
 		"Proxy-Authorization": true,
 		"Te":                  true,
+		"Trailer":             true,
 		"Trailers":            true,
 		"Transfer-Encoding":   true,
 		"Upgrade":             true,
 	}
+
+	// Headers named in the Connection header are also hop-by-hop and must
+	// not be forwarded (RFC 9110, Section 7.6.1).
+	for _, value := range r.Values("Connection") {
+		for field := range strings.SplitSeq(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				ignoredHeaders[http.CanonicalHeaderKey(field)] = true
+			}
+		}
+	}
+
 	for header, values := range r {
 		if !ignoredHeaders[header] {
 			for _, value := range values {
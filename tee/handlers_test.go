@@ -1,9 +1,12 @@
 package tee_test
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -148,3 +151,114 @@ func TestMakeProxyHandler(t *testing.T) {
 		assert.Contains(t, logBuffer.String(), "context deadline exceeded")
 	})
 }
+
+func TestMakeProxyHandler_Upgrade(t *testing.T) {
+	t.Run("happy path - websocket style upgrade", func(t *testing.T) {
+		// given
+		want := []byte("hello world")
+		backend := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				upgrade, ok := tee.UpgradeType(r.Header)
+				if !assert.True(t, ok) {
+					return
+				}
+				conn, buf, err := http.NewResponseController(w).Hijack()
+				if !assert.NoError(t, err) {
+					return
+				}
+				defer conn.Close()
+				_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+					"Connection: Upgrade\r\nUpgrade: " + upgrade + "\r\n\r\n")
+				_ = buf.Flush()
+				_, _ = io.Copy(conn, buf)
+			}),
+		)
+		defer backend.Close()
+
+		logger := slog.New(slog.DiscardHandler)
+		handler := tee.MakeProxyHandler(&http.Client{}, logger, tee.DefaultProxyTimeout)
+		proxy := httptest.NewServer(handler)
+		defer proxy.Close()
+
+		conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// when
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: " +
+			backend.Listener.Addr().String() +
+			"\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+		require.NoError(t, err)
+
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+		assert.Equal(t, "echo", resp.Header.Get("Upgrade"))
+
+		_, err = conn.Write(want)
+		require.NoError(t, err)
+		got := make([]byte, len(want))
+		_, err = io.ReadFull(reader, got)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+}
+
+func TestMakeProxyHandler_Streaming(t *testing.T) {
+	t.Run("happy path - flushes chunks as they arrive", func(t *testing.T) {
+		// given
+		want := "data: first\n"
+		release := make(chan struct{})
+		backend := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				_, _ = io.WriteString(w, want)
+				w.(http.Flusher).Flush() //nolint:forcetypeassert
+				<-release
+			}),
+		)
+		defer backend.Close()
+		defer close(release)
+
+		logger := slog.New(slog.DiscardHandler)
+		handler := tee.MakeProxyHandler(&http.Client{}, logger, tee.DefaultProxyTimeout)
+		proxy := httptest.NewServer(handler)
+		defer proxy.Close()
+
+		req := makeRequest(t, "GET", proxy.URL, nil)
+		req.Host = backend.Listener.Addr().String()
+
+		// when
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		got, err := bufio.NewReader(resp.Body).ReadString('\n')
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+}
+
+func TestCopyHTTPHeadersForForwarding(t *testing.T) {
+	t.Run("happy path - strips headers named in connection", func(t *testing.T) {
+		// given
+		r := http.Header{}
+		r.Set("Connection", "keep-alive, X-Hop-Header")
+		r.Set("X-Hop-Header", "secret")
+		r.Set("X-End-To-End", "value")
+		f := http.Header{}
+
+		// when
+		tee.CopyHTTPHeadersForForwarding(f, r)
+
+		// then
+		assert.Empty(t, f.Get("Connection"))
+		assert.Empty(t, f.Get("X-Hop-Header"))
+		assert.Equal(t, "value", f.Get("X-End-To-End"))
+	})
+}
@@ -0,0 +1,504 @@
+package tee
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	KeyBrokerNoncePath       = "/bearclave/key-broker/nonce"
+	KeyBrokerSecretsPath     = "/bearclave/key-broker/secrets"
+	KeyBrokerLabel           = "bearclave key broker v1"
+	KeyBrokerMaxBodySize     = 1 * Megabyte
+	DefaultKeyBrokerNonceTTL = 1 * time.Minute
+
+	// DefaultKeyBrokerNonceRate and DefaultKeyBrokerNonceBurst limit the nonces
+	// a single source can keep outstanding within a TTL to well below
+	// MemoryNonceStoreMax.
+	DefaultKeyBrokerNonceRate  = 10
+	DefaultKeyBrokerNonceBurst = 20
+	KeyBrokerMaxNonceSources   = MemoryNonceStoreMax
+)
+
+type KeyBrokerNonceResponse struct {
+	Nonce []byte `json:"nonce"`
+}
+
+type KeyBrokerRequest struct {
+	Nonce       []byte        `json:"nonce"`
+	PublicKey   []byte        `json:"publickey"`
+	Secrets     []string      `json:"secrets"`
+	Attestation *AttestResult `json:"attestation"`
+}
+
+// KeyBrokerResponse holds each requested secret sealed to the enclave's key.
+// PublicKey is the broker's ephemeral ECDH key for this response.
+type KeyBrokerResponse struct {
+	PublicKey []byte            `json:"publickey"`
+	Secrets   map[string][]byte `json:"secrets"`
+}
+
+// KeyBrokerSecret is a secret and the measurements of the enclaves allowed to
+// fetch it.
+type KeyBrokerSecret struct {
+	Value        []byte
+	Measurements []string
+}
+
+// KeyBrokerBinding is the user data an enclave attests to when fetching
+// secrets. It binds the attestation to the broker's nonce and to the
+// DER-encoded ECDH public key the secrets will be encrypted to.
+func KeyBrokerBinding(nonce []byte, publicKey []byte) []byte {
+	binding := make([]byte, 0, len(KeyBrokerLabel)+len(nonce)+len(publicKey)+1)
+	binding = append(binding, KeyBrokerLabel...)
+	binding = append(binding, 0)
+	binding = append(binding, nonce...)
+	return append(binding, publicKey...)
+}
+
+// KeyBroker releases secrets to attested enclaves. An enclave fetches a
+// single-use nonce, attests to it together with an ephemeral ECDH public key,
+// and gets back the secrets it asked for, encrypted to that key. Each secret
+// has its own measurement policy, and a request fails unless the enclave is
+// allowed every secret it asks for.
+type KeyBroker struct {
+	verifier  *Verifier
+	secrets   map[string]KeyBrokerSecret
+	nonces    *NonceManager
+	nonceRate *rateLimiter
+	opts      KeyBrokerOptions
+}
+
+func NewKeyBroker(
+	verifier *Verifier,
+	secrets map[string]KeyBrokerSecret,
+	options ...KeyBrokerOption,
+) (*KeyBroker, error) {
+	opts := MakeDefaultKeyBrokerOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	switch {
+	case verifier == nil:
+		return nil, keyBrokerError("verifier is required", nil)
+	case opts.NonceTTL <= 0:
+		return nil, keyBrokerError("nonce ttl must be positive", nil)
+	case opts.NonceRate <= 0 || opts.NonceBurst < 1:
+		return nil, keyBrokerError("nonce rate and burst must be positive", nil)
+	}
+	for name, secret := range secrets {
+		if len(secret.Measurements) == 0 {
+			msg := fmt.Sprintf("secret '%s' has no allowed measurements", name)
+			return nil, keyBrokerError(msg, nil)
+		}
+	}
+
+	nonces := opts.NonceManager
+	if nonces == nil {
+		var err error
+		nonces, err = NewNonceManager(NewMemoryNonceStore(), WithNonceManagerTTL(opts.NonceTTL))
+		if err != nil {
+			return nil, keyBrokerError("making nonce manager", err)
+		}
+	}
+	return &KeyBroker{
+		verifier:  verifier,
+		secrets:   secrets,
+		nonces:    nonces,
+		nonceRate: newRateLimiter(opts.NonceRate, opts.NonceBurst, KeyBrokerMaxNonceSources),
+		opts:      opts,
+	}, nil
+}
+
+// Nonce issues a nonce that Release accepts once, within the nonce TTL.
+// Anyone can ask for a nonce, so issuance is rate limited per source (see
+// WithKeyBrokerNonceRate) to keep clients from filling the nonce store.
+// source identifies the requester, e.g., its IP address.
+func (k *KeyBroker) Nonce(ctx context.Context, source string) ([]byte, error) {
+	if !k.nonceRate.allow(source) {
+		return nil, keyBrokerErrorRateLimited("too many nonce requests", nil)
+	}
+	nonce, err := k.nonces.Issue(ctx)
+	if err != nil {
+		return nil, keyBrokerError("issuing nonce", err)
+	}
+	return nonce, nil
+}
+
+// Release verifies the request and returns the requested secrets encrypted to
+// the request's public key. Like NonceManager.Verify, it only uses up the
+// nonce if the request succeeds, so a forged request cannot burn the nonce of
+// an enclave that is still attesting.
+func (k *KeyBroker) Release(
+	ctx context.Context,
+	req *KeyBrokerRequest,
+) (*KeyBrokerResponse, error) {
+	if err := k.nonces.Check(ctx, req.Nonce); err != nil {
+		return nil, keyBrokerError("checking nonce", err)
+	}
+	if req.Attestation == nil {
+		return nil, keyBrokerError("missing attestation", nil)
+	}
+	if len(req.Secrets) == 0 {
+		return nil, keyBrokerError("no secrets requested", nil)
+	}
+
+	peerKey, err := parseKeyBrokerPublicKey(req.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	binding := KeyBrokerBinding(req.Nonce, req.PublicKey)
+	verified := map[string]error{}
+	for _, name := range req.Secrets {
+		secret, ok := k.secrets[name]
+		if !ok {
+			return nil, keyBrokerError(fmt.Sprintf("unknown secret '%s'", name), nil)
+		}
+		if err = k.verify(req.Attestation, binding, secret.Measurements, verified); err != nil {
+			return nil, keyBrokerError(fmt.Sprintf("not allowed secret '%s'", name), err)
+		}
+	}
+
+	privateKey, err := ecdh.P256().GenerateKey(crand.Reader)
+	if err != nil {
+		return nil, keyBrokerError("generating key", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(privateKey.PublicKey())
+	if err != nil {
+		return nil, keyBrokerError("marshaling public key", err)
+	}
+	sealer, err := keyBrokerSealer(privateKey, peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &KeyBrokerResponse{PublicKey: publicKey, Secrets: map[string][]byte{}}
+	for _, name := range req.Secrets {
+		resp.Secrets[name], err = sealer.Seal(k.secrets[name].Value, []byte(name))
+		if err != nil {
+			return nil, keyBrokerError("sealing secret", err)
+		}
+	}
+	if err = k.nonces.Consume(ctx, req.Nonce); err != nil {
+		return nil, keyBrokerError("consuming nonce", err)
+	}
+	return resp, nil
+}
+
+// verify accepts the attestation if it verifies against any of measurements.
+// Results are cached in verified, keyed by measurement, since secrets often
+// share a policy.
+func (k *KeyBroker) verify(
+	attestation *AttestResult,
+	binding []byte,
+	measurements []string,
+	verified map[string]error,
+) error {
+	var errs []error
+	for _, measurement := range measurements {
+		err, ok := verified[measurement]
+		if !ok {
+			err = k.verifyMeasurement(attestation, binding, measurement)
+			verified[measurement] = err
+		}
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (k *KeyBroker) verifyMeasurement(
+	attestation *AttestResult,
+	binding []byte,
+	measurement string,
+) error {
+	policy := append([]VerifyOption{WithVerifyMeasurement(measurement)}, k.opts.Verify...)
+	verifyResult, err := k.verifier.Verify(attestation, policy...)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(verifyResult.UserData, binding) {
+		return keyBrokerError("attestation is not bound to the nonce and key", nil)
+	}
+	return nil
+}
+
+func parseKeyBrokerPublicKey(der []byte) (*ecdh.PublicKey, error) {
+	publicKey, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, keyBrokerError("parsing public key", err)
+	}
+	ecdsaKey, ok := publicKey.(*ecdsa.PublicKey)
+	if !ok || ecdsaKey.Curve != elliptic.P256() {
+		return nil, keyBrokerError("public key is not a P-256 key", nil)
+	}
+	ecdhKey, err := ecdsaKey.ECDH()
+	if err != nil {
+		return nil, keyBrokerError("converting public key", err)
+	}
+	return ecdhKey, nil
+}
+
+// keyBrokerSealer returns the AEADSealer both sides derive from their ECDH
+// shared secret. The broker uses a fresh key for every response, so the
+// derived key is never reused.
+func keyBrokerSealer(privateKey *ecdh.PrivateKey, peerKey *ecdh.PublicKey) (*AEADSealer, error) {
+	shared, err := privateKey.ECDH(peerKey)
+	if err != nil {
+		return nil, keyBrokerError("computing shared secret", err)
+	}
+	rootKey, err := hkdf.Key(sha256.New, shared, nil, KeyBrokerLabel, SealKeySize)
+	if err != nil {
+		return nil, keyBrokerError("deriving key", err)
+	}
+	return NewAEADSealer(rootKey)
+}
+
+// MakeKeyBrokerHandler serves KeyBroker.Nonce at KeyBrokerNoncePath and
+// KeyBroker.Release at KeyBrokerSecretsPath. Nonces are rate limited by the
+// request's remote IP, so a broker behind a proxy should use a
+// ProxyProtocolListener. Why a request failed is only logged, so that
+// clients cannot use the broker to probe its policy.
+func MakeKeyBrokerHandler(broker *KeyBroker, logger *slog.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+KeyBrokerNoncePath, func(w http.ResponseWriter, r *http.Request) {
+		source, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			source = r.RemoteAddr
+		}
+		nonce, err := broker.Nonce(r.Context(), source)
+		switch {
+		case errors.Is(err, ErrKeyBrokerRateLimited):
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		case err != nil:
+			logger.Error("issuing nonce", slog.String("error", err.Error()))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		WriteResponse(w, KeyBrokerNonceResponse{Nonce: nonce})
+	})
+	mux.HandleFunc("POST "+KeyBrokerSecretsPath, func(w http.ResponseWriter, r *http.Request) {
+		req := KeyBrokerRequest{}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, KeyBrokerMaxBodySize)).Decode(&req)
+		if err != nil {
+			http.Error(w, keyBrokerError("decoding request", err).Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := broker.Release(r.Context(), &req)
+		if err != nil {
+			logger.Error("releasing secrets", slog.String("error", err.Error()))
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		WriteResponse(w, resp)
+	})
+	return mux
+}
+
+type KeyBrokerOption func(*KeyBrokerOptions)
+type KeyBrokerOptions struct {
+	NonceBurst   int
+	NonceManager *NonceManager
+	NonceRate    float64
+	NonceTTL     time.Duration
+	Verify       []VerifyOption
+}
+
+func MakeDefaultKeyBrokerOptions() KeyBrokerOptions {
+	return KeyBrokerOptions{
+		NonceBurst:   DefaultKeyBrokerNonceBurst,
+		NonceManager: nil,
+		NonceRate:    DefaultKeyBrokerNonceRate,
+		NonceTTL:     DefaultKeyBrokerNonceTTL,
+		Verify:       nil,
+	}
+}
+
+// WithKeyBrokerNonceManager sets the NonceManager used to issue nonces, e.g.,
+// one backed by a DirNonceStore so nonces survive restarts. It overrides
+// WithKeyBrokerNonceTTL.
+func WithKeyBrokerNonceManager(manager *NonceManager) KeyBrokerOption {
+	return func(opts *KeyBrokerOptions) {
+		opts.NonceManager = manager
+	}
+}
+
+// WithKeyBrokerNonceRate limits nonce issuance to rate per second with bursts
+// of up to burst, per source. Requests over the limit fail with ErrKeyBrokerRateLimited.
+func WithKeyBrokerNonceRate(rate float64, burst int) KeyBrokerOption {
+	return func(opts *KeyBrokerOptions) {
+		opts.NonceRate = rate
+		opts.NonceBurst = burst
+	}
+}
+
+// WithKeyBrokerNonceTTL sets how long an enclave has to use a nonce, which
+// must cover attesting, e.g., a few seconds on SEV-SNP.
+func WithKeyBrokerNonceTTL(ttl time.Duration) KeyBrokerOption {
+	return func(opts *KeyBrokerOptions) {
+		opts.NonceTTL = ttl
+	}
+}
+
+func WithKeyBrokerVerifyOptions(options ...VerifyOption) KeyBrokerOption {
+	return func(opts *KeyBrokerOptions) {
+		opts.Verify = append(opts.Verify, options...)
+	}
+}
+
+// KeyBrokerClient is the enclave side of KeyBroker, typically used once at
+// boot to fetch the secrets the enclave needs.
+type KeyBrokerClient struct {
+	attester  *Attester
+	client    *http.Client
+	brokerURL string
+}
+
+// NewKeyBrokerClient creates a client that reaches the broker at brokerURL
+// through the proxy at proxyAddr (see NewProxiedClient). brokerURL must be
+// https: the secrets are encrypted to the enclave, but the broker is only
+// authenticated by TLS.
+func NewKeyBrokerClient(
+	platform Platform,
+	proxyAddr string,
+	brokerURL string,
+	attester *Attester,
+) (*KeyBrokerClient, error) {
+	client, err := NewProxiedClient(platform, proxyAddr)
+	if err != nil {
+		return nil, keyBrokerError("creating proxied client", err)
+	}
+	return NewKeyBrokerClientWithClient(client, brokerURL, attester)
+}
+
+func NewKeyBrokerClientWithClient(
+	client *http.Client,
+	brokerURL string,
+	attester *Attester,
+) (*KeyBrokerClient, error) {
+	parsed, err := url.Parse(brokerURL)
+	switch {
+	case err != nil:
+		return nil, keyBrokerError("parsing broker url", err)
+	case parsed.Scheme != "https":
+		return nil, keyBrokerError("broker url must be https: "+brokerURL, nil)
+	case attester == nil:
+		return nil, keyBrokerError("attester is required", nil)
+	}
+	return &KeyBrokerClient{
+		attester:  attester,
+		client:    client,
+		brokerURL: strings.TrimSuffix(brokerURL, "/"),
+	}, nil
+}
+
+// FetchSecrets returns the named secrets, keyed by name.
+func (k *KeyBrokerClient) FetchSecrets(
+	ctx context.Context,
+	names ...string,
+) (map[string][]byte, error) {
+	nonceResp := KeyBrokerNonceResponse{}
+	if err := k.post(ctx, KeyBrokerNoncePath, struct{}{}, &nonceResp); err != nil {
+		return nil, err
+	}
+
+	privateKey, err := ecdh.P256().GenerateKey(crand.Reader)
+	if err != nil {
+		return nil, keyBrokerError("generating key", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(privateKey.PublicKey())
+	if err != nil {
+		return nil, keyBrokerError("marshaling public key", err)
+	}
+	binding := KeyBrokerBinding(nonceResp.Nonce, publicKey)
+	attestation, err := k.attester.Attest(WithAttestUserData(binding))
+	if err != nil {
+		return nil, keyBrokerError("attesting", err)
+	}
+
+	req := KeyBrokerRequest{
+		Nonce:       nonceResp.Nonce,
+		PublicKey:   publicKey,
+		Secrets:     names,
+		Attestation: attestation,
+	}
+	resp := KeyBrokerResponse{}
+	if err = k.post(ctx, KeyBrokerSecretsPath, req, &resp); err != nil {
+		return nil, err
+	}
+
+	peerKey, err := parseKeyBrokerPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sealer, err := keyBrokerSealer(privateKey, peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := make(map[string][]byte, len(names))
+	for _, name := range names {
+		sealed, ok := resp.Secrets[name]
+		if !ok {
+			return nil, keyBrokerError(fmt.Sprintf("missing secret '%s'", name), nil)
+		}
+		secrets[name], err = sealer.Unseal(sealed, []byte(name))
+		if err != nil {
+			return nil, keyBrokerError("unsealing secret", err)
+		}
+	}
+	return secrets, nil
+}
+
+func (k *KeyBrokerClient) post(ctx context.Context, path string, in any, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return keyBrokerError("marshaling request", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.brokerURL+path, bytes.NewReader(body))
+	if err != nil {
+		return keyBrokerError("creating request", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return keyBrokerError("sending request", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, KeyBrokerMaxBodySize))
+		errMsg := fmt.Sprintf("broker rejected request: %s: %s", resp.Status, bytes.TrimSpace(msg))
+		return keyBrokerError(errMsg, nil)
+	}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, KeyBrokerMaxBodySize)).Decode(out)
+	if err != nil {
+		return keyBrokerError("decoding response", err)
+	}
+	return nil
+}
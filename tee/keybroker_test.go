@@ -0,0 +1,232 @@
+package tee_test
+
+import (
+	"context"
+	"crypto/ecdh"
+	crand "crypto/rand"
+	"crypto/x509"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func newTestKeyBroker(t *testing.T, options ...tee.KeyBrokerOption) *tee.KeyBroker {
+	t.Helper()
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+	broker, err := tee.NewKeyBroker(verifier, map[string]tee.KeyBrokerSecret{
+		"db-password": {Value: []byte("hunter2"), Measurements: []string{"old", noTEEMeasurement}},
+		"api-token":   {Value: []byte("token"), Measurements: []string{noTEEMeasurement}},
+		"other-app":   {Value: []byte("other"), Measurements: []string{"other"}},
+	}, options...)
+	require.NoError(t, err)
+	return broker
+}
+
+func newTestKeyBrokerRequest(
+	t *testing.T,
+	broker *tee.KeyBroker,
+	secrets ...string,
+) *tee.KeyBrokerRequest {
+	t.Helper()
+	nonce, err := broker.Nonce(context.Background(), "127.0.0.1")
+	require.NoError(t, err)
+	privateKey, err := ecdh.P256().GenerateKey(crand.Reader)
+	require.NoError(t, err)
+	publicKey, err := x509.MarshalPKIXPublicKey(privateKey.PublicKey())
+	require.NoError(t, err)
+
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	attestation, err := attester.Attest(tee.WithAttestUserData(tee.KeyBrokerBinding(nonce, publicKey)))
+	require.NoError(t, err)
+
+	return &tee.KeyBrokerRequest{
+		Nonce:       nonce,
+		PublicKey:   publicKey,
+		Secrets:     secrets,
+		Attestation: attestation,
+	}
+}
+
+func TestKeyBrokerClient_FetchSecrets(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewTLSServer(tee.MakeKeyBrokerHandler(newTestKeyBroker(t), slog.New(slog.DiscardHandler)))
+	defer server.Close()
+
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	client, err := tee.NewKeyBrokerClientWithClient(server.Client(), server.URL, attester)
+	require.NoError(t, err)
+
+	t.Run("happy path", func(t *testing.T) {
+		// when
+		got, err := client.FetchSecrets(ctx, "db-password", "api-token")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]byte{
+			"db-password": []byte("hunter2"),
+			"api-token":   []byte("token"),
+		}, got)
+	})
+
+	t.Run("error - measurement not allowed", func(t *testing.T) {
+		// when
+		_, err := client.FetchSecrets(ctx, "api-token", "other-app")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrKeyBroker)
+		assert.ErrorContains(t, err, "403 Forbidden: Forbidden")
+		assert.NotContains(t, err.Error(), "other-app")
+	})
+}
+
+func TestNewKeyBrokerClientWithClient(t *testing.T) {
+	t.Run("error - broker url is not https", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+
+		// when
+		_, err = tee.NewKeyBrokerClientWithClient(http.DefaultClient, "http://broker.example", attester)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrKeyBroker)
+		assert.ErrorContains(t, err, "must be https")
+	})
+}
+
+func TestKeyBroker_Nonce(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("happy path - sources are limited separately", func(t *testing.T) {
+		// given
+		broker := newTestKeyBroker(t, tee.WithKeyBrokerNonceRate(0.001, 2))
+		for range 2 {
+			_, err := broker.Nonce(ctx, "192.0.2.1")
+			require.NoError(t, err)
+		}
+
+		// when
+		_, err := broker.Nonce(ctx, "192.0.2.2")
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("error - rate limited", func(t *testing.T) {
+		// given
+		broker := newTestKeyBroker(t, tee.WithKeyBrokerNonceRate(0.001, 2))
+		for range 2 {
+			_, err := broker.Nonce(ctx, "192.0.2.1")
+			require.NoError(t, err)
+		}
+
+		// when
+		_, err := broker.Nonce(ctx, "192.0.2.1")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrKeyBrokerRateLimited)
+	})
+}
+
+func TestKeyBroker_Release(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("error - nonce replayed", func(t *testing.T) {
+		// given
+		broker := newTestKeyBroker(t)
+		req := newTestKeyBrokerRequest(t, broker, "api-token")
+		_, err := broker.Release(ctx, req)
+		require.NoError(t, err)
+
+		// when
+		_, err = broker.Release(ctx, req)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrKeyBroker)
+		require.ErrorIs(t, err, tee.ErrNonceManagerNotFound)
+	})
+
+	t.Run("error - nonce expired", func(t *testing.T) {
+		// given
+		broker := newTestKeyBroker(t, tee.WithKeyBrokerNonceTTL(time.Millisecond))
+		req := newTestKeyBrokerRequest(t, broker, "api-token")
+		time.Sleep(10 * time.Millisecond)
+
+		// when
+		_, err := broker.Release(ctx, req)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrKeyBroker)
+		require.ErrorIs(t, err, tee.ErrNonceManagerExpired)
+	})
+
+	t.Run("happy path - failed request does not use up nonce", func(t *testing.T) {
+		// given
+		broker := newTestKeyBroker(t)
+		req := newTestKeyBrokerRequest(t, broker, "api-token")
+		forged := *req
+		forged.Attestation = newTestKeyBrokerRequest(t, broker, "api-token").Attestation
+		_, err := broker.Release(ctx, &forged)
+		require.Error(t, err)
+
+		// when
+		resp, err := broker.Release(ctx, req)
+
+		// then
+		require.NoError(t, err)
+		assert.Contains(t, resp.Secrets, "api-token")
+	})
+
+	t.Run("error - attestation bound to another key", func(t *testing.T) {
+		// given
+		broker := newTestKeyBroker(t)
+		req := newTestKeyBrokerRequest(t, broker, "api-token")
+		other := newTestKeyBrokerRequest(t, broker, "api-token")
+		req.PublicKey = other.PublicKey
+
+		// when
+		_, err := broker.Release(ctx, req)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrKeyBroker)
+		assert.ErrorContains(t, err, "not bound to the nonce and key")
+	})
+
+	t.Run("error - unknown secret", func(t *testing.T) {
+		// given
+		broker := newTestKeyBroker(t)
+		req := newTestKeyBrokerRequest(t, broker, "missing")
+
+		// when
+		_, err := broker.Release(ctx, req)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrKeyBroker)
+		assert.ErrorContains(t, err, "unknown secret 'missing'")
+	})
+}
+
+func TestNewKeyBroker(t *testing.T) {
+	t.Run("error - secret without measurements", func(t *testing.T) {
+		// given
+		verifier, err := tee.NewVerifier(tee.NoTEE)
+		require.NoError(t, err)
+
+		// when
+		_, err = tee.NewKeyBroker(verifier, map[string]tee.KeyBrokerSecret{
+			"open": {Value: []byte("secret")},
+		})
+
+		// then
+		require.ErrorIs(t, err, tee.ErrKeyBroker)
+	})
+}
@@ -0,0 +1,389 @@
+package tee
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	AWSSignatureAlgorithm     = "AWS4-HMAC-SHA256"
+	AWSSignatureTimeFormat    = "20060102T150405Z"
+	DefaultKMSKeySpec         = "AES_256"
+	KMSContentType            = "application/x-amz-json-1.1"
+	KMSKeyEncryptionAlgorithm = "RSAES_OAEP_SHA_256"
+	KMSMaxBodySize            = 1 * Megabyte
+	KMSRecipientKeyBits       = 2048
+	KMSService                = "kms"
+	KMSTargetDecrypt          = "TrentService.Decrypt"
+	KMSTargetGenerateDataKey  = "TrentService.GenerateDataKey"
+)
+
+// AWSCredentials are the credentials used to sign KMS requests. Enclaves have
+// no instance metadata service, so these are usually fetched by the host and
+// passed in, e.g., over an RPCClient.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+type kmsRecipient struct {
+	KeyEncryptionAlgorithm string `json:"KeyEncryptionAlgorithm"`
+	AttestationDocument    []byte `json:"AttestationDocument"`
+}
+
+type kmsRequest struct {
+	CiphertextBlob    []byte            `json:"CiphertextBlob,omitempty"`
+	EncryptionContext map[string]string `json:"EncryptionContext,omitempty"`
+	KeyID             string            `json:"KeyId,omitempty"`
+	KeySpec           string            `json:"KeySpec,omitempty"`
+	Recipient         *kmsRecipient     `json:"Recipient"`
+}
+
+type kmsResponse struct {
+	CiphertextBlob         []byte `json:"CiphertextBlob"`
+	CiphertextForRecipient []byte `json:"CiphertextForRecipient"`
+	KeyID                  string `json:"KeyId"`
+}
+
+type kmsErrorResponse struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+// KMSClient calls AWS KMS with an attestation document as the Recipient, so
+// KMS encrypts its response to a key that only exists inside the enclave and
+// key policies can require specific PCRs (e.g., kms:RecipientAttestation:PCR0).
+// Every call uses a fresh RSA key, so a response is useless outside the call
+// that requested it.
+type KMSClient struct {
+	attester    *Attester
+	client      *http.Client
+	endpoint    string
+	region      string
+	credentials AWSCredentials
+}
+
+func NewKMSClient(
+	platform Platform,
+	proxyAddr string,
+	region string,
+	attester *Attester,
+	credentials AWSCredentials,
+) (*KMSClient, error) {
+	client, err := NewProxiedClient(platform, proxyAddr)
+	if err != nil {
+		return nil, kmsError("creating proxied client", err)
+	}
+	endpoint := fmt.Sprintf("https://kms.%s.amazonaws.com", region)
+	return NewKMSClientWithClient(client, endpoint, region, attester, credentials)
+}
+
+func NewKMSClientWithClient(
+	client *http.Client,
+	endpoint string,
+	region string,
+	attester *Attester,
+	credentials AWSCredentials,
+) (*KMSClient, error) {
+	switch {
+	case attester == nil:
+		return nil, kmsError("attester is required", nil)
+	case region == "":
+		return nil, kmsError("region is required", nil)
+	case credentials.AccessKeyID == "" || credentials.SecretAccessKey == "":
+		return nil, kmsError("credentials are required", nil)
+	}
+	return &KMSClient{
+		attester:    attester,
+		client:      client,
+		endpoint:    strings.TrimSuffix(endpoint, "/"),
+		region:      region,
+		credentials: credentials,
+	}, nil
+}
+
+// Decrypt asks KMS to decrypt ciphertextBlob, as returned by KMS Encrypt or
+// GenerateDataKey, and decrypts the result inside the enclave.
+func (k *KMSClient) Decrypt(
+	ctx context.Context,
+	ciphertextBlob []byte,
+	options ...KMSOption,
+) ([]byte, error) {
+	opts := MakeDefaultKMSOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	request := kmsRequest{
+		CiphertextBlob:    ciphertextBlob,
+		EncryptionContext: opts.EncryptionContext,
+		KeyID:             opts.KeyID,
+	}
+	plaintext, _, err := k.call(ctx, KMSTargetDecrypt, request)
+	return plaintext, err
+}
+
+// GenerateDataKey asks KMS for a new data key under keyID. It returns the
+// plaintext key, which never leaves the enclave, and the encrypted key, which
+// can be stored anywhere and passed to Decrypt later.
+func (k *KMSClient) GenerateDataKey(
+	ctx context.Context,
+	keyID string,
+	options ...KMSOption,
+) ([]byte, []byte, error) {
+	opts := MakeDefaultKMSOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	request := kmsRequest{
+		EncryptionContext: opts.EncryptionContext,
+		KeyID:             keyID,
+		KeySpec:           opts.KeySpec,
+	}
+	return k.call(ctx, KMSTargetGenerateDataKey, request)
+}
+
+func (k *KMSClient) call(
+	ctx context.Context,
+	target string,
+	request kmsRequest,
+) ([]byte, []byte, error) {
+	privateKey, err := rsa.GenerateKey(crand.Reader, KMSRecipientKeyBits)
+	if err != nil {
+		return nil, nil, kmsError("generating recipient key", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, nil, kmsError("marshaling recipient key", err)
+	}
+	attestation, err := k.attester.Attest(WithAttestPublicKey(publicKey))
+	if err != nil {
+		return nil, nil, kmsError("attesting", err)
+	}
+	request.Recipient = &kmsRecipient{
+		KeyEncryptionAlgorithm: KMSKeyEncryptionAlgorithm,
+		AttestationDocument:    attestation.Base.Report,
+	}
+
+	response, err := k.send(ctx, target, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(response.CiphertextForRecipient) == 0 {
+		return nil, nil, kmsError("missing ciphertext for recipient", nil)
+	}
+
+	plaintext, err := decryptEnvelopedData(response.CiphertextForRecipient, privateKey)
+	if err != nil {
+		return nil, nil, kmsError("decrypting ciphertext for recipient", err)
+	}
+	return plaintext, response.CiphertextBlob, nil
+}
+
+func (k *KMSClient) send(
+	ctx context.Context,
+	target string,
+	request kmsRequest,
+) (*kmsResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, kmsError("marshaling request", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, kmsError("creating request", err)
+	}
+	req.Header.Set("Content-Type", KMSContentType)
+	req.Header.Set("X-Amz-Target", target)
+	err = SignAWSRequestV4(req, body, k.credentials, k.region, KMSService, time.Now())
+	if err != nil {
+		return nil, kmsError("signing request", err)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, kmsError("sending request", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, KMSMaxBodySize))
+	if err != nil {
+		return nil, kmsError("reading response", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		errResp := kmsErrorResponse{}
+		_ = json.Unmarshal(data, &errResp)
+		errMsg := fmt.Sprintf("KMS rejected request: %s: %s: %s", resp.Status, errResp.Type, errResp.Message)
+		return nil, kmsError(errMsg, nil)
+	}
+
+	kmsResp := &kmsResponse{}
+	if err = json.Unmarshal(data, kmsResp); err != nil {
+		return nil, kmsError("decoding response", err)
+	}
+	return kmsResp, nil
+}
+
+// SignAWSRequestV4 signs req with AWS Signature Version 4, setting the
+// X-Amz-Date, X-Amz-Security-Token (for temporary credentials), and
+// Authorization headers. Every header already set on req is signed, so set
+// them all before signing. body must be the request body.
+func SignAWSRequestV4(
+	req *http.Request,
+	body []byte,
+	credentials AWSCredentials,
+	region string,
+	service string,
+	now time.Time,
+) error {
+	if req.URL == nil {
+		return kmsError("request has no URL", nil)
+	}
+
+	amzDate := now.UTC().Format(AWSSignatureTimeFormat)
+	date := amzDate[:8]
+	req.Header.Del("Authorization")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	headers := map[string]string{}
+	for name, values := range req.Header {
+		trimmed := make([]string, 0, len(values))
+		for _, value := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(value), " "))
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	headers["host"] = req.Host
+	if headers["host"] == "" {
+		headers["host"] = req.URL.Host
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := strings.Builder{}
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalAWSQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		AWSSignatureAlgorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + credentials.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		AWSSignatureAlgorithm,
+		credentials.AccessKeyID,
+		scope,
+		signedHeaders,
+		signature,
+	))
+	return nil
+}
+
+func canonicalAWSQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, awsURIEncode(key)+"="+awsURIEncode(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func awsURIEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+type KMSOption func(*KMSOptions)
+type KMSOptions struct {
+	EncryptionContext map[string]string
+	KeyID             string
+	KeySpec           string
+}
+
+func MakeDefaultKMSOptions() KMSOptions {
+	return KMSOptions{
+		EncryptionContext: nil,
+		KeyID:             "",
+		KeySpec:           DefaultKMSKeySpec,
+	}
+}
+
+// WithKMSEncryptionContext sets the encryption context, which must match the
+// one used to encrypt.
+func WithKMSEncryptionContext(encryptionContext map[string]string) KMSOption {
+	return func(opts *KMSOptions) {
+		opts.EncryptionContext = encryptionContext
+	}
+}
+
+// WithKMSKeyID sets the key Decrypt must use. It is required for asymmetric
+// keys and recommended otherwise.
+func WithKMSKeyID(keyID string) KMSOption {
+	return func(opts *KMSOptions) {
+		opts.KeyID = keyID
+	}
+}
+
+// WithKMSKeySpec sets the kind of data key GenerateDataKey returns, e.g.,
+// "AES_128".
+func WithKMSKeySpec(keySpec string) KMSOption {
+	return func(opts *KMSOptions) {
+		opts.KeySpec = keySpec
+	}
+}
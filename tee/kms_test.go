@@ -0,0 +1,333 @@
+package tee_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave"
+	"github.com/tahardi/bearclave/tee"
+)
+
+var testAWSCredentials = tee.AWSCredentials{
+	AccessKeyID:     "AKIDEXAMPLE",
+	SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	SessionToken:    "session-token",
+}
+
+// testKMS is a local stand-in for AWS KMS. It checks request signatures,
+// verifies the NoTEE attestation document in Recipient, and answers with
+// BER-encoded CMS EnvelopedData for the attested public key, using indefinite
+// lengths and chunked OCTET STRINGs the way real CMS producers may.
+type testKMS struct {
+	t        *testing.T
+	verifier *tee.Verifier
+
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func newTestKMSServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+
+	kms := &testKMS{t: t, verifier: verifier, blobs: map[string][]byte{}}
+	server := httptest.NewServer(kms)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestKMSClient(t *testing.T, server *httptest.Server) *tee.KMSClient {
+	t.Helper()
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	client, err := tee.NewKMSClientWithClient(
+		server.Client(),
+		server.URL,
+		"us-east-1",
+		attester,
+		testAWSCredentials,
+	)
+	require.NoError(t, err)
+	return client
+}
+
+func (k *testKMS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	require.NoError(k.t, err)
+	if !k.validSignature(r, body) {
+		k.fail(w, http.StatusForbidden, "InvalidSignatureException", "bad signature")
+		return
+	}
+
+	request := struct {
+		CiphertextBlob    []byte            `json:"CiphertextBlob"`
+		EncryptionContext map[string]string `json:"EncryptionContext"`
+		KeyID             string            `json:"KeyId"`
+		Recipient         struct {
+			KeyEncryptionAlgorithm string `json:"KeyEncryptionAlgorithm"`
+			AttestationDocument    []byte `json:"AttestationDocument"`
+		} `json:"Recipient"`
+	}{}
+	require.NoError(k.t, json.Unmarshal(body, &request))
+	require.Equal(k.t, tee.KMSKeyEncryptionAlgorithm, request.Recipient.KeyEncryptionAlgorithm)
+
+	verified, err := k.verifier.Verify(&tee.AttestResult{
+		Base: &bearclave.AttestResult{Report: request.Recipient.AttestationDocument},
+	})
+	if err != nil {
+		k.fail(w, http.StatusBadRequest, "ValidationException", "bad attestation document")
+		return
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(verified.Base.PublicKey)
+	require.NoError(k.t, err)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	encryptionContext := fmt.Sprint(request.EncryptionContext)
+	response := map[string]any{"KeyId": request.KeyID}
+	var plaintext []byte
+	switch r.Header.Get("X-Amz-Target") {
+	case tee.KMSTargetGenerateDataKey:
+		plaintext = make([]byte, 32)
+		_, err = crand.Read(plaintext)
+		require.NoError(k.t, err)
+		blob := fmt.Sprintf("blob-%d", len(k.blobs))
+		k.blobs[blob+encryptionContext] = plaintext
+		response["CiphertextBlob"] = []byte(blob)
+	case tee.KMSTargetDecrypt:
+		var ok bool
+		if plaintext, ok = k.blobs[string(request.CiphertextBlob)+encryptionContext]; !ok {
+			k.fail(w, http.StatusBadRequest, "InvalidCiphertextException", "")
+			return
+		}
+	default:
+		k.fail(w, http.StatusBadRequest, "UnknownOperationException", "")
+		return
+	}
+	response["CiphertextForRecipient"] = k.envelope(publicKey.(*rsa.PublicKey), plaintext)
+
+	w.Header().Set("Content-Type", tee.KMSContentType)
+	require.NoError(k.t, json.NewEncoder(w).Encode(response))
+}
+
+// validSignature re-signs the request with the headers it claims to have
+// signed and compares the result.
+func (k *testKMS) validSignature(r *http.Request, body []byte) bool {
+	auth := r.Header.Get("Authorization")
+	_, signedHeaders, ok := strings.Cut(auth, "SignedHeaders=")
+	if !ok {
+		return false
+	}
+	signedHeaders, _, _ = strings.Cut(signedHeaders, ",")
+	now, err := time.Parse(tee.AWSSignatureTimeFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+
+	req, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.String(), nil)
+	require.NoError(k.t, err)
+	for _, name := range strings.Split(signedHeaders, ";") {
+		if name != "host" {
+			req.Header[http.CanonicalHeaderKey(name)] = r.Header.Values(name)
+		}
+	}
+	err = tee.SignAWSRequestV4(req, body, testAWSCredentials, "us-east-1", tee.KMSService, now)
+	require.NoError(k.t, err)
+	return req.Header.Get("Authorization") == auth
+}
+
+func (k *testKMS) fail(w http.ResponseWriter, status int, errType string, message string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"__type": errType, "message": message})
+}
+
+func (k *testKMS) envelope(publicKey *rsa.PublicKey, plaintext []byte) []byte {
+	contentKey := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	_, err := crand.Read(contentKey)
+	require.NoError(k.t, err)
+	_, err = crand.Read(iv)
+	require.NoError(k.t, err)
+
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), crand.Reader, publicKey, contentKey, nil)
+	require.NoError(k.t, err)
+
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(bytes.Clone(plaintext), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	block, err := aes.NewCipher(contentKey)
+	require.NoError(k.t, err)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	half := len(ciphertext) / 2
+	return berIndefinite(0x30,
+		berOID(k.t, asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}),
+		berIndefinite(0xa0,
+			berIndefinite(0x30,
+				berDefinite(0x02, []byte{2}),
+				berIndefinite(0x31,
+					berIndefinite(0x30,
+						berDefinite(0x02, []byte{2}),
+						berDefinite(0x80, []byte("subject key id")),
+						berDefinite(0x30, berOID(k.t, asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7})),
+						berDefinite(0x04, encryptedKey),
+					),
+				),
+				berIndefinite(0x30,
+					berOID(k.t, asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}),
+					berDefinite(0x30,
+						berOID(k.t, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}),
+						berDefinite(0x04, iv),
+					),
+					berIndefinite(0xa0,
+						berDefinite(0x04, ciphertext[:half]),
+						berDefinite(0x04, ciphertext[half:]),
+					),
+				),
+			),
+		),
+	)
+}
+
+func berDefinite(tag byte, content ...[]byte) []byte {
+	joined := bytes.Join(content, nil)
+	length := []byte{byte(len(joined))}
+	switch {
+	case len(joined) >= 0x100:
+		length = []byte{0x82, byte(len(joined) >> 8), byte(len(joined))}
+	case len(joined) >= 0x80:
+		length = []byte{0x81, byte(len(joined))}
+	}
+	return append(append([]byte{tag}, length...), joined...)
+}
+
+func berIndefinite(tag byte, children ...[]byte) []byte {
+	encoded := append([]byte{tag, 0x80}, bytes.Join(children, nil)...)
+	return append(encoded, 0, 0)
+}
+
+func berOID(t *testing.T, oid asn1.ObjectIdentifier) []byte {
+	t.Helper()
+	der, err := asn1.Marshal(oid)
+	require.NoError(t, err)
+	return der
+}
+
+func TestKMSClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("happy path - generate and decrypt data key", func(t *testing.T) {
+		// given
+		server := newTestKMSServer(t)
+		client := newTestKMSClient(t, server)
+		encryptionContext := map[string]string{"app": "bearclave"}
+		dataKey, blob, err := client.GenerateDataKey(
+			ctx,
+			"alias/test",
+			tee.WithKMSEncryptionContext(encryptionContext),
+		)
+		require.NoError(t, err)
+
+		// when
+		got, err := client.Decrypt(
+			ctx,
+			blob,
+			tee.WithKMSKeyID("alias/test"),
+			tee.WithKMSEncryptionContext(encryptionContext),
+		)
+
+		// then
+		require.NoError(t, err)
+		assert.Len(t, dataKey, 32)
+		assert.Equal(t, dataKey, got)
+	})
+
+	t.Run("error - kms rejects request", func(t *testing.T) {
+		// given
+		server := newTestKMSServer(t)
+		client := newTestKMSClient(t, server)
+		_, blob, err := client.GenerateDataKey(ctx, "alias/test")
+		require.NoError(t, err)
+
+		// when
+		_, err = client.Decrypt(
+			ctx,
+			blob,
+			tee.WithKMSEncryptionContext(map[string]string{"app": "other"}),
+		)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrKMS)
+		assert.ErrorContains(t, err, "InvalidCiphertextException")
+	})
+
+	t.Run("error - missing credentials", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+
+		// when
+		_, err = tee.NewKMSClientWithClient(
+			http.DefaultClient,
+			"http://127.0.0.1",
+			"us-east-1",
+			attester,
+			tee.AWSCredentials{},
+		)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrKMS)
+	})
+}
+
+func TestSignAWSRequestV4(t *testing.T) {
+	t.Run("happy path - aws test vector", func(t *testing.T) {
+		// given
+		// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+		req, err := http.NewRequest(
+			http.MethodGet,
+			"https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			nil,
+		)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+		credentials := tee.AWSCredentials{
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		}
+		now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+		// when
+		err = tee.SignAWSRequestV4(req, nil, credentials, "us-east-1", "iam", now)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+		assert.Equal(
+			t,
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, "+
+				"SignedHeaders=content-type;host;x-amz-date, "+
+				"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+			req.Header.Get("Authorization"),
+		)
+	})
+}
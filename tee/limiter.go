@@ -0,0 +1,159 @@
+package tee
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a simple token bucket rate limiter. Tokens refill
+// continuously at rate per second up to burst.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token if one is available and otherwise returns how long
+// the caller should wait before trying again.
+func (t *tokenBucket) reserve() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.tokens = min(t.burst, t.tokens+now.Sub(t.last).Seconds()*t.rate)
+	t.last = now
+	if t.tokens >= 1 {
+		t.tokens--
+		return 0
+	}
+	return time.Duration((1 - t.tokens) / t.rate * float64(time.Second))
+}
+
+// wait blocks until a token is available or done is closed. It returns false
+// if done was closed first.
+func (t *tokenBucket) wait(done <-chan struct{}) bool {
+	for {
+		delay := t.reserve()
+		if delay == 0 {
+			return true
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-done:
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+// full reports whether the bucket has refilled to burst.
+func (t *tokenBucket) full() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tokens+time.Since(t.last).Seconds()*t.rate >= t.burst
+}
+
+// rateLimiter keeps a token bucket per source IP, so one noisy source cannot
+// use up the budget of every other. To bound memory, at most maxIPs sources
+// are tracked: buckets that have refilled are dropped to make room, and new
+// sources are refused while every tracked source is still limited.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   int
+	maxIPs  int
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(rate float64, burst int, maxIPs int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   burst,
+		maxIPs:  maxIPs,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// allow takes a token from ip's bucket and reports whether one was available.
+func (r *rateLimiter) allow(ip string) bool {
+	r.mu.Lock()
+	bucket, ok := r.buckets[ip]
+	if !ok {
+		if len(r.buckets) >= r.maxIPs {
+			r.prune()
+		}
+		if len(r.buckets) >= r.maxIPs {
+			r.mu.Unlock()
+			return false
+		}
+		bucket = newTokenBucket(r.rate, r.burst)
+		r.buckets[ip] = bucket
+	}
+	r.mu.Unlock()
+	return bucket.reserve() == 0
+}
+
+func (r *rateLimiter) prune() {
+	for ip, bucket := range r.buckets {
+		if bucket.full() {
+			delete(r.buckets, ip)
+		}
+	}
+}
+
+// connLimiter caps the number of connections held per source IP.
+type connLimiter struct {
+	mu    sync.Mutex
+	max   int
+	conns map[string]int
+}
+
+func newConnLimiter(maxPerIP int) *connLimiter {
+	return &connLimiter{max: maxPerIP, conns: map[string]int{}}
+}
+
+func (c *connLimiter) acquire(addr net.Addr) (string, bool) {
+	ip := addrIP(addr)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conns[ip] >= c.max {
+		return ip, false
+	}
+	c.conns[ip]++
+	return ip, true
+}
+
+func (c *connLimiter) release(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conns[ip]--
+	if c.conns[ip] <= 0 {
+		delete(c.conns, ip)
+	}
+}
+
+func addrIP(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
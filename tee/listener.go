@@ -2,11 +2,18 @@ package tee
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"sync"
 
 	"github.com/tahardi/bearclave"
 )
 
+// NewListener creates a listener for the given platform. Addresses that name
+// their network (see Address), e.g., "tcp6://[::]:8080", "vsock://16:8080" or
+// "unix:///run/bearclave.sock", are listened on over that network regardless
+// of platform. NitroSim is the exception: like an enclave, it can only listen
+// on (simulated) vsock addresses.
 func NewListener(
 	ctx context.Context,
 	platform Platform,
@@ -14,6 +21,23 @@ func NewListener(
 	addr string,
 	options ...ListenerOption,
 ) (net.Listener, error) {
+	address, err := ParseAddress(addr)
+	if err != nil {
+		return nil, listenerError("", err)
+	}
+
+	switch {
+	case address.Network() == SchemeVSock && platform == NitroSim:
+		return bearclave.NewSimVSocketListener(ctx, network, addr, options...)
+	case address.Network() != "" && platform == NitroSim:
+		msg := fmt.Sprintf("enclave cannot listen on '%s' directly", addr)
+		return nil, listenerError(msg, ErrNoNetworkAccess)
+	case address.Network() == SchemeVSock:
+		return bearclave.NewVSocketListener(ctx, network, addr, options...)
+	case address.Network() != "":
+		return bearclave.NewSocketListener(ctx, network, addr, options...)
+	}
+
 	switch platform {
 	case Nitro:
 		return bearclave.NewVSocketListener(ctx, network, addr, options...)
@@ -23,11 +47,17 @@ func NewListener(
 		return bearclave.NewSocketListener(ctx, network, addr, options...)
 	case NoTEE:
 		return bearclave.NewSocketListener(ctx, network, addr, options...)
+	case NitroSim:
+		return bearclave.NewSimVSocketListener(ctx, network, addr, options...)
 	default:
 		return nil, unsupportedPlatformError(string(platform), nil)
 	}
 }
 
+const SimVSocketDirEnv = bearclave.SimVSocketDirEnv
+
+var SimVSocketDir = bearclave.SimVSocketDir
+
 type ListenerOption = bearclave.ListenerOption
 type ListenerOptions = bearclave.ListenerOptions
 
@@ -35,4 +65,79 @@ var (
 	WithListenControl         = bearclave.WithListenControl
 	WithListenKeepAlive       = bearclave.WithListenKeepAlive
 	WithListenKeepAliveConfig = bearclave.WithListenKeepAliveConfig
+	WithListenUnixSocketMode  = bearclave.WithListenUnixSocketMode
 )
+
+// handshakeListener runs handshake on every connection it accepts before
+// Accept returns it. Handshakes run concurrently, so a slow client cannot
+// stall the accept loop. Connections whose handshake fails are closed and
+// dropped rather than returned as an error, which would stop servers such as
+// http.Server. The handshake is responsible for bounding its own duration.
+type handshakeListener struct {
+	net.Listener
+
+	handshake func(conn net.Conn) (net.Conn, error)
+	conns     chan net.Conn
+	done      chan struct{}
+	failed    chan struct{}
+	err       error
+
+	startOnce sync.Once
+	closeOnce sync.Once
+}
+
+func newHandshakeListener(
+	listener net.Listener,
+	handshake func(conn net.Conn) (net.Conn, error),
+) *handshakeListener {
+	return &handshakeListener{
+		Listener:  listener,
+		handshake: handshake,
+		conns:     make(chan net.Conn),
+		done:      make(chan struct{}),
+		failed:    make(chan struct{}),
+	}
+}
+
+func (h *handshakeListener) Accept() (net.Conn, error) {
+	h.startOnce.Do(func() { go h.acceptLoop() })
+	select {
+	case conn := <-h.conns:
+		return conn, nil
+	case <-h.failed:
+		return nil, h.err
+	case <-h.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (h *handshakeListener) Close() error {
+	h.closeOnce.Do(func() { close(h.done) })
+	return h.Listener.Close()
+}
+
+func (h *handshakeListener) acceptLoop() {
+	for {
+		conn, err := h.Listener.Accept()
+		if err != nil {
+			h.err = err
+			close(h.failed)
+			return
+		}
+		go h.serve(conn)
+	}
+}
+
+func (h *handshakeListener) serve(conn net.Conn) {
+	out, err := h.handshake(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	select {
+	case h.conns <- out:
+	case <-h.done:
+		out.Close()
+	}
+}
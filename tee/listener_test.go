@@ -0,0 +1,196 @@
+package tee_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func TestNewServer_Networks(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	want := "hello world"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, want)
+	})
+
+	t.Run("happy path - tcp6", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		server, err := tee.NewServer(ctx, tee.NoTEE, "tcp6://[::1]:0", handler, logger)
+		require.NoError(t, err)
+		defer server.Close()
+		runService(func() { _ = server.Serve() }, 0)
+
+		// when
+		resp, err := http.Get("http://" + server.Addr())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		// then
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	})
+
+	t.Run("happy path - bare ipv6", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		server, err := tee.NewServer(ctx, tee.NoTEE, "[::1]:0", handler, logger)
+		require.NoError(t, err)
+		defer server.Close()
+		runService(func() { _ = server.Serve() }, 0)
+
+		// when
+		resp, err := http.Get("http://" + server.Addr())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		// then
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	})
+
+	t.Run("happy path - reverse proxy to unix socket", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		socketPath := filepath.Join(t.TempDir(), "server.sock")
+		server, err := tee.NewServer(ctx, tee.NoTEE, "unix://"+socketPath, handler, logger)
+		require.NoError(t, err)
+		defer server.Close()
+		runService(func() { _ = server.Serve() }, 0)
+
+		revProxy, err := tee.NewReverseProxy(ctx, tee.NoTEE, "127.0.0.1:0", "unix://"+socketPath, logger)
+		require.NoError(t, err)
+		defer revProxy.Close()
+		runService(func() { _ = revProxy.Serve() }, 0)
+
+		// when
+		resp, err := http.Get("http://" + revProxy.Addr())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		// then
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	})
+
+	t.Run("happy path - unix socket with proxied client", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		server, err := tee.NewServer(ctx, tee.NoTEE, "127.0.0.1:0", handler, logger)
+		require.NoError(t, err)
+		defer server.Close()
+		runService(func() { _ = server.Serve() }, 0)
+
+		socketPath := filepath.Join(t.TempDir(), "proxy.sock")
+		proxy, err := tee.NewProxy(ctx, tee.NoTEE, "unix://"+socketPath, http.DefaultClient, logger)
+		require.NoError(t, err)
+		defer proxy.Close()
+		runService(func() { _ = proxy.Serve() }, 0)
+
+		client, err := tee.NewProxiedClient(tee.NoTEE, "unix://"+socketPath)
+		require.NoError(t, err)
+
+		// when
+		resp, err := client.Get("http://" + server.Addr())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		// then
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	})
+
+	t.Run("happy path - address overrides platform", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		socketPath := filepath.Join(t.TempDir(), "server.sock")
+		server, err := tee.NewServer(ctx, tee.Nitro, "unix://"+socketPath, handler, logger)
+		require.NoError(t, err)
+		defer server.Close()
+		runService(func() { _ = server.Serve() }, 0)
+
+		dialContext, err := tee.NewDialContext(tee.Nitro)
+		require.NoError(t, err)
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				return dialContext(ctx, network, "unix://"+socketPath)
+			},
+		}}
+
+		// when
+		resp, err := client.Get("http://localhost")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		// then
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	})
+
+	t.Run("error - invalid address", func(t *testing.T) {
+		// when
+		_, err := tee.NewListener(context.Background(), tee.NoTEE, tee.NetworkTCP4, "vsock://0:8080")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrListener)
+		require.ErrorIs(t, err, tee.ErrAddress)
+	})
+
+	t.Run("happy path - unix socket mode", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		socketPath := filepath.Join(t.TempDir(), "server.sock")
+		wantMode := os.FileMode(0o600)
+
+		// when
+		listener, err := tee.NewListener(
+			ctx,
+			tee.NoTEE,
+			tee.NetworkTCP4,
+			"unix://"+socketPath,
+			tee.WithListenUnixSocketMode(wantMode),
+		)
+		require.NoError(t, err)
+		defer listener.Close()
+
+		// then
+		info, err := os.Stat(socketPath)
+		require.NoError(t, err)
+		assert.Equal(t, wantMode, info.Mode().Perm())
+	})
+
+	t.Run("error - unix socket mode does not replace existing socket", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		socketPath := filepath.Join(t.TempDir(), "server.sock")
+		live, err := tee.NewListener(ctx, tee.NoTEE, tee.NetworkTCP, "unix://"+socketPath)
+		require.NoError(t, err)
+		defer live.Close()
+
+		// when
+		_, err = tee.NewListener(
+			ctx,
+			tee.NoTEE,
+			tee.NetworkTCP,
+			"unix://"+socketPath,
+			tee.WithListenUnixSocketMode(0o600),
+		)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrListener)
+	})
+}
@@ -0,0 +1,316 @@
+package tee
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MetricsContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	MetricTypeCounter   = "counter"
+	MetricTypeHistogram = "histogram"
+
+	MetricAttestDuration     = "bearclave_attest_duration_seconds"
+	MetricAttestErrors       = "bearclave_attest_errors_total"
+	MetricVerifyDuration     = "bearclave_verify_duration_seconds"
+	MetricVerifyErrors       = "bearclave_verify_errors_total"
+	MetricProxyConnections   = "bearclave_proxy_connections_total"
+	MetricProxyDialFailures  = "bearclave_proxy_dial_failures_total"
+	MetricProxyBytes         = "bearclave_proxy_bytes_total"
+	MetricProxyRejected      = "bearclave_proxy_rejected_connections_total"
+	MetricProxyTunnelSeconds = "bearclave_proxy_tunnel_duration_seconds"
+	MetricHTTPResponses      = "bearclave_http_responses_total"
+
+	ComponentProxy           = "proxy"
+	ComponentProxyTLS        = "proxy_tls"
+	ComponentReverseProxy    = "reverse_proxy"
+	ComponentReverseProxyTLS = "reverse_proxy_tls"
+	ComponentServer          = "server"
+	DirectionUpstream        = "upstream"
+	DirectionDownstream      = "downstream"
+	ErrorClassDebugMode      = "debug_mode"
+	ErrorClassMeasurement    = "measurement"
+	ErrorClassNonce          = "nonce"
+	ErrorClassTimestamp      = "timestamp"
+	ErrorClassUserData       = "user_data"
+	ErrorClassOther          = "other"
+)
+
+var DefaultMetricsBuckets = []float64{
+	0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300,
+}
+
+// DefaultMetrics is the registry the tee proxies, servers, attesters, and
+// verifiers record to. Serve it with MakeMetricsHandler(DefaultMetrics).
+var DefaultMetrics = NewMetrics()
+
+type Labels map[string]string
+
+type metricSeries struct {
+	labels       string
+	value        float64
+	bucketCounts []uint64
+	sum          float64
+	count        uint64
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+// Metrics is a minimal metrics registry that renders the Prometheus text
+// exposition format. It supports just counters and histograms, which is
+// all the tee package needs, and avoids pulling in the Prometheus client.
+type Metrics struct {
+	mu       sync.Mutex
+	families map[string]*metricFamily
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{families: map[string]*metricFamily{}}
+	m.RegisterHistogram(MetricAttestDuration, "Attestation latency by platform.", DefaultMetricsBuckets)
+	m.RegisterCounter(MetricAttestErrors, "Attestation errors by platform and error class.")
+	m.RegisterHistogram(MetricVerifyDuration, "Verification latency by platform.", DefaultMetricsBuckets)
+	m.RegisterCounter(MetricVerifyErrors, "Verification errors by platform and error class.")
+	m.RegisterCounter(MetricProxyConnections, "Connections handled by proxy component.")
+	m.RegisterCounter(MetricProxyDialFailures, "Failed dials to proxy targets.")
+	m.RegisterCounter(MetricProxyBytes, "Bytes proxied by component and direction.")
+	m.RegisterCounter(MetricProxyRejected, "Connections rejected by component and reason.")
+	m.RegisterHistogram(MetricProxyTunnelSeconds, "Tunnel lifetime by proxy component.", DefaultMetricsBuckets)
+	m.RegisterCounter(MetricHTTPResponses, "HTTP responses by component and status code.")
+	return m
+}
+
+func (m *Metrics) RegisterCounter(name string, help string) {
+	m.register(name, help, MetricTypeCounter, nil)
+}
+
+func (m *Metrics) RegisterHistogram(name string, help string, buckets []float64) {
+	m.register(name, help, MetricTypeHistogram, buckets)
+}
+
+func (m *Metrics) register(name string, help string, kind string, buckets []float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.families[name]; ok {
+		return
+	}
+	m.families[name] = &metricFamily{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		buckets: slices.Sorted(slices.Values(buckets)),
+		series:  map[string]*metricSeries{},
+	}
+}
+
+func (m *Metrics) AddCounter(name string, labels Labels, delta float64) {
+	if m == nil {
+		return
+	}
+	m.register(name, "", MetricTypeCounter, nil)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series := m.families[name].getSeries(labels)
+	series.value += delta
+}
+
+func (m *Metrics) IncCounter(name string, labels Labels) {
+	m.AddCounter(name, labels, 1)
+}
+
+func (m *Metrics) ObserveHistogram(name string, labels Labels, value float64) {
+	if m == nil {
+		return
+	}
+	m.register(name, "", MetricTypeHistogram, DefaultMetricsBuckets)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	family := m.families[name]
+	series := family.getSeries(labels)
+	for i, bound := range family.buckets {
+		if value <= bound {
+			series.bucketCounts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+func (m *Metrics) ObserveDuration(name string, labels Labels, start time.Time) {
+	m.ObserveHistogram(name, labels, time.Since(start).Seconds())
+}
+
+func (f *metricFamily) getSeries(labels Labels) *metricSeries {
+	key := formatLabels(labels)
+	series, ok := f.series[key]
+	if !ok {
+		series = &metricSeries{labels: key, bucketCounts: make([]uint64, len(f.buckets))}
+		f.series[key] = series
+	}
+	return series
+}
+
+func (m *Metrics) WriteText(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	buf := bufio.NewWriter(w)
+	for _, name := range slices.Sorted(maps.Keys(m.families)) {
+		family := m.families[name]
+		if family.help != "" {
+			fmt.Fprintf(buf, "# HELP %s %s\n", name, family.help)
+		}
+		fmt.Fprintf(buf, "# TYPE %s %s\n", name, family.kind)
+
+		for _, key := range slices.Sorted(maps.Keys(family.series)) {
+			series := family.series[key]
+			if family.kind == MetricTypeCounter {
+				fmt.Fprintf(buf, "%s%s %s\n", name, wrapLabels(series.labels), formatFloat(series.value))
+				continue
+			}
+			for i, bound := range family.buckets {
+				le := joinLabels(series.labels, `le="`+formatFloat(bound)+`"`)
+				fmt.Fprintf(buf, "%s_bucket%s %d\n", name, le, series.bucketCounts[i])
+			}
+			inf := joinLabels(series.labels, `le="+Inf"`)
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", name, inf, series.count)
+			fmt.Fprintf(buf, "%s_sum%s %s\n", name, wrapLabels(series.labels), formatFloat(series.sum))
+			fmt.Fprintf(buf, "%s_count%s %d\n", name, wrapLabels(series.labels), series.count)
+		}
+	}
+	return buf.Flush()
+}
+
+func MakeMetricsHandler(metrics *Metrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", MetricsContentType)
+		w.WriteHeader(http.StatusOK)
+		_ = metrics.WriteText(w)
+	}
+}
+
+func formatLabels(labels Labels) string {
+	pairs := make([]string, 0, len(labels))
+	for _, key := range slices.Sorted(maps.Keys(labels)) {
+		pairs = append(pairs, key+`="`+escapeLabelValue(labels[key])+`"`)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(labels string, extra string) string {
+	if labels == "" {
+		return "{" + extra + "}"
+	}
+	return "{" + labels + "," + extra + "}"
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func MetricsErrorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrVerifierMeasurement):
+		return ErrorClassMeasurement
+	case errors.Is(err, ErrVerifierNonce):
+		return ErrorClassNonce
+	case errors.Is(err, ErrVerifierTimestamp):
+		return ErrorClassTimestamp
+	case errors.Is(err, ErrVerifierDebugMode):
+		return ErrorClassDebugMode
+	case errors.Is(err, ErrAttesterUserData):
+		return ErrorClassUserData
+	default:
+		return ErrorClassOther
+	}
+}
+
+// InstrumentHandler records the status code of every response served by
+// handler under the given component label.
+func InstrumentHandler(
+	metrics *Metrics,
+	component string,
+	handler http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+		if recorder.hijacked {
+			return
+		}
+		metrics.IncCounter(MetricHTTPResponses, Labels{
+			"component": component,
+			"code":      strconv.Itoa(recorder.status),
+		})
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+
+	status      int
+	wroteHeader bool
+	hijacked    bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	s.hijacked = true
+	return hijacker.Hijack()
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
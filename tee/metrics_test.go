@@ -0,0 +1,132 @@
+package tee_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func TestMetrics_WriteText(t *testing.T) {
+	t.Run("happy path - counter", func(t *testing.T) {
+		// given
+		metrics := tee.NewMetrics()
+		labels := tee.Labels{"component": "proxy", "direction": "up\"stream"}
+		want := `bearclave_proxy_bytes_total{component="proxy",direction="up\"stream"} 15`
+
+		// when
+		metrics.AddCounter(tee.MetricProxyBytes, labels, 10)
+		metrics.AddCounter(tee.MetricProxyBytes, labels, 5)
+
+		// then
+		var buf bytes.Buffer
+		require.NoError(t, metrics.WriteText(&buf))
+		assert.Contains(t, buf.String(), "# TYPE bearclave_proxy_bytes_total counter")
+		assert.Contains(t, buf.String(), want)
+	})
+
+	t.Run("happy path - histogram", func(t *testing.T) {
+		// given
+		metrics := tee.NewMetrics()
+		metrics.RegisterHistogram("test_seconds", "test help", []float64{1, 2})
+		labels := tee.Labels{"platform": "notee"}
+
+		// when
+		metrics.ObserveHistogram("test_seconds", labels, 0.5)
+		metrics.ObserveHistogram("test_seconds", labels, 1.5)
+		metrics.ObserveHistogram("test_seconds", labels, 3)
+
+		// then
+		var buf bytes.Buffer
+		require.NoError(t, metrics.WriteText(&buf))
+		got := buf.String()
+		assert.Contains(t, got, "# HELP test_seconds test help")
+		assert.Contains(t, got, "# TYPE test_seconds histogram")
+		assert.Contains(t, got, `test_seconds_bucket{platform="notee",le="1"} 1`)
+		assert.Contains(t, got, `test_seconds_bucket{platform="notee",le="2"} 2`)
+		assert.Contains(t, got, `test_seconds_bucket{platform="notee",le="+Inf"} 3`)
+		assert.Contains(t, got, `test_seconds_sum{platform="notee"} 5`)
+		assert.Contains(t, got, `test_seconds_count{platform="notee"} 3`)
+	})
+}
+
+func TestMakeMetricsHandler(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		metrics := tee.NewMetrics()
+		metrics.IncCounter(tee.MetricProxyConnections, tee.Labels{"component": "proxy"})
+		handler := tee.MakeMetricsHandler(metrics)
+		recorder := httptest.NewRecorder()
+		req := makeRequest(t, "GET", defaultPath, nil)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, tee.MetricsContentType, recorder.Header().Get("Content-Type"))
+		assert.Contains(t, recorder.Body.String(), tee.MetricProxyConnections)
+	})
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		metrics := tee.NewMetrics()
+		handler := tee.InstrumentHandler(metrics, "test", http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}),
+		)
+		recorder := httptest.NewRecorder()
+		req := makeRequest(t, "GET", defaultPath, nil)
+		want := fmt.Sprintf(`%s{code="418",component="test"} 1`, tee.MetricHTTPResponses)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		var buf bytes.Buffer
+		require.NoError(t, metrics.WriteText(&buf))
+		assert.Contains(t, buf.String(), want)
+	})
+}
+
+func TestMetricsErrorClass(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		assert.Equal(t, tee.ErrorClassMeasurement, tee.MetricsErrorClass(tee.ErrVerifierMeasurement))
+		assert.Equal(t, tee.ErrorClassNonce, tee.MetricsErrorClass(tee.ErrVerifierNonce))
+		assert.Equal(t, tee.ErrorClassTimestamp, tee.MetricsErrorClass(tee.ErrVerifierTimestamp))
+		assert.Equal(t, tee.ErrorClassDebugMode, tee.MetricsErrorClass(tee.ErrVerifierDebugMode))
+		assert.Equal(t, tee.ErrorClassUserData, tee.MetricsErrorClass(tee.ErrAttesterUserData))
+		assert.Equal(t, tee.ErrorClassOther, tee.MetricsErrorClass(assert.AnError))
+	})
+}
+
+func TestVerifier_Metrics(t *testing.T) {
+	t.Run("happy path - verification error recorded", func(t *testing.T) {
+		// given
+		attester, verifier := newTestAttesterVerifier(t)
+		attestResult, err := attester.Attest()
+		require.NoError(t, err)
+		want := fmt.Sprintf(
+			`%s{class="measurement",platform="notee"}`,
+			tee.MetricVerifyErrors,
+		)
+
+		// when
+		_, err = verifier.Verify(attestResult, tee.WithVerifyMeasurement("wrong"))
+		require.ErrorIs(t, err, tee.ErrVerifierMeasurement)
+
+		// then
+		var buf bytes.Buffer
+		require.NoError(t, tee.DefaultMetrics.WriteText(&buf))
+		assert.Contains(t, buf.String(), want)
+		assert.Contains(t, buf.String(), tee.MetricAttestDuration+`_count{platform="notee"}`)
+	})
+}
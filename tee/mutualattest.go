@@ -0,0 +1,381 @@
+package tee
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	MutualAttestPath           = "/bearclave/mutual-attest"
+	MutualAttestNonceSize      = 32
+	MutualAttestMaxBodySize    = 1 * Megabyte
+	MutualAttestLabel          = "bearclave mutual attest v1"
+	MutualAttestRoleClient     = "client"
+	MutualAttestRoleServer     = "server"
+	TLSExporterLabel           = "EXPORTER-Channel-Binding"
+	TLSExporterLength          = 32
+	DefaultMutualAttestTimeout = 10 * time.Second
+)
+
+type MutualAttestRequest struct {
+	Nonce       []byte        `json:"nonce"`
+	Attestation *AttestResult `json:"attestation"`
+}
+
+type MutualAttestResponse struct {
+	Attestation *AttestResult `json:"attestation"`
+}
+
+// TLSExporter returns the tls-exporter channel binding of a TLS 1.3
+// connection (RFC 9266). It is unique to the connection, and both the client
+// and server random contribute to it, so it doubles as a fresh challenge for
+// both sides.
+func TLSExporter(state tls.ConnectionState) ([]byte, error) {
+	if state.Version != tls.VersionTLS13 {
+		msg := fmt.Sprintf("tls-exporter requires TLS 1.3, got %s", tls.VersionName(state.Version))
+		return nil, mutualAttestError(msg, nil)
+	}
+	exporter, err := state.ExportKeyingMaterial(TLSExporterLabel, nil, TLSExporterLength)
+	if err != nil {
+		return nil, mutualAttestError("exporting keying material", err)
+	}
+	return exporter, nil
+}
+
+// MutualAttestBinding is the user data each side attests to. It binds the
+// attestation to the TLS connection (exporter), to the side producing it
+// (role), and to the client's nonce challenge.
+func MutualAttestBinding(role string, exporter []byte, nonce []byte) []byte {
+	binding := make([]byte, 0, len(MutualAttestLabel)+len(role)+len(exporter)+len(nonce)+2)
+	binding = append(binding, MutualAttestLabel...)
+	binding = append(binding, 0)
+	binding = append(binding, role...)
+	binding = append(binding, 0)
+	binding = append(binding, exporter...)
+	return append(binding, nonce...)
+}
+
+func verifyMutualAttestPeer(
+	verifier *Verifier,
+	attestation *AttestResult,
+	binding []byte,
+	options ...VerifyOption,
+) (*VerifyResult, error) {
+	if attestation == nil {
+		return nil, mutualAttestError("missing attestation", nil)
+	}
+	verifyResult, err := verifier.Verify(attestation, options...)
+	if err != nil {
+		return nil, mutualAttestError("verifying peer attestation", err)
+	}
+	if !bytes.Equal(verifyResult.UserData, binding) {
+		return nil, mutualAttestError("peer attestation is not bound to this connection", nil)
+	}
+	return verifyResult, nil
+}
+
+type peerAttestationKey struct{}
+
+// connPeerAttestation holds the verified attestation of the client on the
+// other end of a server connection. NewServerTLSWithListener attaches one to
+// every connection's context.
+type connPeerAttestation struct {
+	mu     sync.Mutex
+	result *VerifyResult
+}
+
+func contextWithConnPeerAttestation(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, peerAttestationKey{}, &connPeerAttestation{})
+}
+
+// PeerAttestationFromContext returns the verified attestation of the client
+// that sent the request, if the connection completed mutual attestation.
+func PeerAttestationFromContext(ctx context.Context) (*VerifyResult, bool) {
+	conn, ok := ctx.Value(peerAttestationKey{}).(*connPeerAttestation)
+	if !ok {
+		return nil, false
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.result, conn.result != nil
+}
+
+// MakeMutualAttestHandler serves the server side of mutual attestation at
+// MutualAttestPath and only passes requests to next once the connection they
+// arrive on has completed it. The client attests first, binding its
+// attestation to the connection's tls-exporter; the server then attests to
+// the same exporter and the client's nonce. It must be served by a Server
+// from NewServerTLS, which tracks attestation per connection.
+func MakeMutualAttestHandler(
+	attester *Attester,
+	verifier *Verifier,
+	next http.Handler,
+	logger *slog.Logger,
+	options ...VerifyOption,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, ok := r.Context().Value(peerAttestationKey{}).(*connPeerAttestation)
+		if !ok || r.TLS == nil {
+			logger.Error("mutual attestation requires a TLS server from NewServerTLS")
+			http.Error(w, "mutual attestation unavailable", http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Path != MutualAttestPath {
+			if _, attested := PeerAttestationFromContext(r.Context()); !attested {
+				http.Error(w, "connection is not attested", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "method should be POST but got: "+r.Method, http.StatusMethodNotAllowed)
+			return
+		}
+
+		exporter, err := TLSExporter(*r.TLS)
+		if err != nil {
+			logger.Error("mutual attestation", slog.String("error", err.Error()))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		req := MutualAttestRequest{}
+		err = json.NewDecoder(http.MaxBytesReader(w, r.Body, MutualAttestMaxBodySize)).Decode(&req)
+		if err != nil {
+			http.Error(w, mutualAttestError("decoding request", err).Error(), http.StatusBadRequest)
+			return
+		}
+
+		binding := MutualAttestBinding(MutualAttestRoleClient, exporter, req.Nonce)
+		verifyResult, err := verifyMutualAttestPeer(verifier, req.Attestation, binding, options...)
+		if err != nil {
+			logger.Error("verifying client attestation", slog.String("error", err.Error()))
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		binding = MutualAttestBinding(MutualAttestRoleServer, exporter, req.Nonce)
+		attestation, err := attester.Attest(WithAttestUserData(binding))
+		if err != nil {
+			logger.Error("attesting server", slog.String("error", err.Error()))
+			WriteError(w, mutualAttestError("attesting server", err))
+			return
+		}
+
+		conn.mu.Lock()
+		conn.result = verifyResult
+		conn.mu.Unlock()
+		WriteResponse(w, MutualAttestResponse{Attestation: attestation})
+	})
+}
+
+// NewMutualAttestClient creates a client whose every TLS connection completes
+// mutual attestation before carrying requests. If proxyAddr is not empty,
+// connections are tunneled through the CONNECT proxy there (see NewProxyTLS),
+// as with NewProxiedClient. The server's certificate is not checked against
+// any CA: the server's attestation, bound to the connection's tls-exporter,
+// authenticates it instead. Requests to http:// URLs fail.
+func NewMutualAttestClient(
+	platform Platform,
+	proxyAddr string,
+	attester *Attester,
+	verifier *Verifier,
+	options ...VerifyOption,
+) (*http.Client, error) {
+	dialContext, err := NewDialContext(platform)
+	if err != nil {
+		return nil, mutualAttestError("creating dialer", err)
+	}
+	return NewMutualAttestClientWithDialContext(dialContext, proxyAddr, attester, verifier, options...)
+}
+
+func NewMutualAttestClientWithDialContext(
+	dialContext DialContext,
+	proxyAddr string,
+	attester *Attester,
+	verifier *Verifier,
+	options ...VerifyOption,
+) (*http.Client, error) {
+	dialTLSContext := func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		return dialMutualAttest(ctx, dialContext, network, addr, proxyAddr, attester, verifier, options...)
+	}
+	// Plain http:// requests have no TLS channel to bind an attestation to,
+	// so they are refused rather than sent unattested.
+	plainDialContext := func(context.Context, string, string) (net.Conn, error) {
+		return nil, mutualAttestError("refusing unattested plain http connection", nil)
+	}
+	transport := &http.Transport{
+		DialContext:    plainDialContext,
+		DialTLSContext: dialTLSContext,
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+func dialMutualAttest(
+	ctx context.Context,
+	dialContext DialContext,
+	network string,
+	addr string,
+	proxyAddr string,
+	attester *Attester,
+	verifier *Verifier,
+	options ...VerifyOption,
+) (net.Conn, error) {
+	conn, err := dialMutualAttestTarget(ctx, dialContext, network, addr, proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	tlsConn := tls.Client(conn, &tls.Config{
+		MinVersion: tls.VersionTLS13,
+		ServerName: host,
+		NextProtos: []string{"http/1.1"},
+		// The server is authenticated by its channel-bound attestation.
+		InsecureSkipVerify: true, //nolint:gosec
+	})
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultMutualAttestTimeout)
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = tlsConn.SetDeadline(deadline)
+	}
+
+	err = tlsConn.HandshakeContext(ctx)
+	if err == nil {
+		err = mutualAttestClientHandshake(tlsConn, addr, attester, verifier, options...)
+	}
+	if err != nil {
+		tlsConn.Close()
+		return nil, err
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+func dialMutualAttestTarget(
+	ctx context.Context,
+	dialContext DialContext,
+	network string,
+	addr string,
+	proxyAddr string,
+) (net.Conn, error) {
+	if proxyAddr == "" {
+		conn, err := dialContext(ctx, network, addr)
+		if err != nil {
+			return nil, mutualAttestError("dialing "+addr, err)
+		}
+		return conn, nil
+	}
+
+	conn, err := dialContext(ctx, network, proxyAddr)
+	if err != nil {
+		return nil, mutualAttestError("dialing proxy "+proxyAddr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	connectReq := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if err = connectReq.Write(conn); err != nil {
+		conn.Close()
+		return nil, mutualAttestError("writing CONNECT request", err)
+	}
+
+	// The proxy sends nothing after its response until we do, so the reader
+	// cannot buffer any of the tunneled bytes.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), connectReq)
+	if err != nil {
+		conn.Close()
+		return nil, mutualAttestError("reading CONNECT response", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, mutualAttestError("proxy CONNECT failed: "+resp.Status, nil)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func mutualAttestClientHandshake(
+	tlsConn *tls.Conn,
+	addr string,
+	attester *Attester,
+	verifier *Verifier,
+	options ...VerifyOption,
+) error {
+	exporter, err := TLSExporter(tlsConn.ConnectionState())
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, MutualAttestNonceSize)
+	if _, err = crand.Read(nonce); err != nil {
+		return mutualAttestError("generating nonce", err)
+	}
+
+	binding := MutualAttestBinding(MutualAttestRoleClient, exporter, nonce)
+	attestation, err := attester.Attest(WithAttestUserData(binding))
+	if err != nil {
+		return mutualAttestError("attesting client", err)
+	}
+
+	body, err := json.Marshal(MutualAttestRequest{Nonce: nonce, Attestation: attestation})
+	if err != nil {
+		return mutualAttestError("marshaling request", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, "https://"+addr+MutualAttestPath, bytes.NewReader(body))
+	if err != nil {
+		return mutualAttestError("creating request", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err = req.Write(tlsConn); err != nil {
+		return mutualAttestError("writing request", err)
+	}
+
+	// The server sends nothing after its response until the next request,
+	// so the reader cannot buffer bytes the transport needs later.
+	resp, err := http.ReadResponse(bufio.NewReader(tlsConn), req)
+	if err != nil {
+		return mutualAttestError("reading response", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return mutualAttestError("server rejected attestation: "+resp.Status, nil)
+	}
+
+	attestResp := MutualAttestResponse{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, MutualAttestMaxBodySize)).Decode(&attestResp)
+	if err != nil {
+		return mutualAttestError("decoding response", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	binding = MutualAttestBinding(MutualAttestRoleServer, exporter, nonce)
+	_, err = verifyMutualAttestPeer(verifier, attestResp.Attestation, binding, options...)
+	return err
+}
@@ -0,0 +1,135 @@
+package tee_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func newTestMutualAttestServer(t *testing.T) *tee.Server {
+	t.Helper()
+	attester, verifier := newTestAttesterVerifier(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := tee.PeerAttestationFromContext(r.Context())
+		if !ok {
+			http.Error(w, "missing peer attestation", http.StatusInternalServerError)
+			return
+		}
+		_, _ = io.WriteString(w, "attested")
+	})
+	return newTestServerTLSWithHandler(
+		t,
+		handler,
+		tee.WithServerTLSMutualAttestation(attester, verifier),
+	)
+}
+
+func TestMutualAttestation(t *testing.T) {
+	t.Run("happy path - direct", func(t *testing.T) {
+		// given
+		server := newTestMutualAttestServer(t)
+		attester, verifier := newTestAttesterVerifier(t)
+		client, err := tee.NewMutualAttestClient(tee.NoTEE, "", attester, verifier)
+		require.NoError(t, err)
+
+		for range 2 {
+			// when
+			resp, err := client.Get("https://" + server.Addr())
+			require.NoError(t, err)
+
+			// then
+			got, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "attested", string(got))
+		}
+	})
+
+	t.Run("happy path - through proxy", func(t *testing.T) {
+		// given
+		server := newTestMutualAttestServer(t)
+		proxy, err := tee.NewProxyTLS(
+			context.Background(),
+			tee.NoTEE,
+			"127.0.0.1:0",
+			slog.New(slog.DiscardHandler),
+		)
+		require.NoError(t, err)
+		defer proxy.Close()
+		runService(func() { _ = proxy.Serve() }, 0)
+
+		attester, verifier := newTestAttesterVerifier(t)
+		client, err := tee.NewMutualAttestClient(tee.NoTEE, proxy.Addr(), attester, verifier)
+		require.NoError(t, err)
+
+		// when
+		resp, err := client.Get("https://" + server.Addr())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		// then
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "attested", string(got))
+	})
+
+	t.Run("error - server measurement mismatch", func(t *testing.T) {
+		// given
+		server := newTestMutualAttestServer(t)
+		attester, verifier := newTestAttesterVerifier(t)
+		client, err := tee.NewMutualAttestClient(
+			tee.NoTEE,
+			"",
+			attester,
+			verifier,
+			tee.WithVerifyMeasurement("wrong"),
+		)
+		require.NoError(t, err)
+
+		// when
+		_, err = client.Get("https://" + server.Addr())
+
+		// then
+		require.ErrorIs(t, err, tee.ErrMutualAttest)
+		require.ErrorIs(t, err, tee.ErrVerifierMeasurement)
+	})
+
+	t.Run("error - plain http", func(t *testing.T) {
+		// given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, "unattested")
+		}))
+		defer server.Close()
+		attester, verifier := newTestAttesterVerifier(t)
+		client, err := tee.NewMutualAttestClient(tee.NoTEE, "", attester, verifier)
+		require.NoError(t, err)
+
+		// when
+		_, err = client.Get(server.URL)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrMutualAttest)
+	})
+
+	t.Run("error - client without attestation", func(t *testing.T) {
+		// given
+		server := newTestMutualAttestServer(t)
+		client := newTestClientTLS()
+
+		// when
+		resp, err := client.Get("https://" + server.Addr())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		// then
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
@@ -0,0 +1,200 @@
+package tee_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func TestNitroSim(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	want := "hello world"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, want)
+	})
+
+	t.Run("happy path - host dials enclave server", func(t *testing.T) {
+		// given
+		t.Setenv(tee.SimVSocketDirEnv, t.TempDir())
+		ctx := context.Background()
+		server, err := tee.NewServer(ctx, tee.NitroSim, "16:8080", handler, logger)
+		require.NoError(t, err)
+		defer server.Close()
+		runService(func() { _ = server.Serve() }, 0)
+
+		dialContext, err := tee.NewDialContext(tee.NitroSim)
+		require.NoError(t, err)
+		client := &http.Client{Transport: &http.Transport{DialContext: dialContext}}
+
+		// when
+		resp, err := client.Get("http://" + server.Addr())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		// then
+		assert.Equal(t, "16:8080", server.Addr())
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	})
+
+	t.Run("happy path - enclave reaches network through host proxy", func(t *testing.T) {
+		// given
+		t.Setenv(tee.SimVSocketDirEnv, t.TempDir())
+		ctx := context.Background()
+		target := httptest.NewServer(handler)
+		defer target.Close()
+
+		proxy, err := tee.NewProxy(ctx, tee.NitroSim, "3:8080", http.DefaultClient, logger)
+		require.NoError(t, err)
+		defer proxy.Close()
+		runService(func() { _ = proxy.Serve() }, 0)
+
+		client, err := tee.NewProxiedClient(tee.NitroSim, "http://3:8080")
+		require.NoError(t, err)
+
+		// when
+		resp, err := client.Get(target.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		// then
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	})
+
+	t.Run("happy path - vsock proxy address", func(t *testing.T) {
+		// given
+		t.Setenv(tee.SimVSocketDirEnv, t.TempDir())
+		ctx := context.Background()
+		target := httptest.NewServer(handler)
+		defer target.Close()
+
+		proxy, err := tee.NewProxy(ctx, tee.NitroSim, "vsock://3:8080", http.DefaultClient, logger)
+		require.NoError(t, err)
+		defer proxy.Close()
+		runService(func() { _ = proxy.Serve() }, 0)
+
+		client, err := tee.NewProxiedClient(tee.NitroSim, "vsock://3:8080")
+		require.NoError(t, err)
+
+		// when
+		resp, err := client.Get(target.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		// then
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	})
+
+	t.Run("error - enclave dials network directly", func(t *testing.T) {
+		// given
+		t.Setenv(tee.SimVSocketDirEnv, t.TempDir())
+		target := httptest.NewServer(handler)
+		defer target.Close()
+
+		dialContext, err := tee.NewDialContext(tee.NitroSim)
+		require.NoError(t, err)
+
+		// when
+		_, err = dialContext(context.Background(), tee.NetworkTCP4, target.Listener.Addr().String())
+
+		// then
+		require.ErrorIs(t, err, tee.ErrNoNetworkAccess)
+	})
+
+	t.Run("error - listening on network address", func(t *testing.T) {
+		// given
+		t.Setenv(tee.SimVSocketDirEnv, t.TempDir())
+
+		// when
+		_, err := tee.NewListener(context.Background(), tee.NitroSim, tee.NetworkTCP4, "127.0.0.1:0")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrListener)
+		require.ErrorIs(t, err, tee.ErrNoNetworkAccess)
+	})
+
+	for _, addr := range []string{"tcp://127.0.0.1:0", "unix:///tmp/bearclave-test.sock"} {
+		t.Run("error - listening on explicit network address", func(t *testing.T) {
+			// given
+			t.Setenv(tee.SimVSocketDirEnv, t.TempDir())
+
+			// when
+			_, err := tee.NewListener(context.Background(), tee.NitroSim, tee.NetworkTCP, addr)
+
+			// then
+			require.ErrorIs(t, err, tee.ErrListener)
+			require.ErrorIs(t, err, tee.ErrNoNetworkAccess)
+		})
+
+		t.Run("error - dialing explicit network address", func(t *testing.T) {
+			// given
+			dialContext, err := tee.NewDialContext(tee.NitroSim)
+			require.NoError(t, err)
+
+			// when
+			_, err = dialContext(context.Background(), tee.NetworkTCP, addr)
+
+			// then
+			require.ErrorIs(t, err, tee.ErrNoNetworkAccess)
+		})
+	}
+
+	t.Run("error - address in use", func(t *testing.T) {
+		// given
+		t.Setenv(tee.SimVSocketDirEnv, t.TempDir())
+		ctx := context.Background()
+		live, err := tee.NewListener(ctx, tee.NitroSim, tee.NetworkTCP, "16:8080")
+		require.NoError(t, err)
+		defer live.Close()
+
+		// when
+		_, err = tee.NewListener(ctx, tee.NitroSim, tee.NetworkTCP, "16:8080")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrListener)
+		assert.ErrorContains(t, err, "in use")
+	})
+
+	t.Run("error - shared simulator directory", func(t *testing.T) {
+		// given
+		dir := t.TempDir()
+		require.NoError(t, os.Chmod(dir, 0o777)) //nolint:gosec
+		t.Setenv(tee.SimVSocketDirEnv, dir)
+
+		// when
+		_, err := tee.NewListener(context.Background(), tee.NitroSim, tee.NetworkTCP, "16:8080")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrListener)
+		assert.ErrorContains(t, err, "writable by other users")
+	})
+
+	t.Run("error - dialing unknown cid", func(t *testing.T) {
+		// given
+		t.Setenv(tee.SimVSocketDirEnv, t.TempDir())
+		dialContext, err := tee.NewDialContext(tee.NitroSim)
+		require.NoError(t, err)
+
+		// when
+		_, err = dialContext(context.Background(), tee.NetworkTCP4, "42:8080")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrDialContext)
+		var opErr *net.OpError
+		assert.ErrorAs(t, err, &opErr)
+	})
+}
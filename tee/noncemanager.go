@@ -0,0 +1,341 @@
+package tee
+
+import (
+	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultNonceSize     = 32
+	DefaultNonceTTL      = 1 * time.Minute
+	MemoryNonceStoreMax  = 10000
+	NonceStoreDirMode    = 0o700
+	NonceStoreFileMode   = 0o600
+	NonceStoreFileSuffix = ".nonce"
+	NonceStoreFileTmpExt = ".tmp"
+)
+
+// NonceStore keeps track of outstanding nonces for a NonceManager.
+type NonceStore interface {
+	Put(ctx context.Context, nonce []byte, expires time.Time) error
+	// Get returns ErrNonceManagerNotFound if nonce is not outstanding.
+	Get(ctx context.Context, nonce []byte) (expires time.Time, err error)
+	// Delete returns ErrNonceManagerNotFound if nonce is not outstanding. Of
+	// several concurrent deletes of the same nonce, only one may succeed.
+	Delete(ctx context.Context, nonce []byte) error
+	// Prune removes nonces that expired before now.
+	Prune(ctx context.Context, now time.Time) error
+}
+
+// NonceManager issues nonces to the parties a server verifies and makes sure
+// each is used at most once, within its TTL. Use it wherever the verifier,
+// not the attester, picks the nonce, e.g., an enclave verifying a client.
+type NonceManager struct {
+	store NonceStore
+	opts  NonceManagerOptions
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+func NewNonceManager(store NonceStore, options ...NonceManagerOption) (*NonceManager, error) {
+	opts := MakeDefaultNonceManagerOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	switch {
+	case store == nil:
+		return nil, nonceManagerError("store is required", nil)
+	case opts.TTL <= 0:
+		return nil, nonceManagerError("ttl must be positive", nil)
+	case opts.Size < AttestMinNonceSize:
+		return nil, nonceManagerError("nonce size is too small", nil)
+	}
+	return &NonceManager{store: store, opts: opts, lastPrune: time.Now()}, nil
+}
+
+// Issue returns a new nonce that is valid for the manager's TTL.
+func (n *NonceManager) Issue(ctx context.Context) ([]byte, error) {
+	if err := n.prune(ctx); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, n.opts.Size)
+	if _, err := crand.Read(nonce); err != nil {
+		return nil, nonceManagerError("generating nonce", err)
+	}
+	if err := n.store.Put(ctx, nonce, time.Now().Add(n.opts.TTL)); err != nil {
+		return nil, nonceManagerError("storing nonce", err)
+	}
+	return nonce, nil
+}
+
+// Check returns an error unless nonce was issued, has not expired, and has not
+// been consumed. It does not consume the nonce.
+func (n *NonceManager) Check(ctx context.Context, nonce []byte) error {
+	expires, err := n.store.Get(ctx, nonce)
+	switch {
+	case errors.Is(err, ErrNonceManagerNotFound):
+		return err
+	case err != nil:
+		return nonceManagerError("loading nonce", err)
+	case time.Now().After(expires):
+		return nonceManagerErrorExpired("", nil)
+	}
+	return nil
+}
+
+// Consume uses up nonce. It returns an error if nonce was never issued, has
+// expired, or was already consumed.
+func (n *NonceManager) Consume(ctx context.Context, nonce []byte) error {
+	if err := n.Check(ctx, nonce); err != nil {
+		return err
+	}
+	err := n.store.Delete(ctx, nonce)
+	switch {
+	case errors.Is(err, ErrNonceManagerNotFound):
+		return err
+	case err != nil:
+		return nonceManagerError("deleting nonce", err)
+	}
+	return nil
+}
+
+// Verify verifies attestResult against nonce, which the attester must have
+// passed to WithAttestNonce, and consumes nonce if, and only if, verification
+// succeeds.
+func (n *NonceManager) Verify(
+	ctx context.Context,
+	verifier *Verifier,
+	attestResult *AttestResult,
+	nonce []byte,
+	options ...VerifyOption,
+) (*VerifyResult, error) {
+	if err := n.Check(ctx, nonce); err != nil {
+		return nil, err
+	}
+	options = append([]VerifyOption{WithVerifyNonce(nonce)}, options...)
+	verifyResult, err := verifier.Verify(attestResult, options...)
+	if err != nil {
+		return nil, err
+	}
+	if err = n.Consume(ctx, nonce); err != nil {
+		return nil, err
+	}
+	return verifyResult, nil
+}
+
+// prune removes expired nonces at most once per TTL, so that nonces that are
+// never used do not pile up.
+func (n *NonceManager) prune(ctx context.Context) error {
+	n.mu.Lock()
+	now := time.Now()
+	due := now.Sub(n.lastPrune) >= n.opts.TTL
+	if due {
+		n.lastPrune = now
+	}
+	n.mu.Unlock()
+
+	if !due {
+		return nil
+	}
+	if err := n.store.Prune(ctx, now); err != nil {
+		return nonceManagerError("pruning nonces", err)
+	}
+	return nil
+}
+
+type NonceManagerOption func(*NonceManagerOptions)
+type NonceManagerOptions struct {
+	Size int
+	TTL  time.Duration
+}
+
+func MakeDefaultNonceManagerOptions() NonceManagerOptions {
+	return NonceManagerOptions{
+		Size: DefaultNonceSize,
+		TTL:  DefaultNonceTTL,
+	}
+}
+
+func WithNonceManagerSize(size int) NonceManagerOption {
+	return func(opts *NonceManagerOptions) {
+		opts.Size = size
+	}
+}
+
+// WithNonceManagerTTL sets how long a nonce stays valid. It must cover the
+// round trip to the attester, including producing the attestation.
+func WithNonceManagerTTL(ttl time.Duration) NonceManagerOption {
+	return func(opts *NonceManagerOptions) {
+		opts.TTL = ttl
+	}
+}
+
+// MemoryNonceStore keeps nonces in memory. It holds at most
+// MemoryNonceStoreMax outstanding nonces, so clients cannot exhaust memory by
+// requesting nonces they never use.
+type MemoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: map[string]time.Time{}}
+}
+
+func (m *MemoryNonceStore) Put(_ context.Context, nonce []byte, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.nonces) >= MemoryNonceStoreMax {
+		m.prune(time.Now())
+	}
+	if len(m.nonces) >= MemoryNonceStoreMax {
+		return nonceManagerError("too many outstanding nonces", nil)
+	}
+	m.nonces[string(nonce)] = expires
+	return nil
+}
+
+func (m *MemoryNonceStore) Get(_ context.Context, nonce []byte) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	expires, ok := m.nonces[string(nonce)]
+	if !ok {
+		return time.Time{}, nonceManagerErrorNotFound("", nil)
+	}
+	return expires, nil
+}
+
+func (m *MemoryNonceStore) Delete(_ context.Context, nonce []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.nonces[string(nonce)]; !ok {
+		return nonceManagerErrorNotFound("", nil)
+	}
+	delete(m.nonces, string(nonce))
+	return nil
+}
+
+func (m *MemoryNonceStore) Prune(_ context.Context, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune(now)
+	return nil
+}
+
+func (m *MemoryNonceStore) prune(now time.Time) {
+	for nonce, expires := range m.nonces {
+		if now.After(expires) {
+			delete(m.nonces, nonce)
+		}
+	}
+}
+
+// DirNonceStore keeps each nonce in its own file in dir, so outstanding
+// nonces survive restarts and can be shared by processes on the same host.
+// File names are derived from a hash of the nonce, so nonces cannot escape
+// dir.
+type DirNonceStore struct {
+	dir string
+}
+
+func NewDirNonceStore(dir string) (*DirNonceStore, error) {
+	if err := os.MkdirAll(dir, NonceStoreDirMode); err != nil {
+		return nil, nonceManagerError("creating directory", err)
+	}
+	return &DirNonceStore{dir: dir}, nil
+}
+
+func (d *DirNonceStore) Put(_ context.Context, nonce []byte, expires time.Time) error {
+	path := d.path(nonce)
+	file, err := os.CreateTemp(d.dir, filepath.Base(path)+"*"+NonceStoreFileTmpExt)
+	if err != nil {
+		return nonceManagerError("creating file", err)
+	}
+	defer os.Remove(file.Name())
+
+	data := binary.BigEndian.AppendUint64(nil, uint64(expires.UnixNano()))
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Chmod(NonceStoreFileMode)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nonceManagerError("writing file", err)
+	}
+
+	if err = os.Rename(file.Name(), path); err != nil {
+		return nonceManagerError("renaming file", err)
+	}
+	return nil
+}
+
+func (d *DirNonceStore) Get(_ context.Context, nonce []byte) (time.Time, error) {
+	return d.read(d.path(nonce))
+}
+
+// Delete relies on os.Remove succeeding for only one of several concurrent
+// callers.
+func (d *DirNonceStore) Delete(_ context.Context, nonce []byte) error {
+	err := os.Remove(d.path(nonce))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nonceManagerErrorNotFound("", nil)
+	case err != nil:
+		return nonceManagerError("removing file", err)
+	}
+	return nil
+}
+
+func (d *DirNonceStore) Prune(_ context.Context, now time.Time) error {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nonceManagerError("reading directory", err)
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), NonceStoreFileSuffix) {
+			continue
+		}
+		path := filepath.Join(d.dir, entry.Name())
+		expires, err := d.read(path)
+		if errors.Is(err, ErrNonceManagerNotFound) || (err == nil && !now.After(expires)) {
+			continue
+		}
+		if err = os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nonceManagerError("removing file", err)
+		}
+	}
+	return nil
+}
+
+func (d *DirNonceStore) read(path string) (time.Time, error) {
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return time.Time{}, nonceManagerErrorNotFound("", nil)
+	case err != nil:
+		return time.Time{}, nonceManagerError("reading file", err)
+	case len(data) != 8:
+		return time.Time{}, nonceManagerError("malformed file", nil)
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data))), nil
+}
+
+func (d *DirNonceStore) path(nonce []byte) string {
+	hash := sha256.Sum256(nonce)
+	return filepath.Join(d.dir, hex.EncodeToString(hash[:])+NonceStoreFileSuffix)
+}
@@ -0,0 +1,168 @@
+package tee_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func newTestDirNonceStore(t *testing.T, dir string) *tee.DirNonceStore {
+	t.Helper()
+	store, err := tee.NewDirNonceStore(dir)
+	require.NoError(t, err)
+	return store
+}
+
+func TestNonceManager_Interfaces(t *testing.T) {
+	t.Run("NonceStore", func(_ *testing.T) {
+		var _ tee.NonceStore = &tee.MemoryNonceStore{}
+		var _ tee.NonceStore = &tee.DirNonceStore{}
+	})
+}
+
+func TestNonceManager_Verify(t *testing.T) {
+	ctx := context.Background()
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+
+	stores := map[string]func(t *testing.T) tee.NonceStore{
+		"memory": func(_ *testing.T) tee.NonceStore { return tee.NewMemoryNonceStore() },
+		"dir":    func(t *testing.T) tee.NonceStore { return newTestDirNonceStore(t, t.TempDir()) },
+	}
+	for name, newStore := range stores {
+		t.Run(name+" - happy path - consumed once", func(t *testing.T) {
+			// given
+			manager, err := tee.NewNonceManager(newStore(t))
+			require.NoError(t, err)
+			nonce, err := manager.Issue(ctx)
+			require.NoError(t, err)
+			attestation, err := attester.Attest(tee.WithAttestNonce(nonce))
+			require.NoError(t, err)
+
+			// when
+			_, err = manager.Verify(ctx, verifier, attestation, nonce)
+			_, replayErr := manager.Verify(ctx, verifier, attestation, nonce)
+
+			// then
+			require.NoError(t, err)
+			require.ErrorIs(t, replayErr, tee.ErrNonceManagerNotFound)
+		})
+
+		t.Run(name+" - happy path - failed verification keeps nonce", func(t *testing.T) {
+			// given
+			manager, err := tee.NewNonceManager(newStore(t))
+			require.NoError(t, err)
+			nonce, err := manager.Issue(ctx)
+			require.NoError(t, err)
+			attestation, err := attester.Attest(tee.WithAttestNonce(nonce))
+			require.NoError(t, err)
+			_, err = manager.Verify(ctx, verifier, attestation, nonce, tee.WithVerifyMeasurement("other"))
+			require.ErrorIs(t, err, tee.ErrVerifierMeasurement)
+
+			// when
+			_, err = manager.Verify(ctx, verifier, attestation, nonce)
+
+			// then
+			require.NoError(t, err)
+		})
+
+		t.Run(name+" - error - expired", func(t *testing.T) {
+			// given
+			manager, err := tee.NewNonceManager(newStore(t), tee.WithNonceManagerTTL(time.Millisecond))
+			require.NoError(t, err)
+			nonce, err := manager.Issue(ctx)
+			require.NoError(t, err)
+			attestation, err := attester.Attest(tee.WithAttestNonce(nonce))
+			require.NoError(t, err)
+			time.Sleep(10 * time.Millisecond)
+
+			// when
+			_, err = manager.Verify(ctx, verifier, attestation, nonce)
+
+			// then
+			require.ErrorIs(t, err, tee.ErrNonceManagerExpired)
+		})
+
+		t.Run(name+" - error - never issued", func(t *testing.T) {
+			// given
+			manager, err := tee.NewNonceManager(newStore(t))
+			require.NoError(t, err)
+			nonce := []byte("0123456789abcdef0123456789abcdef")
+			attestation, err := attester.Attest(tee.WithAttestNonce(nonce))
+			require.NoError(t, err)
+
+			// when
+			_, err = manager.Verify(ctx, verifier, attestation, nonce)
+
+			// then
+			require.ErrorIs(t, err, tee.ErrNonceManagerNotFound)
+		})
+
+		t.Run(name+" - error - attestation for another nonce", func(t *testing.T) {
+			// given
+			manager, err := tee.NewNonceManager(newStore(t))
+			require.NoError(t, err)
+			nonce, err := manager.Issue(ctx)
+			require.NoError(t, err)
+			other, err := manager.Issue(ctx)
+			require.NoError(t, err)
+			attestation, err := attester.Attest(tee.WithAttestNonce(other))
+			require.NoError(t, err)
+
+			// when
+			_, err = manager.Verify(ctx, verifier, attestation, nonce)
+
+			// then
+			require.ErrorIs(t, err, tee.ErrVerifierNonce)
+		})
+	}
+}
+
+func TestDirNonceStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("happy path - nonces survive restarts", func(t *testing.T) {
+		// given
+		dir := t.TempDir()
+		manager, err := tee.NewNonceManager(newTestDirNonceStore(t, dir))
+		require.NoError(t, err)
+		nonce, err := manager.Issue(ctx)
+		require.NoError(t, err)
+
+		// when
+		restarted, err := tee.NewNonceManager(newTestDirNonceStore(t, dir))
+		require.NoError(t, err)
+		err = restarted.Consume(ctx, nonce)
+
+		// then
+		require.NoError(t, err)
+		require.ErrorIs(t, manager.Consume(ctx, nonce), tee.ErrNonceManagerNotFound)
+	})
+
+	t.Run("happy path - prune removes expired nonces", func(t *testing.T) {
+		// given
+		dir := t.TempDir()
+		store := newTestDirNonceStore(t, dir)
+		now := time.Now()
+		require.NoError(t, store.Put(ctx, []byte("expired"), now.Add(-time.Second)))
+		require.NoError(t, store.Put(ctx, []byte("outstanding"), now.Add(time.Minute)))
+
+		// when
+		err := store.Prune(ctx, now)
+
+		// then
+		require.NoError(t, err)
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+		_, err = store.Get(ctx, []byte("outstanding"))
+		require.NoError(t, err)
+	})
+}
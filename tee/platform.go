@@ -8,3 +8,12 @@ const (
 	TDX   Platform = "tdx"
 	NoTEE Platform = "notee"
 )
+
+// NitroSim emulates the Nitro topology without Nitro hardware. vsock
+// "cid:port" addresses map onto Unix sockets in SimVSocketDir and, as in a
+// real enclave, nothing else can be dialed. Attestation is the same as NoTEE.
+const NitroSim Platform = "nitrosim"
+
+// UnknownPlatform labels metrics for attesters and verifiers constructed
+// from a caller-provided base rather than a known Platform.
+const UnknownPlatform Platform = "unknown"
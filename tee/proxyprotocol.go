@@ -0,0 +1,301 @@
+package tee
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ProxyProtocolV1                   = 1
+	ProxyProtocolV2                   = 2
+	ProxyProtocolV1MaxLength          = 107
+	ProxyProtocolV2HeaderSize         = 16
+	ProxyProtocolV2CmdLocal           = 0x20
+	ProxyProtocolV2CmdProxy           = 0x21
+	ProxyProtocolV2FamUnspec          = 0x00
+	ProxyProtocolV2FamTCP4            = 0x11
+	ProxyProtocolV2FamTCP6            = 0x21
+	ProxyProtocolV2AddrLenTCP4        = 12
+	ProxyProtocolV2AddrLenTCP6        = 36
+	DefaultProxyProtocolHeaderTimeout = 5 * time.Second
+)
+
+var ProxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// MakeProxyProtocolHeader builds a PROXY protocol v1 or v2 header describing
+// a connection from src to dst. Addresses that are not TCP (e.g., vsock) are
+// sent as UNKNOWN (v1) or LOCAL (v2), which tells the receiver to fall back
+// to the address of the connection itself.
+func MakeProxyProtocolHeader(version int, src net.Addr, dst net.Addr) ([]byte, error) {
+	srcTCP, srcOK := src.(*net.TCPAddr)
+	dstTCP, dstOK := dst.(*net.TCPAddr)
+	known := srcOK && dstOK
+	isTCP4 := known && srcTCP.IP.To4() != nil && dstTCP.IP.To4() != nil
+
+	switch version {
+	case ProxyProtocolV1:
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		family := "TCP6"
+		if isTCP4 {
+			family = "TCP4"
+		}
+		header := fmt.Sprintf(
+			"PROXY %s %s %s %d %d\r\n",
+			family,
+			srcTCP.IP.String(),
+			dstTCP.IP.String(),
+			srcTCP.Port,
+			dstTCP.Port,
+		)
+		return []byte(header), nil
+	case ProxyProtocolV2:
+		header := bytes.NewBuffer(bytes.Clone(ProxyProtocolV2Signature))
+		switch {
+		case !known:
+			header.Write([]byte{ProxyProtocolV2CmdLocal, ProxyProtocolV2FamUnspec, 0, 0})
+		case isTCP4:
+			header.Write([]byte{ProxyProtocolV2CmdProxy, ProxyProtocolV2FamTCP4})
+			_ = binary.Write(header, binary.BigEndian, uint16(ProxyProtocolV2AddrLenTCP4))
+			header.Write(srcTCP.IP.To4())
+			header.Write(dstTCP.IP.To4())
+			_ = binary.Write(header, binary.BigEndian, uint16(srcTCP.Port)) //nolint:gosec
+			_ = binary.Write(header, binary.BigEndian, uint16(dstTCP.Port)) //nolint:gosec
+		default:
+			header.Write([]byte{ProxyProtocolV2CmdProxy, ProxyProtocolV2FamTCP6})
+			_ = binary.Write(header, binary.BigEndian, uint16(ProxyProtocolV2AddrLenTCP6))
+			header.Write(srcTCP.IP.To16())
+			header.Write(dstTCP.IP.To16())
+			_ = binary.Write(header, binary.BigEndian, uint16(srcTCP.Port)) //nolint:gosec
+			_ = binary.Write(header, binary.BigEndian, uint16(dstTCP.Port)) //nolint:gosec
+		}
+		return header.Bytes(), nil
+	default:
+		msg := fmt.Sprintf("unsupported proxy protocol version: %d", version)
+		return nil, proxyProtocolError(msg, nil)
+	}
+}
+
+// ReadProxyProtocolHeader reads a v1 or v2 header from r and returns the
+// source and destination addresses it describes. Both are nil for v1 UNKNOWN
+// and v2 LOCAL headers.
+func ReadProxyProtocolHeader(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	peek, err := r.Peek(len(ProxyProtocolV2Signature))
+	if err != nil {
+		return nil, nil, proxyProtocolError("reading header", err)
+	}
+	if bytes.Equal(peek, ProxyProtocolV2Signature) {
+		return readProxyProtocolV2(r)
+	}
+	if bytes.HasPrefix(peek, []byte("PROXY ")) {
+		return readProxyProtocolV1(r)
+	}
+	return nil, nil, proxyProtocolError("missing header", nil)
+}
+
+func readProxyProtocolV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	line := make([]byte, 0, ProxyProtocolV1MaxLength)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= ProxyProtocolV1MaxLength {
+			return nil, nil, proxyProtocolError("v1 header too long", nil)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, proxyProtocolError("reading v1 header", err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(strings.TrimSuffix(string(line), "\r\n"))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") { //nolint:mnd
+		msg := fmt.Sprintf("malformed v1 header: %q", string(line))
+		return nil, nil, proxyProtocolError(msg, nil)
+	}
+
+	src, err := parseProxyProtocolV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyProtocolV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseProxyProtocolV1Addr(host string, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, proxyProtocolError("invalid v1 address: "+host, nil)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, proxyProtocolError("invalid v1 port: "+port, err)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func readProxyProtocolV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, ProxyProtocolV2HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, proxyProtocolError("reading v2 header", err)
+	}
+
+	cmd, family := header[12], header[13]
+	length := binary.BigEndian.Uint16(header[14:])
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, proxyProtocolError("reading v2 addresses", err)
+	}
+
+	switch {
+	case cmd == ProxyProtocolV2CmdLocal:
+		return nil, nil, nil
+	case cmd != ProxyProtocolV2CmdProxy:
+		msg := fmt.Sprintf("unsupported v2 command: %#x", cmd)
+		return nil, nil, proxyProtocolError(msg, nil)
+	}
+
+	// Any bytes past the addresses are TLVs, which are skipped.
+	var ipLen int
+	switch {
+	case family == ProxyProtocolV2FamTCP4 && length >= ProxyProtocolV2AddrLenTCP4:
+		ipLen = net.IPv4len
+	case family == ProxyProtocolV2FamTCP6 && length >= ProxyProtocolV2AddrLenTCP6:
+		ipLen = net.IPv6len
+	case family == ProxyProtocolV2FamUnspec:
+		return nil, nil, nil
+	default:
+		msg := fmt.Sprintf("unsupported v2 family %#x with length %d", family, length)
+		return nil, nil, proxyProtocolError(msg, nil)
+	}
+
+	ports := payload[2*ipLen:]
+	src := &net.TCPAddr{
+		IP:   net.IP(bytes.Clone(payload[:ipLen])),
+		Port: int(binary.BigEndian.Uint16(ports)),
+	}
+	dst := &net.TCPAddr{
+		IP:   net.IP(bytes.Clone(payload[ipLen : 2*ipLen])),
+		Port: int(binary.BigEndian.Uint16(ports[2:])),
+	}
+	return src, dst, nil
+}
+
+type ProxyProtocolTrustFunc func(upstream net.Addr) bool
+
+// TrustProxyProtocolAll trusts every upstream. Use it when the listener can
+// only be reached by the proxy, e.g., a Nitro enclave vsock listener.
+func TrustProxyProtocolAll(net.Addr) bool { return true }
+
+// TrustProxyProtocolCIDRs trusts upstreams whose IP address is in one of
+// cidrs. Only IP upstreams can match: vsock and Unix socket peers have no IP
+// address, so they are never trusted. Use TrustProxyProtocolAll for vsock
+// listeners that only the proxy can reach.
+func TrustProxyProtocolCIDRs(cidrs ...string) (ProxyProtocolTrustFunc, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, proxyProtocolError("parsing cidr: "+cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return func(upstream net.Addr) bool {
+		ip := net.ParseIP(addrIP(upstream))
+		if ip == nil {
+			return false
+		}
+		for _, network := range networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// ProxyProtocolListener parses PROXY protocol headers on accepted connections
+// so RemoteAddr reports the original client rather than the proxy. Headers
+// are only honored from trusted upstreams; connections from trusted
+// upstreams must send one. Untrusted connections are passed through as-is.
+//
+// Headers are parsed concurrently, each bounded by
+// DefaultProxyProtocolHeaderTimeout, before Accept returns the connection, so
+// a slow client cannot stall the accept loop and RemoteAddr never blocks.
+// Connections with a missing or malformed header are closed and dropped.
+type ProxyProtocolListener struct {
+	*handshakeListener
+
+	trust         ProxyProtocolTrustFunc
+	headerTimeout time.Duration
+}
+
+func NewProxyProtocolListener(
+	listener net.Listener,
+	trust ProxyProtocolTrustFunc,
+) *ProxyProtocolListener {
+	proxyListener := &ProxyProtocolListener{
+		trust:         trust,
+		headerTimeout: DefaultProxyProtocolHeaderTimeout,
+	}
+	proxyListener.handshakeListener = newHandshakeListener(
+		listener,
+		proxyListener.readHeader,
+	)
+	return proxyListener
+}
+
+func (p *ProxyProtocolListener) readHeader(conn net.Conn) (net.Conn, error) {
+	if !p.trust(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	// The connection is not handed out until the header is parsed, so there
+	// is no caller deadline to preserve.
+	reader := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(p.headerTimeout))
+	src, dst, err := ReadProxyProtocolHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	return &proxyProtocolConn{Conn: conn, reader: reader, src: src, dst: dst}, nil
+}
+
+type proxyProtocolConn struct {
+	net.Conn
+
+	reader *bufio.Reader
+	src    net.Addr
+	dst    net.Addr
+}
+
+func (p *proxyProtocolConn) Read(b []byte) (int, error) {
+	return p.reader.Read(b)
+}
+
+func (p *proxyProtocolConn) RemoteAddr() net.Addr {
+	if p.src != nil {
+		return p.src
+	}
+	return p.Conn.RemoteAddr()
+}
+
+func (p *proxyProtocolConn) LocalAddr() net.Addr {
+	if p.dst != nil {
+		return p.dst
+	}
+	return p.Conn.LocalAddr()
+}
@@ -0,0 +1,212 @@
+package tee_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func TestProxyProtocolHeader(t *testing.T) {
+	tcp4Src := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234}
+	tcp4Dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8443}
+	tcp6Src := &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 51234}
+	tcp6Dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 8443}
+	unixAddr := &net.UnixAddr{Name: "/tmp/sock", Net: "unix"}
+
+	for _, version := range []int{tee.ProxyProtocolV1, tee.ProxyProtocolV2} {
+		t.Run("happy path - tcp4", func(t *testing.T) {
+			// given
+			header, err := tee.MakeProxyProtocolHeader(version, tcp4Src, tcp4Dst)
+			require.NoError(t, err)
+
+			// when
+			src, dst, err := tee.ReadProxyProtocolHeader(
+				bufio.NewReader(bytes.NewReader(header)),
+			)
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, tcp4Src.String(), src.String())
+			assert.Equal(t, tcp4Dst.String(), dst.String())
+		})
+
+		t.Run("happy path - tcp6", func(t *testing.T) {
+			// given
+			header, err := tee.MakeProxyProtocolHeader(version, tcp6Src, tcp6Dst)
+			require.NoError(t, err)
+
+			// when
+			src, dst, err := tee.ReadProxyProtocolHeader(
+				bufio.NewReader(bytes.NewReader(header)),
+			)
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, tcp6Src.String(), src.String())
+			assert.Equal(t, tcp6Dst.String(), dst.String())
+		})
+
+		t.Run("happy path - unknown address", func(t *testing.T) {
+			// given
+			header, err := tee.MakeProxyProtocolHeader(version, unixAddr, unixAddr)
+			require.NoError(t, err)
+
+			// when
+			src, dst, err := tee.ReadProxyProtocolHeader(
+				bufio.NewReader(bytes.NewReader(header)),
+			)
+
+			// then
+			require.NoError(t, err)
+			assert.Nil(t, src)
+			assert.Nil(t, dst)
+		})
+	}
+
+	t.Run("error - unsupported version", func(t *testing.T) {
+		// when
+		_, err := tee.MakeProxyProtocolHeader(3, tcp4Src, tcp4Dst)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrProxyProtocol)
+	})
+
+	t.Run("error - missing header", func(t *testing.T) {
+		// given
+		reader := bufio.NewReader(bytes.NewReader([]byte("GET / HTTP/1.1\r\n\r\n")))
+
+		// when
+		_, _, err := tee.ReadProxyProtocolHeader(reader)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrProxyProtocol)
+		assert.ErrorContains(t, err, "missing header")
+	})
+}
+
+func newTestRemoteAddrServer(t *testing.T, trust tee.ProxyProtocolTrustFunc) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	ppListener := tee.NewProxyProtocolListener(listener, trust)
+	go func() {
+		for {
+			conn, err := ppListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1)
+				if _, err := conn.Read(buf); err != nil {
+					return
+				}
+				_, _ = io.WriteString(conn, conn.RemoteAddr().String()+"\n")
+			}()
+		}
+	}()
+	return ppListener
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	dialContext, err := tee.NewDialContext(tee.NoTEE)
+	require.NoError(t, err)
+
+	serveRevProxy := func(t *testing.T, targetAddr string, version int) (string, func()) {
+		t.Helper()
+		listener, err := net.Listen("tcp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		closeRevProxy := make(chan struct{})
+		serveFunc := tee.MakeReverseProxyTLSServeFunc(
+			dialContext,
+			listener,
+			targetAddr,
+			closeRevProxy,
+			logger,
+			tee.WithReverseProxyProxyProtocol(version),
+		)
+		runService(func() { _ = serveFunc() }, 10*time.Millisecond)
+		return listener.Addr().String(), func() {
+			close(closeRevProxy)
+			listener.Close()
+		}
+	}
+
+	for _, version := range []int{tee.ProxyProtocolV1, tee.ProxyProtocolV2} {
+		t.Run("happy path - trusted upstream reports client address", func(t *testing.T) {
+			// given
+			trust, err := tee.TrustProxyProtocolCIDRs("127.0.0.0/8")
+			require.NoError(t, err)
+			target := newTestRemoteAddrServer(t, trust)
+			defer target.Close()
+
+			revProxyAddr, closeRevProxy := serveRevProxy(t, target.Addr().String(), version)
+			defer closeRevProxy()
+
+			conn, err := net.Dial("tcp4", revProxyAddr)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			// when
+			_, err = conn.Write([]byte("x"))
+			require.NoError(t, err)
+			got, err := bufio.NewReader(conn).ReadString('\n')
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, conn.LocalAddr().String()+"\n", got)
+		})
+	}
+
+	t.Run("error - untrusted upstream header is not honored", func(t *testing.T) {
+		// given
+		trust, err := tee.TrustProxyProtocolCIDRs("192.0.2.0/24")
+		require.NoError(t, err)
+		target := newTestRemoteAddrServer(t, trust)
+		defer target.Close()
+
+		conn, err := net.Dial("tcp4", target.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		spoofed := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 1234}
+		header, err := tee.MakeProxyProtocolHeader(tee.ProxyProtocolV1, spoofed, spoofed)
+		require.NoError(t, err)
+
+		// when
+		_, err = conn.Write(header)
+		require.NoError(t, err)
+		got, err := bufio.NewReader(conn).ReadString('\n')
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, conn.LocalAddr().String()+"\n", got)
+	})
+
+	t.Run("error - trusted upstream without header", func(t *testing.T) {
+		// given
+		target := newTestRemoteAddrServer(t, tee.TrustProxyProtocolAll)
+		defer target.Close()
+
+		conn, err := net.Dial("tcp4", target.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// when
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		_, err = bufio.NewReader(conn).ReadString('\n')
+
+		// then
+		require.ErrorIs(t, err, io.EOF)
+	})
+}
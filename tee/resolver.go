@@ -0,0 +1,343 @@
+package tee
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDNSUpstream     = "1.1.1.1:53"
+	DefaultDoHURL          = "https://cloudflare-dns.com/dns-query"
+	DNSMaxMessageSize      = 64 * KiloByte
+	DNSHeaderSize          = 12
+	DNSLengthPrefixSize    = 2
+	DNSFlagsOffset         = 3
+	DNSFlagAuthenticData   = 0x20
+	DoHContentType         = "application/dns-message"
+	DefaultResolverTimeout = 5 * time.Second
+)
+
+// NewResolver returns a net.Resolver that sends DNS queries over a stream
+// connection to the host (vsock on Nitro, TCP elsewhere). The host should be
+// running a DNS forwarder (see NewHostDNSForwarder) that relays the queries
+// to an upstream resolver. Because the resolver is dialed as a stream, the
+// Go resolver uses DNS-over-TCP framing (RFC 7766). Answers are not
+// authenticated unless WithResolverDNSSEC is given, so otherwise the host
+// can spoof them.
+func NewResolver(
+	platform Platform,
+	hostAddr string,
+	options ...ResolverOption,
+) (*net.Resolver, error) {
+	dialContext, err := NewDialContext(platform)
+	if err != nil {
+		return nil, resolverError("creating dialer", err)
+	}
+	return NewResolverWithDialContext(dialContext, hostAddr, options...)
+}
+
+func NewResolverWithDialContext(
+	dialContext DialContext,
+	hostAddr string,
+	options ...ResolverOption,
+) (*net.Resolver, error) {
+	opts := MakeDefaultResolverOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	if len(opts.TrustAnchors) == 0 {
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+				// Ignore the nameserver the Go resolver picked from resolv.conf
+				// and always send queries to the host forwarder.
+				return dialContext(ctx, NetworkTCP, hostAddr)
+			},
+		}, nil
+	}
+
+	validator, err := newDNSSECValidator(streamExchange(dialContext, hostAddr), opts.TrustAnchors)
+	if err != nil {
+		return nil, err
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			return newDNSConn(ctx, validator.exchange, hostAddr, opts.Timeout), nil
+		},
+	}, nil
+}
+
+// streamExchange sends each query to hostAddr on its own DNS-over-TCP
+// connection.
+func streamExchange(dialContext DialContext, hostAddr string) dnsExchange {
+	return func(ctx context.Context, query []byte) ([]byte, error) {
+		conn, err := dialContext(ctx, NetworkTCP, hostAddr)
+		if err != nil {
+			return nil, resolverError("dialing host", err)
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+		}
+
+		prefix := binary.BigEndian.AppendUint16(nil, uint16(len(query))) //nolint:gosec
+		if _, err = conn.Write(append(prefix, query...)); err != nil {
+			return nil, resolverError("sending query", err)
+		}
+		if _, err = io.ReadFull(conn, prefix); err != nil {
+			return nil, resolverError("reading answer", err)
+		}
+		answer := make([]byte, binary.BigEndian.Uint16(prefix))
+		if _, err = io.ReadFull(conn, answer); err != nil {
+			return nil, resolverError("reading answer", err)
+		}
+		return answer, nil
+	}
+}
+
+// NewHostDNSForwarder runs on the host and relays DNS-over-TCP queries from
+// the enclave to upstreamAddr. It does not interpret the queries, so the
+// host can still observe and tamper with them. Use WithResolverDNSSEC on the
+// enclave side when the answers need to be authenticated.
+func NewHostDNSForwarder(
+	ctx context.Context,
+	platform Platform,
+	listenAddr string,
+	upstreamAddr string,
+	logger *slog.Logger,
+) (*Forwarder, error) {
+	mappings := []ForwardMapping{{ListenAddr: listenAddr, TargetAddr: upstreamAddr}}
+	return NewHostForwarder(ctx, platform, mappings, logger)
+}
+
+type ResolverOption func(*ResolverOptions)
+type ResolverOptions struct {
+	TrustAnchors    []DNSSECTrustAnchor
+	TrustUpstreamAD bool
+	Timeout         time.Duration
+}
+
+func MakeDefaultResolverOptions() ResolverOptions {
+	return ResolverOptions{
+		TrustAnchors:    nil,
+		TrustUpstreamAD: false,
+		Timeout:         DefaultResolverTimeout,
+	}
+}
+
+// WithResolverDNSSEC validates every answer inside the enclave, following
+// the chain of signatures down from the given trust anchors, or from
+// DNSSECRootAnchors if none are given. Neither the host nor the upstream
+// resolver can forge an answer that passes. It fails closed: names in
+// unsigned zones, nonexistent names, and answers that cannot be proven
+// (e.g., DNAME redirections) cannot be resolved.
+func WithResolverDNSSEC(anchors ...DNSSECTrustAnchor) ResolverOption {
+	return func(opts *ResolverOptions) {
+		if len(anchors) == 0 {
+			anchors = DNSSECRootAnchors
+		}
+		opts.TrustAnchors = anchors
+	}
+}
+
+func WithResolverTrustUpstreamAD(trust bool) ResolverOption {
+	return func(opts *ResolverOptions) {
+		opts.TrustUpstreamAD = trust
+	}
+}
+
+func WithResolverTimeout(timeout time.Duration) ResolverOption {
+	return func(opts *ResolverOptions) {
+		opts.Timeout = timeout
+	}
+}
+
+// NewDoHResolver returns a net.Resolver that sends DNS queries over HTTPS
+// (RFC 8484). Pass the client returned by NewProxiedClient so the queries
+// tunnel through the host proxy. TLS is terminated inside the enclave, so
+// the host cannot read or spoof the answers.
+func NewDoHResolver(
+	client *http.Client,
+	dohURL string,
+	options ...ResolverOption,
+) (*net.Resolver, error) {
+	opts := MakeDefaultResolverOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	exchange := dohExchange(client, dohURL, opts)
+	if len(opts.TrustAnchors) > 0 {
+		validator, err := newDNSSECValidator(exchange, opts.TrustAnchors)
+		if err != nil {
+			return nil, err
+		}
+		exchange = validator.exchange
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			return newDNSConn(ctx, exchange, dohURL, opts.Timeout), nil
+		},
+	}, nil
+}
+
+// dohExchange sends each query as a single HTTPS POST.
+func dohExchange(client *http.Client, dohURL string, opts ResolverOptions) dnsExchange {
+	return func(ctx context.Context, query []byte) ([]byte, error) {
+		if len(query) < DNSHeaderSize {
+			return nil, resolverError("query too short", nil)
+		}
+		if opts.TrustUpstreamAD {
+			// Setting AD in a query asks the upstream resolver to report
+			// whether it validated the answer (RFC 6840, Section 5.7).
+			query[DNSFlagsOffset] |= DNSFlagAuthenticData
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, dohURL, bytes.NewReader(query))
+		if err != nil {
+			return nil, resolverError("creating request", err)
+		}
+		req.Header.Set("Content-Type", DoHContentType)
+		req.Header.Set("Accept", DoHContentType)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, resolverError("sending query", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			msg := fmt.Sprintf("unexpected status code: %d", resp.StatusCode)
+			return nil, resolverError(msg, nil)
+		}
+
+		answer, err := io.ReadAll(io.LimitReader(resp.Body, DNSMaxMessageSize))
+		switch {
+		case err != nil:
+			return nil, resolverError("reading answer", err)
+		case len(answer) < DNSHeaderSize:
+			return nil, resolverError("answer too short", nil)
+		case opts.TrustUpstreamAD &&
+			answer[DNSFlagsOffset]&DNSFlagAuthenticData == 0:
+			return nil, resolverError("upstream did not authenticate the answer", nil)
+		}
+		return answer, nil
+	}
+}
+
+// dnsConn adapts a query/answer exchange to the net.Conn the Go resolver
+// expects. It is a stream (not a net.PacketConn), so the resolver writes
+// length-prefixed queries and reads length-prefixed answers. Each complete
+// query is handed to exchange.
+type dnsConn struct {
+	ctx      context.Context //nolint:containedctx
+	exchange dnsExchange
+	addr     string
+	timeout  time.Duration
+
+	mu       sync.Mutex
+	deadline time.Time
+	writeBuf bytes.Buffer
+	readBuf  bytes.Buffer
+	closed   bool
+}
+
+func newDNSConn(
+	ctx context.Context,
+	exchange dnsExchange,
+	addr string,
+	timeout time.Duration,
+) *dnsConn {
+	return &dnsConn{ctx: ctx, exchange: exchange, addr: addr, timeout: timeout}
+}
+
+func (d *dnsConn) Read(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return 0, net.ErrClosed
+	}
+	if d.readBuf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return d.readBuf.Read(b)
+}
+
+func (d *dnsConn) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return 0, net.ErrClosed
+	}
+
+	d.writeBuf.Write(b)
+	for d.writeBuf.Len() >= DNSLengthPrefixSize {
+		size := int(binary.BigEndian.Uint16(d.writeBuf.Bytes()))
+		if d.writeBuf.Len() < DNSLengthPrefixSize+size {
+			break
+		}
+
+		query := make([]byte, size)
+		d.writeBuf.Next(DNSLengthPrefixSize)
+		_, _ = d.writeBuf.Read(query)
+
+		answer, err := d.exchangeWithDeadline(query)
+		if err != nil {
+			return 0, err
+		}
+
+		prefix := make([]byte, DNSLengthPrefixSize)
+		binary.BigEndian.PutUint16(prefix, uint16(len(answer))) //nolint:gosec
+		d.readBuf.Write(prefix)
+		d.readBuf.Write(answer)
+	}
+	return len(b), nil
+}
+
+func (d *dnsConn) exchangeWithDeadline(query []byte) ([]byte, error) {
+	ctx := d.ctx
+	var cancel context.CancelFunc
+	if !d.deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, d.deadline)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+	}
+	defer cancel()
+	return d.exchange(ctx, query)
+}
+
+func (d *dnsConn) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	return nil
+}
+
+func (d *dnsConn) LocalAddr() net.Addr  { return dnsAddr(d.addr) }
+func (d *dnsConn) RemoteAddr() net.Addr { return dnsAddr(d.addr) }
+
+func (d *dnsConn) SetDeadline(t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deadline = t
+	return nil
+}
+
+func (d *dnsConn) SetReadDeadline(time.Time) error    { return nil }
+func (d *dnsConn) SetWriteDeadline(t time.Time) error { return d.SetDeadline(t) }
+
+type dnsAddr string
+
+func (a dnsAddr) Network() string { return "dns" }
+func (a dnsAddr) String() string  { return string(a) }
@@ -0,0 +1,194 @@
+package tee_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var testDNSAnswerIP = [4]byte{10, 1, 2, 3}
+
+func makeTestDNSAnswer(t *testing.T, query []byte, authenticated bool) []byte {
+	t.Helper()
+	parser := dnsmessage.Parser{}
+	header, err := parser.Start(query)
+	require.NoError(t, err)
+	question, err := parser.Question()
+	require.NoError(t, err)
+
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:            header.ID,
+		Response:      true,
+		Authoritative: true,
+		AuthenticData: authenticated,
+	})
+	require.NoError(t, builder.StartQuestions())
+	require.NoError(t, builder.Question(question))
+	require.NoError(t, builder.StartAnswers())
+	if question.Type == dnsmessage.TypeA {
+		err = builder.AResource(
+			dnsmessage.ResourceHeader{
+				Name:  question.Name,
+				Class: dnsmessage.ClassINET,
+				TTL:   60,
+			},
+			dnsmessage.AResource{A: testDNSAnswerIP},
+		)
+		require.NoError(t, err)
+	}
+	answer, err := builder.Finish()
+	require.NoError(t, err)
+	return answer
+}
+
+func makeTestDNSAnswerFunc(t *testing.T, authenticated bool) func([]byte) []byte {
+	t.Helper()
+	return func(query []byte) []byte {
+		return makeTestDNSAnswer(t, query, authenticated)
+	}
+}
+
+func newTestDNSServer(t *testing.T, answerFunc func([]byte) []byte) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					prefix := make([]byte, tee.DNSLengthPrefixSize)
+					if _, err := io.ReadFull(conn, prefix); err != nil {
+						return
+					}
+					query := make([]byte, binary.BigEndian.Uint16(prefix))
+					if _, err := io.ReadFull(conn, query); err != nil {
+						return
+					}
+					answer := answerFunc(query)
+					binary.BigEndian.PutUint16(prefix, uint16(len(answer))) //nolint:gosec
+					_, _ = conn.Write(append(prefix, answer...))
+				}
+			}()
+		}
+	}()
+	return listener
+}
+
+func newTestDoHServer(t *testing.T, answerFunc func([]byte) []byte) *httptest.Server {
+	t.Helper()
+	return httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, tee.DoHContentType, r.Header.Get("Content-Type"))
+			query, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+
+			w.Header().Set("Content-Type", tee.DoHContentType)
+			_, _ = w.Write(answerFunc(query))
+		}),
+	)
+}
+
+func TestResolver(t *testing.T) {
+	ctx := context.Background()
+	platform := tee.NoTEE
+	logger := slog.New(slog.DiscardHandler)
+	want := []string{net.IP(testDNSAnswerIP[:]).String()}
+
+	t.Run("happy path - through host dns forwarder", func(t *testing.T) {
+		// given
+		upstream := newTestDNSServer(t, makeTestDNSAnswerFunc(t, false))
+		defer upstream.Close()
+
+		forwarder, err := tee.NewHostDNSForwarder(
+			ctx,
+			platform,
+			"127.0.0.1:0",
+			upstream.Addr().String(),
+			logger,
+		)
+		require.NoError(t, err)
+		defer forwarder.Close()
+		runService(func() { _ = forwarder.Serve() }, 10*time.Millisecond)
+
+		resolver, err := tee.NewResolver(platform, forwarder.Addrs()[0])
+		require.NoError(t, err)
+
+		// when
+		got, err := resolver.LookupHost(ctx, "example.com")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("happy path - dns over https", func(t *testing.T) {
+		// given
+		dohServer := newTestDoHServer(t, makeTestDNSAnswerFunc(t, false))
+		defer dohServer.Close()
+
+		resolver, err := tee.NewDoHResolver(dohServer.Client(), dohServer.URL)
+		require.NoError(t, err)
+
+		// when
+		got, err := resolver.LookupHost(ctx, "example.com")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("happy path - dns over https trusting upstream ad", func(t *testing.T) {
+		// given
+		dohServer := newTestDoHServer(t, makeTestDNSAnswerFunc(t, true))
+		defer dohServer.Close()
+
+		resolver, err := tee.NewDoHResolver(
+			dohServer.Client(),
+			dohServer.URL,
+			tee.WithResolverTrustUpstreamAD(true),
+		)
+		require.NoError(t, err)
+
+		// when
+		got, err := resolver.LookupHost(ctx, "example.com")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("error - dns over https answer not authenticated", func(t *testing.T) {
+		// given
+		dohServer := newTestDoHServer(t, makeTestDNSAnswerFunc(t, false))
+		defer dohServer.Close()
+
+		resolver, err := tee.NewDoHResolver(
+			dohServer.Client(),
+			dohServer.URL,
+			tee.WithResolverTrustUpstreamAD(true),
+		)
+		require.NoError(t, err)
+
+		// when
+		_, err = resolver.LookupHost(ctx, "example.com")
+
+		// then
+		assert.Error(t, err)
+	})
+}
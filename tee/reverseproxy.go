@@ -10,6 +10,14 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"time"
+)
+
+const (
+	DefaultReverseProxyMaxConns = 1024
+	DefaultAcceptMaxBackoff     = 1 * time.Second
+	MinAcceptBackoff            = 5 * time.Millisecond
+	RejectReasonPerIP           = "per_ip_limit"
 )
 
 type ReverseProxy struct {
@@ -39,23 +47,24 @@ func NewReverseProxyWithDialContext(
 	targetAddr string,
 	logger *slog.Logger,
 ) (*ReverseProxy, error) {
-	targetURL, err := url.Parse(targetAddr)
+	targetURL, transportDialContext, err := parseReverseProxyTarget(dialContext, targetAddr)
 	if err != nil {
-		return nil, reverseProxyError("parsing target URL", err)
+		return nil, err
 	}
 
 	reverseProxy := httputil.NewSingleHostReverseProxy(targetURL)
-	reverseProxy.Transport = &http.Transport{DialContext: dialContext}
+	reverseProxy.Transport = &http.Transport{DialContext: transportDialContext}
 
 	// NOTE: Reverse Proxies are only ever run (1) outside a Nitro Enclave
 	// or (2) within an SEV-SNP/TDX enclave. This means the reverse proxy will
 	// always listen on a regular socket, which is why we use NoTEE here.
-	listener, err := NewListener(ctx, NoTEE, NetworkTCP4, addr)
+	listener, err := NewListener(ctx, NoTEE, NetworkTCP, addr)
 	if err != nil {
 		return nil, reverseProxyError("creating listener", err)
 	}
 
-	server := DefaultReverseProxyServer(reverseProxy, logger)
+	handler := InstrumentHandler(DefaultMetrics, ComponentReverseProxy, reverseProxy)
+	server := DefaultReverseProxyServer(handler, logger)
 	closeFunc := func() error {
 		if closeErr := listener.Close(); closeErr != nil {
 			return closeErr
@@ -70,18 +79,52 @@ func NewReverseProxyWithDialContext(
 	}, nil
 }
 
+// parseReverseProxyTarget returns the URL to proxy requests to and the dial
+// function to reach it. A "unix://" target has no host for httputil to dial,
+// so requests are sent to a placeholder host and every connection is dialed
+// to the socket instead.
+func parseReverseProxyTarget(
+	dialContext DialContext,
+	targetAddr string,
+) (*url.URL, DialContext, error) {
+	address, err := ParseAddress(targetAddr)
+	if err != nil {
+		return nil, nil, reverseProxyError("parsing target address", err)
+	}
+	if address.Scheme == SchemeUnix {
+		unixDialContext := func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			return dialContext(ctx, NetworkUnix, targetAddr)
+		}
+		return &url.URL{Scheme: SchemeHTTP, Host: "localhost"}, unixDialContext, nil
+	}
+
+	targetURL, err := url.Parse(targetAddr)
+	if err != nil {
+		return nil, nil, reverseProxyError("parsing target URL", err)
+	}
+	return targetURL, dialContext, nil
+}
+
 func NewReverseProxyTLS(
 	ctx context.Context,
 	platform Platform,
 	addr string,
 	targetAddr string,
 	logger *slog.Logger,
+	options ...ReverseProxyOption,
 ) (*ReverseProxy, error) {
 	dialContext, err := NewDialContext(platform)
 	if err != nil {
 		return nil, reverseProxyError("creating dialer", err)
 	}
-	return NewReverseProxyTLSWithDialContext(ctx, dialContext, addr, targetAddr, logger)
+	return NewReverseProxyTLSWithDialContext(
+		ctx,
+		dialContext,
+		addr,
+		targetAddr,
+		logger,
+		options...,
+	)
 }
 
 //nolint:contextcheck
@@ -91,11 +134,12 @@ func NewReverseProxyTLSWithDialContext(
 	addr string,
 	targetAddr string,
 	logger *slog.Logger,
+	options ...ReverseProxyOption,
 ) (*ReverseProxy, error) {
 	// NOTE: Reverse Proxies are only ever run (1) outside a Nitro Enclave
 	// or (2) within an SEV-SNP/TDX enclave. This means the reverse proxy will
 	// always listen on a regular socket, which is why we use NoTEE here.
-	listener, err := NewListener(ctx, NoTEE, NetworkTCP4, addr)
+	listener, err := NewListener(ctx, NoTEE, NetworkTCP, addr)
 	if err != nil {
 		return nil, reverseProxyError("creating listener", err)
 	}
@@ -112,6 +156,7 @@ func NewReverseProxyTLSWithDialContext(
 		targetAddr,
 		closeRevProxy,
 		logger,
+		options...,
 	)
 	return &ReverseProxy{
 		listener:  listener,
@@ -124,29 +169,183 @@ func (r *ReverseProxy) Addr() string { return r.listener.Addr().String() }
 func (r *ReverseProxy) Close() error { return r.closeFunc() }
 func (r *ReverseProxy) Serve() error { return r.serveFunc() }
 
+type ReverseProxyOption func(*ReverseProxyOptions)
+type ReverseProxyOptions struct {
+	MaxConns         int
+	MaxConnsPerIP    int
+	AcceptRate       float64
+	AcceptBurst      int
+	AcceptMaxBackoff time.Duration
+	ProxyProtocol    int
+}
+
+func MakeDefaultReverseProxyOptions() ReverseProxyOptions {
+	return ReverseProxyOptions{
+		MaxConns:         DefaultReverseProxyMaxConns,
+		MaxConnsPerIP:    0,
+		AcceptRate:       0,
+		AcceptBurst:      0,
+		AcceptMaxBackoff: DefaultAcceptMaxBackoff,
+		ProxyProtocol:    0,
+	}
+}
+
+// WithReverseProxyMaxConns caps the number of connections being proxied at
+// once. When the cap is reached the proxy stops accepting, leaving new
+// connections queued in the kernel backlog. Zero or less disables the cap.
+func WithReverseProxyMaxConns(maxConns int) ReverseProxyOption {
+	return func(opts *ReverseProxyOptions) {
+		opts.MaxConns = maxConns
+	}
+}
+
+// WithReverseProxyMaxConnsPerIP caps the number of connections from a single
+// source IP. Connections over the cap are closed immediately. Zero or less
+// disables the cap.
+func WithReverseProxyMaxConnsPerIP(maxConns int) ReverseProxyOption {
+	return func(opts *ReverseProxyOptions) {
+		opts.MaxConnsPerIP = maxConns
+	}
+}
+
+// WithReverseProxyAcceptRate limits accepted connections to rate per second
+// with bursts of up to burst connections. Zero or less disables the limit.
+func WithReverseProxyAcceptRate(rate float64, burst int) ReverseProxyOption {
+	return func(opts *ReverseProxyOptions) {
+		opts.AcceptRate = rate
+		opts.AcceptBurst = max(burst, 1)
+	}
+}
+
+func WithReverseProxyAcceptMaxBackoff(backoff time.Duration) ReverseProxyOption {
+	return func(opts *ReverseProxyOptions) {
+		opts.AcceptMaxBackoff = backoff
+	}
+}
+
+// WithReverseProxyProxyProtocol prepends a PROXY protocol header of the
+// given version (ProxyProtocolV1 or ProxyProtocolV2) to every connection
+// forwarded to the target, so the enclave can see the original client
+// address. Zero disables the header.
+func WithReverseProxyProxyProtocol(version int) ReverseProxyOption {
+	return func(opts *ReverseProxyOptions) {
+		opts.ProxyProtocol = version
+	}
+}
+
 func MakeReverseProxyTLSServeFunc(
 	dialContext DialContext,
 	listener net.Listener,
 	targetAddr string,
 	closeRevProxy chan struct{},
 	logger *slog.Logger,
+	options ...ReverseProxyOption,
 ) ServeFunc {
+	opts := MakeDefaultReverseProxyOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	var slots chan struct{}
+	if opts.MaxConns > 0 {
+		slots = make(chan struct{}, opts.MaxConns)
+	}
+	var perIP *connLimiter
+	if opts.MaxConnsPerIP > 0 {
+		perIP = newConnLimiter(opts.MaxConnsPerIP)
+	}
+	var rate *tokenBucket
+	if opts.AcceptRate > 0 {
+		rate = newTokenBucket(opts.AcceptRate, opts.AcceptBurst)
+	}
+	rejected := func(reason string) {
+		DefaultMetrics.IncCounter(MetricProxyRejected, Labels{
+			"component": ComponentReverseProxyTLS,
+			"reason":    reason,
+		})
+	}
+
 	return func() error {
+		backoff := time.Duration(0)
 		for {
-			select {
-			case <-closeRevProxy:
+			if slots != nil {
+				select {
+				case <-closeRevProxy:
+					return nil
+				case slots <- struct{}{}:
+				}
+			}
+			release := func() {
+				if slots != nil {
+					<-slots
+				}
+			}
+
+			if rate != nil && !rate.wait(closeRevProxy) {
+				release()
 				return nil
-			default:
 			}
 
 			clientConn, err := listener.Accept()
 			if err != nil {
-				logger.Error("accepting connection", slog.String("error", err.Error()))
+				release()
+				select {
+				case <-closeRevProxy:
+					return nil
+				default:
+				}
+				if errors.Is(err, net.ErrClosed) {
+					return reverseProxyError("accepting connection", err)
+				}
+
+				// Back off on temporary errors (e.g., running out of file
+				// descriptors) instead of spinning and flooding the logs.
+				backoff = min(max(2*backoff, MinAcceptBackoff), opts.AcceptMaxBackoff)
+				logger.Error(
+					"accepting connection",
+					slog.String("error", err.Error()),
+					slog.Duration("retry", backoff),
+				)
+				timer := time.NewTimer(backoff)
+				select {
+				case <-closeRevProxy:
+					timer.Stop()
+					return nil
+				case <-timer.C:
+				}
 				continue
 			}
+			backoff = 0
+
+			remoteAddr := clientConn.RemoteAddr()
+			ip := ""
+			if perIP != nil {
+				var ok bool
+				ip, ok = perIP.acquire(remoteAddr)
+				if !ok {
+					logger.Warn("too many connections from source", slog.String("ip", ip))
+					rejected(RejectReasonPerIP)
+					clientConn.Close()
+					release()
+					continue
+				}
+			}
 
-			logger.Info("accepted connection", slog.String("addr", clientConn.RemoteAddr().String()))
-			go proxyTLSConn(clientConn, dialContext, targetAddr, closeRevProxy, logger)
+			logger.Info("accepted connection", slog.String("addr", remoteAddr.String()))
+			go func() {
+				defer release()
+				if perIP != nil {
+					defer perIP.release(ip)
+				}
+				proxyTLSConn(
+					clientConn,
+					dialContext,
+					targetAddr,
+					closeRevProxy,
+					logger,
+					opts.ProxyProtocol,
+				)
+			}()
 		}
 	}
 }
@@ -157,29 +356,54 @@ func proxyTLSConn(
 	targetAddr string,
 	closeRevProxy chan struct{},
 	logger *slog.Logger,
+	proxyProtocol int,
 ) {
 	defer clientConn.Close()
 
+	labels := Labels{"component": ComponentReverseProxyTLS}
+	DefaultMetrics.IncCounter(MetricProxyConnections, labels)
+
 	dialCtx, dialCancel := context.WithTimeout(context.Background(), DefaultConnTimeout)
 	defer dialCancel()
 
-	serverConn, err := dialContext(dialCtx, NetworkTCP4, targetAddr)
+	serverConn, err := dialContext(dialCtx, NetworkTCP, targetAddr)
 	if err != nil {
 		logger.Error("dialing target", slog.String("error", err.Error()))
+		DefaultMetrics.IncCounter(MetricProxyDialFailures, labels)
 		return
 	}
 	defer serverConn.Close()
 
+	if proxyProtocol != 0 {
+		header, headerErr := MakeProxyProtocolHeader(
+			proxyProtocol,
+			clientConn.RemoteAddr(),
+			clientConn.LocalAddr(),
+		)
+		if headerErr == nil {
+			_, headerErr = serverConn.Write(header)
+		}
+		if headerErr != nil {
+			logger.Error("writing proxy protocol header", slog.String("error", headerErr.Error()))
+			return
+		}
+	}
+
 	connCtx, connCancel := context.WithTimeout(context.Background(), DefaultConnTimeout)
 	defer connCancel()
 
+	start := time.Now()
+	defer DefaultMetrics.ObserveDuration(MetricProxyTunnelSeconds, labels, start)
+
 	connDone := make(chan error, NumConnDoneChannels)
 	go func() {
-		_, connErr := copyNoSplice(serverConn, clientConn)
+		n, connErr := copyNoSplice(serverConn, clientConn, reverseProxyError)
+		recordProxyBytes(ComponentReverseProxyTLS, DirectionUpstream, n)
 		connDone <- connErr
 	}()
 	go func() {
-		_, connErr := copyNoSplice(clientConn, serverConn)
+		n, connErr := copyNoSplice(clientConn, serverConn, reverseProxyError)
+		recordProxyBytes(ComponentReverseProxyTLS, DirectionDownstream, n)
 		connDone <- connErr
 	}()
 
@@ -201,7 +425,12 @@ func proxyTLSConn(
 // copyNoSplice copies from src to dst without using splice, which avoids
 // kernel issues on SEV/TDX and TDX. If you try using io.Copy with a splice-enabled
 // connection, you'll get an error.
-func copyNoSplice(dst io.Writer, src io.Reader) (int64, error) {
+// Errors are wrapped with wrapErr, the error helper of the calling component.
+func copyNoSplice(
+	dst io.Writer,
+	src io.Reader,
+	wrapErr func(msg string, err error) error,
+) (int64, error) {
 	total := int64(0)
 	buf := make([]byte, DefaultConnBufferSize) // 32KB buffer
 	for {
@@ -213,17 +442,17 @@ func copyNoSplice(dst io.Writer, src io.Reader) (int64, error) {
 			total += int64(numWrite)
 			switch {
 			case writeErr != nil:
-				return total, reverseProxyError("writing bytes", writeErr)
+				return total, wrapErr("writing bytes", writeErr)
 			case numRead != numWrite:
 				msg := fmt.Sprintf("read %d bytes, wrote %d bytes", numRead, numWrite)
-				return total, reverseProxyError(msg, io.ErrShortWrite)
+				return total, wrapErr(msg, io.ErrShortWrite)
 			}
 		}
 		if readErr != nil {
 			if errors.Is(readErr, io.EOF) {
 				return total, nil
 			}
-			return total, reverseProxyError("reading bytes", readErr)
+			return total, wrapErr("reading bytes", readErr)
 		}
 	}
 }
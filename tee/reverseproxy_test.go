@@ -14,6 +14,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/mocks"
 	"github.com/tahardi/bearclave/tee"
 )
 
@@ -160,3 +161,175 @@ func TestReverseProxyTLS(t *testing.T) {
 		require.ErrorIs(t, err, io.EOF)
 	})
 }
+
+func TestMakeReverseProxyTLSServeFunc(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	dialContext, err := tee.NewDialContext(tee.NoTEE)
+	require.NoError(t, err)
+
+	echo := func(t *testing.T, conn net.Conn, want []byte) error {
+		t.Helper()
+		_ = conn.SetDeadline(time.Now().Add(100 * time.Millisecond))
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+		if _, err := conn.Write(want); err != nil {
+			return err
+		}
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(conn, got); err != nil {
+			return err
+		}
+		assert.Equal(t, want, got)
+		return nil
+	}
+
+	t.Run("happy path - max conns applies backpressure", func(t *testing.T) {
+		// given
+		want := []byte("hello world")
+		target := newTestEchoServer(t)
+		defer target.Close()
+
+		listener, err := net.Listen("tcp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		closeRevProxy := make(chan struct{})
+		defer close(closeRevProxy)
+		serveFunc := tee.MakeReverseProxyTLSServeFunc(
+			dialContext,
+			listener,
+			target.Addr().String(),
+			closeRevProxy,
+			logger,
+			tee.WithReverseProxyMaxConns(1),
+		)
+		runService(func() { _ = serveFunc() }, 10*time.Millisecond)
+
+		conn1, err := net.Dial("tcp4", listener.Addr().String())
+		require.NoError(t, err)
+		require.NoError(t, echo(t, conn1, want))
+
+		// when
+		conn2, err := net.Dial("tcp4", listener.Addr().String())
+		require.NoError(t, err)
+		defer conn2.Close()
+
+		// then
+		require.Error(t, echo(t, conn2, want))
+		conn1.Close()
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, echo(t, conn2, want))
+	})
+
+	t.Run("happy path - max conns per ip closes excess", func(t *testing.T) {
+		// given
+		want := []byte("hello world")
+		target := newTestEchoServer(t)
+		defer target.Close()
+
+		listener, err := net.Listen("tcp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		closeRevProxy := make(chan struct{})
+		defer close(closeRevProxy)
+		serveFunc := tee.MakeReverseProxyTLSServeFunc(
+			dialContext,
+			listener,
+			target.Addr().String(),
+			closeRevProxy,
+			logger,
+			tee.WithReverseProxyMaxConnsPerIP(1),
+		)
+		runService(func() { _ = serveFunc() }, 10*time.Millisecond)
+
+		conn1, err := net.Dial("tcp4", listener.Addr().String())
+		require.NoError(t, err)
+		defer conn1.Close()
+		require.NoError(t, echo(t, conn1, want))
+
+		// when
+		conn2, err := net.Dial("tcp4", listener.Addr().String())
+		require.NoError(t, err)
+		defer conn2.Close()
+
+		// then
+		_ = conn2.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err = conn2.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("happy path - accept rate limited", func(t *testing.T) {
+		// given
+		listener := mocks.NewListener(t)
+		listener.On("Accept").Return(nil, assert.AnError).Maybe()
+
+		closeRevProxy := make(chan struct{})
+		serveFunc := tee.MakeReverseProxyTLSServeFunc(
+			dialContext,
+			listener,
+			"127.0.0.1:9",
+			closeRevProxy,
+			logger,
+			tee.WithReverseProxyAcceptRate(10, 1),
+			tee.WithReverseProxyAcceptMaxBackoff(time.Millisecond),
+		)
+
+		// when
+		serveErr := make(chan error, 1)
+		go func() { serveErr <- serveFunc() }()
+		time.Sleep(100 * time.Millisecond)
+		close(closeRevProxy)
+
+		// then
+		require.NoError(t, <-serveErr)
+		assert.LessOrEqual(t, len(listener.Calls), 3)
+	})
+
+	t.Run("happy path - backoff on accept errors", func(t *testing.T) {
+		// given
+		listener := mocks.NewListener(t)
+		listener.On("Accept").Return(nil, assert.AnError).Maybe()
+
+		closeRevProxy := make(chan struct{})
+		serveFunc := tee.MakeReverseProxyTLSServeFunc(
+			dialContext,
+			listener,
+			"127.0.0.1:9",
+			closeRevProxy,
+			logger,
+		)
+
+		// when
+		serveErr := make(chan error, 1)
+		go func() { serveErr <- serveFunc() }()
+		time.Sleep(100 * time.Millisecond)
+		close(closeRevProxy)
+
+		// then
+		require.NoError(t, <-serveErr)
+		assert.Less(t, len(listener.Calls), 10)
+	})
+
+	t.Run("error - listener closed", func(t *testing.T) {
+		// given
+		listener := mocks.NewListener(t)
+		listener.On("Accept").Return(nil, net.ErrClosed)
+
+		closeRevProxy := make(chan struct{})
+		defer close(closeRevProxy)
+		serveFunc := tee.MakeReverseProxyTLSServeFunc(
+			dialContext,
+			listener,
+			"127.0.0.1:9",
+			closeRevProxy,
+			logger,
+		)
+
+		// when
+		err := serveFunc()
+
+		// then
+		require.ErrorIs(t, err, tee.ErrReverseProxy)
+		require.ErrorIs(t, err, net.ErrClosed)
+	})
+}
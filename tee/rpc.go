@@ -0,0 +1,340 @@
+package tee
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	RPCMaxMessageSize        = 4 * Megabyte
+	RPCMaxConcurrentRequests = 64
+)
+
+type RPCHandler func(ctx context.Context, params []byte) ([]byte, error)
+
+type RPCMessage struct {
+	ID     uint64 `json:"id"`
+	Method string `json:"method,omitempty"`
+	Params []byte `json:"params,omitempty"`
+	Result []byte `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Timeout is relative, in nanoseconds, so that client and server clocks
+	// need not agree.
+	Timeout int64 `json:"timeout,omitempty"`
+}
+
+type RPCServer struct {
+	mu       sync.RWMutex
+	handlers map[string]RPCHandler
+	listener net.Listener
+	logger   *slog.Logger
+	done     chan struct{}
+}
+
+func NewRPCServer(
+	ctx context.Context,
+	platform Platform,
+	network string,
+	addr string,
+	logger *slog.Logger,
+) (*RPCServer, error) {
+	listener, err := NewListener(ctx, platform, network, addr)
+	if err != nil {
+		return nil, rpcError("creating listener", err)
+	}
+	return NewRPCServerWithListener(listener, logger)
+}
+
+func NewRPCServerWithListener(
+	listener net.Listener,
+	logger *slog.Logger,
+) (*RPCServer, error) {
+	return &RPCServer{
+		handlers: map[string]RPCHandler{},
+		listener: listener,
+		logger:   logger,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func (s *RPCServer) Addr() string { return s.listener.Addr().String() }
+
+func (s *RPCServer) Close() error {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	return s.listener.Close()
+}
+
+func (s *RPCServer) Register(method string, handler RPCHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = handler
+}
+
+func (s *RPCServer) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+			}
+			return rpcError("accepting connection", err)
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *RPCServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	writeMu := sync.Mutex{}
+	inFlight := make(chan struct{}, RPCMaxConcurrentRequests)
+	reader := bufio.NewReader(conn)
+	for {
+		req, err := ReadRPCMessage(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.logger.Error("reading rpc request", slog.String("error", err.Error()))
+			}
+			return
+		}
+
+		// Stop reading once too many requests are in flight, so a peer
+		// cannot make us spawn goroutines without bound.
+		inFlight <- struct{}{}
+		go func() {
+			defer func() { <-inFlight }()
+			resp := s.dispatch(ctx, req)
+
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			if writeErr := WriteRPCMessage(conn, resp); writeErr != nil {
+				s.logger.Error("writing rpc response", slog.String("error", writeErr.Error()))
+			}
+		}()
+	}
+}
+
+func (s *RPCServer) dispatch(ctx context.Context, req *RPCMessage) (resp *RPCMessage) {
+	resp = &RPCMessage{ID: req.ID}
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error(
+				"rpc handler panicked",
+				slog.String("method", req.Method),
+				slog.Any("panic", r),
+			)
+			resp = &RPCMessage{ID: req.ID, Error: "internal error"}
+		}
+	}()
+
+	s.mu.RLock()
+	handler, ok := s.handlers[req.Method]
+	s.mu.RUnlock()
+	if !ok {
+		resp.Error = "unknown method: " + req.Method
+		return resp
+	}
+
+	if req.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.Timeout))
+		defer cancel()
+	}
+
+	result, err := handler(ctx, req.Params)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	resp.Result = result
+	return resp
+}
+
+type RPCClient struct {
+	mu       sync.Mutex
+	writeSem chan struct{}
+	conn     net.Conn
+	nextID   uint64
+	pending  map[uint64]chan *RPCMessage
+	err      error
+	done     chan struct{}
+}
+
+func NewRPCClient(
+	ctx context.Context,
+	platform Platform,
+	network string,
+	addr string,
+) (*RPCClient, error) {
+	dialContext, err := NewDialContext(platform)
+	if err != nil {
+		return nil, rpcError("creating dialer", err)
+	}
+
+	conn, err := dialContext(ctx, network, addr)
+	if err != nil {
+		msg := fmt.Sprintf("dialing '%s'", addr)
+		return nil, rpcError(msg, err)
+	}
+	return NewRPCClientWithConn(conn)
+}
+
+func NewRPCClientWithConn(conn net.Conn) (*RPCClient, error) {
+	client := &RPCClient{
+		writeSem: make(chan struct{}, 1),
+		conn:     conn,
+		pending:  map[uint64]chan *RPCMessage{},
+		done:     make(chan struct{}),
+	}
+	go client.readLoop()
+	return client, nil
+}
+
+func (c *RPCClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *RPCClient) Call(
+	ctx context.Context,
+	method string,
+	params []byte,
+) ([]byte, error) {
+	respChan := make(chan *RPCMessage, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return nil, rpcError("connection closed", err)
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = respChan
+
+	c.mu.Unlock()
+
+	req := &RPCMessage{ID: id, Method: method, Params: params}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Timeout = max(int64(time.Until(deadline)), 1)
+	}
+
+	if err := c.write(ctx, req); err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, rpcError("writing request", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, rpcError("deadline exceeded or context cancelled", ctx.Err())
+	case <-c.done:
+		return nil, rpcError("connection closed", c.err)
+	case resp := <-respChan:
+		if resp.Error != "" {
+			return nil, rpcError(resp.Error, nil)
+		}
+		return resp.Result, nil
+	}
+}
+
+// write serializes writes, so concurrent calls never interleave frames on the
+// connection, without holding the lock readLoop needs. Cancelling ctx
+// interrupts a stalled write, which closes the connection, since a partial
+// frame leaves it unusable.
+func (c *RPCClient) write(ctx context.Context, req *RPCMessage) error {
+	select {
+	case c.writeSem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return c.err
+	}
+	defer func() { <-c.writeSem }()
+
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		_ = c.conn.SetWriteDeadline(time.Now())
+		close(interrupted)
+	})
+	err := WriteRPCMessage(c.conn, req)
+	if !stop() {
+		<-interrupted
+		_ = c.conn.SetWriteDeadline(time.Time{})
+	}
+	if err != nil {
+		c.conn.Close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *RPCClient) readLoop() {
+	reader := bufio.NewReader(c.conn)
+	for {
+		resp, err := ReadRPCMessage(reader)
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.pending = map[uint64]chan *RPCMessage{}
+			c.mu.Unlock()
+			close(c.done)
+			return
+		}
+
+		c.mu.Lock()
+		respChan, ok := c.pending[resp.ID]
+		delete(c.pending, resp.ID)
+		c.mu.Unlock()
+		if ok {
+			respChan <- resp
+		}
+	}
+}
+
+func ReadRPCMessage(r io.Reader) (*RPCMessage, error) {
+	data, err := readFrame(r, RPCMaxMessageSize)
+	if err != nil {
+		return nil, rpcError("reading message", err)
+	}
+
+	msg := &RPCMessage{}
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, rpcError("unmarshaling message", err)
+	}
+	return msg, nil
+}
+
+func WriteRPCMessage(w io.Writer, msg *RPCMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return rpcError("marshaling message", err)
+	}
+	if err := writeFrame(w, data, RPCMaxMessageSize); err != nil {
+		return rpcError("writing message", err)
+	}
+	return nil
+}
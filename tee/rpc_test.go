@@ -0,0 +1,195 @@
+package tee_test
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func newTestRPCServer(t *testing.T) *tee.RPCServer {
+	t.Helper()
+	ctx := context.Background()
+	logger := slog.New(slog.DiscardHandler)
+	server, err := tee.NewRPCServer(ctx, tee.NoTEE, "tcp", "127.0.0.1:0", logger)
+	require.NoError(t, err)
+	return server
+}
+
+func TestRPC(t *testing.T) {
+	ctx := context.Background()
+	platform := tee.NoTEE
+	network := "tcp"
+
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		want := []byte("pong")
+		server := newTestRPCServer(t)
+		defer server.Close()
+		server.Register("ping", func(context.Context, []byte) ([]byte, error) {
+			return want, nil
+		})
+		runService(func() { _ = server.Serve() }, 10*time.Millisecond)
+
+		client, err := tee.NewRPCClient(ctx, platform, network, server.Addr())
+		require.NoError(t, err)
+		defer client.Close()
+
+		// when
+		got, err := client.Call(ctx, "ping", nil)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("happy path - concurrent calls", func(t *testing.T) {
+		// given
+		numCalls := 16
+		server := newTestRPCServer(t)
+		defer server.Close()
+		server.Register("echo", func(_ context.Context, params []byte) ([]byte, error) {
+			return params, nil
+		})
+		runService(func() { _ = server.Serve() }, 10*time.Millisecond)
+
+		client, err := tee.NewRPCClient(ctx, platform, network, server.Addr())
+		require.NoError(t, err)
+		defer client.Close()
+
+		// when
+		var wg sync.WaitGroup
+		for i := range numCalls {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				want := []byte{byte(i)}
+				got, callErr := client.Call(ctx, "echo", want)
+
+				// then
+				assert.NoError(t, callErr)
+				assert.Equal(t, want, got)
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("error - unknown method", func(t *testing.T) {
+		// given
+		server := newTestRPCServer(t)
+		defer server.Close()
+		runService(func() { _ = server.Serve() }, 10*time.Millisecond)
+
+		client, err := tee.NewRPCClient(ctx, platform, network, server.Addr())
+		require.NoError(t, err)
+		defer client.Close()
+
+		// when
+		_, err = client.Call(ctx, "missing", nil)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrRPC)
+		assert.ErrorContains(t, err, "unknown method")
+	})
+
+	t.Run("error - handler error", func(t *testing.T) {
+		// given
+		server := newTestRPCServer(t)
+		defer server.Close()
+		server.Register("fail", func(context.Context, []byte) ([]byte, error) {
+			return nil, assert.AnError
+		})
+		runService(func() { _ = server.Serve() }, 10*time.Millisecond)
+
+		client, err := tee.NewRPCClient(ctx, platform, network, server.Addr())
+		require.NoError(t, err)
+		defer client.Close()
+
+		// when
+		_, err = client.Call(ctx, "fail", nil)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrRPC)
+		assert.ErrorContains(t, err, assert.AnError.Error())
+	})
+
+	t.Run("error - deadline propagated to handler", func(t *testing.T) {
+		// given
+		timeout := 20 * time.Millisecond
+		server := newTestRPCServer(t)
+		defer server.Close()
+
+		handlerErr := make(chan error, 1)
+		server.Register("slow", func(ctx context.Context, _ []byte) ([]byte, error) {
+			<-ctx.Done()
+			handlerErr <- ctx.Err()
+			return nil, ctx.Err()
+		})
+		runService(func() { _ = server.Serve() }, 10*time.Millisecond)
+
+		client, err := tee.NewRPCClient(ctx, platform, network, server.Addr())
+		require.NoError(t, err)
+		defer client.Close()
+
+		callCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		// when
+		_, err = client.Call(callCtx, "slow", nil)
+
+		// then
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.ErrorIs(t, <-handlerErr, context.DeadlineExceeded)
+	})
+
+	t.Run("error - handler panic", func(t *testing.T) {
+		// given
+		server := newTestRPCServer(t)
+		defer server.Close()
+		server.Register("panic", func(context.Context, []byte) ([]byte, error) {
+			panic("boom")
+		})
+		server.Register("ping", func(context.Context, []byte) ([]byte, error) {
+			return []byte("pong"), nil
+		})
+		runService(func() { _ = server.Serve() }, 10*time.Millisecond)
+
+		client, err := tee.NewRPCClient(ctx, platform, network, server.Addr())
+		require.NoError(t, err)
+		defer client.Close()
+
+		// when
+		_, err = client.Call(ctx, "panic", nil)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrRPC)
+		assert.ErrorContains(t, err, "internal error")
+		got, err := client.Call(ctx, "ping", nil)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("pong"), got)
+	})
+
+	t.Run("error - stalled write honors context", func(t *testing.T) {
+		// given
+		clientConn, serverConn := net.Pipe()
+		defer serverConn.Close()
+		client, err := tee.NewRPCClientWithConn(clientConn)
+		require.NoError(t, err)
+		defer client.Close()
+
+		callCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+
+		// when
+		_, err = client.Call(callCtx, "ping", nil)
+
+		// then
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
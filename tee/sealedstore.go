@@ -0,0 +1,440 @@
+package tee
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	SealedStoreLabel       = "bearclave sealed store v1"
+	SealedStoreHeaderSize  = 9
+	SealedStoreFlagDeleted = byte(1)
+	SealedStoreGetMethod   = "sealedstore.get"
+	SealedStorePutMethod   = "sealedstore.put"
+	SealedStoreFileSuffix  = ".sealed"
+	DirCounterFileSuffix   = ".counter"
+	DirCounterVersionSize  = 8
+	SealedStoreFileTmpExt  = ".tmp"
+	SealedStoreDirMode     = 0o700
+	SealedStoreFileMode    = 0o600
+)
+
+// SealedStoreBackend persists sealed records somewhere the host controls. It
+// is not trusted: SealedStore authenticates everything it reads back.
+type SealedStoreBackend interface {
+	// Get returns ErrSealedStoreNotFound if there is no record for name.
+	Get(ctx context.Context, name string) (record []byte, err error)
+	Put(ctx context.Context, name string, record []byte) error
+}
+
+// MonotonicCounter tracks the latest version of every record. It must live
+// somewhere the host cannot roll back, since it is what SealedStore checks
+// records against.
+type MonotonicCounter interface {
+	Load(ctx context.Context, name string) (version uint64, err error)
+	// Advance sets the version for name and fails if it would go down.
+	Advance(ctx context.Context, name string, version uint64) error
+}
+
+// SealedStore is a key-value store for enclave state kept on untrusted
+// storage. Each record is sealed together with its name and version, so the
+// host cannot swap records between names, and the version is checked against
+// a MonotonicCounter, so the host cannot replay an older record. Deleting a
+// name leaves a sealed tombstone behind for the same reason.
+type SealedStore struct {
+	mu      sync.Mutex
+	sealer  Sealer
+	backend SealedStoreBackend
+	counter MonotonicCounter
+}
+
+// NewSealedStore refuses a MemoryCounter, which cannot detect rollback
+// across restarts, unless WithSealedStoreMemoryCounter says that is enough.
+func NewSealedStore(
+	sealer Sealer,
+	backend SealedStoreBackend,
+	counter MonotonicCounter,
+	options ...SealedStoreOption,
+) (*SealedStore, error) {
+	opts := MakeDefaultSealedStoreOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	if sealer == nil || backend == nil || counter == nil {
+		return nil, sealedStoreError("sealer, backend, and counter are required", nil)
+	}
+	if _, ok := counter.(*MemoryCounter); ok && !opts.AllowMemoryCounter {
+		return nil, sealedStoreError("memory counter resets on restart", nil)
+	}
+	return &SealedStore{sealer: sealer, backend: backend, counter: counter}, nil
+}
+
+type SealedStoreOption func(*SealedStoreOptions)
+type SealedStoreOptions struct {
+	AllowMemoryCounter bool
+}
+
+func MakeDefaultSealedStoreOptions() SealedStoreOptions {
+	return SealedStoreOptions{
+		AllowMemoryCounter: false,
+	}
+}
+
+// WithSealedStoreMemoryCounter allows a MemoryCounter, accepting that the
+// host can roll records back to any earlier version by restarting the
+// enclave.
+func WithSealedStoreMemoryCounter() SealedStoreOption {
+	return func(opts *SealedStoreOptions) {
+		opts.AllowMemoryCounter = true
+	}
+}
+
+func (s *SealedStore) Get(ctx context.Context, name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, deleted, _, err := s.load(ctx, name)
+	switch {
+	case err != nil:
+		return nil, err
+	case deleted:
+		return nil, sealedStoreErrorNotFound(name, nil)
+	}
+	return value, nil
+}
+
+func (s *SealedStore) Put(ctx context.Context, name string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store(ctx, name, value, 0)
+}
+
+func (s *SealedStore) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store(ctx, name, nil, SealedStoreFlagDeleted)
+}
+
+// load returns the current record for name. A name that was never written
+// reads as deleted at version 0.
+func (s *SealedStore) load(
+	ctx context.Context,
+	name string,
+) ([]byte, bool, uint64, error) {
+	counter, err := s.counter.Load(ctx, name)
+	if err != nil {
+		return nil, false, 0, sealedStoreError("loading counter", err)
+	}
+
+	record, err := s.backend.Get(ctx, name)
+	switch {
+	case errors.Is(err, ErrSealedStoreNotFound) && counter == 0:
+		return nil, true, 0, nil
+	case errors.Is(err, ErrSealedStoreNotFound):
+		msg := fmt.Sprintf("record '%s' at version %d is missing", name, counter)
+		return nil, false, 0, sealedStoreErrorRollback(msg, nil)
+	case err != nil:
+		return nil, false, 0, sealedStoreError("reading record", err)
+	case len(record) < SealedStoreHeaderSize:
+		return nil, false, 0, sealedStoreError("record too short", nil)
+	}
+
+	header := record[:SealedStoreHeaderSize]
+	version := binary.BigEndian.Uint64(header)
+	value, err := s.sealer.Unseal(record[SealedStoreHeaderSize:], sealedStoreAD(name, header))
+	if err != nil {
+		return nil, false, 0, sealedStoreError("unsealing record", err)
+	}
+
+	if version < counter {
+		msg := fmt.Sprintf("record '%s' has version %d, expected %d", name, version, counter)
+		return nil, false, 0, sealedStoreErrorRollback(msg, nil)
+	}
+	// A newer record means a previous write reached the backend but not the
+	// counter. Only we can seal records, so it is safe to catch up.
+	if version > counter {
+		if err = s.counter.Advance(ctx, name, version); err != nil {
+			return nil, false, 0, sealedStoreError("advancing counter", err)
+		}
+	}
+	return value, header[8] == SealedStoreFlagDeleted, version, nil
+}
+
+func (s *SealedStore) store(
+	ctx context.Context,
+	name string,
+	value []byte,
+	flags byte,
+) error {
+	_, _, version, err := s.load(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, SealedStoreHeaderSize)
+	binary.BigEndian.PutUint64(header, version+1)
+	header[8] = flags
+	sealed, err := s.sealer.Seal(value, sealedStoreAD(name, header))
+	if err != nil {
+		return sealedStoreError("sealing record", err)
+	}
+
+	if err = s.backend.Put(ctx, name, append(header, sealed...)); err != nil {
+		return sealedStoreError("writing record", err)
+	}
+	if err = s.counter.Advance(ctx, name, version+1); err != nil {
+		return sealedStoreError("advancing counter", err)
+	}
+	return nil
+}
+
+func sealedStoreAD(name string, header []byte) []byte {
+	ad := make([]byte, 0, len(SealedStoreLabel)+len(name)+len(header)+2)
+	ad = append(ad, SealedStoreLabel...)
+	ad = append(ad, 0)
+	ad = append(ad, name...)
+	ad = append(ad, 0)
+	return append(ad, header...)
+}
+
+// MemoryCounter keeps versions in enclave memory. It detects rollback for as
+// long as the enclave runs, but starts over after a restart; use a
+// CounterServiceClient to detect rollback across restarts.
+// NewSealedStore only accepts it with WithSealedStoreMemoryCounter.
+type MemoryCounter struct {
+	mu       sync.Mutex
+	versions map[string]uint64
+}
+
+func NewMemoryCounter() *MemoryCounter {
+	return &MemoryCounter{versions: map[string]uint64{}}
+}
+
+func (m *MemoryCounter) Load(_ context.Context, name string) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.versions[name], nil
+}
+
+func (m *MemoryCounter) Advance(_ context.Context, name string, version uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if version < m.versions[name] {
+		msg := fmt.Sprintf("counter '%s' cannot go from %d to %d", name, m.versions[name], version)
+		return sealedStoreErrorRollback(msg, nil)
+	}
+	m.versions[name] = version
+	return nil
+}
+
+// DirCounter keeps each version in its own file in dir, so versions survive
+// restarts. It is only as trustworthy as dir, so it is meant to back a
+// CounterService on machines the enclave host does not control, not to be
+// used inside the enclave.
+type DirCounter struct {
+	mu  sync.Mutex
+	dir string
+}
+
+func NewDirCounter(dir string) (*DirCounter, error) {
+	if err := os.MkdirAll(dir, SealedStoreDirMode); err != nil {
+		return nil, sealedStoreError("creating directory", err)
+	}
+	return &DirCounter{dir: dir}, nil
+}
+
+func (d *DirCounter) Load(_ context.Context, name string) (uint64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.load(name)
+}
+
+func (d *DirCounter) Advance(_ context.Context, name string, version uint64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current, err := d.load(name)
+	if err != nil {
+		return err
+	}
+	if version < current {
+		msg := fmt.Sprintf("counter '%s' cannot go from %d to %d", name, current, version)
+		return sealedStoreErrorRollback(msg, nil)
+	}
+	data := binary.BigEndian.AppendUint64(nil, version)
+	return writeSealedStoreFile(d.dir, d.path(name), data)
+}
+
+func (d *DirCounter) load(name string) (uint64, error) {
+	data, err := os.ReadFile(d.path(name))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return 0, nil
+	case err != nil:
+		return 0, sealedStoreError("reading file", err)
+	case len(data) != DirCounterVersionSize:
+		return 0, sealedStoreError("malformed counter file", nil)
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+func (d *DirCounter) path(name string) string {
+	return filepath.Join(d.dir, sealedStoreRecordID(name)+DirCounterFileSuffix)
+}
+
+// DirStoreBackend keeps each record in its own file in dir. File names are
+// derived from a hash of the record name, so names cannot escape dir.
+type DirStoreBackend struct {
+	dir string
+}
+
+func NewDirStoreBackend(dir string) (*DirStoreBackend, error) {
+	if err := os.MkdirAll(dir, SealedStoreDirMode); err != nil {
+		return nil, sealedStoreError("creating directory", err)
+	}
+	return &DirStoreBackend{dir: dir}, nil
+}
+
+func (d *DirStoreBackend) Get(_ context.Context, name string) ([]byte, error) {
+	record, err := os.ReadFile(d.path(name))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, sealedStoreErrorNotFound(name, nil)
+	case err != nil:
+		return nil, sealedStoreError("reading file", err)
+	}
+	return record, nil
+}
+
+// Put writes the record to a temporary file and renames it into place, so a
+// crash leaves either the old or the new record.
+func (d *DirStoreBackend) Put(_ context.Context, name string, record []byte) error {
+	return writeSealedStoreFile(d.dir, d.path(name), record)
+}
+
+func (d *DirStoreBackend) path(name string) string {
+	return filepath.Join(d.dir, sealedStoreRecordID(name)+SealedStoreFileSuffix)
+}
+
+func writeSealedStoreFile(dir string, path string, data []byte) error {
+	file, err := os.CreateTemp(dir, filepath.Base(path)+"*"+SealedStoreFileTmpExt)
+	if err != nil {
+		return sealedStoreError("creating file", err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Chmod(SealedStoreFileMode)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return sealedStoreError("writing file", err)
+	}
+
+	if err = os.Rename(file.Name(), path); err != nil {
+		return sealedStoreError("renaming file", err)
+	}
+	return nil
+}
+
+// sealedStoreRecordID is the hex-encoded SHA-256 hash of name, which is all
+// backends get to see of it.
+func sealedStoreRecordID(name string) string {
+	hash := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(hash[:])
+}
+
+type sealedStoreRPCParams struct {
+	Name   string `json:"name"`
+	Record []byte `json:"record,omitempty"`
+}
+
+type sealedStoreRPCResult struct {
+	Found  bool   `json:"found"`
+	Record []byte `json:"record,omitempty"`
+}
+
+// RPCStoreBackend keeps records on the host, reached over the enclave-host
+// socket. The host serves them with RegisterSealedStoreBackend. Records are
+// sent under a hash of their name (see DirStoreBackend), so the host does not
+// learn the names.
+type RPCStoreBackend struct {
+	client *RPCClient
+}
+
+func NewRPCStoreBackend(client *RPCClient) *RPCStoreBackend {
+	return &RPCStoreBackend{client: client}
+}
+
+func (r *RPCStoreBackend) Get(ctx context.Context, name string) ([]byte, error) {
+	params, err := json.Marshal(sealedStoreRPCParams{Name: sealedStoreRecordID(name)})
+	if err != nil {
+		return nil, sealedStoreError("marshaling params", err)
+	}
+	data, err := r.client.Call(ctx, SealedStoreGetMethod, params)
+	if err != nil {
+		return nil, sealedStoreError("calling host", err)
+	}
+
+	result := sealedStoreRPCResult{}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, sealedStoreError("unmarshaling result", err)
+	}
+	if !result.Found {
+		return nil, sealedStoreErrorNotFound(name, nil)
+	}
+	return result.Record, nil
+}
+
+func (r *RPCStoreBackend) Put(ctx context.Context, name string, record []byte) error {
+	params, err := json.Marshal(sealedStoreRPCParams{Name: sealedStoreRecordID(name), Record: record})
+	if err != nil {
+		return sealedStoreError("marshaling params", err)
+	}
+	if _, err = r.client.Call(ctx, SealedStorePutMethod, params); err != nil {
+		return sealedStoreError("calling host", err)
+	}
+	return nil
+}
+
+// RegisterSealedStoreBackend serves backend to RPCStoreBackend clients.
+func RegisterSealedStoreBackend(server *RPCServer, backend SealedStoreBackend) {
+	server.Register(SealedStoreGetMethod, func(ctx context.Context, data []byte) ([]byte, error) {
+		params := sealedStoreRPCParams{}
+		if err := json.Unmarshal(data, &params); err != nil {
+			return nil, sealedStoreError("unmarshaling params", err)
+		}
+
+		record, err := backend.Get(ctx, params.Name)
+		switch {
+		case errors.Is(err, ErrSealedStoreNotFound):
+			return json.Marshal(sealedStoreRPCResult{Found: false})
+		case err != nil:
+			return nil, err
+		}
+		return json.Marshal(sealedStoreRPCResult{Found: true, Record: record})
+	})
+	server.Register(SealedStorePutMethod, func(ctx context.Context, data []byte) ([]byte, error) {
+		params := sealedStoreRPCParams{}
+		if err := json.Unmarshal(data, &params); err != nil {
+			return nil, sealedStoreError("unmarshaling params", err)
+		}
+		return nil, backend.Put(ctx, params.Name, params.Record)
+	})
+}
@@ -0,0 +1,281 @@
+package tee_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func newTestSealedStore(
+	t *testing.T,
+	dir string,
+	counter tee.MonotonicCounter,
+) *tee.SealedStore {
+	t.Helper()
+	sealer, err := tee.NewAEADSealer(bytes.Repeat([]byte{0x07}, tee.SealKeySize))
+	require.NoError(t, err)
+	backend, err := tee.NewDirStoreBackend(dir)
+	require.NoError(t, err)
+	store, err := tee.NewSealedStore(sealer, backend, counter, tee.WithSealedStoreMemoryCounter())
+	require.NoError(t, err)
+	return store
+}
+
+func readTestSealedRecord(t *testing.T, dir string) (string, []byte) {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+tee.SealedStoreFileSuffix))
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	record, err := os.ReadFile(paths[0])
+	require.NoError(t, err)
+	return paths[0], record
+}
+
+func TestSealedStore_Interfaces(t *testing.T) {
+	t.Run("SealedStoreBackend", func(_ *testing.T) {
+		var _ tee.SealedStoreBackend = &tee.DirStoreBackend{}
+		var _ tee.SealedStoreBackend = &tee.RPCStoreBackend{}
+	})
+
+	t.Run("MonotonicCounter", func(_ *testing.T) {
+		var _ tee.MonotonicCounter = &tee.MemoryCounter{}
+		var _ tee.MonotonicCounter = &tee.DirCounter{}
+	})
+}
+
+func TestSealedStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("happy path - put, overwrite, and delete", func(t *testing.T) {
+		// given
+		store := newTestSealedStore(t, t.TempDir(), tee.NewMemoryCounter())
+		require.NoError(t, store.Put(ctx, "config", []byte("v1")))
+		require.NoError(t, store.Put(ctx, "config", []byte("v2")))
+
+		// when
+		got, err := store.Get(ctx, "config")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v2"), got)
+
+		require.NoError(t, store.Delete(ctx, "config"))
+		_, err = store.Get(ctx, "config")
+		require.ErrorIs(t, err, tee.ErrSealedStoreNotFound)
+	})
+
+	t.Run("happy path - restart catches up counter", func(t *testing.T) {
+		// given
+		dir := t.TempDir()
+		store := newTestSealedStore(t, dir, tee.NewMemoryCounter())
+		require.NoError(t, store.Put(ctx, "config", []byte("v1")))
+		require.NoError(t, store.Put(ctx, "config", []byte("v2")))
+
+		// when
+		counter := tee.NewMemoryCounter()
+		restarted := newTestSealedStore(t, dir, counter)
+		got, err := restarted.Get(ctx, "config")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v2"), got)
+		version, err := counter.Load(ctx, "config")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), version)
+	})
+
+	t.Run("error - never written", func(t *testing.T) {
+		// given
+		store := newTestSealedStore(t, t.TempDir(), tee.NewMemoryCounter())
+
+		// when
+		_, err := store.Get(ctx, "config")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrSealedStoreNotFound)
+	})
+
+	t.Run("error - host replays old record", func(t *testing.T) {
+		// given
+		dir := t.TempDir()
+		store := newTestSealedStore(t, dir, tee.NewMemoryCounter())
+		require.NoError(t, store.Put(ctx, "config", []byte("v1")))
+		path, oldRecord := readTestSealedRecord(t, dir)
+		require.NoError(t, store.Put(ctx, "config", []byte("v2")))
+		require.NoError(t, os.WriteFile(path, oldRecord, 0o600))
+
+		// when
+		_, err := store.Get(ctx, "config")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrSealedStoreRollback)
+	})
+
+	t.Run("error - host restores deleted record", func(t *testing.T) {
+		// given
+		dir := t.TempDir()
+		store := newTestSealedStore(t, dir, tee.NewMemoryCounter())
+		require.NoError(t, store.Put(ctx, "config", []byte("v1")))
+		path, oldRecord := readTestSealedRecord(t, dir)
+		require.NoError(t, store.Delete(ctx, "config"))
+		require.NoError(t, os.WriteFile(path, oldRecord, 0o600))
+
+		// when
+		_, err := store.Get(ctx, "config")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrSealedStoreRollback)
+	})
+
+	t.Run("error - host hides record", func(t *testing.T) {
+		// given
+		dir := t.TempDir()
+		store := newTestSealedStore(t, dir, tee.NewMemoryCounter())
+		require.NoError(t, store.Put(ctx, "config", []byte("v1")))
+		path, _ := readTestSealedRecord(t, dir)
+		require.NoError(t, os.Remove(path))
+
+		// when
+		_, err := store.Get(ctx, "config")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrSealedStoreRollback)
+	})
+
+	t.Run("error - host swaps records between names", func(t *testing.T) {
+		// given
+		dir := t.TempDir()
+		store := newTestSealedStore(t, dir, tee.NewMemoryCounter())
+		require.NoError(t, store.Put(ctx, "a", []byte("a")))
+		pathA, recordA := readTestSealedRecord(t, dir)
+		require.NoError(t, os.Rename(pathA, pathA+".bak"))
+		require.NoError(t, store.Put(ctx, "b", []byte("b")))
+		pathB, _ := readTestSealedRecord(t, dir)
+		require.NoError(t, os.WriteFile(pathB, recordA, 0o600))
+
+		// when
+		_, err := store.Get(ctx, "b")
+
+		// then
+		require.ErrorIs(t, err, tee.ErrSealedStore)
+		assert.ErrorContains(t, err, "unsealing record")
+	})
+}
+
+func TestNewSealedStore(t *testing.T) {
+	t.Run("error - memory counter without opt in", func(t *testing.T) {
+		// given
+		sealer, err := tee.NewAEADSealer(bytes.Repeat([]byte{0x07}, tee.SealKeySize))
+		require.NoError(t, err)
+		backend, err := tee.NewDirStoreBackend(t.TempDir())
+		require.NoError(t, err)
+
+		// when
+		_, err = tee.NewSealedStore(sealer, backend, tee.NewMemoryCounter())
+
+		// then
+		require.ErrorIs(t, err, tee.ErrSealedStore)
+		assert.ErrorContains(t, err, "resets on restart")
+	})
+}
+
+func TestRPCStoreBackend(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("happy path - records kept on host under hashed names", func(t *testing.T) {
+		// given
+		hostDir := t.TempDir()
+		hostBackend, err := tee.NewDirStoreBackend(hostDir)
+		require.NoError(t, err)
+		server := newTestRPCServer(t)
+		defer server.Close()
+		tee.RegisterSealedStoreBackend(server, hostBackend)
+		runService(func() { _ = server.Serve() }, 10*time.Millisecond)
+
+		client, err := tee.NewRPCClient(ctx, tee.NoTEE, "tcp", server.Addr())
+		require.NoError(t, err)
+		defer client.Close()
+
+		sealer, err := tee.NewAEADSealer(bytes.Repeat([]byte{0x07}, tee.SealKeySize))
+		require.NoError(t, err)
+		store, err := tee.NewSealedStore(
+			sealer,
+			tee.NewRPCStoreBackend(client),
+			tee.NewMemoryCounter(),
+			tee.WithSealedStoreMemoryCounter(),
+		)
+		require.NoError(t, err)
+
+		// when
+		_, notFoundErr := store.Get(ctx, "config")
+		putErr := store.Put(ctx, "config", []byte("secret"))
+		got, err := store.Get(ctx, "config")
+
+		// then
+		require.ErrorIs(t, notFoundErr, tee.ErrSealedStoreNotFound)
+		require.NoError(t, putErr)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("secret"), got)
+
+		_, record := readTestSealedRecord(t, hostDir)
+		assert.NotContains(t, string(record), "secret")
+		_, err = hostBackend.Get(ctx, "config")
+		require.ErrorIs(t, err, tee.ErrSealedStoreNotFound)
+	})
+}
+
+func TestMemoryCounter_Advance(t *testing.T) {
+	t.Run("error - going backwards", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		counter := tee.NewMemoryCounter()
+		require.NoError(t, counter.Advance(ctx, "config", 2))
+
+		// when
+		err := counter.Advance(ctx, "config", 1)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrSealedStoreRollback)
+	})
+}
+
+func TestDirCounter_Advance(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("happy path - survives restarts", func(t *testing.T) {
+		// given
+		dir := t.TempDir()
+		counter, err := tee.NewDirCounter(dir)
+		require.NoError(t, err)
+		require.NoError(t, counter.Advance(ctx, "config", 2))
+
+		// when
+		restarted, err := tee.NewDirCounter(dir)
+		require.NoError(t, err)
+		got, err := restarted.Load(ctx, "config")
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), got)
+	})
+
+	t.Run("error - going backwards", func(t *testing.T) {
+		// given
+		counter, err := tee.NewDirCounter(t.TempDir())
+		require.NoError(t, err)
+		require.NoError(t, counter.Advance(ctx, "config", 2))
+
+		// when
+		err = counter.Advance(ctx, "config", 1)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrSealedStoreRollback)
+	})
+}
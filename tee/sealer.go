@@ -0,0 +1,200 @@
+package tee
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/tahardi/bearclave"
+)
+
+const (
+	DefaultSealKeyPath = "bearclave/seal.key"
+	SealKeySize        = 32
+	SealLabel          = "bearclave seal v1"
+)
+
+// Sealer encrypts data so that only the same enclave can decrypt it later,
+// e.g., after a restart. What "the same enclave" means depends on the
+// platform: on SEV-SNP the key is derived from the launch measurement and
+// guest policy by default.
+type Sealer interface {
+	Seal(plaintext []byte, additionalData []byte) (ciphertext []byte, err error)
+	Unseal(ciphertext []byte, additionalData []byte) (plaintext []byte, err error)
+}
+
+// NewSealer returns the Sealer for platform. Nitro Enclaves cannot derive
+// persistent keys and TDX guests have no sealing key, so both need an
+// external KMS instead.
+func NewSealer(platform Platform, options ...SealerOption) (Sealer, error) {
+	opts := MakeDefaultSealerOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	switch platform {
+	case SEV:
+		client, err := bearclave.NewSEVClient()
+		if err != nil {
+			return nil, sealerError("making sev client", err)
+		}
+		defer client.Close()
+		return NewSEVSealer(client, opts.SEVDerivedKey...)
+	case NoTEE, NitroSim:
+		keyPath, err := sealKeyPath(opts.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		return NewFileSealer(keyPath)
+	default:
+		return nil, unsupportedPlatformError(string(platform), nil)
+	}
+}
+
+// AEADSealer seals with AES-256-GCM under a key expanded from a root sealing
+// key. Sealed data is the random nonce followed by the ciphertext.
+type AEADSealer struct {
+	aead cipher.AEAD
+}
+
+func NewAEADSealer(rootKey []byte) (*AEADSealer, error) {
+	if len(rootKey) < SealKeySize {
+		msg := fmt.Sprintf("root key must be at least %d bytes", SealKeySize)
+		return nil, sealerError(msg, nil)
+	}
+
+	key, err := hkdf.Key(sha256.New, rootKey, nil, SealLabel, SealKeySize)
+	if err != nil {
+		return nil, sealerError("expanding root key", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, sealerError("creating cipher", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, sealerError("creating aead", err)
+	}
+	return &AEADSealer{aead: aead}, nil
+}
+
+// NewSEVSealer seals with a key derived by the AMD Secure Processor (see
+// bearclave.SEV GetDerivedKey). Data sealed by one guest can be unsealed by
+// any guest on the same chip with the same values for the selected fields.
+func NewSEVSealer(
+	client bearclave.SEV,
+	options ...bearclave.SEVDerivedKeyOption,
+) (*AEADSealer, error) {
+	rootKey, err := client.GetDerivedKey(options...)
+	if err != nil {
+		return nil, sealerError("getting derived key", err)
+	}
+	return NewAEADSealer(rootKey)
+}
+
+// NewFileSealer seals with a root key stored in the file at path, creating
+// the file if it does not exist. It offers no protection against the host
+// and is meant for NoTEE development.
+func NewFileSealer(path string) (*AEADSealer, error) {
+	rootKey, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		rootKey, err = createSealKeyFile(path)
+	}
+	if err != nil {
+		return nil, sealerError("loading key file", err)
+	}
+	return NewAEADSealer(rootKey)
+}
+
+// sealKeyPath resolves an empty path to DefaultSealKeyPath under the user
+// config directory, so the key does not depend on the working directory.
+func sealKeyPath(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", sealerError("resolving default key path", err)
+	}
+	return filepath.Join(configDir, DefaultSealKeyPath), nil
+}
+
+func createSealKeyFile(path string) ([]byte, error) {
+	rootKey := make([]byte, SealKeySize)
+	if _, err := crand.Read(rootKey); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	// O_EXCL ensures two sealers racing to create the key agree on it.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		return os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = file.Write(rootKey); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return rootKey, file.Close()
+}
+
+func (a *AEADSealer) Seal(plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, a.aead.NonceSize(), a.aead.NonceSize()+len(plaintext)+a.aead.Overhead())
+	if _, err := crand.Read(nonce); err != nil {
+		return nil, sealerError("generating nonce", err)
+	}
+	return a.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (a *AEADSealer) Unseal(ciphertext []byte, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < a.aead.NonceSize()+a.aead.Overhead() {
+		return nil, sealerError("ciphertext too short", nil)
+	}
+	nonce, sealed := ciphertext[:a.aead.NonceSize()], ciphertext[a.aead.NonceSize():]
+	plaintext, err := a.aead.Open(nil, nonce, sealed, additionalData)
+	if err != nil {
+		return nil, sealerError("opening ciphertext", err)
+	}
+	return plaintext, nil
+}
+
+type SealerOption func(*SealerOptions)
+type SealerOptions struct {
+	KeyPath       string
+	SEVDerivedKey []bearclave.SEVDerivedKeyOption
+}
+
+func MakeDefaultSealerOptions() SealerOptions {
+	return SealerOptions{
+		KeyPath:       "",
+		SEVDerivedKey: nil,
+	}
+}
+
+// WithSealerKeyPath sets the key file used on NoTEE and NitroSim. By default
+// the key is kept at DefaultSealKeyPath under os.UserConfigDir.
+func WithSealerKeyPath(path string) SealerOption {
+	return func(opts *SealerOptions) {
+		opts.KeyPath = path
+	}
+}
+
+// WithSealerSEVDerivedKey sets the options used to derive the sealing key on
+// SEV, e.g., to also bind it to the TCB version.
+func WithSealerSEVDerivedKey(options ...bearclave.SEVDerivedKeyOption) SealerOption {
+	return func(opts *SealerOptions) {
+		opts.SEVDerivedKey = append(opts.SEVDerivedKey, options...)
+	}
+}
@@ -0,0 +1,141 @@
+package tee_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/mocks"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func TestAEADSealer_Interfaces(t *testing.T) {
+	t.Run("Sealer", func(_ *testing.T) {
+		var _ tee.Sealer = &tee.AEADSealer{}
+	})
+}
+
+func TestNewSealer(t *testing.T) {
+	t.Run("happy path - notee key file survives restarts", func(t *testing.T) {
+		// given
+		keyPath := filepath.Join(t.TempDir(), "keys", "seal.key")
+		sealer, err := tee.NewSealer(tee.NoTEE, tee.WithSealerKeyPath(keyPath))
+		require.NoError(t, err)
+		sealed, err := sealer.Seal([]byte("secret"), []byte("v1"))
+		require.NoError(t, err)
+
+		// when
+		restarted, err := tee.NewSealer(tee.NoTEE, tee.WithSealerKeyPath(keyPath))
+		require.NoError(t, err)
+		got, err := restarted.Unseal(sealed, []byte("v1"))
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, []byte("secret"), got)
+
+		info, err := os.Stat(keyPath)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	})
+
+	t.Run("happy path - notee default key path is under the config dir", func(t *testing.T) {
+		// given
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+		configDir, err := os.UserConfigDir()
+		require.NoError(t, err)
+
+		// when
+		_, err = tee.NewSealer(tee.NoTEE)
+
+		// then
+		require.NoError(t, err)
+		_, err = os.Stat(filepath.Join(configDir, tee.DefaultSealKeyPath))
+		require.NoError(t, err)
+	})
+
+	t.Run("error - unsupported platform", func(t *testing.T) {
+		// when
+		_, err := tee.NewSealer(tee.Nitro)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrUnsupportedPlatform)
+	})
+}
+
+func TestAEADSealer_Unseal(t *testing.T) {
+	rootKey := bytes.Repeat([]byte{0x01}, tee.SealKeySize)
+	sealer, err := tee.NewAEADSealer(rootKey)
+	require.NoError(t, err)
+	sealed, err := sealer.Seal([]byte("secret"), []byte("v1"))
+	require.NoError(t, err)
+
+	t.Run("error - wrong additional data", func(t *testing.T) {
+		// when
+		_, err := sealer.Unseal(sealed, []byte("v2"))
+
+		// then
+		require.ErrorIs(t, err, tee.ErrSealer)
+	})
+
+	t.Run("error - different key", func(t *testing.T) {
+		// given
+		other, err := tee.NewAEADSealer(bytes.Repeat([]byte{0x02}, tee.SealKeySize))
+		require.NoError(t, err)
+
+		// when
+		_, err = other.Unseal(sealed, []byte("v1"))
+
+		// then
+		require.ErrorIs(t, err, tee.ErrSealer)
+	})
+
+	t.Run("error - truncated", func(t *testing.T) {
+		// when
+		_, err := sealer.Unseal(sealed[:8], []byte("v1"))
+
+		// then
+		require.ErrorIs(t, err, tee.ErrSealer)
+	})
+}
+
+func TestNewSEVSealer(t *testing.T) {
+	t.Run("happy path - same derived key unseals", func(t *testing.T) {
+		// given
+		derivedKey := bytes.Repeat([]byte{0x42}, tee.SealKeySize)
+		client := mocks.NewSEV(t)
+		client.On("GetDerivedKey", mock.Anything).Return(derivedKey, nil).Twice()
+
+		sealer, err := tee.NewSEVSealer(client)
+		require.NoError(t, err)
+		sealed, err := sealer.Seal([]byte("secret"), nil)
+		require.NoError(t, err)
+
+		// when
+		restarted, err := tee.NewSEVSealer(client)
+		require.NoError(t, err)
+		got, err := restarted.Unseal(sealed, nil)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, []byte("secret"), got)
+	})
+
+	t.Run("error - deriving key", func(t *testing.T) {
+		// given
+		client := mocks.NewSEV(t)
+		client.On("GetDerivedKey", mock.Anything).Return(nil, assert.AnError)
+
+		// when
+		_, err := tee.NewSEVSealer(client)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrSealer)
+		require.ErrorIs(t, err, assert.AnError)
+	})
+}
@@ -0,0 +1,349 @@
+package tee
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	SecureConnKeySize          = 32
+	SecureConnNonceSize        = 32
+	SecureConnMaxRecordSize    = 16 * KiloByte
+	SecureConnMaxHandshakeSize = 64 * KiloByte
+	SecureConnInfoClientWrite  = "bearclave secure conn client write"
+	SecureConnInfoServerWrite  = "bearclave secure conn server write"
+	DefaultSecureConnTimeout   = 10 * time.Second
+)
+
+type SecureConnClientHello struct {
+	PublicKey []byte `json:"public_key"`
+	Nonce     []byte `json:"nonce"`
+}
+
+type SecureConnServerHello struct {
+	PublicKey   []byte        `json:"public_key"`
+	Attestation *AttestResult `json:"attestation"`
+}
+
+// SecureConn is an AEAD-protected net.Conn established by an attested key
+// exchange. The server (enclave) side binds its ephemeral X25519 public key,
+// the client's public key, and the client's nonce into an attestation report.
+// The client verifies the report before deriving the session keys, so a
+// host sitting between the two can neither read nor modify the traffic.
+type SecureConn struct {
+	conn net.Conn
+
+	readMu   sync.Mutex
+	readAEAD cipher.AEAD
+	readSeq  uint64
+	readBuf  []byte
+
+	writeMu   sync.Mutex
+	writeAEAD cipher.AEAD
+	writeSeq  uint64
+
+	peerAttestation *AttestResult
+}
+
+func NewSecureServerConn(
+	conn net.Conn,
+	attester *Attester,
+) (*SecureConn, error) {
+	privateKey, err := ecdh.X25519().GenerateKey(crand.Reader)
+	if err != nil {
+		return nil, secureConnError("generating ephemeral key", err)
+	}
+
+	helloBytes, err := readFrame(conn, SecureConnMaxHandshakeSize)
+	if err != nil {
+		return nil, secureConnError("reading client hello", err)
+	}
+	clientHello := SecureConnClientHello{}
+	err = json.Unmarshal(helloBytes, &clientHello)
+	if err != nil {
+		return nil, secureConnError("unmarshaling client hello", err)
+	}
+
+	clientPublicKey, err := ecdh.X25519().NewPublicKey(clientHello.PublicKey)
+	if err != nil {
+		return nil, secureConnError("parsing client public key", err)
+	}
+
+	serverPublicKey := privateKey.PublicKey().Bytes()
+	transcript := SecureConnTranscript(
+		clientHello.PublicKey,
+		serverPublicKey,
+		clientHello.Nonce,
+	)
+	attestation, err := attester.Attest(WithAttestUserData(transcript))
+	if err != nil {
+		return nil, secureConnError("attesting handshake", err)
+	}
+
+	serverHello := SecureConnServerHello{
+		PublicKey:   serverPublicKey,
+		Attestation: attestation,
+	}
+	helloBytes, err = json.Marshal(serverHello)
+	if err != nil {
+		return nil, secureConnError("marshaling server hello", err)
+	}
+	err = writeFrame(conn, helloBytes, SecureConnMaxHandshakeSize)
+	if err != nil {
+		return nil, secureConnError("writing server hello", err)
+	}
+
+	sharedSecret, err := privateKey.ECDH(clientPublicKey)
+	if err != nil {
+		return nil, secureConnError("computing shared secret", err)
+	}
+	return newSecureConn(conn, sharedSecret, transcript, false, nil)
+}
+
+func NewSecureClientConn(
+	conn net.Conn,
+	verifier *Verifier,
+	options ...VerifyOption,
+) (*SecureConn, error) {
+	privateKey, err := ecdh.X25519().GenerateKey(crand.Reader)
+	if err != nil {
+		return nil, secureConnError("generating ephemeral key", err)
+	}
+
+	nonce := make([]byte, SecureConnNonceSize)
+	_, err = crand.Read(nonce)
+	if err != nil {
+		return nil, secureConnError("generating nonce", err)
+	}
+
+	clientPublicKey := privateKey.PublicKey().Bytes()
+	clientHello := SecureConnClientHello{PublicKey: clientPublicKey, Nonce: nonce}
+	helloBytes, err := json.Marshal(clientHello)
+	if err != nil {
+		return nil, secureConnError("marshaling client hello", err)
+	}
+	err = writeFrame(conn, helloBytes, SecureConnMaxHandshakeSize)
+	if err != nil {
+		return nil, secureConnError("writing client hello", err)
+	}
+
+	helloBytes, err = readFrame(conn, SecureConnMaxHandshakeSize)
+	if err != nil {
+		return nil, secureConnError("reading server hello", err)
+	}
+	serverHello := SecureConnServerHello{}
+	err = json.Unmarshal(helloBytes, &serverHello)
+	switch {
+	case err != nil:
+		return nil, secureConnError("unmarshaling server hello", err)
+	case serverHello.Attestation == nil:
+		return nil, secureConnError("missing server attestation", nil)
+	}
+
+	verified, err := verifier.Verify(serverHello.Attestation, options...)
+	if err != nil {
+		return nil, secureConnError("verifying server attestation", err)
+	}
+
+	transcript := SecureConnTranscript(clientPublicKey, serverHello.PublicKey, nonce)
+	if !bytes.Equal(transcript, verified.UserData) {
+		return nil, secureConnError("attested transcript mismatch", nil)
+	}
+
+	serverPublicKey, err := ecdh.X25519().NewPublicKey(serverHello.PublicKey)
+	if err != nil {
+		return nil, secureConnError("parsing server public key", err)
+	}
+
+	sharedSecret, err := privateKey.ECDH(serverPublicKey)
+	if err != nil {
+		return nil, secureConnError("computing shared secret", err)
+	}
+	return newSecureConn(conn, sharedSecret, transcript, true, serverHello.Attestation)
+}
+
+func newSecureConn(
+	conn net.Conn,
+	sharedSecret []byte,
+	transcript []byte,
+	isClient bool,
+	peerAttestation *AttestResult,
+) (*SecureConn, error) {
+	salt := sha256.Sum256(transcript)
+	clientWrite, err := newSecureConnAEAD(sharedSecret, salt[:], SecureConnInfoClientWrite)
+	if err != nil {
+		return nil, err
+	}
+	serverWrite, err := newSecureConnAEAD(sharedSecret, salt[:], SecureConnInfoServerWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	secureConn := &SecureConn{conn: conn, peerAttestation: peerAttestation}
+	if isClient {
+		secureConn.readAEAD, secureConn.writeAEAD = serverWrite, clientWrite
+	} else {
+		secureConn.readAEAD, secureConn.writeAEAD = clientWrite, serverWrite
+	}
+	return secureConn, nil
+}
+
+func newSecureConnAEAD(secret []byte, salt []byte, info string) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, secret, salt, info, SecureConnKeySize)
+	if err != nil {
+		return nil, secureConnError("deriving key", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, secureConnError("creating cipher", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, secureConnError("creating aead", err)
+	}
+	return aead, nil
+}
+
+// SecureConnTranscript returns the bytes the enclave attests to during the
+// handshake. Binding both public keys and the client nonce prevents a host
+// from replaying an old attestation or substituting its own key.
+func SecureConnTranscript(
+	clientPublicKey []byte,
+	serverPublicKey []byte,
+	nonce []byte,
+) []byte {
+	transcript := make([]byte, 0, len(clientPublicKey)+len(serverPublicKey)+len(nonce))
+	transcript = append(transcript, clientPublicKey...)
+	transcript = append(transcript, serverPublicKey...)
+	return append(transcript, nonce...)
+}
+
+func (s *SecureConn) PeerAttestation() *AttestResult { return s.peerAttestation }
+
+func (s *SecureConn) Read(b []byte) (int, error) {
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+
+	if len(s.readBuf) == 0 {
+		record, err := readFrame(s.conn, SecureConnMaxRecordSize+s.readAEAD.Overhead())
+		switch {
+		case err == io.EOF: //nolint:errorlint
+			return 0, io.EOF
+		case err != nil:
+			return 0, secureConnError("reading record", err)
+		}
+		plaintext, err := s.readAEAD.Open(record[:0], secureConnNonce(s.readSeq), record, nil)
+		if err != nil {
+			return 0, secureConnError("decrypting record", err)
+		}
+		s.readSeq++
+		s.readBuf = plaintext
+	}
+
+	n := copy(b, s.readBuf)
+	s.readBuf = s.readBuf[n:]
+	return n, nil
+}
+
+func (s *SecureConn) Write(b []byte) (int, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	total := 0
+	for len(b) > 0 {
+		chunk := b[:min(len(b), SecureConnMaxRecordSize)]
+		record := s.writeAEAD.Seal(nil, secureConnNonce(s.writeSeq), chunk, nil)
+		err := writeFrame(s.conn, record, SecureConnMaxRecordSize+s.writeAEAD.Overhead())
+		if err != nil {
+			return total, secureConnError("writing record", err)
+		}
+		s.writeSeq++
+		total += len(chunk)
+		b = b[len(chunk):]
+	}
+	return total, nil
+}
+
+func (s *SecureConn) Close() error                       { return s.conn.Close() }
+func (s *SecureConn) LocalAddr() net.Addr                { return s.conn.LocalAddr() }
+func (s *SecureConn) RemoteAddr() net.Addr               { return s.conn.RemoteAddr() }
+func (s *SecureConn) SetDeadline(t time.Time) error      { return s.conn.SetDeadline(t) }
+func (s *SecureConn) SetReadDeadline(t time.Time) error  { return s.conn.SetReadDeadline(t) }
+func (s *SecureConn) SetWriteDeadline(t time.Time) error { return s.conn.SetWriteDeadline(t) }
+
+func secureConnNonce(seq uint64) []byte {
+	nonce := make([]byte, 12) //nolint:mnd
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+// SecureListener completes the server side of the handshake for every
+// connection it accepts. Handshakes run concurrently, each bounded by
+// DefaultSecureConnTimeout, so a slow client cannot stall Accept. Connections
+// whose handshake fails are closed and dropped rather than returned as an
+// error, which would stop servers such as http.Server.
+type SecureListener struct {
+	*handshakeListener
+
+	attester *Attester
+}
+
+func NewSecureListener(listener net.Listener, attester *Attester) *SecureListener {
+	secureListener := &SecureListener{attester: attester}
+	secureListener.handshakeListener = newHandshakeListener(
+		listener,
+		secureListener.handshake,
+	)
+	return secureListener
+}
+
+func (l *SecureListener) handshake(conn net.Conn) (net.Conn, error) {
+	_ = conn.SetDeadline(time.Now().Add(DefaultSecureConnTimeout))
+	secureConn, err := NewSecureServerConn(conn, l.attester)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return secureConn, nil
+}
+
+func MakeSecureDialContext(
+	dialContext DialContext,
+	verifier *Verifier,
+	options ...VerifyOption,
+) DialContext {
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		conn, err := dialContext(ctx, network, addr)
+		if err != nil {
+			msg := fmt.Sprintf("dialing '%s'", addr)
+			return nil, secureConnError(msg, err)
+		}
+
+		// The handshake has no notion of a context, so apply the context
+		// deadline to the underlying connection while it runs.
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+			defer func() { _ = conn.SetDeadline(time.Time{}) }()
+		}
+
+		secureConn, err := NewSecureClientConn(conn, verifier, options...)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return secureConn, nil
+	}
+}
@@ -0,0 +1,220 @@
+package tee_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func newTestAttesterVerifier(t *testing.T) (*tee.Attester, *tee.Verifier) {
+	t.Helper()
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+	return attester, verifier
+}
+
+func TestSecureConn(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		want := bytes.Repeat([]byte("hello world "), 4*1024)
+		attester, verifier := newTestAttesterVerifier(t)
+		clientConn, serverConn := net.Pipe()
+
+		serverErr := make(chan error, 1)
+		serverGot := make(chan []byte, 1)
+		go func() {
+			secureConn, err := tee.NewSecureServerConn(serverConn, attester)
+			if err != nil {
+				serverErr <- err
+				return
+			}
+			defer secureConn.Close()
+
+			got := make([]byte, len(want))
+			_, err = io.ReadFull(secureConn, got)
+			serverErr <- err
+			serverGot <- got
+		}()
+
+		// when
+		secureConn, err := tee.NewSecureClientConn(clientConn, verifier)
+		require.NoError(t, err)
+		defer secureConn.Close()
+
+		n, err := secureConn.Write(want)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, len(want), n)
+		require.NoError(t, <-serverErr)
+		assert.Equal(t, want, <-serverGot)
+		assert.NotNil(t, secureConn.PeerAttestation())
+	})
+
+	t.Run("happy path - listener and dial context", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		want := []byte("hello world")
+		attester, verifier := newTestAttesterVerifier(t)
+
+		listener, err := tee.NewListener(ctx, tee.NoTEE, "tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		secureListener := tee.NewSecureListener(listener, attester)
+		defer secureListener.Close()
+
+		go func() {
+			conn, acceptErr := secureListener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+
+		dialContext, err := tee.NewDialContext(tee.NoTEE)
+		require.NoError(t, err)
+		secureDialContext := tee.MakeSecureDialContext(dialContext, verifier)
+
+		// when
+		conn, err := secureDialContext(ctx, "tcp", listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write(want)
+		require.NoError(t, err)
+
+		got := make([]byte, len(want))
+		_, err = io.ReadFull(conn, got)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("error - verifying server attestation", func(t *testing.T) {
+		// given
+		attester, verifier := newTestAttesterVerifier(t)
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+		defer serverConn.Close()
+
+		go func() { _, _ = tee.NewSecureServerConn(serverConn, attester) }()
+
+		// when
+		_, err := tee.NewSecureClientConn(
+			clientConn,
+			verifier,
+			tee.WithVerifyMeasurement("wrong measurement"),
+		)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrSecureConn)
+		require.ErrorIs(t, err, tee.ErrVerifierMeasurement)
+	})
+
+	t.Run("error - attested transcript mismatch", func(t *testing.T) {
+		// given
+		attester, verifier := newTestAttesterVerifier(t)
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+		defer serverConn.Close()
+
+		// Simulate a host that replays a genuine attestation for a different
+		// handshake instead of one bound to this client's key and nonce.
+		staleKey, err := ecdh.X25519().GenerateKey(crand.Reader)
+		require.NoError(t, err)
+		stalePublicKey := staleKey.PublicKey().Bytes()
+		staleTranscript := tee.SecureConnTranscript(stalePublicKey, stalePublicKey, nil)
+		staleAttestation, err := attester.Attest(tee.WithAttestUserData(staleTranscript))
+		require.NoError(t, err)
+
+		go func() {
+			header := make([]byte, tee.FrameHeaderSize)
+			_, _ = io.ReadFull(serverConn, header)
+			hello := make([]byte, binary.BigEndian.Uint32(header))
+			_, _ = io.ReadFull(serverConn, hello)
+
+			reply, _ := json.Marshal(tee.SecureConnServerHello{
+				PublicKey:   stalePublicKey,
+				Attestation: staleAttestation,
+			})
+			binary.BigEndian.PutUint32(header, uint32(len(reply))) //nolint:gosec
+			_, _ = serverConn.Write(append(header, reply...))
+		}()
+
+		// when
+		_, err = tee.NewSecureClientConn(clientConn, verifier)
+
+		// then
+		require.ErrorIs(t, err, tee.ErrSecureConn)
+		assert.ErrorContains(t, err, "attested transcript mismatch")
+	})
+}
+
+func TestSecureListener_Accept(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("happy path - stalled and bad clients do not block accept", func(t *testing.T) {
+		// given
+		attester, verifier := newTestAttesterVerifier(t)
+		listener, err := tee.NewListener(ctx, tee.NoTEE, "tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		secureListener := tee.NewSecureListener(listener, attester)
+		defer secureListener.Close()
+		addr := listener.Addr().String()
+
+		stalled, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer stalled.Close()
+		bad, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		_, err = bad.Write([]byte{0xff, 0xff, 0xff, 0xff})
+		require.NoError(t, err)
+		bad.Close()
+
+		dialContext, err := tee.NewDialContext(tee.NoTEE)
+		require.NoError(t, err)
+		secureDialContext := tee.MakeSecureDialContext(dialContext, verifier)
+		go func() {
+			conn, dialErr := secureDialContext(ctx, "tcp", addr)
+			if dialErr == nil {
+				defer conn.Close()
+				_, _ = io.Copy(io.Discard, conn)
+			}
+		}()
+
+		// when
+		conn, err := secureListener.Accept()
+
+		// then
+		require.NoError(t, err)
+		conn.Close()
+	})
+
+	t.Run("error - closed", func(t *testing.T) {
+		// given
+		attester, _ := newTestAttesterVerifier(t)
+		listener, err := tee.NewListener(ctx, tee.NoTEE, "tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		secureListener := tee.NewSecureListener(listener, attester)
+		require.NoError(t, secureListener.Close())
+
+		// when
+		_, err = secureListener.Accept()
+
+		// then
+		require.ErrorIs(t, err, net.ErrClosed)
+	})
+}
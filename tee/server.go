@@ -3,10 +3,13 @@ package tee
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"log/slog"
 	"net"
 	"net/http"
 	"time"
+
+	"golang.org/x/crypto/acme"
 )
 
 const (
@@ -19,7 +22,10 @@ const (
 	DefaultWriteTimeout      = 15 * time.Second
 	DefaultIdleTimeout       = 60 * time.Second
 	DefaultMaxHeaderBytes    = 1 * Megabyte // 1MB
+	NetworkTCP               = "tcp"
 	NetworkTCP4              = "tcp4"
+	NetworkTCP6              = "tcp6"
+	NetworkUnix              = "unix"
 	NumConnDoneChannels      = 2
 )
 
@@ -38,7 +44,7 @@ func NewServer(
 	handler http.Handler,
 	logger *slog.Logger,
 ) (*Server, error) {
-	listener, err := NewListener(ctx, platform, NetworkTCP4, addr)
+	listener, err := NewListener(ctx, platform, NetworkTCP, addr)
 	if err != nil {
 		return nil, serverError("creating listener", err)
 	}
@@ -50,6 +56,7 @@ func NewServerWithListener(
 	handler http.Handler,
 	logger *slog.Logger,
 ) (*Server, error) {
+	handler = InstrumentHandler(DefaultMetrics, ComponentServer, handler)
 	server := DefaultServer(handler, logger)
 	closeFunc := func() error {
 		if closeErr := listener.Close(); closeErr != nil {
@@ -73,12 +80,13 @@ func NewServerTLS(
 	handler http.Handler,
 	certProvider CertProvider,
 	logger *slog.Logger,
+	options ...ServerTLSOption,
 ) (*Server, error) {
-	listener, err := NewListener(ctx, platform, NetworkTCP4, addr)
+	listener, err := NewListener(ctx, platform, NetworkTCP, addr)
 	if err != nil {
 		return nil, serverError("creating listener", err)
 	}
-	return NewServerTLSWithListener(listener, handler, certProvider, logger)
+	return NewServerTLSWithListener(listener, handler, certProvider, logger, options...)
 }
 
 func NewServerTLSWithListener(
@@ -86,7 +94,13 @@ func NewServerTLSWithListener(
 	handler http.Handler,
 	certProvider CertProvider,
 	logger *slog.Logger,
+	options ...ServerTLSOption,
 ) (*Server, error) {
+	opts := MakeDefaultServerTLSOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
 	tlsConfig := &tls.Config{
 		MinVersion: tls.VersionTLS12,
 		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
@@ -99,9 +113,50 @@ func NewServerTLSWithListener(
 			}
 			return cert, nil
 		},
+		ClientAuth: opts.ClientAuth,
+		ClientCAs:  opts.ClientCAs,
+	}
+	if responder, ok := certProvider.(TLSALPNChallengeResponder); ok {
+		getCertificate := tlsConfig.GetCertificate
+		tlsConfig.NextProtos = []string{"http/1.1", acme.ALPNProto}
+		tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if cert, isChallenge := responder.TLSALPNChallengeCert(hello); isChallenge {
+				return cert, nil
+			}
+			return getCertificate(hello)
+		}
+	}
+	if opts.ClientCAs != nil && opts.ClientAuth == tls.NoClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if len(opts.VerifyClient) > 0 {
+		// Checks on an identity the server has not verified prove nothing, so
+		// clients must present a certificate that chains to ClientCAs.
+		if opts.ClientCAs == nil {
+			return nil, serverError("verifying clients requires client CAs", nil)
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			identity, ok := NewClientIdentity(state)
+			if !ok {
+				return serverError("client certificate was not verified", nil)
+			}
+			for _, verify := range opts.VerifyClient {
+				if err := verify(identity); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
 	}
 
+	if opts.MutualAttest != nil {
+		handler = opts.MutualAttest(handler, logger)
+	}
+	handler = WithClientIdentity(handler)
+	handler = InstrumentHandler(DefaultMetrics, ComponentServer, handler)
 	server := DefaultServer(handler, logger)
+	server.ConnContext = contextWithConnPeerAttestation
 	closeFunc := func() error {
 		if closeErr := listener.Close(); closeErr != nil {
 			return closeErr
@@ -119,6 +174,71 @@ func NewServerTLSWithListener(
 	}, nil
 }
 
+type ServerTLSOption func(*ServerTLSOptions)
+type ServerTLSOptions struct {
+	ClientAuth   tls.ClientAuthType
+	ClientCAs    *x509.CertPool
+	VerifyClient []VerifyClientFunc
+	MutualAttest func(next http.Handler, logger *slog.Logger) http.Handler
+}
+
+func MakeDefaultServerTLSOptions() ServerTLSOptions {
+	return ServerTLSOptions{
+		ClientAuth:   tls.NoClientCert,
+		ClientCAs:    nil,
+		VerifyClient: nil,
+		MutualAttest: nil,
+	}
+}
+
+// WithServerTLSClientAuth sets the client certificate policy, e.g.,
+// tls.VerifyClientCertIfGiven for optional or tls.RequireAndVerifyClientCert
+// for required client authentication.
+func WithServerTLSClientAuth(clientAuth tls.ClientAuthType) ServerTLSOption {
+	return func(opts *ServerTLSOptions) {
+		opts.ClientAuth = clientAuth
+	}
+}
+
+// WithServerTLSClientCAs sets the pool client certificates are verified
+// against. Unless WithServerTLSClientAuth says otherwise, client certificates
+// are then required.
+func WithServerTLSClientCAs(clientCAs *x509.CertPool) ServerTLSOption {
+	return func(opts *ServerTLSOptions) {
+		opts.ClientCAs = clientCAs
+	}
+}
+
+// WithServerTLSVerifyClient adds a check run on every connection. Returning
+// an error aborts the handshake. It requires WithServerTLSClientCAs, and
+// clients must then present a certificate that chains to them.
+func WithServerTLSVerifyClient(verify VerifyClientFunc) ServerTLSOption {
+	return func(opts *ServerTLSOptions) {
+		opts.VerifyClient = append(opts.VerifyClient, verify)
+	}
+}
+
+// WithServerTLSAllowedSPIFFEIDs only accepts clients whose certificate
+// carries a SPIFFE ID matching one of patterns (see MatchSPIFFEID).
+func WithServerTLSAllowedSPIFFEIDs(patterns ...string) ServerTLSOption {
+	return WithServerTLSVerifyClient(VerifyClientSPIFFEIDs(patterns...))
+}
+
+// WithServerTLSMutualAttestation requires every connection to complete
+// mutual attestation (see MakeMutualAttestHandler) before its requests reach
+// the handler. Clients should use NewMutualAttestClient.
+func WithServerTLSMutualAttestation(
+	attester *Attester,
+	verifier *Verifier,
+	options ...VerifyOption,
+) ServerTLSOption {
+	return func(opts *ServerTLSOptions) {
+		opts.MutualAttest = func(next http.Handler, logger *slog.Logger) http.Handler {
+			return MakeMutualAttestHandler(attester, verifier, next, logger, options...)
+		}
+	}
+}
+
 func (s *Server) Addr() string { return s.listener.Addr().String() }
 func (s *Server) Close() error { return s.closeFunc() }
 func (s *Server) Serve() error { return s.serveFunc() }
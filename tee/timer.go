@@ -16,6 +16,8 @@ func NewTimer(platform Platform) (Timer, error) {
 		return bearclave.NewTSCTimer()
 	case NoTEE:
 		return bearclave.NewTSCTimer()
+	case NitroSim:
+		return bearclave.NewTSCTimer()
 	default:
 		return nil, unsupportedPlatformError(string(platform), nil)
 	}
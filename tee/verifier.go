@@ -10,7 +10,8 @@ import (
 )
 
 type Verifier struct {
-	base bearclave.Verifier
+	base     bearclave.Verifier
+	platform Platform
 }
 
 func NewVerifier(platform Platform) (*Verifier, error) {
@@ -26,6 +27,8 @@ func NewVerifier(platform Platform) (*Verifier, error) {
 		base, err = bearclave.NewTDXVerifier()
 	case NoTEE:
 		base, err = bearclave.NewNoTEEVerifier()
+	case NitroSim:
+		base, err = bearclave.NewNoTEEVerifier()
 	default:
 		return nil, unsupportedPlatformError(string(platform), nil)
 	}
@@ -33,11 +36,11 @@ func NewVerifier(platform Platform) (*Verifier, error) {
 	if err != nil {
 		return nil, verifierError("making verifier", err)
 	}
-	return NewVerifierWithBase(base)
+	return &Verifier{base: base, platform: platform}, nil
 }
 
 func NewVerifierWithBase(base bearclave.Verifier) (*Verifier, error) {
-	return &Verifier{base: base}, nil
+	return &Verifier{base: base, platform: UnknownPlatform}, nil
 }
 
 type VerifyResult struct {
@@ -48,11 +51,30 @@ type VerifyResult struct {
 func (v *Verifier) Verify(
 	attestResult *AttestResult,
 	options ...VerifyOption,
+) (*VerifyResult, error) {
+	start := time.Now()
+	verifyResult, err := v.verify(attestResult, options...)
+
+	labels := Labels{"platform": string(v.platform)}
+	DefaultMetrics.ObserveDuration(MetricVerifyDuration, labels, start)
+	if err != nil {
+		labels["class"] = MetricsErrorClass(err)
+		DefaultMetrics.IncCounter(MetricVerifyErrors, labels)
+	}
+	return verifyResult, err
+}
+
+func (v *Verifier) verify(
+	attestResult *AttestResult,
+	options ...VerifyOption,
 ) (*VerifyResult, error) {
 	opts := MakeDefaultVerifyOptions()
 	for _, opt := range options {
 		opt(&opts)
 	}
+	if attestResult == nil || attestResult.Base == nil {
+		return nil, verifierError("missing attestation report", nil)
+	}
 
 	baseResult, err := v.base.Verify(attestResult.Base, opts.Base...)
 	switch {
@@ -114,6 +136,14 @@ func WithVerifyDebug(debug bool) VerifyOption {
 	}
 }
 
+// WithVerifyMaxAge rejects attestations older than maxAge. On SEV and TDX,
+// which have no report timestamp, it requires WithVerifyNonce instead.
+func WithVerifyMaxAge(maxAge time.Duration) VerifyOption {
+	return func(opts *VerifyOptions) {
+		opts.Base = append(opts.Base, bearclave.WithVerifyMaxAge(maxAge))
+	}
+}
+
 func WithVerifyMeasurement(measurement string) VerifyOption {
 	return func(opts *VerifyOptions) {
 		opts.Base = append(opts.Base, bearclave.WithVerifyMeasurement(measurement))
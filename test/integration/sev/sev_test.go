@@ -14,6 +14,7 @@ func TestSEV_Drivers(t *testing.T) {
 	t.Run("happy path - new sev client", func(t *testing.T) {
 		client, err := drivers.NewSEVClient()
 		require.NoError(t, err)
+		defer client.Close()
 		require.NotNil(t, client)
 	})
 
@@ -21,6 +22,7 @@ func TestSEV_Drivers(t *testing.T) {
 		// given
 		client, err := drivers.NewSEVClient()
 		require.NoError(t, err)
+		defer client.Close()
 
 		userData := []byte("Hello, World!")
 
@@ -10,130 +10,48 @@ import (
 	"github.com/tahardi/bearclave/tee"
 )
 
-const (
-	AttestCertPath      = "/attest-cert"
-	AttestHTTPCallPath  = "/attest-http-call"
-	AttestHTTPSCallPath = "/attest-https-call"
-)
-
-type AttestCertRequest struct {}
-type AttestCertResponse struct {
-	Attestation *tee.AttestResult `json:"attestation"`
-}
-
-func MakeAttestCertHandler(
-	t *testing.T,
-	attester *tee.Attester,
-	certProvider tee.CertProvider,
-) http.HandlerFunc {
-	t.Helper()
-	return func(w http.ResponseWriter, r *http.Request) {
-		t.Helper()
-		certReq := AttestCertRequest{}
-		err := json.NewDecoder(r.Body).Decode(&certReq)
-		assert.NoError(t, err)
+const CallPath = "/call"
 
+// MakeCertChainUserData returns the HTTPS server's certificate chain as
+// JSON, so that tee.MakeAttestHandler can attest to it.
+func MakeCertChainUserData(certProvider tee.CertProvider) tee.AttestUserDataFunc {
+	return func(r *http.Request) ([]byte, string, error) {
 		cert, err := certProvider.GetCert(r.Context())
-		assert.NoError(t, err)
-
-		chainDER := append([][]byte{}, cert.Certificate...)
-		chainJSON, err := json.Marshal(chainDER)
-		assert.NoError(t, err)
-
-		att, err := attester.Attest(tee.WithAttestUserData(chainJSON))
-		assert.NoError(t, err)
-		tee.WriteResponse(w, AttestCertResponse{Attestation: att})
-	}
-}
-
-type AttestHTTPCallRequest struct {
-	Method string `json:"method"`
-	URL    string `json:"url"`
-}
-
-type AttestHTTPCallResponse struct {
-	Attestation *tee.AttestResult `json:"attestation"`
-}
-
-func MakeAttestHTTPCallHandler(
-	t *testing.T,
-	attester *tee.Attester,
-	client *http.Client,
-) http.HandlerFunc {
-	t.Helper()
-	return func(w http.ResponseWriter, r *http.Request) {
-		t.Helper()
-		httpCallReq := AttestHTTPCallRequest{}
-		err := json.NewDecoder(r.Body).Decode(&httpCallReq)
-		assert.NoError(t, err)
-
-		req, err := http.NewRequestWithContext(
-			r.Context(),
-			httpCallReq.Method,
-			httpCallReq.URL,
-			nil,
-		)
-		assert.NoError(t, err)
-
-		resp, err := client.Do(req)
-		assert.NoError(t, err)
-		defer resp.Body.Close()
-
-		respBytes, err := io.ReadAll(resp.Body)
-		assert.NoError(t, err)
-
-		attestation, err := attester.Attest(tee.WithAttestUserData(respBytes))
-		assert.NoError(t, err)
-
-		httpCallResp := AttestHTTPCallResponse{
-			Attestation: attestation,
+		if err != nil {
+			return nil, "", err
 		}
-		tee.WriteResponse(w, httpCallResp)
+		chainJSON, err := json.Marshal(cert.Certificate)
+		return chainJSON, "application/json", err
 	}
 }
 
-type AttestHTTPSCallRequest struct {
-	Method string `json:"method"`
-	URL    string `json:"url"`
-}
-
-type AttestHTTPSCallResponse struct {
-	Attestation *tee.AttestResult `json:"attestation"`
-}
-
-func MakeAttestHTTPSCallHandler(
-	t *testing.T,
-	attester *tee.Attester,
-	client *http.Client,
-) http.HandlerFunc {
+// MakeCallHandler GETs the URL in the "url" query parameter and writes back
+// the response. Wrap it in tee.AttestResponses to attest to the result.
+func MakeCallHandler(t *testing.T, client *http.Client) http.Handler {
 	t.Helper()
-	return func(w http.ResponseWriter, r *http.Request) {
-		t.Helper()
-		httpsCallReq := AttestHTTPSCallRequest{}
-		err := json.NewDecoder(r.Body).Decode(&httpsCallReq)
-		assert.NoError(t, err)
-
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+CallPath, func(w http.ResponseWriter, r *http.Request) {
 		req, err := http.NewRequestWithContext(
 			r.Context(),
-			httpsCallReq.Method,
-			httpsCallReq.URL,
+			http.MethodGet,
+			r.URL.Query().Get("url"),
 			nil,
 		)
-		assert.NoError(t, err)
+		if !assert.NoError(t, err) {
+			tee.WriteError(w, err)
+			return
+		}
 
 		resp, err := client.Do(req)
-		assert.NoError(t, err)
+		if !assert.NoError(t, err) {
+			tee.WriteError(w, err)
+			return
+		}
 		defer resp.Body.Close()
 
-		respBytes, err := io.ReadAll(resp.Body)
-		assert.NoError(t, err)
-
-		attestation, err := attester.Attest(tee.WithAttestUserData(respBytes))
-		assert.NoError(t, err)
-
-		httpsCallResp := AttestHTTPSCallResponse{
-			Attestation: attestation,
-		}
-		tee.WriteResponse(w, httpsCallResp)
-	}
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	})
+	return mux
 }
@@ -83,11 +83,7 @@ func TestNoTEE_HTTP(t *testing.T) {
 	proxiedClient, err := tee.NewProxiedClient(platform, proxyAddr)
 	require.NoError(t, err)
 
-	serverMux := http.NewServeMux()
-	serverMux.HandleFunc(
-		AttestHTTPCallPath,
-		MakeAttestHTTPCallHandler(t, attester, proxiedClient),
-	)
+	serverMux := tee.AttestResponses(MakeCallHandler(t, proxiedClient), attester)
 
 	// given - a server that makes HTTP requests on behalf of some remote client
 	serverAddr := "http://127.0.0.1:8081"
@@ -109,26 +105,21 @@ func TestNoTEE_HTTP(t *testing.T) {
 	defer revProxy.Close()
 
 	// given - a remote client that wants the server to make an attested HTTP call
-	wantMethod := "GET"
 	wantURL := "http://httpbin.org/get"
-	client := NewClient(t)
+	verifier, err := tee.NewVerifier(platform)
+	require.NoError(t, err)
+	client := NewClient(t, verifier)
 
 	// when
 	runService(func() { _ = proxy.Serve() }, 100*time.Millisecond)
 	runService(func() { _ = server.Serve() }, 100*time.Millisecond)
 	runService(func() { _ = revProxy.Serve() }, 100*time.Millisecond)
 
-	attested := client.AttestHTTPCall(ctx, revProxyAddr, wantMethod, wantURL)
+	got := client.Call(ctx, revProxyAddr, wantURL)
 
 	// then
-	verifier, err := tee.NewVerifier(platform)
-	require.NoError(t, err)
-
-	verified, err := verifier.Verify(attested)
-	require.NoError(t, err)
-
 	httpBinResp := HTTPBinGetResponse{}
-	err = json.Unmarshal(verified.UserData, &httpBinResp)
+	err = json.Unmarshal(got, &httpBinResp)
 	require.NoError(t, err)
 	assert.Equal(t, wantURL, httpBinResp.URL)
 }
@@ -175,10 +166,10 @@ func TestNoTEE_HTTPS(t *testing.T) {
 	require.NoError(t, err)
 
 	// given - an HTTP server that attests to the HTTPS server's certificate
-	serverMux := http.NewServeMux()
-	serverMux.HandleFunc(
-		AttestCertPath,
-		MakeAttestCertHandler(t, attester, certProvider),
+	serverMux := tee.MakeAttestHandler(
+		attester,
+		logger,
+		tee.WithAttestHandlerUserData(MakeCertChainUserData(certProvider)),
 	)
 
 	serverAddr := "http://127.0.0.1:8081"
@@ -209,11 +200,7 @@ func TestNoTEE_HTTPS(t *testing.T) {
 	proxiedClient, err := tee.NewProxiedClient(platform, proxyTLSAddr)
 	require.NoError(t, err)
 
-	serverTLSMux := http.NewServeMux()
-	serverTLSMux.HandleFunc(
-		AttestHTTPSCallPath,
-		MakeAttestHTTPSCallHandler(t, attester, proxiedClient),
-	)
+	serverTLSMux := tee.AttestResponses(MakeCallHandler(t, proxiedClient), attester)
 
 	// given - an HTTPS server that makes HTTPS requests on behalf of a remote client
 	serverTLSAddr := "https://127.0.0.1:8444"
@@ -241,10 +228,11 @@ func TestNoTEE_HTTPS(t *testing.T) {
 	require.NoError(t, err)
 	defer revProxyTLS.Close()
 
-	// given - a remote client that wants the server to make an attested HTTP call
-	wantMethod := "GET"
+	// given - a remote client that wants the server to make an attested HTTPS call
 	wantURL := "https://httpbin.org/get"
-	client := NewClient(t)
+	verifier, err := tee.NewVerifier(platform)
+	require.NoError(t, err)
+	client := NewClient(t, verifier)
 
 	// when
 	runService(func() { _ = server.Serve() }, 100*time.Millisecond)
@@ -253,21 +241,17 @@ func TestNoTEE_HTTPS(t *testing.T) {
 	runService(func() { _ = serverTLS.Serve() }, 100*time.Millisecond)
 	runService(func() { _ = revProxyTLS.Serve() }, 100*time.Millisecond)
 
-	attestedCert := client.AttestCertChain(ctx, revProxyAddr)
-	verifier, err := tee.NewVerifier(platform)
+	attestationClient, err := tee.NewAttestationClient(verifier)
 	require.NoError(t, err)
-	verifiedCert, err := verifier.Verify(attestedCert)
+	attestedCert, err := attestationClient.Attest(ctx, revProxyAddr)
 	require.NoError(t, err)
 
-	client.AddCertChain(verifiedCert.UserData)
-	attestedCall := client.AttestHTTPSCall(ctx, revProxyTLSAddr, wantMethod, wantURL)
+	client.AddCertChain(attestedCert.UserData)
+	got := client.Call(ctx, revProxyTLSAddr, wantURL)
 
 	// then
-	verifiedCall, err := verifier.Verify(attestedCall)
-	require.NoError(t, err)
-
 	httpBinResp := HTTPBinGetResponse{}
-	err = json.Unmarshal(verifiedCall.UserData, &httpBinResp)
+	err = json.Unmarshal(got, &httpBinResp)
 	require.NoError(t, err)
 	assert.Equal(t, wantURL, httpBinResp.URL)
 }
@@ -19,6 +19,7 @@ type VerifyOptions = attestation.VerifyOptions
 
 var (
 	WithVerifyDebug       = attestation.WithVerifyDebug
+	WithVerifyMaxAge      = attestation.WithVerifyMaxAge
 	WithVerifyMeasurement = attestation.WithVerifyMeasurement
 	WithVerifyTimestamp   = attestation.WithVerifyTimestamp
 	WithVerifyNonce       = attestation.WithVerifyVerifyNonce